@@ -0,0 +1,214 @@
+package mindb
+
+import (
+	"errors"
+	"mindb/storage"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// ErrChangesDisabled 没有配置 ChangeBacklog，不保留数据变更
+	ErrChangesDisabled = errors.New("mindb: change backlog is disabled")
+
+	// ErrChangesTruncated 要读取的变更已不在保留的范围内，读取方需要重新全量同步
+	ErrChangesTruncated = errors.New("mindb: changes from the sequence are no longer retained")
+
+	// ErrChangesStopped 读取变更时 stop 被关闭
+	ErrChangesStopped = errors.New("mindb: reading changes is stopped")
+)
+
+// Change 一条数据变更，与写入数据文件的一条entry对应
+type Change struct {
+	Seq      uint64   // 变更的序号，在整个数据库内递增，但不保证连续
+	Type     DataType // 数据类型
+	Mark     uint16   // 操作标识，如 StringSet、ListLPush，与数据类型一起决定变更的含义
+	Key      []byte
+	Value    []byte
+	Extra    []byte // 操作的额外信息，如哈希的 field、列表 LSet 的位置
+	Deadline uint64 // 过期时间（unix 秒），为 0 表示不过期
+}
+
+// 最近的数据变更，保存在一个环形缓冲区中，供外部的消费者按序号订阅及断点续传
+type changeFeed struct {
+	mu      sync.Mutex
+	seq     *uint64  // 数据库的写入序号
+	backlog []Change // 环形缓冲区，长度即最多保留的变更数
+	head    int      // 最早的变更在缓冲区中的位置
+	size    int      // 保留的变更数
+	notify  chan struct{}
+	closed  bool
+}
+
+func newChangeFeed(seq *uint64) *changeFeed {
+	return &changeFeed{seq: seq, notify: make(chan struct{})}
+}
+
+// 为写入的entry分配序号并返回，开启了变更保留时记录变更并通知等待的读取方，调用方需持有该类型索引的写锁
+// 序号在 feed 的锁内分配，保证缓冲区中的变更按序号排列
+func (f *changeFeed) append(backlog int, e *storage.Entry) (seq uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	seq = atomic.AddUint64(f.seq, 1)
+	f.resize(backlog)
+	if backlog <= 0 {
+		return
+	}
+
+	c := Change{
+		Seq:      seq,
+		Type:     e.Type,
+		Mark:     e.Mark,
+		Key:      append([]byte{}, e.Meta.Key...),
+		Value:    append([]byte{}, e.Meta.Value...),
+		Extra:    append([]byte{}, e.Meta.Extra...),
+		Deadline: e.Deadline,
+	}
+	if f.size < len(f.backlog) {
+		f.backlog[(f.head+f.size)%len(f.backlog)] = c
+		f.size++
+	} else { // 缓冲区已满，覆盖最早的变更
+		f.backlog[f.head] = c
+		f.head = (f.head + 1) % len(f.backlog)
+	}
+	f.wake()
+	return
+}
+
+// 保留的变更数被修改时调整缓冲区的大小，只保留最近的变更
+func (f *changeFeed) resize(n int) {
+	if n < 0 {
+		n = 0
+	}
+	if n == len(f.backlog) {
+		return
+	}
+
+	keep := f.size
+	if keep > n {
+		keep = n
+	}
+	var backlog []Change
+	if n > 0 {
+		backlog = make([]Change, n)
+		for i := 0; i < keep; i++ {
+			backlog[i] = f.backlog[(f.head+f.size-keep+i)%len(f.backlog)]
+		}
+	}
+	f.backlog, f.head, f.size = backlog, 0, keep
+	f.wake()
+}
+
+// 唤醒等待新变更的读取方，调用方需持有锁
+func (f *changeFeed) wake() {
+	close(f.notify)
+	f.notify = make(chan struct{})
+}
+
+// 数据库关闭时唤醒所有的读取方
+func (f *changeFeed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	f.wake()
+}
+
+// 最早可以读取的变更序号，没有保留任何变更时为下一个将要分配的序号，调用方需持有锁
+func (f *changeFeed) firstSeq() uint64 {
+	if f.size == 0 {
+		return atomic.LoadUint64(f.seq) + 1
+	}
+	return f.backlog[f.head].Seq
+}
+
+// Sequence 返回已分配的最大写入序号，即最后一次写入的变更序号
+func (db *MinDB) Sequence() uint64 {
+	return atomic.LoadUint64(&db.meta.Sequence)
+}
+
+// ChangeReader 按序号顺序读取数据变更
+type ChangeReader struct {
+	feed *changeFeed
+	next uint64 // 下一条要读取的变更序号不小于此值
+}
+
+// Changes 从序号 from 开始读取数据变更，from 为 0 时从保留的最早的变更开始
+// 读取方记录已处理的最后一条变更的序号，断开后以该序号加一重新读取即可续传，
+// 变更已不在保留范围内（落后太多）时返回 ErrChangesTruncated，需要重新全量同步。
+// 保留的变更只在内存中，数据库重启（包括异常退出）后序号从重启前预留的上限继续（见 seqLimit），
+// 此时除非读取方已经处理了重启前的所有变更，续传都会返回 ErrChangesTruncated，不会跳过重启之后的变更
+func (db *MinDB) Changes(from uint64) (*ChangeReader, error) {
+	return db.changes.reader(db.Config().ChangeBacklog, from)
+}
+
+// 创建从序号 from 开始读取的 ChangeReader
+func (f *changeFeed) reader(backlog int, from uint64) (*ChangeReader, error) {
+	if backlog <= 0 {
+		return nil, ErrChangesDisabled
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.resize(backlog)
+	first := f.firstSeq()
+	if from == 0 {
+		from = first
+	}
+	if from < first {
+		return nil, ErrChangesTruncated
+	}
+	return &ChangeReader{feed: f, next: from}, nil
+}
+
+// Next 返回下一条变更，没有新的变更时等待，直到有新的变更、stop 被关闭或数据库被关闭
+func (r *ChangeReader) Next(stop <-chan struct{}) (Change, error) {
+	f := r.feed
+	for {
+		f.mu.Lock()
+		if f.closed {
+			f.mu.Unlock()
+			return Change{}, ErrDBClosed
+		}
+		if len(f.backlog) == 0 {
+			f.mu.Unlock()
+			return Change{}, ErrChangesDisabled
+		}
+		if r.next < f.firstSeq() {
+			f.mu.Unlock()
+			return Change{}, ErrChangesTruncated
+		}
+
+		// 缓冲区中的变更按序号排列，二分查找第一条序号不小于 next 的变更
+		i := sort.Search(f.size, func(i int) bool {
+			return f.backlog[(f.head+i)%len(f.backlog)].Seq >= r.next
+		})
+		if i < f.size {
+			c := f.backlog[(f.head+i)%len(f.backlog)]
+			f.mu.Unlock()
+			r.next = c.Seq + 1
+			return c, nil
+		}
+		notify := f.notify
+		f.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-stop:
+			return Change{}, ErrChangesStopped
+		}
+	}
+}
+
+// 保留的变更中key最后一次被修改的序号，没有找到时返回 0，需要从新到旧遍历保留的变更
+func (f *changeFeed) lastSeqOf(key []byte) uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := f.size - 1; i >= 0; i-- {
+		c := &f.backlog[(f.head+i)%len(f.backlog)]
+		if string(c.Key) == string(key) {
+			return c.Seq
+		}
+	}
+	return 0
+}
@@ -0,0 +1,77 @@
+package mindb
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// 模拟异常退出：数据文件已持久化，但不保存 meta，释放目录锁后可以重新打开
+func crash(t *testing.T, db *MinDB) {
+	t.Helper()
+	if err := db.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&db.state, stateClosed)
+	db.changes.close()
+	for _, file := range db.activeFile {
+		file.Close(false)
+	}
+	unlockDir(db.dirLock)
+}
+
+// 异常退出后写入序号不能倒退，续传重启前的序号时返回 ErrChangesTruncated，而不是跳过重启之后的变更
+func TestChangesSeqAfterCrash(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DirPath = t.TempDir()
+	cfg.ChangeBacklog = 100
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err = db.Set([]byte("k"), []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	last := db.Sequence()
+	crash(t, db)
+
+	if db, err = Open(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if seq := db.Sequence(); seq < last {
+		t.Fatalf("sequence after crash = %d, want >= %d", seq, last)
+	}
+	if err = db.Set([]byte("k"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Changes(last - 4); err != ErrChangesTruncated {
+		t.Fatalf("Changes from before the crash err = %v, want ErrChangesTruncated", err)
+	}
+}
+
+// 中途失败的批量写入中已经写入文件的操作不能出现在数据变更中
+func TestChangesSkipFailedBatch(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DirPath = t.TempDir()
+	cfg.ChangeBacklog = 100
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	before := db.Sequence()
+	b := db.NewWriteBatch()
+	b.Set([]byte("a"), []byte("1"))
+	b.HSet([]byte("h"), []byte("f"), []byte("1"))
+	db.activeFile[Hash].Close(false) // 哈希的写入失败时字符串的操作已经写入
+	if err = b.Commit(); err == nil {
+		t.Fatal("Commit succeeded with a closed hash file")
+	}
+	if seq := db.Sequence(); seq > before+1 { // 只分配了批次id
+		t.Fatalf("sequence advanced from %d to %d for a failed batch", before, seq)
+	}
+}
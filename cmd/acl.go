@@ -0,0 +1,521 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"math"
+	"mindb"
+	"mindb/cmd/protocol"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUserNotExist = errors.New("user not exist")
+
+	ErrInvalidACLRule = errors.New("invalid acl rule")
+
+	ErrDefaultUser = errors.New("the default user can not be deleted")
+)
+
+// DefaultUser 默认用户，未指定用户名的 AUTH 认证为此用户，其密码即配置中的 password
+const DefaultUser = "default"
+
+// CmdGroup 命令分组，用于按组授权
+type CmdGroup uint8
+
+const (
+	// ReadGroup 读取数据的命令
+	ReadGroup CmdGroup = 1 << iota
+	// WriteGroup 修改数据的命令
+	WriteGroup
+	// AdminGroup 管理命令
+	AdminGroup
+
+	allGroups = ReadGroup | WriteGroup | AdminGroup
+)
+
+var cmdGroupNames = map[string]CmdGroup{
+	"read":  ReadGroup,
+	"write": WriteGroup,
+	"admin": AdminGroup,
+	"all":   allGroups,
+}
+
+// 命令的权限信息：所属分组，以及参数中哪些是key
+type cmdSpec struct {
+	group    CmdGroup
+	firstKey int // 第一个key参数的位置，-1 表示没有key
+	lastKey  int // 最后一个key参数的位置，-1 表示直到最后一个参数
+	keyStep  int // 相邻两个key参数的间隔，0 表示与 1 相同，用于 key value 成对出现的命令
+}
+
+func readCmd(firstKey, lastKey int) cmdSpec  { return cmdSpec{ReadGroup, firstKey, lastKey, 0} }
+func writeCmd(firstKey, lastKey int) cmdSpec { return cmdSpec{WriteGroup, firstKey, lastKey, 0} }
+
+// 每隔 step 个参数是一个key
+func (spec cmdSpec) step(step int) cmdSpec {
+	spec.keyStep = step
+	return spec
+}
+
+// 所有命令的权限信息，没有登记的命令属于管理命令
+var cmdSpecs = map[string]cmdSpec{
+	"set": writeCmd(0, 0), "get": readCmd(0, 0), "setnx": writeCmd(0, 0), "getset": writeCmd(0, 0),
+	"append": writeCmd(0, 0), "setrange": writeCmd(0, 0), "strlen": readCmd(0, 0), "strexists": readCmd(0, 0),
+	"strrem": writeCmd(0, 0), "prefixscan": readCmd(0, 0), "rangescan": readCmd(0, 1),
+	"expire": writeCmd(0, -1).step(2), "expireat": writeCmd(0, 0),
+	"persist": writeCmd(0, -1), "ttl": readCmd(0, 0), "ratelimit": writeCmd(0, 0),
+	"undelete": writeCmd(0, 0), "purge": writeCmd(0, -1),
+
+	"lock": writeCmd(0, 0), "renewlock": writeCmd(0, 0), "unlock": writeCmd(0, 0),
+
+	"lpush": writeCmd(0, 0), "rpush": writeCmd(0, 0), "lpop": writeCmd(0, 0), "rpop": writeCmd(0, 0),
+	"lindex": readCmd(0, 0), "lrem": writeCmd(0, 0), "linsert": writeCmd(0, 0), "lset": writeCmd(0, 0),
+	"ltrim": writeCmd(0, 0), "lrange": readCmd(0, 0), "llen": readCmd(0, 0),
+
+	"hset": writeCmd(0, 0), "hsetnx": writeCmd(0, 0), "hincrby": writeCmd(0, 0), "hget": readCmd(0, 0),
+	"hgetall": readCmd(0, 0), "hdel": writeCmd(0, 0), "hexists": readCmd(0, 0), "hlen": readCmd(0, 0),
+	"hkeys": readCmd(0, 0), "hvalues": readCmd(0, 0),
+
+	"sadd": writeCmd(0, 0), "spop": writeCmd(0, 0), "sismember": readCmd(0, 0), "srandmember": readCmd(0, 0),
+	"srem": writeCmd(0, 0), "smove": writeCmd(0, 1), "scard": readCmd(0, 0), "smembers": readCmd(0, 0),
+	"sunion": readCmd(0, -1), "sdiff": readCmd(0, -1),
+
+	"zadd": writeCmd(0, 0), "zscore": readCmd(0, 0), "zcard": readCmd(0, 0), "zrank": readCmd(0, 0),
+	"zrevrank": readCmd(0, 0), "zincrby": writeCmd(0, 0), "zrange": readCmd(0, 0), "zrevrange": readCmd(0, 0),
+	"zrem": writeCmd(0, 0), "zgetbyrank": readCmd(0, 0), "zrevgetbyrank": readCmd(0, 0),
+	"zscorerange": readCmd(0, 0), "zrevscorerange": readCmd(0, 0),
+
+	"topk.reserve": writeCmd(0, 0), "topk.add": writeCmd(0, 0), "topk.query": readCmd(0, 0),
+	"topk.count": readCmd(0, 0), "topk.list": readCmd(0, 0),
+
+	"bf.reserve": writeCmd(0, 0), "bf.add": writeCmd(0, 0), "bf.exists": readCmd(0, 0),
+
+	"cf.reserve": writeCmd(0, 0), "cf.add": writeCmd(0, 0), "cf.del": writeCmd(0, 0), "cf.exists": readCmd(0, 0),
+
+	"json.get": readCmd(0, 0), "json.set": writeCmd(0, 0),
+
+	"qpush": writeCmd(0, 0), "qpop": writeCmd(0, 0), "qack": writeCmd(0, 0), "qlen": readCmd(0, 0),
+	"qpushat": writeCmd(0, 0), "qdelay": writeCmd(0, 0), "bqpop": writeCmd(0, 0),
+
+	"exists": readCmd(0, -1), "type": readCmd(0, -1), "keymeta": readCmd(0, 0), "scan": readCmd(-1, -1),
+
+	"ping": readCmd(-1, -1), "echo": readCmd(-1, -1), "client": readCmd(-1, -1),
+
+	"multi": readCmd(-1, -1), "exec": readCmd(-1, -1), "discard": readCmd(-1, -1), "watch": readCmd(0, -1),
+	"unwatch": readCmd(-1, -1), "wait": readCmd(-1, -1),
+
+	"subscribe": readCmd(-1, -1), "psubscribe": readCmd(-1, -1), "unsubscribe": readCmd(-1, -1),
+	"punsubscribe": readCmd(-1, -1), "publish": writeCmd(-1, -1),
+}
+
+// 获取命令的权限信息
+func specOf(cmd string) cmdSpec {
+	if spec, ok := cmdSpecs[cmd]; ok {
+		return spec
+	}
+	return cmdSpec{group: AdminGroup, firstKey: -1}
+}
+
+// CommandGroup 获取命令所属的分组，没有登记的命令属于管理命令
+func CommandGroup(cmd string) CmdGroup {
+	return specOf(strings.ToLower(cmd)).group
+}
+
+// 获取命令参数中的key
+func (spec cmdSpec) keys(args []string) []string {
+	if spec.firstKey < 0 || spec.firstKey >= len(args) {
+		return nil
+	}
+	last := spec.lastKey
+	if last < 0 || last >= len(args) {
+		last = len(args) - 1
+	}
+	if spec.keyStep <= 1 {
+		return args[spec.firstKey : last+1]
+	}
+	var keys []string
+	for i := spec.firstKey; i <= last; i += spec.keyStep {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+// ACLUser 服务端的用户，限制其可执行的命令分组及可访问的key前缀
+type ACLUser struct {
+	Name      string
+	Enabled   bool
+	NoPass    bool                // 不需要密码
+	Passwords map[string]struct{} // 密码的sha256
+	Groups    CmdGroup            // 允许执行的命令分组
+	Prefixes  []string            // 允许访问的key前缀，为空时不能访问任何key，包含空字符串时可访问所有key
+	RateLimit float64             // 用户的所有连接每秒最多执行的命令数，0表示不限制
+
+	bucket *tokenBucket // 用户的令牌桶，修改用户时保留
+}
+
+func newACLUser(name string) *ACLUser {
+	return &ACLUser{Name: name, Passwords: make(map[string]struct{}), bucket: &tokenBucket{}}
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// 复制用户，修改用户时在副本上修改，规则全部合法后再替换
+func (u *ACLUser) clone() *ACLUser {
+	c := *u
+	c.Passwords = make(map[string]struct{}, len(u.Passwords))
+	for p := range u.Passwords {
+		c.Passwords[p] = struct{}{}
+	}
+	c.Prefixes = append([]string(nil), u.Prefixes...)
+	return &c
+}
+
+// 检查密码是否正确
+func (u *ACLUser) checkPassword(password string) bool {
+	if u.NoPass {
+		return true
+	}
+	hash := hashPassword(password)
+	for p := range u.Passwords {
+		if subtle.ConstantTimeCompare([]byte(p), []byte(hash)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// 检查是否可以访问key
+func (u *ACLUser) canAccess(key string) bool {
+	for _, prefix := range u.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// 应用一条规则，规则的格式与 Redis 的 ACL SETUSER 类似：
+// on/off 启用或禁用用户，>password 添加密码，<password 删除密码，nopass 不需要密码，resetpass 清空密码
+// +@read/+@write/+@admin/+@all 允许执行某组命令，-@group 禁止执行某组命令
+// ~prefix 允许访问以 prefix 开头的key（结尾的 * 可省略），allkeys 允许访问所有key，resetkeys 清空可访问的key
+// ratelimit=<n> 限制用户每秒最多执行 n 个命令，为 0 时不限制
+// reset 重置用户的所有规则
+func (u *ACLUser) applyRule(rule string) error {
+	switch lower := strings.ToLower(rule); {
+	case lower == "on":
+		u.Enabled = true
+	case lower == "off":
+		u.Enabled = false
+	case lower == "nopass":
+		u.NoPass = true
+		u.Passwords = make(map[string]struct{})
+	case lower == "resetpass":
+		u.NoPass = false
+		u.Passwords = make(map[string]struct{})
+	case lower == "allkeys":
+		u.Prefixes = []string{""}
+	case lower == "resetkeys":
+		u.Prefixes = nil
+	case lower == "reset":
+		bucket := u.bucket
+		*u = *newACLUser(u.Name)
+		u.bucket = bucket
+	case strings.HasPrefix(rule, ">"):
+		u.NoPass = false
+		u.Passwords[hashPassword(rule[1:])] = struct{}{}
+	case strings.HasPrefix(rule, "<"):
+		delete(u.Passwords, hashPassword(rule[1:]))
+	case strings.HasPrefix(rule, "~"):
+		prefix := strings.TrimSuffix(rule[1:], "*")
+		if strings.ContainsAny(prefix, "*?[") { // 只支持前缀匹配
+			return ErrInvalidACLRule
+		}
+		u.Prefixes = append(u.Prefixes, prefix)
+	case strings.HasPrefix(lower, "ratelimit="):
+		rate, err := strconv.ParseFloat(lower[len("ratelimit="):], 64)
+		if err != nil || rate < 0 || math.IsInf(rate, 0) {
+			return ErrInvalidACLRule
+		}
+		u.RateLimit = rate
+	case strings.HasPrefix(lower, "+@") || strings.HasPrefix(lower, "-@"):
+		group, ok := cmdGroupNames[lower[2:]]
+		if !ok {
+			return ErrInvalidACLRule
+		}
+		if lower[0] == '+' {
+			u.Groups |= group
+		} else {
+			u.Groups &^= group
+		}
+	default:
+		return ErrInvalidACLRule
+	}
+	return nil
+}
+
+// 将用户的规则描述为 ACL GETUSER 的响应
+func (u *ACLUser) describe() protocol.Reply {
+	var flags protocol.Array
+	if u.Enabled {
+		flags = append(flags, protocol.Bulk("on"))
+	} else {
+		flags = append(flags, protocol.Bulk("off"))
+	}
+	if u.NoPass {
+		flags = append(flags, protocol.Bulk("nopass"))
+	}
+
+	passwords := make([]string, 0, len(u.Passwords))
+	for p := range u.Passwords {
+		passwords = append(passwords, p)
+	}
+	sort.Strings(passwords)
+
+	var groups []string
+	for _, name := range []string{"read", "write", "admin"} {
+		if u.Groups&cmdGroupNames[name] != 0 {
+			groups = append(groups, "+@"+name)
+		}
+	}
+
+	keys := make([]string, 0, len(u.Prefixes))
+	for _, prefix := range u.Prefixes {
+		keys = append(keys, "~"+prefix+"*")
+	}
+
+	return protocol.Array{
+		protocol.Bulk("flags"), flags,
+		protocol.Bulk("passwords"), stringsReply(passwords),
+		protocol.Bulk("commands"), protocol.Bulk(strings.Join(groups, " ")),
+		protocol.Bulk("keys"), stringsReply(keys),
+		protocol.Bulk("ratelimit"), protocol.Bulk(strconv.FormatFloat(u.RateLimit, 'f', -1, 64)),
+	}
+}
+
+// 将用户的规则描述为一行文本，用于 ACL LIST
+func (u *ACLUser) String() string {
+	rules := []string{"user", u.Name}
+	if u.Enabled {
+		rules = append(rules, "on")
+	} else {
+		rules = append(rules, "off")
+	}
+	if u.NoPass {
+		rules = append(rules, "nopass")
+	}
+	for p := range u.Passwords {
+		rules = append(rules, "#"+p)
+	}
+	for _, prefix := range u.Prefixes {
+		rules = append(rules, "~"+prefix+"*")
+	}
+	for _, name := range []string{"read", "write", "admin"} {
+		if u.Groups&cmdGroupNames[name] != 0 {
+			rules = append(rules, "+@"+name)
+		}
+	}
+	if u.RateLimit > 0 {
+		rules = append(rules, "ratelimit="+strconv.FormatFloat(u.RateLimit, 'f', -1, 64))
+	}
+	return strings.Join(rules, " ")
+}
+
+func stringsReply(values []string) protocol.Array {
+	items := make(protocol.Array, 0, len(values))
+	for _, v := range values {
+		items = append(items, protocol.Bulk(v))
+	}
+	return items
+}
+
+// ACL 服务端的用户列表
+type ACL struct {
+	mu    sync.RWMutex
+	users map[string]*ACLUser
+}
+
+// NewACL 创建用户列表，默认用户可以执行所有命令、访问所有key，password 为空时不需要密码
+func NewACL(password string) *ACL {
+	def := newACLUser(DefaultUser)
+	def.Enabled = true
+	def.Groups = allGroups
+	def.Prefixes = []string{""}
+	if password == "" {
+		def.NoPass = true
+	} else {
+		def.Passwords[hashPassword(password)] = struct{}{}
+	}
+	return &ACL{users: map[string]*ACLUser{DefaultUser: def}}
+}
+
+// 获取用户
+func (acl *ACL) user(name string) *ACLUser {
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+	return acl.users[name]
+}
+
+// Authenticate 验证用户名和密码
+func (acl *ACL) Authenticate(name, password string) bool {
+	u := acl.user(name)
+	return u != nil && u.Enabled && u.checkPassword(password)
+}
+
+// 新连接是否可以不认证直接使用默认用户
+func (acl *ACL) defaultNoPass() bool {
+	u := acl.user(DefaultUser)
+	return u != nil && u.Enabled && u.NoPass
+}
+
+// SetUser 创建或修改用户，任一规则不合法时不做任何修改
+func (acl *ACL) SetUser(name string, rules ...string) error {
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+
+	u := newACLUser(name)
+	if old, ok := acl.users[name]; ok {
+		u = old.clone()
+	}
+	for _, rule := range rules {
+		if err := u.applyRule(rule); err != nil {
+			return err
+		}
+	}
+	acl.users[name] = u
+	return nil
+}
+
+// DelUser 删除用户，返回删除的用户数量
+func (acl *ACL) DelUser(names ...string) (int, error) {
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+
+	count := 0
+	for _, name := range names {
+		if name == DefaultUser {
+			return count, ErrDefaultUser
+		}
+		if _, ok := acl.users[name]; ok {
+			delete(acl.users, name)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// 获取用户的令牌桶及其速率，用户没有速率限制时返回 nil
+func (acl *ACL) limiter(name string) (*tokenBucket, float64) {
+	u := acl.user(name)
+	if u == nil || u.RateLimit <= 0 {
+		return nil, 0
+	}
+	return u.bucket, u.RateLimit
+}
+
+// 检查用户是否有权限执行命令，参数中的key不能是内部key
+func (acl *ACL) check(name string, cmd string, args []string) protocol.Reply {
+	u := acl.user(name)
+	if u == nil || !u.Enabled {
+		return errNoAuth
+	}
+
+	spec := specOf(cmd)
+	if u.Groups&spec.group == 0 {
+		return protocol.Error("NOPERM this user has no permissions to run the '" + cmd + "' command")
+	}
+	for _, key := range spec.keys(args) {
+		if strings.HasPrefix(key, mindb.InternalKeyPrefix) { // 内部key保存队列、回收站等数据，客户端写入会破坏其结构
+			return protocol.Error("ERR keys starting with \\x00 are reserved for internal use")
+		}
+		if !u.canAccess(key) {
+			return protocol.Error("NOPERM this user has no permissions to access one of the keys used as arguments")
+		}
+	}
+	return nil
+}
+
+// 命令的 context 中保存连接的用户可以访问的key，SCAN 等返回key的命令据此过滤结果
+type keyFilterKey struct{}
+
+// 在 ctx 中保存用户可以访问的key，用户不存在时不保存
+func (acl *ACL) withKeyFilter(ctx context.Context, name string) context.Context {
+	if u := acl.user(name); u != nil {
+		return context.WithValue(ctx, keyFilterKey{}, u.canAccess)
+	}
+	return ctx
+}
+
+// 获取 ctx 中用户可以访问的key，没有时（如不经过连接执行的命令）返回 nil，不过滤
+func keyFilter(ctx context.Context) func(key string) bool {
+	allow, _ := ctx.Value(keyFilterKey{}).(func(key string) bool)
+	return allow
+}
+
+// 处理 ACL 命令：SETUSER、GETUSER、DELUSER、LIST、USERS、WHOAMI
+func (s *Server) aclCmd(state *connState, args []string) protocol.Reply {
+	if len(args) == 0 {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "setuser":
+		if len(args) < 2 {
+			break
+		}
+		if err := s.acl.SetUser(args[1], args[2:]...); err != nil {
+			return protocol.Error("ERR " + err.Error())
+		}
+		return okReply
+	case "getuser":
+		if len(args) != 2 {
+			break
+		}
+		u := s.acl.user(args[1])
+		if u == nil {
+			return protocol.Bulk(nil)
+		}
+		return u.describe()
+	case "deluser":
+		if len(args) < 2 {
+			break
+		}
+		count, err := s.acl.DelUser(args[1:]...)
+		if err != nil {
+			return protocol.Error("ERR " + err.Error())
+		}
+		return protocol.Integer(count)
+	case "list", "users":
+		s.acl.mu.RLock()
+		names := make([]string, 0, len(s.acl.users))
+		for name := range s.acl.users {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var res protocol.Array
+		for _, name := range names {
+			if strings.ToLower(args[0]) == "list" {
+				res = append(res, protocol.Bulk(s.acl.users[name].String()))
+			} else {
+				res = append(res, protocol.Bulk(name))
+			}
+		}
+		s.acl.mu.RUnlock()
+		return res
+	case "whoami":
+		if name := state.username(); name != "" {
+			return protocol.Bulk(name)
+		}
+		return protocol.Bulk(DefaultUser)
+	}
+	return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"mindb/cmd/protocol"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var host = flag.String("h", "127.0.0.1", "the mindb server host, default 127.0.0.1")
+var port = flag.Int("p", 5200, "the mindb server port, default 5200")
+var socket = flag.String("s", "", "the unix socket of the mindb server, overrides -h and -p")
+var password = flag.String("a", "", "password to use when connecting to the server")
+var user = flag.String("user", "", "username to authenticate with, default user if empty")
+var clients = flag.Int("c", 50, "the number of parallel connections")
+var requests = flag.Int("n", 100000, "the total number of requests")
+var pipeline = flag.Int("P", 1, "the number of requests each connection sends before waiting for the replies")
+var valueSize = flag.String("d", "100", "the value size in bytes of SET, a fixed size like 100 or a range like 64-4096")
+var ratio = flag.String("ratio", "1:1", "the ratio of SET to GET requests, e.g. 1:9 for a read-heavy workload")
+var keyspace = flag.Int("r", 100000, "the number of distinct keys, chosen at random from <prefix>0 to <prefix><r-1>")
+var prefix = flag.String("prefix", "bench:", "the prefix of the keys, so that the benchmark keys can be told apart and removed")
+
+// 一种命令的统计
+type opStats struct {
+	name      string
+	latencies []time.Duration
+	errors    int64
+}
+
+// 一个连接上的统计，结束后合并
+type clientStats struct {
+	set opStats
+	get opStats
+	err error
+}
+
+// 压测工具：多个连接并发发送按比例混合的 SET、GET，统计吞吐量及各命令的延迟分位数，
+// 用于比较不同版本之间的性能变化；开启 -P 后每个连接一次发送多个请求再读取响应
+func main() {
+	flag.Parse()
+	minSize, maxSize, err := parseRange(*valueSize)
+	if err != nil {
+		log.Println("invalid value size: ", *valueSize)
+		return
+	}
+	setWeight, getWeight, err := parseRatio(*ratio)
+	if err != nil {
+		log.Println("invalid ratio: ", *ratio)
+		return
+	}
+	if *clients <= 0 || *requests <= 0 || *pipeline <= 0 || *keyspace <= 0 {
+		log.Println("-c, -n, -P and -r must be positive")
+		return
+	}
+
+	network, addr := "tcp", fmt.Sprintf("%s:%d", *host, *port)
+	if *socket != "" {
+		network, addr = "unix", *socket
+	}
+	conns := make([]*benchConn, *clients)
+	for i := range conns {
+		if conns[i], err = dial(network, addr); err != nil {
+			log.Println(network+" connect err: ", err)
+			return
+		}
+		defer conns[i].conn.Close()
+	}
+
+	var (
+		wg      sync.WaitGroup
+		pending = int64(*requests)
+		results = make([]*clientStats, *clients)
+	)
+	start := time.Now()
+	for i, c := range conns {
+		wg.Add(1)
+		go func(i int, c *benchConn) {
+			defer wg.Done()
+			w := &worker{
+				conn:  c,
+				rand:  rand.New(rand.NewSource(time.Now().UnixNano() + int64(i))),
+				stats: &clientStats{set: opStats{name: "SET"}, get: opStats{name: "GET"}},
+				setP:  float64(setWeight) / float64(setWeight+getWeight),
+				min:   minSize,
+				max:   maxSize,
+			}
+			results[i] = w.stats
+			for {
+				n := int64(*pipeline)
+				if left := atomic.AddInt64(&pending, -n); left < 0 {
+					if n += left; n <= 0 {
+						return
+					}
+				}
+				if err := w.batch(int(n)); err != nil {
+					w.stats.err = err
+					return
+				}
+			}
+		}(i, c)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report(results, elapsed)
+}
+
+// 与服务端的一个连接
+type benchConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+	reqId  uint32
+}
+
+// 建立连接，完成握手及认证
+func dial(network, addr string) (*benchConn, error) {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &benchConn{conn: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn)}
+
+	handshake := []string{"hello " + strconv.Itoa(protocol.Version)}
+	if *password != "" {
+		auth := "auth " + *password
+		if *user != "" {
+			auth = "auth " + *user + " " + *password
+		}
+		handshake = append(handshake, auth)
+	}
+	for _, cmd := range handshake {
+		c.send(cmd)
+		if err = c.writer.Flush(); err == nil {
+			var reply protocol.Reply
+			if reply, err = c.receive(c.reqId); err == nil {
+				if e, ok := reply.(protocol.Error); ok {
+					err = errors.New(string(e))
+				}
+			}
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// 将请求写入缓冲，返回请求id
+func (c *benchConn) send(cmd string) uint32 {
+	c.reqId++
+	if c.reqId == protocol.PushId {
+		c.reqId++
+	}
+	_, _ = c.writer.Write(protocol.EncodeRequest(c.reqId, cmd))
+	return c.reqId
+}
+
+// 读取请求id为id的响应，推送的消息被丢弃
+func (c *benchConn) receive(id uint32) (protocol.Reply, error) {
+	for {
+		respId, reply, err := protocol.ReadResponse(c.reader)
+		if err != nil || respId == id {
+			return reply, err
+		}
+	}
+}
+
+// 一个连接上的压测
+type worker struct {
+	conn     *benchConn
+	rand     *rand.Rand
+	stats    *clientStats
+	setP     float64 // 每个请求是 SET 的概率
+	min, max int
+}
+
+// 发送 n 个请求后读取它们的响应，每个请求的延迟为从发送这一批请求到收到其响应的时间
+// 服务端并发执行同一连接上的请求，响应的顺序可能与请求不同，按请求id对应
+func (w *worker) batch(n int) error {
+	ops := make(map[uint32]*opStats, n)
+	for i := 0; i < n; i++ {
+		key := *prefix + strconv.Itoa(w.rand.Intn(*keyspace))
+		if w.rand.Float64() < w.setP {
+			ops[w.conn.send("set "+key+" "+w.value())] = &w.stats.set
+		} else {
+			ops[w.conn.send("get "+key)] = &w.stats.get
+		}
+	}
+	start := time.Now()
+	if err := w.conn.writer.Flush(); err != nil {
+		return err
+	}
+
+	for len(ops) > 0 {
+		id, reply, err := protocol.ReadResponse(w.conn.reader)
+		if err != nil {
+			return err
+		}
+		op, ok := ops[id]
+		if !ok { // 推送的消息
+			continue
+		}
+		delete(ops, id)
+		op.latencies = append(op.latencies, time.Since(start))
+		if _, isErr := reply.(protocol.Error); isErr {
+			op.errors++
+		}
+	}
+	return nil
+}
+
+// 生成大小在 [min, max] 之间的值，值中不含空白字符，可以直接作为命令的参数
+func (w *worker) value() string {
+	size := w.min
+	if w.max > w.min {
+		size += w.rand.Intn(w.max - w.min + 1)
+	}
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = 'a' + byte(w.rand.Intn(26))
+	}
+	return string(b)
+}
+
+// 输出各命令的延迟分位数及总的吞吐量
+func report(results []*clientStats, elapsed time.Duration) {
+	set, get := opStats{name: "SET"}, opStats{name: "GET"}
+	var errs []error
+	for _, s := range results {
+		set.latencies = append(set.latencies, s.set.latencies...)
+		set.errors += s.set.errors
+		get.latencies = append(get.latencies, s.get.latencies...)
+		get.errors += s.get.errors
+		if s.err != nil {
+			errs = append(errs, s.err)
+		}
+	}
+
+	total := len(set.latencies) + len(get.latencies)
+	fmt.Printf("%d requests completed in %s\n", total, elapsed.Round(time.Millisecond))
+	fmt.Printf("  %d clients, pipeline %d, value size %s bytes, set:get %s, %d keys\n\n",
+		*clients, *pipeline, *valueSize, *ratio, *keyspace)
+	for _, op := range []*opStats{&set, &get} {
+		if len(op.latencies) == 0 {
+			continue
+		}
+		sort.Slice(op.latencies, func(i, j int) bool { return op.latencies[i] < op.latencies[j] })
+		fmt.Printf("%s: %d requests, %d errors, %.0f requests/s\n",
+			op.name, len(op.latencies), op.errors, float64(len(op.latencies))/elapsed.Seconds())
+		fmt.Printf("  latency p50 %s  p90 %s  p99 %s  p99.9 %s  max %s\n",
+			percentile(op.latencies, 50), percentile(op.latencies, 90), percentile(op.latencies, 99),
+			percentile(op.latencies, 99.9), op.latencies[len(op.latencies)-1].Round(time.Microsecond))
+	}
+	fmt.Printf("\nthroughput: %.0f requests/s\n", float64(total)/elapsed.Seconds())
+
+	if len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "%d connections stopped early, the first err: %v\n", len(errs), errs[0])
+		os.Exit(1)
+	}
+}
+
+// 已排序的延迟中的第 p 百分位
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(float64(len(sorted))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i].Round(time.Microsecond)
+}
+
+// 解析 100 或 64-4096 形式的大小
+func parseRange(s string) (min, max int, err error) {
+	parts := strings.SplitN(s, "-", 2)
+	if min, err = strconv.Atoi(parts[0]); err != nil {
+		return
+	}
+	max = min
+	if len(parts) == 2 {
+		if max, err = strconv.Atoi(parts[1]); err != nil {
+			return
+		}
+	}
+	if min <= 0 || max < min {
+		err = errors.New("invalid range")
+	}
+	return
+}
+
+// 解析 1:9 形式的比例
+func parseRatio(s string) (set, get int, err error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return 0, 0, errors.New("invalid ratio")
+	}
+	if set, err = strconv.Atoi(parts[0]); err != nil {
+		return
+	}
+	if get, err = strconv.Atoi(parts[1]); err != nil {
+		return
+	}
+	if set < 0 || get < 0 || set+get == 0 {
+		err = errors.New("invalid ratio")
+	}
+	return
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"mindb"
+	"mindb/cmd/protocol"
+	"strconv"
+	"sync/atomic"
+)
+
+// 各数据类型的操作在 CHANGES 推送中的名称，下标为操作标识（如 mindb.StringSet）
+var opNames = map[mindb.DataType][]string{
+	mindb.String: {"set", "rem", "expire", "persist", "patch", "intent", "commit"},
+	mindb.List:   {"lpush", "rpush", "lpop", "rpop", "lrem", "linsert", "lset", "ltrim", "commit"},
+	mindb.Hash:   {"hset", "hdel", "intent", "commit"},
+	mindb.Set:    {"sadd", "srem", "smove", "intent", "commit"},
+	mindb.ZSet:   {"zadd", "zrem", "intent", "commit"},
+}
+
+// 操作的名称，未知的操作返回操作标识的数字
+func opName(dataType mindb.DataType, mark uint16) string {
+	if names := opNames[dataType]; int(mark) < len(names) {
+		return names[mark]
+	}
+	return strconv.Itoa(int(mark))
+}
+
+// 处理 CHANGES [from] 命令，订阅数据变更，供搜索索引、数据仓库等外部系统同步数据
+//
+// 命令返回 OK 后，连接上会按序号顺序持续推送变更（与订阅的消息一样以推送的方式发送），每条变更为一个数组：
+//
+//	"change" 序号 类型 操作 key value extra 过期时间
+//
+// 类型为 string、list、hash、set、zset，操作为 opNames 中的名称，extra 为操作的额外信息（如哈希的 field），
+// 过期时间为 unix 秒，0 表示不过期。序号在整个数据库内递增但不保证连续，消费者记录已处理的最后一个序号，
+// 断开后以 CHANGES 序号+1 续传；from 省略或为 0 时从保留的最早的变更开始。
+// 修改多个key的操作（如 SMOVE）推送为 intent、各key上的操作（srem、sadd）、commit，消费者可以忽略 intent 和 commit；
+// 批量写入在每种类型中各推送一组，列表没有 intent，批次id在列表操作的 extra 中。
+// 变更只保留最近 change_backlog 条，要续传的变更已不在保留范围内时命令返回错误，
+// 推送过程中消费者落后过多时推送 "changes-error" 及错误信息后停止推送，此时消费者需要重新全量同步
+func (s *Server) changesCmd(state *connState, args []string) protocol.Reply {
+	if len(args) > 1 {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+	var from uint64
+	if len(args) == 1 {
+		var err error
+		if from, err = strconv.ParseUint(args[0], 10, 64); err != nil {
+			return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+		}
+	}
+
+	if !atomic.CompareAndSwapInt32(&state.streaming, 0, 1) {
+		return protocol.Error("ERR the connection is already streaming changes")
+	}
+	var reader *mindb.ChangeReader
+	var err error
+	s.shared(func() { reader, err = s.db.Changes(from) }) // 不从 EXEC 执行的事务中间开始
+	if err != nil {
+		atomic.StoreInt32(&state.streaming, 0)
+		return protocol.Error("ERR " + err.Error())
+	}
+
+	state.onReplied(func() {
+		go s.streamChanges(state, reader, nil)
+	})
+	return okReply
+}
+
+// 将数据变更持续推送给连接，直到连接关闭或出错，连接为副本时记录已推送的序号
+func (s *Server) streamChanges(state *connState, reader *mindb.ChangeReader, r *replica) {
+	defer atomic.StoreInt32(&state.streaming, 0)
+	for {
+		c, err := reader.Next(state.done)
+		if err == mindb.ErrChangesStopped {
+			return
+		}
+		if err != nil {
+			_ = state.sub.push(protocol.Array{protocol.Bulk("changes-error"), protocol.Bulk(err.Error())})
+			return
+		}
+
+		if err = state.sub.push(changeReply(c)); err != nil {
+			return
+		}
+		if r != nil {
+			atomic.StoreUint64(&r.sent, c.Seq)
+		}
+	}
+}
+
+// 推送给消费者的一条变更
+func changeReply(c mindb.Change) protocol.Reply {
+	return protocol.Array{
+		protocol.Bulk("change"),
+		protocol.Integer(c.Seq),
+		protocol.Bulk(typeNames[c.Type]),
+		protocol.Bulk(opName(c.Type, c.Mark)),
+		protocol.Bulk(c.Key),
+		protocol.Bulk(c.Value),
+		protocol.Bulk(c.Extra),
+		protocol.Integer(c.Deadline),
+	}
+}
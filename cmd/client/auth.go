@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"mindb/cmd/protocol"
+	"os"
+	"strings"
+
+	"github.com/peterh/liner"
+)
+
+var askPass = flag.Bool("askpass", false, "prompt for the password without echoing it, instead of passing it with -a")
+
+// 未指定 -a 时使用的密码的环境变量
+const passwordEnv = "MINDB_CLI_AUTH"
+
+// 确定认证使用的密码：-askpass 时从终端读取且不回显，否则依次使用 -a 及环境变量 MINDB_CLI_AUTH
+// -a 中的密码会出现在进程列表及 shell 的历史中，使用时给出提示
+func resolvePassword() (string, error) {
+	if *askPass {
+		if !stdinIsTerminal() { // 通过管道传入密码，读取第一行
+			return readLine(os.Stdin)
+		}
+		line := liner.NewLiner()
+		defer line.Close()
+		return line.PasswordPrompt("Password: ")
+	}
+	if *password != "" {
+		fmt.Fprintln(os.Stderr, "Warning: using a password with -a may not be safe, use -askpass or "+passwordEnv+" instead.")
+		return *password, nil
+	}
+	return os.Getenv(passwordEnv), nil
+}
+
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// 逐个字节读取一行，不多读取之后的内容，之后的输入仍作为命令读取
+func readLine(r io.Reader) (string, error) {
+	var b []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				break
+			}
+			b = append(b, buf[0])
+		}
+		if err == io.EOF && len(b) > 0 {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSuffix(string(b), "\r"), nil
+}
+
+// 认证使用的 AUTH 命令，密码为空时返回空
+func authCommand(pw string) string {
+	if pw == "" {
+		return ""
+	}
+	if *user != "" {
+		return "auth " + quoteArg(*user) + " " + quoteArg(pw)
+	}
+	return "auth " + quoteArg(pw)
+}
+
+// 交互模式下服务端要求认证而启动时没有提供密码，提示输入密码（不回显）并认证，成功时返回 true
+// 标准输入不是终端时不提示，返回 false
+func promptAuth(line *liner.State, c *client, reply protocol.Reply) bool {
+	e, isErr := reply.(protocol.Error)
+	if !isErr || !strings.HasPrefix(string(e), "NOAUTH") || c.auth != "" || !stdinIsTerminal() {
+		return false
+	}
+	pw, err := line.PasswordPrompt("Password: ")
+	if err != nil || pw == "" {
+		return false
+	}
+	args := []string{"auth", pw}
+	if *user != "" {
+		args = []string{"auth", *user, pw}
+	}
+	if _, err = c.call(args...); err != nil {
+		fmt.Println(err)
+		return false
+	}
+	return true
+}
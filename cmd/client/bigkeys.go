@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+var bigKeys = flag.Bool("bigkeys", false, "scan the keyspace and report the biggest keys of each data type")
+
+// 扫描结果的输出顺序
+var bigKeysTypes = []string{"string", "list", "hash", "set", "zset"}
+
+// 一个key的元素个数及占用的字节数
+type keySize struct {
+	key   string
+	count int64
+	bytes int64
+}
+
+// 一种类型的key的统计
+type typeSummary struct {
+	keys     int64
+	elements int64
+	bytes    int64
+	byCount  keySize // 元素最多的key
+	byBytes  keySize // 占用字节最多的key
+}
+
+// 通过 SCAN 遍历所有的key（指定了 -pattern 时只遍历匹配的key），统计每种类型中元素最多及占用字节最多的key，用于找出需要清理的key
+// 遍历只读取key的大小，不会修改数据；遍历期间被修改的key按读取时的大小计算
+func runBigKeys(c *client) int {
+	fmt.Println("# Scanning the keyspace to find the biggest keys of each type")
+
+	summaries := make(map[string]*typeSummary)
+	scanned := int64(0)
+	err := scanKeys(c, *pattern, "", true, func(item scannedKey) error {
+		s := summaries[item.typ]
+		if s == nil {
+			s = &typeSummary{}
+			summaries[item.typ] = s
+		}
+		s.keys++
+		s.elements += item.count
+		s.bytes += item.bytes
+		if s.byCount.key == "" || item.count > s.byCount.count {
+			s.byCount = item.keySize
+		}
+		if s.byBytes.key == "" || item.bytes > s.byBytes.bytes {
+			s.byBytes = item.keySize
+			fmt.Printf("[%d] biggest %s found so far %q with %d bytes\n", scanned, item.typ, item.key, item.bytes)
+		}
+		scanned++
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "scan err: ", err)
+		return 1
+	}
+
+	fmt.Printf("\n-------- summary --------\n\n")
+	fmt.Printf("Sampled %d keys in the keyspace\n\n", scanned)
+	for _, typ := range bigKeysTypes {
+		if s := summaries[typ]; s != nil {
+			fmt.Printf("Biggest %6s by elements %q has %d elements\n", typ, s.byCount.key, s.byCount.count)
+			fmt.Printf("Biggest %6s by bytes    %q has %d bytes\n", typ, s.byBytes.key, s.byBytes.bytes)
+		}
+	}
+	fmt.Println()
+	for _, typ := range bigKeysTypes {
+		s := summaries[typ]
+		if s == nil {
+			s = &typeSummary{}
+		}
+		avg := float64(0)
+		if s.keys > 0 {
+			avg = float64(s.elements) / float64(s.keys)
+		}
+		fmt.Printf("%d %s keys with %d elements (%.2f avg) and %d bytes\n", s.keys, typ, s.elements, avg, s.bytes)
+	}
+	return 0
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"mindb/cmd/protocol"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// 连接断开后最多尝试重连的次数
+	reconnectAttempts = 5
+
+	// 第一次重连前等待的时间，之后每次加倍
+	reconnectBackoff = 200 * time.Millisecond
+)
+
+// ErrTxLost 连接断开时处于 MULTI 或 WATCH 中，服务端保存的事务状态随连接一起丢失，命令不会重试
+var ErrTxLost = errors.New("connection lost during a transaction, MULTI/WATCH state was discarded")
+
+// 与服务端的连接，连接断开时自动重连，并恢复连接上的认证状态
+type client struct {
+	network string
+	addr    string
+	tls     *tls.Config // 为 nil 时不使用 TLS
+	conn    net.Conn
+	reader  *bufio.Reader
+	reqId   uint32
+
+	auth     string // 最近一次认证成功的 AUTH 命令，重连后重新执行
+	readonly bool   // 是否开启了 CLIENT NO-WRITES，重连后重新开启
+	multi    bool   // 是否处于 MULTI 之后
+	watching bool   // 是否有 WATCH 的key
+}
+
+// 建立连接，完成 TLS 握手（tlsConfig 不为 nil 时）、协议握手及认证，readonly 为 true 时拒绝修改数据的命令
+func dial(network, addr, auth string, tlsConfig *tls.Config, readonly bool) (*client, error) {
+	c := &client{network: network, addr: addr, auth: auth, tls: tlsConfig, readonly: readonly}
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *client) connect() error {
+	conn, err := net.Dial(c.network, c.addr)
+	if err != nil {
+		return err
+	}
+	if c.tls != nil {
+		tlsConn := tls.Client(conn, c.tls)
+		if err = tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return fmt.Errorf("tls handshake err: %v", err)
+		}
+		conn = tlsConn
+	}
+	c.conn, c.reader = conn, bufio.NewReader(conn)
+
+	// 先握手，确认服务端使用相同版本的协议，避免错误地解析响应
+	c.reqId++
+	if err = handshake(conn, c.reader, c.reqId); err != nil {
+		conn.Close()
+		return fmt.Errorf("handshake err: %v", err)
+	}
+
+	if c.auth != "" {
+		reply, err := c.do(c.auth, nil)
+		if err == nil {
+			if e, ok := reply.(protocol.Error); ok {
+				err = errors.New(string(e))
+			}
+		}
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("auth err: %v", err)
+		}
+	}
+
+	// 只读的会话在每次连接后都需要开启，否则重连后可以执行写命令
+	if c.readonly {
+		reply, err := c.do("client no-writes on", nil)
+		if err == nil {
+			if e, ok := reply.(protocol.Error); ok {
+				err = errors.New(string(e))
+			}
+		}
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("enable no-writes err: %v", err)
+		}
+	}
+	return nil
+}
+
+// 发送一条命令并读取它的响应，onItem 不为 nil 时多值响应的元素逐个交给 onItem，返回的响应为 nil
+func (c *client) do(cmd string, onItem func(protocol.Reply) error) (protocol.Reply, error) {
+	c.reqId++
+	if _, err := c.conn.Write(protocol.EncodeRequest(c.reqId, cmd)); err != nil { // 带上请求id发送给服务端
+		return nil, err
+	}
+	if onItem != nil {
+		return streamReply(c.reader, c.reqId, onItem)
+	}
+	return readReply(c.reader, c.reqId)
+}
+
+// 执行命令，连接断开时重连并重试一次，已经逐个输出了部分元素时不再重试
+// 命令可能在连接断开前已经被服务端执行，因此重试后非幂等的命令（如 LPUSH）可能被执行两次
+func (c *client) exec(cmd string, args []string, onItem func(protocol.Reply) error) (protocol.Reply, error) {
+	var streamed bool
+	if onItem != nil {
+		fn := onItem
+		onItem = func(item protocol.Reply) error {
+			streamed = true
+			return fn(item)
+		}
+	}
+
+	reply, err := c.do(cmd, onItem)
+	if err != nil && isBroken(err) && !streamed {
+		lostTx := c.multi || c.watching
+		if err = c.reconnect(err); err != nil {
+			return nil, err
+		}
+		if lostTx {
+			return nil, ErrTxLost
+		}
+		reply, err = c.do(cmd, onItem)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.track(cmd, args, reply)
+	return reply, nil
+}
+
+// 执行由参数组成的命令，参数按原样发送，不受其中的空白字符及引号影响，服务端返回的错误作为 error 返回
+func (c *client) call(args ...string) (protocol.Reply, error) {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quoteArg(arg)
+	}
+	reply, err := c.exec(strings.Join(quoted, " "), args, nil)
+	if err != nil {
+		return nil, err
+	}
+	if e, isErr := reply.(protocol.Error); isErr {
+		return nil, errors.New(string(e))
+	}
+	return reply, nil
+}
+
+// 包含空白字符、引号等的参数用双引号括起，引号、反斜杠及不可打印的字节转义，服务端按 SplitArgs 的规则还原为原来的参数
+func quoteArg(arg string) string {
+	plain := arg != ""
+	for i := 0; i < len(arg) && plain; i++ {
+		c := arg[i]
+		plain = c > ' ' && c < 0x7f && c != '"' && c != '\'' && c != '\\'
+	}
+	if plain {
+		return arg
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(arg); i++ {
+		switch c := arg[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// 重新建立连接，重连失败时按指数退避再次尝试
+func (c *client) reconnect(cause error) error {
+	c.conn.Close()
+	c.multi, c.watching = false, false
+
+	fmt.Printf("connection lost: %v, reconnecting...\n", cause)
+	backoff := reconnectBackoff
+	var err error
+	for i := 0; i < reconnectAttempts; i++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		if err = c.connect(); err == nil {
+			fmt.Println("reconnected")
+			return nil
+		}
+		fmt.Printf("reconnect %d/%d failed: %v\n", i+1, reconnectAttempts, err)
+	}
+	return err
+}
+
+// 根据执行成功的命令记录需要在重连后恢复或者提示丢失的会话状态
+func (c *client) track(cmd string, args []string, reply protocol.Reply) {
+	if _, isErr := reply.(protocol.Error); isErr || len(args) == 0 {
+		return
+	}
+	switch strings.ToLower(args[0]) {
+	case "auth":
+		c.auth = cmd
+	case "multi":
+		c.multi = true
+	case "watch":
+		c.watching = true
+	case "exec", "discard":
+		c.multi, c.watching = false, false
+	case "unwatch":
+		if !c.multi {
+			c.watching = false
+		}
+	case "client":
+		if len(args) == 3 && strings.EqualFold(args[1], "no-writes") {
+			c.readonly = strings.EqualFold(args[2], "on")
+		}
+	}
+}
+
+// 判断错误是否是连接断开导致的
+func isBroken(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr)
+}
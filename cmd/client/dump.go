@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"mindb/cmd/protocol"
+	"os"
+	"strconv"
+	"time"
+)
+
+var dump = flag.Bool("dump", false, "write the keys matching -pattern to stdout as commands that -pipe can import, TTLs included")
+var ttlMode = flag.String("ttl-mode", "relative", "with -dump, write TTLs as the remaining seconds counted again from the import (relative), or as the time they expire at (absolute)")
+
+// 导出列表、集合时每条命令最多包含的元素数量
+const dumpChunk = 100
+
+// 导出匹配 -pattern 的key，每个key输出为一条或多条可以用 -pipe 导入的命令，返回进程的退出码
+// 字符串的过期时间随key一起导出：relative 时导出剩余的秒数，导入后重新计时，适合导出后很久才导入的缓存；
+// absolute 时导出过期的时刻，导入后在与原来相同的时刻过期，导入时已经过期的key不会被导入
+func runDump(c *client) int {
+	if *ttlMode != "relative" && *ttlMode != "absolute" {
+		fmt.Fprintln(os.Stderr, "unknown ttl mode: ", *ttlMode)
+		return 1
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	fmt.Fprintf(w, "# mindb dump of %s at %s, ttl-mode %s\n", c.addr, time.Now().Format(time.RFC3339), *ttlMode)
+
+	keys := 0
+	err := scanKeys(c, *pattern, "", false, func(item scannedKey) error {
+		cmds, err := dumpKey(c, item)
+		if err != nil {
+			return fmt.Errorf("dump %q err: %v", item.key, err)
+		}
+		for _, args := range cmds {
+			for i, arg := range args {
+				if i > 0 {
+					w.WriteByte(' ')
+				}
+				w.WriteString(quoteArg(arg))
+			}
+			w.WriteByte('\n')
+		}
+		if len(cmds) > 0 {
+			keys++
+		}
+		return nil
+	})
+	if err != nil {
+		w.Flush()
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "dumped %d keys\n", keys)
+	return 0
+}
+
+// 生成重建一个key的命令，key在遍历之后被删除时返回空
+func dumpKey(c *client, item scannedKey) ([][]string, error) {
+	key := item.key
+	if item.typ == "string" {
+		return dumpStr(c, key)
+	}
+
+	var cmds [][]string
+	switch item.typ {
+	case "list":
+		values, err := callStrings(c, "lrange", key, "0", "-1")
+		if err != nil {
+			return nil, err
+		}
+		cmds = chunkCmds("rpush", key, values)
+	case "hash":
+		pairs, err := callStrings(c, "hgetall", key)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i+1 < len(pairs); i += 2 {
+			cmds = append(cmds, []string{"hset", key, pairs[i], pairs[i+1]})
+		}
+	case "set":
+		members, err := callStrings(c, "smembers", key)
+		if err != nil {
+			return nil, err
+		}
+		cmds = chunkCmds("sadd", key, members)
+	case "zset":
+		pairs, err := callStrings(c, "zrange", key, "0", "-1") // 成员与分数交替排列
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i+1 < len(pairs); i += 2 {
+			cmds = append(cmds, []string{"zadd", key, pairs[i+1], pairs[i]})
+		}
+	default:
+		return nil, errors.New("unknown type " + item.typ)
+	}
+	return cmds, nil
+}
+
+// 字符串导出为 SET，有过期时间时再加上 EXPIRE 或 EXPIREAT
+func dumpStr(c *client, key string) ([][]string, error) {
+	reply, err := c.call("get", key)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := reply.(protocol.Bulk)
+	if !ok || value == nil {
+		return nil, nil
+	}
+	cmds := [][]string{{"set", key, string(value)}}
+
+	reply, err = c.call("ttl", key)
+	if err != nil {
+		return nil, err
+	}
+	ttl, _ := reply.(protocol.Integer)
+	if ttl > 0 {
+		if *ttlMode == "absolute" {
+			deadline := time.Now().Unix() + int64(ttl)
+			cmds = append(cmds, []string{"expireat", key, strconv.FormatInt(deadline, 10)})
+		} else {
+			cmds = append(cmds, []string{"expire", key, strconv.FormatInt(int64(ttl), 10)})
+		}
+	}
+	return cmds, nil
+}
+
+// 执行返回多个字符串的命令
+func callStrings(c *client, args ...string) ([]string, error) {
+	reply, err := c.call(args...)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.(protocol.Array)
+	if !ok {
+		return nil, errors.New("unexpected reply: " + renderReply(reply))
+	}
+	res := make([]string, 0, len(items))
+	for _, item := range items {
+		b, ok := item.(protocol.Bulk)
+		if !ok {
+			return nil, errors.New("unexpected reply: " + renderReply(reply))
+		}
+		res = append(res, string(b))
+	}
+	return res, nil
+}
+
+// 将元素分成多条命令，每条最多 dumpChunk 个元素
+func chunkCmds(cmd, key string, values []string) (cmds [][]string) {
+	for len(values) > 0 {
+		n := len(values)
+		if n > dumpChunk {
+			n = dumpChunk
+		}
+		cmds = append(cmds, append([]string{cmd, key}, values[:n]...))
+		values = values[n:]
+	}
+	return
+}
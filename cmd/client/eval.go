@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"mindb/cmd/protocol"
+	"os"
+	"strings"
+)
+
+var eval = flag.String("eval", "", "run the commands in the file, one per line, and print the result of each")
+var evalTx = flag.Bool("tx", false, "with -eval, run all commands of the file in a single MULTI/EXEC transaction")
+
+// 脚本中的一条命令
+type scriptCmd struct {
+	lineNo int
+	cmd    string
+	args   []string
+}
+
+// 读取脚本文件，每行一条命令，空行及 # 开头的行被忽略，命令的参数规则与交互模式相同
+func readScript(path string) ([]scriptCmd, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cmds []scriptCmd
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), pipeMaxLine)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		cmd := strings.TrimSpace(scanner.Text())
+		if cmd == "" || strings.HasPrefix(cmd, "#") {
+			continue
+		}
+		args, err := protocol.SplitArgs(cmd)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		cmds = append(cmds, scriptCmd{lineNo: lineNo, cmd: cmd, args: args})
+	}
+	return cmds, scanner.Err()
+}
+
+// 执行脚本文件中的命令并依次输出每条命令的结果，返回进程的退出码：全部成功时为 0
+// 默认逐条执行，某条命令出错时继续执行之后的命令；tx 为 true 时所有命令在一个事务中执行，有命令无法排队时放弃整个事务
+func runEval(c *client, path string, tx bool) int {
+	cmds, err := readScript(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "eval err: ", err)
+		return 1
+	}
+	if tx {
+		return runEvalTx(c, cmds)
+	}
+
+	failures := 0
+	for _, sc := range cmds {
+		reply, err := c.exec(sc.cmd, sc.args, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "line %d: %v\n", sc.lineNo, err)
+			return 1
+		}
+		fmt.Println(renderReply(reply))
+		if e, isErr := reply.(protocol.Error); isErr {
+			failures++
+			fmt.Fprintf(os.Stderr, "line %d: %s\n", sc.lineNo, string(e))
+		}
+	}
+	if failures > 0 {
+		return 1
+	}
+	return 0
+}
+
+func runEvalTx(c *client, cmds []scriptCmd) int {
+	reply, err := c.exec("multi", []string{"multi"}, nil)
+	if e, isErr := reply.(protocol.Error); isErr {
+		err = errors.New(string(e))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "multi err: ", err)
+		return 1
+	}
+
+	failed := false
+	for _, sc := range cmds {
+		reply, err := c.exec(sc.cmd, sc.args, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "line %d: %v\n", sc.lineNo, err)
+			return 1
+		}
+		if e, isErr := reply.(protocol.Error); isErr {
+			failed = true
+			fmt.Fprintf(os.Stderr, "line %d: %s\n", sc.lineNo, string(e))
+		}
+	}
+	if failed {
+		_, _ = c.exec("discard", []string{"discard"}, nil)
+		fmt.Fprintln(os.Stderr, "transaction discarded")
+		return 1
+	}
+
+	reply, err = c.exec("exec", []string{"exec"}, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "exec err: ", err)
+		return 1
+	}
+	replies, ok := reply.(protocol.Array)
+	if !ok { // 事务被放弃，如 WATCH 的key被修改
+		fmt.Println(renderReply(reply))
+		return 1
+	}
+
+	code := 0
+	for i, r := range replies {
+		fmt.Println(renderReply(r))
+		if e, isErr := r.(protocol.Error); isErr && i < len(cmds) {
+			code = 1
+			fmt.Fprintf(os.Stderr, "line %d: %s\n", cmds[i].lineNo, string(e))
+		}
+	}
+	return code
+}
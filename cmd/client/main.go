@@ -2,13 +2,15 @@ package main
 
 import (
 	"bufio"
-	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/peterh/liner"
 	"log"
+	"mindb/cmd/protocol"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -19,14 +21,24 @@ var commandList = [][]string{
 	{"SETNX", "key value", "STRING"},
 	{"GETSET", "key value", "STRING"},
 	{"APPEND", "key value", "STRING"},
+	{"SETRANGE", "key offset value", "STRING"},
 	{"STRLEN", "key", "STRING"},
 	{"STREXISTS", "key", "STRING"},
 	{"STRREM", "key", "STRING"},
+	{"UNDELETE", "key", "STRING"},
+	{"PURGE", "key [key...]", "STRING"},
+	{"TRASH", "[PURGE]", "STRING"},
 	{"PREFIXSCAN", "prefix limit offset", "STRING"},
 	{"RANGESCAN", "start end", "STRING"},
-	{"EXPIRE", "key seconds", "STRING"},
-	{"PERSIST", "key", "STRING"},
+	{"EXPIRE", "key seconds [key seconds...]", "STRING"},
+	{"EXPIREAT", "key timestamp", "STRING"},
+	{"PERSIST", "key [key...]", "STRING"},
 	{"TTL", "key", "STRING"},
+	{"RATELIMIT", "key limit window_seconds", "STRING"},
+
+	{"LOCK", "key ttl [timeout]", "LOCK"},
+	{"RENEWLOCK", "key token ttl", "LOCK"},
+	{"UNLOCK", "key token", "LOCK"},
 
 	{"LPUSH", "key value [value...]", "LIST"},
 	{"RPUSH", "key value [value...]", "LIST"},
@@ -42,8 +54,9 @@ var commandList = [][]string{
 
 	{"HSET", "key field value", "HASH"},
 	{"HSETNX", "key field value", "HASH"},
+	{"HINCRBY", "key field increment", "HASH"},
 	{"HGET", "key field", "HASH"},
-	{"HGETALL", "key", "HASH"},
+	{"HGETALL", "key [LIMIT offset count]", "HASH"},
 	{"HDEL", "key field [field...]", "HASH"},
 	{"HEXISTS", "key field", "HASH"},
 	{"HLEN", "key", "HASH"},
@@ -57,7 +70,7 @@ var commandList = [][]string{
 	{"SREM", "key members [members...]", "SET"},
 	{"SMOVE", "src dst member", "SET"},
 	{"SCARD", "key", "key", "SET"},
-	{"SMEMBERS", "key", "SET"},
+	{"SMEMBERS", "key [LIMIT offset count]", "SET"},
 	{"SUNION", "key [key...]", "SET"},
 	{"SDIFF", "key [key...]", "SET"},
 
@@ -67,30 +80,133 @@ var commandList = [][]string{
 	{"ZRANK", "key member", "ZSET"},
 	{"ZREVRANK", "key member", "ZSET"},
 	{"ZINCRBY", "key increment member", "ZSET"},
-	{"ZRANGE", "key start stop", "ZSET"},
-	{"ZREVRANGE", "key start stop", "ZSET"},
+	{"ZRANGE", "key start stop [LIMIT offset count]", "ZSET"},
+	{"ZREVRANGE", "key start stop [LIMIT offset count]", "ZSET"},
 	{"ZREM", "key member", "ZSET"},
 	{"ZGETBYRANK", "key rank", "ZSET"},
 	{"ZREVGETBYRANK", "key rank", "ZSET"},
 	{"ZSCORERANGE", "key min max", "ZSET"},
 	{"ZREVSCORERANGE", "key max min", "ZSET"},
+
+	{"TOPK.RESERVE", "key topk [width depth]", "TOPK"},
+	{"TOPK.ADD", "key item [item...]", "TOPK"},
+	{"TOPK.QUERY", "key item [item...]", "TOPK"},
+	{"TOPK.COUNT", "key item [item...]", "TOPK"},
+	{"TOPK.LIST", "key [WITHCOUNT]", "TOPK"},
+
+	{"BF.RESERVE", "key error_rate capacity", "BLOOM"},
+	{"BF.ADD", "key item", "BLOOM"},
+	{"BF.EXISTS", "key item", "BLOOM"},
+
+	{"CF.RESERVE", "key capacity", "CUCKOO"},
+	{"CF.ADD", "key item", "CUCKOO"},
+	{"CF.DEL", "key item", "CUCKOO"},
+	{"CF.EXISTS", "key item", "CUCKOO"},
+
+	{"JSON.GET", "key [path]", "JSON"},
+	{"JSON.SET", "key path value", "JSON"},
+
+	{"QPUSH", "queue payload [payload...]", "QUEUE"},
+	{"QPUSHAT", "queue timestamp payload", "QUEUE"},
+	{"QDELAY", "queue seconds payload", "QUEUE"},
+	{"QPOP", "queue [visibility]", "QUEUE"},
+	{"BQPOP", "queue visibility timeout", "QUEUE"},
+	{"QACK", "queue id [id...]", "QUEUE"},
+	{"QLEN", "queue", "QUEUE"},
+
+	{"EXISTS", "key [key...]", "KEYS"},
+	{"TYPE", "key [key...]", "KEYS"},
+	{"SCAN", "cursor [MATCH pattern] [COUNT count] [TYPE type] [WITHSIZES]", "KEYS"},
+	{"KEYMETA", "key", "KEYS"},
+
+	{"AUTH", "[username] password", "CONNECTION"},
+	{"HELLO", "[protover]", "CONNECTION"},
+	{"PING", "[message]", "CONNECTION"},
+	{"CLIENT", "NO-WRITES on|off", "CONNECTION"},
+	{"ECHO", "message", "CONNECTION"},
+	{"ACL", "SETUSER|GETUSER|DELUSER|LIST|USERS|WHOAMI [args...]", "SERVER"},
+	{"INFO", "[section]", "SERVER"},
+	{"CONFIG", "GET pattern [pattern...]|SET parameter value|REWRITE", "SERVER"},
+	{"SHUTDOWN", "", "SERVER"},
+	{"MONITOR", "", "SERVER"},
+	{"SLOWLOG", "GET [count]|LEN|RESET", "SERVER"},
+	{"HOTKEYS", "[count]|RESET", "SERVER"},
+	{"CHANGES", "[from_seq]", "SERVER"},
+	{"SYNC", "", "SERVER"},
+	{"REPLCONF", "ACK offset", "SERVER"},
+	{"WAIT", "numreplicas timeout", "SERVER"},
+	{"SEGMENTS", "", "SERVER"},
+	{"SEGMENT", "name offset count", "SERVER"},
+	{"VIEW", "CREATE name LEADERBOARD target prefix field|CREATE name COUNT target prefix|DROP name|LIST", "SERVER"},
+
+	{"SUBSCRIBE", "channel [channel...]", "PUBSUB"},
+	{"PSUBSCRIBE", "pattern [pattern...]", "PUBSUB"},
+	{"UNSUBSCRIBE", "[channel...]", "PUBSUB"},
+	{"PUNSUBSCRIBE", "[pattern...]", "PUBSUB"},
+	{"PUBLISH", "channel message", "PUBSUB"},
+
+	{"MULTI", "", "TRANSACTION"},
+	{"EXEC", "", "TRANSACTION"},
+	{"DISCARD", "", "TRANSACTION"},
+	{"WATCH", "key [key...]", "TRANSACTION"},
+	{"UNWATCH", "", "TRANSACTION"},
 }
 
 var host = flag.String("h", "127.0.0.1", "the mindb server host, default 127.0.0.1")
 var port = flag.Int("p", 5200, "the mindb server port, default 5200")
-
-const cmdHistoryPath = "/tmp/mindb-cli"
+var password = flag.String("a", "", "password to use when connecting to the server, see also -askpass and "+passwordEnv)
+var user = flag.String("user", "", "username to authenticate with, default user if empty")
+var socket = flag.String("s", "", "the unix socket of the mindb server, overrides -h and -p")
+var readonly = flag.Bool("readonly", false, "reject all commands that modify data on this connection, to look around production data safely")
+var pipe = flag.Bool("pipe", false, "read newline-delimited commands from stdin and send them with pipelining, executed in input order")
 
 func main() {
 	flag.Parse() // 解析配置
+	if !validOutput(*output) {
+		log.Println("unknown output format: ", *output)
+		return
+	}
 
-	addr := fmt.Sprintf("%s:%d", *host, *port)
-	conn, err := net.Dial("tcp", addr) // 与服务器建立连接
+	network, addr := "tcp", fmt.Sprintf("%s:%d", *host, *port)
+	if *socket != "" {
+		network, addr = "unix", *socket
+	}
+
+	tlsConfig, err := newTLSConfig()
+	if err != nil {
+		log.Println("tls err: ", err)
+		return
+	}
+	pw, err := resolvePassword()
 	if err != nil {
-		log.Println("tcp dial err: ", err)
+		log.Println("read password err: ", err)
+		return
+	}
+	c, err := dial(network, addr, authCommand(pw), tlsConfig, *readonly) // 与服务器建立连接，连接后先进行认证
+	if err != nil {
+		log.Println(network+" connect err: ", err)
 		return
 	}
 
+	if *pipe { // 批量导入模式，不进入交互
+		os.Exit(runPipe(c.conn, c.reader, c.reqId))
+	}
+	if *eval != "" { // 执行脚本文件中的命令，不进入交互
+		os.Exit(runEval(c, *eval, *evalTx))
+	}
+	if *bigKeys { // 统计每种类型中最大的key，不进入交互
+		os.Exit(runBigKeys(c))
+	}
+	if *scan { // 输出匹配的key，不进入交互
+		os.Exit(runScan(c))
+	}
+	if *dump { // 导出匹配的key，不进入交互
+		os.Exit(runDump(c))
+	}
+	if *ttlAction != "" { // 列出或调整过期时间，不进入交互
+		os.Exit(runTTL(c))
+	}
+
 	line := liner.NewLiner()
 	defer line.Close()
 
@@ -105,27 +221,28 @@ func main() {
 	})
 
 	// open and save cmd history
-	if f, err := os.Open(cmdHistoryPath); err == nil {
-		line.ReadHistory(f)
-		f.Close()
-	}
-	defer func() {
-		if f, err := os.Create(cmdHistoryPath); err != nil {
-			fmt.Printf("writing cmd history err: %v\n", err)
-		} else {
-			line.WriteHistory(f)
+	if path := historyPath(addr); path != "" {
+		if f, err := os.Open(path); err == nil {
+			line.ReadHistory(f)
 			f.Close()
 		}
-	}()
+		defer func() {
+			if f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600); err != nil {
+				fmt.Printf("writing cmd history err: %v\n", err)
+			} else {
+				line.WriteHistory(f)
+				f.Close()
+			}
+		}()
+	}
 
 	commandSet := map[string]bool{}
 	for _, cmd := range commandList {
 		commandSet[strings.ToLower(cmd[0])] = true
 	}
 
-	prompt := addr + ">"
 	for {
-		cmd, err := line.Prompt(prompt)
+		cmd, err := line.Prompt(renderPrompt(c))
 		if err != nil {
 			fmt.Println(err)
 			break
@@ -136,14 +253,18 @@ func main() {
 			continue
 		}
 		lowerCmd := strings.ToLower(cmd)
-		c := strings.Split(cmd, " ")
+		args, err := protocol.SplitArgs(cmd)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
 
 		if lowerCmd == "help" {
 			printCmdHelp()
 		} else if lowerCmd == "quit" {
 			break
-		} else if strings.ToLower(c[0]) == "help" && len(c) == 2 {
-			helpCmd := strings.ToLower(c[1])
+		} else if strings.ToLower(args[0]) == "help" && len(args) == 2 {
+			helpCmd := strings.ToLower(args[1])
 			if !commandSet[helpCmd] {
 				fmt.Println("command not found")
 				continue
@@ -159,19 +280,32 @@ func main() {
 		} else {
 			line.AppendHistory(cmd)
 
-			lowerC := strings.ToLower(strings.TrimSpace(c[0]))
+			lowerC := strings.ToLower(args[0])
 			if !commandSet[lowerC] && lowerC != "quit" {
 				continue
 			}
 
-			wInfo := wrapCmdInfo(cmd)   // 获取到命令
-			_, err := conn.Write(wInfo) // 发送给服务端
+			printer := newStreamPrinter()
+			reply, err := c.exec(cmd, args, printer.handler()) // 连接断开时自动重连，很大的多值响应逐个输出
+			if err == nil && promptAuth(line, c, reply) {
+				// 服务端要求认证，输入密码认证后重新执行
+				reply, err = c.exec(cmd, args, printer.handler())
+			}
 			if err != nil {
+				printer.flush()
 				fmt.Println(err)
+				continue
+			}
+			if reply == nil {
+				printer.end()
+			} else {
+				fmt.Println(renderReply(reply))
 			}
 
-			reply := readReply(conn) // 读取响应
-			fmt.Println(reply)
+			if lowerC == "subscribe" || lowerC == "psubscribe" || ((lowerC == "changes" || lowerC == "sync") && reply == protocol.SimpleString("OK")) {
+				printMessages(c.reader) // 进入订阅模式，持续输出收到的消息或数据变更
+				break
+			}
 		}
 	}
 }
@@ -187,26 +321,88 @@ func printCmdHelp() {
 	fmt.Println(help)
 }
 
-func wrapCmdInfo(cmd string) []byte {
-	b := make([]byte, len(cmd)+4)
-	binary.BigEndian.PutUint32(b[:4], uint32(len(cmd)))
-	copy(b[4:], cmd)
-	return b
+// 发送 HELLO 进行握手，服务端不支持客户端的协议版本时返回错误
+// 不认识 HELLO 的旧版本服务端使用的是第一版协议，可以继续使用
+func handshake(conn net.Conn, reader *bufio.Reader, id uint32) error {
+	if _, err := conn.Write(protocol.EncodeRequest(id, "hello "+strconv.Itoa(protocol.Version))); err != nil {
+		return err
+	}
+	reply, err := readReply(reader, id)
+	if err != nil {
+		return err
+	}
+	if e, ok := reply.(protocol.Error); ok && strings.HasPrefix(string(e), "NOPROTO") {
+		return errors.New(string(e))
+	}
+	return nil
 }
 
-func readReply(conn net.Conn) (res string) {
-	reader := bufio.NewReader(conn)
+// 读取请求id为id的响应，之前请求遗留的响应会被丢弃
+func readReply(reader *bufio.Reader, id uint32) (protocol.Reply, error) {
+	for {
+		respId, reply, err := protocol.ReadResponse(reader)
+		if err != nil || respId == id {
+			return reply, err
+		}
+	}
+}
 
-	b := make([]byte, 4)
-	_, err := reader.Read(b)
-	if err != nil {
-		return
+// 读取请求id为id的响应，多值响应的元素逐个交给 onItem，不在内存中保留整个响应
+func streamReply(reader *bufio.Reader, id uint32, onItem func(protocol.Reply) error) (protocol.Reply, error) {
+	for {
+		respId, err := protocol.ReadResponseId(reader)
+		if err != nil {
+			return nil, err
+		}
+		if respId == id {
+			return protocol.StreamFrame(reader, onItem)
+		}
+		if _, err = protocol.ReadFrame(reader); err != nil { // 之前请求遗留的响应
+			return nil, err
+		}
 	}
-	size := binary.BigEndian.Uint32(b)
-	if size > 0 {
-		data := make([]byte, size)
-		reader.Read(data)
-		res = string(data)
+}
+
+// 持续读取并输出服务端推送的订阅消息，直到连接断开
+func printMessages(reader *bufio.Reader) {
+	fmt.Println("Reading messages... (press Ctrl-C to quit)")
+	for {
+		id, reply, err := protocol.ReadResponse(reader)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if id == protocol.PushId {
+			fmt.Println(renderReply(reply))
+		}
+	}
+}
+
+// 按照响应的类型格式化输出，多值响应的每个元素前加上序号
+func formatReply(reply protocol.Reply, indent string) string {
+	switch r := reply.(type) {
+	case protocol.Error:
+		return "(error) " + string(r)
+	case protocol.Integer:
+		return "(integer) " + strconv.FormatInt(int64(r), 10)
+	case protocol.Bulk:
+		if r == nil {
+			return "(nil)"
+		}
+		return string(r)
+	case protocol.Array:
+		if len(r) == 0 {
+			return "(empty list or set)"
+		}
+		var b strings.Builder
+		for i, item := range r {
+			if i > 0 {
+				b.WriteString("\n" + indent)
+			}
+			prefix := strconv.Itoa(i+1) + ") "
+			b.WriteString(prefix + formatReply(item, indent+strings.Repeat(" ", len(prefix))))
+		}
+		return b.String()
 	}
-	return
+	return ""
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"mindb/cmd/protocol"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var output = flag.String("output", "", "the output format of replies: json, csv or raw, human readable if empty")
+
+// 支持的输出格式
+func validOutput(format string) bool {
+	switch format {
+	case "", "json", "csv", "raw":
+		return true
+	}
+	return false
+}
+
+// 按照 -output 指定的格式输出响应，便于交给 jq、表格等其他工具处理
+// json：每个响应输出为一行 JSON，数组为 JSON 数组，空值为 null，错误为 {"error": "..."}
+// csv：每个响应输出为一行，数组中的元素（嵌套的数组展开）各占一列
+// raw：只输出值本身，数组的每个元素占一行，空值为空行
+func renderReply(reply protocol.Reply) string {
+	switch *output {
+	case "json":
+		b, _ := json.Marshal(jsonValue(reply))
+		return string(b)
+	case "csv":
+		var b strings.Builder
+		w := csv.NewWriter(&b)
+		_ = w.Write(csvFields(reply, nil))
+		w.Flush()
+		return strings.TrimSuffix(b.String(), "\n")
+	case "raw":
+		return rawReply(reply)
+	}
+	return formatReply(reply, "")
+}
+
+func jsonValue(reply protocol.Reply) interface{} {
+	switch r := reply.(type) {
+	case protocol.SimpleString:
+		return string(r)
+	case protocol.Error:
+		return map[string]string{"error": string(r)}
+	case protocol.Integer:
+		return int64(r)
+	case protocol.Bulk:
+		if r == nil {
+			return nil
+		}
+		return string(r)
+	case protocol.Array:
+		if r == nil {
+			return nil
+		}
+		values := make([]interface{}, 0, len(r))
+		for _, item := range r {
+			values = append(values, jsonValue(item))
+		}
+		return values
+	}
+	return nil
+}
+
+// 逐个输出多值响应的元素，输出的格式与 renderReply 相同，很大的响应（如上百万个元素的 LRANGE）不需要全部保存在内存中
+type streamPrinter struct {
+	w *bufio.Writer
+	n int // 已经输出的元素个数
+}
+
+func newStreamPrinter() *streamPrinter {
+	return &streamPrinter{w: bufio.NewWriter(os.Stdout)}
+}
+
+// 返回逐个输出元素的函数，csv 格式的一行需要所有元素，不能逐个输出，返回 nil
+func (p *streamPrinter) handler() func(protocol.Reply) error {
+	if *output == "csv" {
+		return nil
+	}
+	return p.item
+}
+
+func (p *streamPrinter) item(reply protocol.Reply) error {
+	var err error
+	switch *output {
+	case "json":
+		sep := ","
+		if p.n == 0 {
+			sep = "["
+		}
+		b, _ := json.Marshal(jsonValue(reply))
+		_, err = p.w.WriteString(sep + string(b))
+	case "raw":
+		_, err = p.w.WriteString(rawReply(reply) + "\n")
+	default:
+		prefix := strconv.Itoa(p.n+1) + ") "
+		_, err = p.w.WriteString(prefix + formatReply(reply, strings.Repeat(" ", len(prefix))) + "\n")
+	}
+	p.n++
+	return err
+}
+
+// 所有元素输出完成
+func (p *streamPrinter) end() {
+	switch *output {
+	case "json":
+		if p.n == 0 {
+			_, _ = p.w.WriteString("[")
+		}
+		_, _ = p.w.WriteString("]\n")
+	case "raw":
+		if p.n == 0 {
+			_, _ = p.w.WriteString("\n")
+		}
+	default:
+		if p.n == 0 {
+			_, _ = p.w.WriteString("(empty list or set)\n")
+		}
+	}
+	p.flush()
+}
+
+// 输出缓冲的内容，读取响应中途出错时在输出错误之前调用
+func (p *streamPrinter) flush() {
+	_ = p.w.Flush()
+}
+
+func csvFields(reply protocol.Reply, fields []string) []string {
+	if arr, ok := reply.(protocol.Array); ok {
+		for _, item := range arr {
+			fields = csvFields(item, fields)
+		}
+		return fields
+	}
+	return append(fields, rawReply(reply))
+}
+
+func rawReply(reply protocol.Reply) string {
+	switch r := reply.(type) {
+	case protocol.SimpleString:
+		return string(r)
+	case protocol.Error:
+		return string(r)
+	case protocol.Integer:
+		return strconv.FormatInt(int64(r), 10)
+	case protocol.Bulk:
+		return string(r)
+	case protocol.Array:
+		lines := make([]string, 0, len(r))
+		for _, item := range r {
+			lines = append(lines, rawReply(item))
+		}
+		return strings.Join(lines, "\n")
+	}
+	return ""
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"mindb/cmd/protocol"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 批量导入时最多等待响应的请求数，达到后暂停发送，避免服务端的响应堆积
+	pipeWindow = 1024
+
+	// 一行命令的最大长度
+	pipeMaxLine = 64 << 20
+)
+
+// 从标准输入读取每行一条的命令，不等待响应连续发送给服务端，空行及 # 开头的行被忽略
+// 服务端按发送的顺序逐条执行同一连接上的请求，因此对同一个key的多次写入、MULTI/EXEC 等依赖顺序的输入与逐条执行的结果相同；
+// 一行命令长于服务端的 max_request_size 时服务端会断开连接，之后的命令不再执行
+// 返回错误的命令连同行号输出到标准错误，结束后输出统计，返回进程的退出码：全部成功时为 0
+func runPipe(conn net.Conn, reader *bufio.Reader, lastId uint32) int {
+	start := time.Now()
+	window := make(chan struct{}, pipeWindow)
+	stopped := make(chan struct{})
+
+	var (
+		mu       sync.Mutex
+		lines    = make(map[uint32]int) // 等待响应的请求id -> 输入的行号
+		replies  int
+		failures int
+		readErr  error
+	)
+
+	// 读取响应，每收到一个响应窗口中空出一个位置
+	go func() {
+		defer close(stopped)
+		for {
+			id, reply, err := protocol.ReadResponse(reader)
+			if err != nil {
+				readErr = err
+				return
+			}
+			mu.Lock()
+			lineNo, ok := lines[id]
+			delete(lines, id)
+			mu.Unlock()
+			if !ok { // 推送的消息或者其他请求的响应
+				continue
+			}
+
+			replies++
+			if e, isErr := reply.(protocol.Error); isErr {
+				failures++
+				fmt.Fprintf(os.Stderr, "line %d: %s\n", lineNo, string(e))
+			}
+			<-window
+		}
+	}()
+
+	writer := bufio.NewWriter(conn)
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), pipeMaxLine)
+
+	var (
+		err    error
+		sent   int
+		lineNo int
+		id     = lastId
+	)
+send:
+	for scanner.Scan() {
+		lineNo++
+		cmd := strings.TrimSpace(scanner.Text())
+		if cmd == "" || strings.HasPrefix(cmd, "#") {
+			continue
+		}
+
+		select {
+		case window <- struct{}{}:
+		default: // 窗口已满，先发出缓冲的请求再等待响应
+			if err = writer.Flush(); err != nil {
+				break send
+			}
+			select {
+			case window <- struct{}{}:
+			case <-stopped:
+				break send
+			}
+		}
+
+		id++
+		mu.Lock()
+		lines[id] = lineNo
+		mu.Unlock()
+		if _, err = writer.Write(protocol.EncodeRequest(id, cmd)); err != nil {
+			break send
+		}
+		sent++
+	}
+	if err == nil {
+		err = scanner.Err()
+	}
+	if flushErr := writer.Flush(); err == nil {
+		err = flushErr
+	}
+
+	// 占满整个窗口即所有请求都已收到响应
+	for i := 0; err == nil && i < pipeWindow; i++ {
+		select {
+		case window <- struct{}{}:
+		case <-stopped:
+			i = pipeWindow
+		}
+	}
+	_ = conn.SetReadDeadline(time.Now()) // 结束读取响应的goroutine
+	<-stopped
+
+	if err == nil && replies < sent {
+		err = readErr
+	}
+	elapsed := time.Since(start)
+	fmt.Printf("sent: %d, replies: %d, errors: %d, elapsed: %s, %.0f commands/s\n",
+		sent, replies, failures, elapsed.Round(time.Millisecond), float64(replies)/elapsed.Seconds())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "pipe err: ", err)
+	}
+	if err != nil || failures > 0 || replies < sent {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// 默认的命令提示符格式
+	defaultPrompt = "{addr}>"
+
+	// 历史命令目录的默认位置，位于用户的主目录下
+	defaultHistoryDir = ".mindb-cli"
+)
+
+var promptFormat = flag.String("prompt", os.Getenv("MINDB_CLI_PROMPT"),
+	"the prompt format, {addr} {host} {port} {user} and {tx} are replaced, default \""+defaultPrompt+"\", or from MINDB_CLI_PROMPT")
+var historyDir = flag.String("history-dir", os.Getenv("MINDB_CLI_HISTORY_DIR"),
+	"the directory of the command history, one file per server address, default ~/"+defaultHistoryDir+", or from MINDB_CLI_HISTORY_DIR")
+
+// 生成命令提示符，{tx} 在 MULTI 之后替换为 (TX)，其他情况下为空
+func renderPrompt(c *client) string {
+	format := *promptFormat
+	if format == "" {
+		format = defaultPrompt
+	}
+	tx := ""
+	if c.multi {
+		tx = "(TX)"
+	}
+	name := *user
+	if name == "" && *password != "" {
+		name = "default"
+	}
+	return strings.NewReplacer(
+		"{addr}", c.addr,
+		"{host}", *host,
+		"{port}", strconv.Itoa(*port),
+		"{user}", name,
+		"{tx}", tx,
+	).Replace(format)
+}
+
+// 连接某个地址时使用的历史命令文件，每个地址的历史命令分开保存，目录不存在时创建
+// 无法确定用户的主目录时返回空，不读写历史命令
+func historyPath(addr string) string {
+	dir := *historyDir
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, defaultHistoryDir)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return ""
+	}
+
+	// 地址中的 : 及 unix socket 路径中的 / 等不能作为文件名
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, addr)
+	return filepath.Join(dir, "history_"+name)
+}
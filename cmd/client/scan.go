@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"mindb/cmd/protocol"
+	"os"
+	"strconv"
+)
+
+var scan = flag.Bool("scan", false, "iterate the keyspace with SCAN and print the keys, one per line")
+var pattern = flag.String("pattern", "", "with -scan or -bigkeys, only the keys matching the glob-style pattern, e.g. 'user:*'")
+
+// 每次 SCAN 遍历的key数量
+const scanCount = 100
+
+// SCAN 返回的一个key，不带 WITHSIZES 时元素个数及字节数为 0
+type scannedKey struct {
+	keySize
+	typ string
+}
+
+// 输出所有匹配 -pattern 的key，每行一个
+func runScan(c *client) int {
+	err := scanKeys(c, *pattern, "", false, func(item scannedKey) error {
+		fmt.Println(item.key)
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "scan err: ", err)
+		return 1
+	}
+	return 0
+}
+
+// 使用 SCAN 以游标分批遍历所有匹配 pattern 的key，pattern 为空时遍历所有的key，typ 不为空时只遍历该类型的key
+// 每次只让服务端遍历 scanCount 个key，不会长时间阻塞服务端；fn 返回错误时停止遍历并返回该错误
+func scanKeys(c *client, pattern, typ string, withSizes bool, fn func(scannedKey) error) error {
+	cursor := "0"
+	for {
+		args := []string{"scan", cursor, "count", strconv.Itoa(scanCount)}
+		if pattern != "" {
+			args = append(args, "match", pattern)
+		}
+		if typ != "" {
+			args = append(args, "type", typ)
+		}
+		if withSizes {
+			args = append(args, "withsizes")
+		}
+
+		reply, err := c.call(args...)
+		if err != nil {
+			return err
+		}
+		next, keys, err := parseScanReply(reply)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err = fn(key); err != nil {
+				return err
+			}
+		}
+		if next == "0" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// 解析 SCAN 的响应：[下一次的游标, [[key, 类型], ...]]，带 WITHSIZES 时每个key还有元素个数及字节数
+func parseScanReply(reply protocol.Reply) (next string, keys []scannedKey, err error) {
+	errFormat := errors.New("unexpected scan reply: " + renderReply(reply))
+	arr, ok := reply.(protocol.Array)
+	if !ok || len(arr) != 2 {
+		return "", nil, errFormat
+	}
+	cursor, ok := arr[0].(protocol.Bulk)
+	items, ok2 := arr[1].(protocol.Array)
+	if !ok || !ok2 {
+		return "", nil, errFormat
+	}
+	for _, it := range items {
+		fields, ok := it.(protocol.Array)
+		if !ok || (len(fields) != 2 && len(fields) != 4) {
+			return "", nil, errFormat
+		}
+		key, ok1 := fields[0].(protocol.Bulk)
+		typ, ok2 := fields[1].(protocol.Bulk) // 简单字符串在帧中以二进制字符串传输
+		if !ok1 || !ok2 {
+			return "", nil, errFormat
+		}
+		item := scannedKey{keySize: keySize{key: string(key)}, typ: string(typ)}
+		if len(fields) == 4 {
+			count, ok3 := fields[2].(protocol.Integer)
+			size, ok4 := fields[3].(protocol.Integer)
+			if !ok3 || !ok4 {
+				return "", nil, errFormat
+			}
+			item.count, item.bytes = int64(count), int64(size)
+		}
+		keys = append(keys, item)
+	}
+	return string(cursor), keys, nil
+}
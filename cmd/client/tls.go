@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"io/ioutil"
+)
+
+var useTLS = flag.Bool("tls", false, "connect to the server with TLS")
+var caCert = flag.String("cacert", "", "with -tls, the CA certificate file to verify the server, the system CAs if empty")
+var cert = flag.String("cert", "", "with -tls, the client certificate file, for servers that verify client certificates")
+var key = flag.String("key", "", "with -tls, the private key file of the client certificate")
+var sni = flag.String("sni", "", "with -tls, the server name to verify the certificate against, default the -h host")
+var insecure = flag.Bool("insecure", false, "with -tls, do not verify the server certificate, for testing only")
+
+// 根据命令行参数创建连接服务端使用的 TLS 配置，没有指定 -tls 时返回 nil
+func newTLSConfig() (*tls.Config, error) {
+	if !*useTLS {
+		if *caCert != "" || *cert != "" || *key != "" {
+			return nil, errors.New("-cacert, -cert and -key require -tls")
+		}
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         *sni,
+		InsecureSkipVerify: *insecure,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if config.ServerName == "" && *socket == "" {
+		config.ServerName = *host
+	}
+
+	if *caCert != "" {
+		pem, err := ioutil.ReadFile(*caCert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no valid certificate found in " + *caCert)
+		}
+		config.RootCAs = pool
+	}
+
+	if (*cert == "") != (*key == "") {
+		return nil, errors.New("-cert and -key must be given together")
+	}
+	if *cert != "" {
+		pair, err := tls.LoadX509KeyPair(*cert, *key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config, nil
+}
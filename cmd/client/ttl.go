@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"mindb/cmd/protocol"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ttlAction = flag.String("ttl", "", "list or adjust the TTLs of the string keys matching -pattern: list, persist, set:<seconds> or add:<seconds>")
+
+// 列出或批量调整匹配 -pattern 的字符串key的过期时间，返回进程的退出码
+// list 输出每个key的剩余秒数，没有过期时间的输出 -；persist 清除过期时间；set:N 将过期时间设为 N 秒；
+// add:N 将已有的过期时间延长 N 秒（N 为负数时缩短），没有过期时间的key不受影响，缩短到不大于 0 时key被删除
+func runTTL(c *client) int {
+	action, arg := *ttlAction, 0
+	if i := strings.IndexByte(action, ':'); i >= 0 {
+		n, err := strconv.Atoi(action[i+1:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid ttl seconds: ", action[i+1:])
+			return 1
+		}
+		action, arg = action[:i], n
+	}
+	switch action {
+	case "list", "persist", "add":
+	case "set":
+		if arg <= 0 {
+			fmt.Fprintln(os.Stderr, "the ttl to set must be positive")
+			return 1
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "unknown ttl action: ", *ttlAction)
+		return 1
+	}
+
+	changed := 0
+	err := scanKeys(c, *pattern, "string", false, func(item scannedKey) error {
+		reply, err := c.call("ttl", item.key)
+		if err != nil {
+			return err
+		}
+		ttl, ok := reply.(protocol.Integer)
+		if !ok {
+			return errors.New("unexpected ttl reply: " + renderReply(reply))
+		}
+
+		var args []string
+		switch action {
+		case "list":
+			if ttl > 0 {
+				fmt.Printf("%s\t%d\n", item.key, ttl)
+			} else {
+				fmt.Printf("%s\t-\n", item.key)
+			}
+		case "persist":
+			if ttl > 0 {
+				args = []string{"persist", item.key}
+			}
+		case "set":
+			args = []string{"expire", item.key, strconv.Itoa(arg)}
+		case "add":
+			if ttl > 0 {
+				deadline := time.Now().Unix() + int64(ttl) + int64(arg)
+				args = []string{"expireat", item.key, strconv.FormatInt(deadline, 10)}
+			}
+		}
+		if args == nil {
+			return nil
+		}
+		if _, err = c.call(args...); err != nil {
+			return fmt.Errorf("%s %q err: %v", args[0], item.key, err)
+		}
+		changed++
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if action != "list" {
+		fmt.Printf("changed the ttl of %d keys\n", changed)
+	}
+	return 0
+}
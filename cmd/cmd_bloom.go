@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"mindb"
+	"mindb/cmd/protocol"
+	"strconv"
+)
+
+// BF.RESERVE key error_rate capacity
+func bfReserve(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) != 3 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	errorRate, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	capacity, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	if err = db.BFReserve([]byte(args[0]), errorRate, capacity); err == nil {
+		res = okReply
+	}
+	return
+}
+
+func bfAdd(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) != 2 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	var added bool
+	if added, err = db.BFAdd([]byte(args[0]), []byte(args[1])); err == nil {
+		res = boolReply(added)
+	}
+	return
+}
+
+func bfExists(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) != 2 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	var exists bool
+	if exists, err = db.BFExists([]byte(args[0]), []byte(args[1])); err == nil {
+		res = boolReply(exists)
+	}
+	return
+}
+
+func init() {
+	addExecCommand("bf.reserve", bfReserve)
+	addExecCommand("bf.add", bfAdd)
+	addExecCommand("bf.exists", bfExists)
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"mindb"
+	"mindb/cmd/protocol"
+	"strconv"
+)
+
+// CF.RESERVE key capacity
+func cfReserve(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) != 2 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	capacity, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	if err = db.CFReserve([]byte(args[0]), capacity); err == nil {
+		res = okReply
+	}
+	return
+}
+
+func cfAdd(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) != 2 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	if err = db.CFAdd([]byte(args[0]), []byte(args[1])); err == nil {
+		res = boolReply(true)
+	}
+	return
+}
+
+func cfDel(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) != 2 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	var deleted bool
+	if deleted, err = db.CFDel([]byte(args[0]), []byte(args[1])); err == nil {
+		res = boolReply(deleted)
+	}
+	return
+}
+
+func cfExists(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) != 2 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	var exists bool
+	if exists, err = db.CFExists([]byte(args[0]), []byte(args[1])); err == nil {
+		res = boolReply(exists)
+	}
+	return
+}
+
+func init() {
+	addExecCommand("cf.reserve", cfReserve)
+	addExecCommand("cf.add", cfAdd)
+	addExecCommand("cf.del", cfDel)
+	addExecCommand("cf.exists", cfExists)
+}
@@ -2,261 +2,159 @@ package cmd
 
 import (
 	"mindb"
+	"mindb/cmd/protocol"
 	"strconv"
 )
 
-func hSet(db *mindb.MinDB, args []string) (res string, err error) {
-
+func hSet(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 3 {
-
 		err = ErrSyntaxIncorrect
-
 		return
-
 	}
 
 	var count int
-
 	if count, err = db.HSet([]byte(args[0]), []byte(args[1]), []byte(args[2])); err == nil {
-
-		res = strconv.Itoa(count)
-
+		res = protocol.Integer(count)
 	}
-
 	return
-
 }
 
-func hSetNx(db *mindb.MinDB, args []string) (res string, err error) {
-
+func hSetNx(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 3 {
-
 		err = ErrSyntaxIncorrect
-
 		return
-
 	}
 
 	var ok bool
-
 	if ok, err = db.HSetNx([]byte(args[0]), []byte(args[1]), []byte(args[2])); err == nil {
+		res = boolReply(ok)
+	}
+	return
+}
 
-		if ok {
-
-			res = "1"
-
-		} else {
-
-			res = "0"
-
-		}
-
+func hIncrBy(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) != 3 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	increment, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		err = ErrSyntaxIncorrect
+		return
 	}
 
+	var val int64
+	if val, err = db.HIncrBy([]byte(args[0]), []byte(args[1]), increment); err == nil {
+		res = protocol.Integer(val)
+	}
 	return
-
 }
 
-func hGet(db *mindb.MinDB, args []string) (res string, err error) {
-
+func hGet(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 2 {
-
 		err = ErrSyntaxIncorrect
-
 		return
-
 	}
 
 	val := db.HGet([]byte(args[0]), []byte(args[1]))
-
-	if len(val) == 0 {
-
-		res = "<nil>"
-
-	} else {
-
-		res = string(val)
-
-	}
-
+	res = protocol.Bulk(val)
 	return
-
 }
 
-func hGetAll(db *mindb.MinDB, args []string) (res string, err error) {
-
-	if len(args) != 1 {
-
+// HGETALL key [LIMIT offset count]，指定 LIMIT 时按域的字节序分页返回
+func hGetAll(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) < 1 {
 		err = ErrSyntaxIncorrect
-
 		return
-
 	}
-
-	val := db.HGetAll([]byte(args[0]))
-
-	for i, v := range val {
-
-		res += string(v)
-
-		if i != len(val)-1 {
-
-			res += "\n"
-
-		}
-
+	offset, count, paged, err := parseLimit(args[1:])
+	if err != nil {
+		return
 	}
 
+	if paged {
+		res = multiBulk(db.HGetAllPage([]byte(args[0]), offset, count))
+		return
+	}
+	val := db.HGetAll([]byte(args[0]))
+	res = multiBulk(val)
 	return
-
 }
 
-func hDel(db *mindb.MinDB, args []string) (res string, err error) {
-
+func hDel(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) <= 1 {
-
 		err = ErrSyntaxIncorrect
-
 		return
-
 	}
 
 	var fields [][]byte
-
 	for _, f := range args[1:] {
-
 		fields = append(fields, []byte(f))
-
 	}
 
 	var count int
-
 	if count, err = db.HDel([]byte(args[0]), fields...); err == nil {
-
-		res = strconv.Itoa(count)
-
+		res = protocol.Integer(count)
 	}
-
 	return
-
 }
 
-func hExists(db *mindb.MinDB, args []string) (res string, err error) {
-
+func hExists(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 2 {
-
 		err = ErrSyntaxIncorrect
-
 		return
-
-	}
-
-	if exists := db.HExists([]byte(args[0]), []byte(args[1])); exists {
-
-		res = "1"
-
-	} else {
-
-		res = "0"
-
 	}
 
+	res = boolReply(db.HExists([]byte(args[0]), []byte(args[1])))
 	return
-
 }
 
-func hLen(db *mindb.MinDB, args []string) (res string, err error) {
-
+func hLen(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 1 {
-
 		err = ErrSyntaxIncorrect
-
 		return
-
 	}
 
 	count := db.HLen([]byte(args[0]))
-
-	res = strconv.Itoa(count)
-
+	res = protocol.Integer(count)
 	return
-
 }
 
-func hKeys(db *mindb.MinDB, args []string) (res string, err error) {
-
+func hKeys(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 1 {
-
 		err = ErrSyntaxIncorrect
-
 		return
-
 	}
 
 	val := db.HKeys([]byte(args[0]))
-
-	for i, v := range val {
-
-		res += v
-
-		if i != len(val)-1 {
-
-			res += "\n"
-
-		}
-
+	fields := make(protocol.Array, 0, len(val))
+	for _, v := range val {
+		fields = append(fields, protocol.Bulk(v))
 	}
-
+	res = fields
 	return
-
 }
 
-func hValues(db *mindb.MinDB, args []string) (res string, err error) {
-
+func hValues(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 1 {
-
 		err = ErrSyntaxIncorrect
-
 		return
-
 	}
 
 	val := db.HValues([]byte(args[0]))
-
-	for i, v := range val {
-
-		res += string(v)
-
-		if i != len(val)-1 {
-
-			res += "\n"
-
-		}
-
-	}
-
+	res = multiBulk(val)
 	return
-
 }
 
 func init() {
-
 	addExecCommand("hset", hSet)
-
 	addExecCommand("hsetnx", hSetNx)
-
+	addExecCommand("hincrby", hIncrBy)
 	addExecCommand("hget", hGet)
-
 	addExecCommand("hgetall", hGetAll)
-
 	addExecCommand("hdel", hDel)
-
 	addExecCommand("hexists", hExists)
-
 	addExecCommand("hlen", hLen)
-
 	addExecCommand("hkeys", hKeys)
-
 	addExecCommand("hvalues", hValues)
-
 }
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"mindb"
+	"mindb/cmd/protocol"
+)
+
+// JSON.GET key [path]
+func jsonGet(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) != 1 && len(args) != 2 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	path := "$"
+	if len(args) == 2 {
+		path = args[1]
+	}
+
+	var val []byte
+	if val, err = db.JSONGet([]byte(args[0]), path); err == nil {
+		res = protocol.Bulk(val)
+	} else if err == mindb.ErrKeyNotExist {
+		res, err = protocol.Bulk(nil), nil
+	}
+	return
+}
+
+// JSON.SET key path value
+func jsonSet(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) != 3 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	if err = db.JSONSet([]byte(args[0]), args[1], []byte(args[2])); err == nil {
+		res = okReply
+	}
+	return
+}
+
+func init() {
+	addExecCommand("json.get", jsonGet)
+	addExecCommand("json.set", jsonSet)
+}
@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"mindb"
+	"mindb/cmd/protocol"
+	"strconv"
+	"strings"
+)
+
+// 各数据类型在 TYPE、INFO 等命令中的名称
+var typeNames = map[mindb.DataType]string{
+	mindb.String: "string",
+	mindb.List:   "list",
+	mindb.Hash:   "hash",
+	mindb.Set:    "set",
+	mindb.ZSet:   "zset",
+	mindb.None:   "none",
+}
+
+// EXISTS key [key...]，返回存在的key的个数，重复的key会被重复计数
+func exists(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) == 0 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	var n int64
+	for _, ok := range db.BatchExists(toBytes(args)...) {
+		if ok {
+			n++
+		}
+	}
+	res = protocol.Integer(n)
+	return
+}
+
+// TYPE key [key...]，一个key时返回其类型，多个key时返回每个key的类型
+func keyType(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) == 0 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	types := db.BatchType(toBytes(args)...)
+	if len(types) == 1 {
+		res = protocol.SimpleString(typeNames[types[0]])
+		return
+	}
+	items := make(protocol.Array, 0, len(types))
+	for _, t := range types {
+		items = append(items, protocol.SimpleString(typeNames[t]))
+	}
+	res = items
+	return
+}
+
+// HOTKEYS [count] 返回访问次数最多的key前缀，默认返回10个，count 为 0 时返回所有前缀
+// 每个前缀返回 前缀、总访问次数、各时间窗口的访问次数（从当前窗口往前），HOTKEYS RESET 清空统计
+// 统计包括所有用户访问的key，RESET 会清空所有用户的统计，因此有意不登记在 cmdSpecs 中，属于管理命令
+func hotKeys(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) > 1 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	count := 10
+	if len(args) == 1 {
+		if strings.ToLower(args[0]) == "reset" {
+			db.ResetHotKeys()
+			res = okReply
+			return
+		}
+		if count, err = strconv.Atoi(args[0]); err != nil || count < 0 {
+			err = ErrSyntaxIncorrect
+			return
+		}
+	}
+
+	items := protocol.Array{}
+	for _, p := range db.HotKeys(count) {
+		counts := make(protocol.Array, 0, len(p.Counts))
+		for _, n := range p.Counts {
+			counts = append(counts, protocol.Integer(n))
+		}
+		items = append(items, protocol.Array{protocol.Bulk(p.Prefix), protocol.Integer(p.Total), counts})
+	}
+	res = items
+	return
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type] [WITHSIZES]，以游标分批遍历所有的key，cursor 为 0 时从头开始
+// 返回 [下一次的游标, [[key, 类型], ...]]，游标为 0 时遍历结束；WITHSIZES 时每个key还返回元素个数及占用的字节数
+// MATCH 按 glob 风格过滤这一批遍历到的key，因此返回的key可能少于 COUNT 甚至为空，但只要游标不为 0 就需要继续遍历
+// 每次只遍历一批key，遍历期间不会长时间阻塞其他命令；与 MATCH 一样，只返回用户可以访问的key
+func scan(ctx context.Context, db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) == 0 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	var cursor []byte
+	if args[0] != "0" {
+		if cursor, err = hex.DecodeString(args[0]); err != nil {
+			return nil, mindb.ErrInvalidCursor
+		}
+	}
+	count, withSizes, pattern := 10, false, ""
+	var types []mindb.DataType
+	for i := 1; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "match":
+			if i++; i == len(args) {
+				return nil, ErrSyntaxIncorrect
+			}
+			pattern = args[i]
+		case "count":
+			if i++; i == len(args) {
+				return nil, ErrSyntaxIncorrect
+			}
+			if count, err = strconv.Atoi(args[i]); err != nil || count <= 0 {
+				return nil, ErrSyntaxIncorrect
+			}
+		case "type":
+			if i++; i == len(args) {
+				return nil, ErrSyntaxIncorrect
+			}
+			dataType, ok := parseTypeName(args[i])
+			if !ok {
+				return nil, ErrSyntaxIncorrect
+			}
+			types = append(types, dataType)
+		case "withsizes":
+			withSizes = true
+		default:
+			return nil, ErrSyntaxIncorrect
+		}
+	}
+
+	next, keys, err := db.Scan(cursor, count, types...)
+	if err != nil {
+		return
+	}
+	allow := keyFilter(ctx)
+	items := make(protocol.Array, 0, len(keys))
+	for _, k := range keys {
+		if pattern != "" && !matchPattern(pattern, string(k.Key)) {
+			continue
+		}
+		if allow != nil && !allow(string(k.Key)) {
+			continue
+		}
+		item := protocol.Array{protocol.Bulk(k.Key), protocol.SimpleString(typeNames[k.Type])}
+		if withSizes {
+			n, size := db.KeyUsage(k.Type, k.Key)
+			item = append(item, protocol.Integer(n), protocol.Integer(size))
+		}
+		items = append(items, item)
+	}
+	nextCursor := "0"
+	if len(next) > 0 {
+		nextCursor = hex.EncodeToString(next)
+	}
+	res = protocol.Array{protocol.Bulk(nextCursor), items}
+	return
+}
+
+// KEYMETA key 返回key的元信息而不读取其值，以 名称、值 交替的数组返回：
+// type、count（元素个数）、size（字节数）、ttl（秒，-1 表示不过期）、last_modified（最后一次修改的写入序号，0 表示无法得到）、
+// locations（字符串的值及增量修改所在的 [文件id, 位置, 大小]，其他类型为空）
+func keyMeta(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) != 1 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	meta, err := db.KeyMeta([]byte(args[0]))
+	if err != nil {
+		return
+	}
+	locations := make(protocol.Array, 0, len(meta.Locations))
+	for _, l := range meta.Locations {
+		locations = append(locations, protocol.Array{protocol.Integer(l.FileId), protocol.Integer(l.Offset), protocol.Integer(l.Size)})
+	}
+	res = protocol.Array{
+		protocol.Bulk("type"), protocol.SimpleString(typeNames[meta.Type]),
+		protocol.Bulk("count"), protocol.Integer(meta.Count),
+		protocol.Bulk("size"), protocol.Integer(meta.Size),
+		protocol.Bulk("ttl"), protocol.Integer(meta.TTL),
+		protocol.Bulk("last_modified"), protocol.Integer(meta.LastModified),
+		protocol.Bulk("locations"), locations,
+	}
+	return
+}
+
+// 根据类型的名称获取数据类型
+func parseTypeName(name string) (mindb.DataType, bool) {
+	for _, dataType := range mindb.DataTypes {
+		if strings.EqualFold(typeNames[dataType], name) {
+			return dataType, true
+		}
+	}
+	return 0, false
+}
+
+func init() {
+	addExecCommand("exists", exists)
+	addExecCommand("type", keyType)
+	addExecCommand("hotkeys", hotKeys)
+	addExecCommandContext("scan", scan)
+	addExecCommand("keymeta", keyMeta)
+}
@@ -2,11 +2,12 @@ package cmd
 
 import (
 	"mindb"
+	"mindb/cmd/protocol"
 	"mindb/ds/list"
 	"strconv"
 )
 
-func lPush(db *mindb.MinDB, args []string) (res string, err error) {
+func lPush(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) < 2 {
 		err = ErrSyntaxIncorrect
 		return
@@ -19,12 +20,12 @@ func lPush(db *mindb.MinDB, args []string) (res string, err error) {
 
 	var val int
 	if val, err = db.LPush([]byte(args[0]), values...); err == nil {
-		res = strconv.Itoa(val)
+		res = protocol.Integer(val)
 	}
 	return
 }
 
-func rPush(db *mindb.MinDB, args []string) (res string, err error) {
+func rPush(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) < 2 {
 		err = ErrSyntaxIncorrect
 		return
@@ -37,12 +38,12 @@ func rPush(db *mindb.MinDB, args []string) (res string, err error) {
 
 	var val int
 	if val, err = db.RPush([]byte(args[0]), values...); err == nil {
-		res = strconv.Itoa(val)
+		res = protocol.Integer(val)
 	}
 	return
 }
 
-func lPop(db *mindb.MinDB, args []string) (res string, err error) {
+func lPop(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 1 {
 		err = ErrSyntaxIncorrect
 		return
@@ -50,12 +51,12 @@ func lPop(db *mindb.MinDB, args []string) (res string, err error) {
 
 	var val []byte
 	if val, err = db.LPop([]byte(args[0])); err == nil {
-		res = string(val)
+		res = protocol.Bulk(val)
 	}
 	return
 }
 
-func rPop(db *mindb.MinDB, args []string) (res string, err error) {
+func rPop(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 1 {
 		err = ErrSyntaxIncorrect
 		return
@@ -63,13 +64,13 @@ func rPop(db *mindb.MinDB, args []string) (res string, err error) {
 
 	var val []byte
 	if val, err = db.RPop([]byte(args[0])); err == nil {
-		res = string(val)
+		res = protocol.Bulk(val)
 	}
 	return
 }
 
-func lIndex(db *mindb.MinDB, args []string) (res string, err error) {
-	if len(args) != 0 {
+func lIndex(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) != 2 {
 		err = ErrSyntaxIncorrect
 		return
 	}
@@ -80,11 +81,11 @@ func lIndex(db *mindb.MinDB, args []string) (res string, err error) {
 	}
 
 	val := db.LIndex([]byte(args[0]), index)
-	res = string(val)
+	res = protocol.Bulk(val)
 	return
 }
 
-func lRem(db *mindb.MinDB, args []string) (res string, err error) {
+func lRem(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 3 {
 		err = ErrSyntaxIncorrect
 		return
@@ -97,12 +98,12 @@ func lRem(db *mindb.MinDB, args []string) (res string, err error) {
 
 	var val int
 	if val, err = db.LRem([]byte(args[0]), []byte(args[1]), count); err == nil {
-		res = strconv.Itoa(val)
+		res = protocol.Integer(val)
 	}
 	return
 }
 
-func lInsert(db *mindb.MinDB, args []string) (res string, err error) {
+func lInsert(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 4 {
 		err = ErrSyntaxIncorrect
 		return
@@ -116,12 +117,12 @@ func lInsert(db *mindb.MinDB, args []string) (res string, err error) {
 	}
 	var val int
 	if val, err = db.LInsert(args[0], list.InsertOption(flag), []byte(args[2]), []byte(args[3])); err == nil {
-		res = strconv.Itoa(val)
+		res = protocol.Integer(val)
 	}
 	return
 }
 
-func lSet(db *mindb.MinDB, args []string) (res string, err error) {
+func lSet(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 3 {
 		err = ErrSyntaxIncorrect
 		return
@@ -134,15 +135,11 @@ func lSet(db *mindb.MinDB, args []string) (res string, err error) {
 
 	var ok bool
 	ok, err = db.LSet([]byte(args[0]), index, []byte(args[2]))
-	if ok {
-		res = "1"
-	} else {
-		res = "0"
-	}
+	res = boolReply(ok)
 	return
 }
 
-func lTrim(db *mindb.MinDB, args []string) (res string, err error) {
+func lTrim(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 3 {
 		err = ErrSyntaxIncorrect
 		return
@@ -159,12 +156,12 @@ func lTrim(db *mindb.MinDB, args []string) (res string, err error) {
 	}
 
 	if err = db.LTrim([]byte(args[0]), start, end); err == nil {
-		res = "OK"
+		res = okReply
 	}
 	return
 }
 
-func lRange(db *mindb.MinDB, args []string) (res string, err error) {
+func lRange(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 3 {
 		err = ErrSyntaxIncorrect
 		return
@@ -182,24 +179,19 @@ func lRange(db *mindb.MinDB, args []string) (res string, err error) {
 
 	var val [][]byte
 	if val, err = db.LRange([]byte(args[0]), start, end); err == nil {
-		for i, v := range val {
-			res += string(v)
-			if i != len(val)-1 {
-				res += "\n"
-			}
-		}
+		res = multiBulk(val)
 	}
 	return
 }
 
-func lLen(db *mindb.MinDB, args []string) (res string, err error) {
+func lLen(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 1 {
 		err = ErrSyntaxIncorrect
 		return
 	}
 
 	length := db.LLen([]byte(args[0]))
-	res = strconv.Itoa(length)
+	res = protocol.Integer(length)
 	return
 }
 
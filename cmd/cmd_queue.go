@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"mindb"
+	"mindb/cmd/protocol"
+	"strconv"
+	"time"
+)
+
+// QPUSH queue payload [payload...]，返回元素的id
+func qPush(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) < 2 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	var ids [][]byte
+	if ids, err = db.QPush([]byte(args[0]), toBytes(args[1:])...); err == nil {
+		res = multiBulk(ids)
+	}
+	return
+}
+
+// QPUSHAT queue timestamp payload，timestamp 为元素可以被取出的 unix 时间（秒），返回元素的id
+func qPushAt(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	return enqueueAt(db, args, func(v int64) time.Time { return time.Unix(v, 0) })
+}
+
+// QDELAY queue seconds payload，元素在 seconds 秒之后才能被取出，返回元素的id
+func qDelay(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	return enqueueAt(db, args, func(v int64) time.Time { return time.Now().Add(time.Duration(v) * time.Second) })
+}
+
+func enqueueAt(db *mindb.MinDB, args []string, at func(v int64) time.Time) (res protocol.Reply, err error) {
+	if len(args) != 3 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	v, e := strconv.ParseInt(args[1], 10, 64)
+	if e != nil || v < 0 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	var id []byte
+	if id, err = db.EnqueueAt([]byte(args[0]), []byte(args[2]), at(v)); err == nil {
+		res = protocol.Bulk(id)
+	}
+	return
+}
+
+// QPOP queue [visibility]，visibility 为可见性超时的秒数，返回元素的id及内容，队列为空时返回 nil
+func qPop(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) != 1 && len(args) != 2 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	visibility := mindb.DefaultQueueVisibility
+	if len(args) == 2 {
+		if visibility, err = parseVisibility(args[1]); err != nil {
+			return
+		}
+	}
+
+	var item *mindb.QueueItem
+	if item, err = db.QPop([]byte(args[0]), visibility); err == nil {
+		res = queueItemReply(item)
+	}
+	return
+}
+
+func parseVisibility(arg string) (time.Duration, error) {
+	seconds, err := strconv.ParseUint(arg, 10, 32)
+	if err != nil {
+		return 0, ErrSyntaxIncorrect
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func queueItemReply(item *mindb.QueueItem) protocol.Reply {
+	if item == nil {
+		return protocol.Bulk(nil)
+	}
+	return multiBulk([][]byte{item.Id, item.Payload})
+}
+
+// QACK queue id [id...]，返回确认成功的数量
+func qAck(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) < 2 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	var n int
+	if n, err = db.QAck([]byte(args[0]), toBytes(args[1:])...); err == nil {
+		res = protocol.Integer(n)
+	}
+	return
+}
+
+// QLEN queue，返回待投递、已取出未确认及延迟投递的元素数量
+func qLen(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) != 1 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	ready, unacked, delayed := db.QLen([]byte(args[0]))
+	res = protocol.Array{protocol.Integer(ready), protocol.Integer(unacked), protocol.Integer(delayed)}
+	return
+}
+
+func init() {
+	addExecCommand("qpush", qPush)
+	addExecCommand("qpushat", qPushAt)
+	addExecCommand("qdelay", qDelay)
+	addExecCommand("qpop", qPop)
+	addExecCommand("qack", qAck)
+	addExecCommand("qlen", qLen)
+}
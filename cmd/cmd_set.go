@@ -2,10 +2,11 @@ package cmd
 
 import (
 	"mindb"
+	"mindb/cmd/protocol"
 	"strconv"
 )
 
-func sAdd(db *mindb.MinDB, args []string) (res string, err error) {
+func sAdd(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) <= 1 {
 		err = ErrSyntaxIncorrect
 		return
@@ -15,14 +16,15 @@ func sAdd(db *mindb.MinDB, args []string) (res string, err error) {
 	for _, m := range args[1:] {
 		members = append(members, []byte(m))
 	}
+
 	var count int
 	if count, err = db.SAdd([]byte(args[0]), members...); err == nil {
-		res = strconv.Itoa(count)
+		res = protocol.Integer(count)
 	}
 	return
 }
 
-func sPop(db *mindb.MinDB, args []string) (res string, err error) {
+func sPop(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 2 {
 		err = ErrSyntaxIncorrect
 		return
@@ -32,32 +34,25 @@ func sPop(db *mindb.MinDB, args []string) (res string, err error) {
 		err = ErrSyntaxIncorrect
 		return
 	}
+
 	var val [][]byte
 	if val, err = db.SPop([]byte(args[0]), count); err == nil {
-		for i, v := range val {
-			res += string(v)
-			if i != len(val)-1 {
-				res += "\n"
-			}
-		}
+		res = multiBulk(val)
 	}
 	return
 }
 
-func sIsMember(db *mindb.MinDB, args []string) (res string, err error) {
+func sIsMember(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 2 {
 		err = ErrSyntaxIncorrect
 		return
 	}
-	if ok := db.SIsMember([]byte(args[0]), []byte(args[1])); ok {
-		res = "1"
-	} else {
-		res = "0"
-	}
+
+	res = boolReply(db.SIsMember([]byte(args[0]), []byte(args[1])))
 	return
 }
 
-func sRandMember(db *mindb.MinDB, args []string) (res string, err error) {
+func sRandMember(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 2 {
 		err = ErrSyntaxIncorrect
 		return
@@ -67,69 +62,72 @@ func sRandMember(db *mindb.MinDB, args []string) (res string, err error) {
 		err = ErrSyntaxIncorrect
 		return
 	}
+
 	val := db.SRandMember([]byte(args[0]), count)
-	for i, v := range val {
-		res += string(v)
-		if i != len(val)-1 {
-			res += "\n"
-		}
-	}
+	res = multiBulk(val)
 	return
 }
 
-func sRem(db *mindb.MinDB, args []string) (res string, err error) {
+func sRem(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) <= 1 {
 		err = ErrSyntaxIncorrect
 		return
 	}
+
 	var members [][]byte
 	for _, m := range args[1:] {
 		members = append(members, []byte(m))
 	}
+
 	var count int
 	if count, err = db.SRem([]byte(args[0]), members...); err == nil {
-		res = strconv.Itoa(count)
+		res = protocol.Integer(count)
 	}
 	return
 }
 
-func sMove(db *mindb.MinDB, args []string) (res string, err error) {
+func sMove(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 3 {
 		err = ErrSyntaxIncorrect
 		return
 	}
 	if err = db.SMove([]byte(args[0]), []byte(args[1]), []byte(args[2])); err == nil {
-		res = "OK"
+		res = okReply
 	}
 	return
 }
 
-func sCard(db *mindb.MinDB, args []string) (res string, err error) {
+func sCard(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 1 {
 		err = ErrSyntaxIncorrect
 		return
 	}
 	card := db.SCard([]byte(args[0]))
-	res = strconv.Itoa(card)
+	res = protocol.Integer(card)
 	return
 }
 
-func sMembers(db *mindb.MinDB, args []string) (res string, err error) {
-	if len(args) != 1 {
+// SMEMBERS key [LIMIT offset count]，指定 LIMIT 时按字节序分页返回
+func sMembers(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) < 1 {
 		err = ErrSyntaxIncorrect
 		return
 	}
-	members := db.SMembers([]byte(args[0]))
-	for i, v := range members {
-		res += string(v)
-		if i != len(members)-1 {
-			res += "\n"
-		}
+	offset, count, paged, err := parseLimit(args[1:])
+	if err != nil {
+		return
 	}
+
+	if paged {
+		res = multiBulk(db.SMembersPage([]byte(args[0]), offset, count))
+		return
+	}
+	members := db.SMembers([]byte(args[0]))
+	res = multiBulk(members)
 	return
 }
 
-func sUnion(db *mindb.MinDB, args []string) (res string, err error) {
+func sUnion(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) <= 0 {
 		err = ErrSyntaxIncorrect
 		return
@@ -139,16 +137,11 @@ func sUnion(db *mindb.MinDB, args []string) (res string, err error) {
 		keys = append(keys, []byte(v))
 	}
 	val := db.SUnion(keys...)
-	for i, v := range val {
-		res += string(v)
-		if i != len(val)-1 {
-			res += "\n"
-		}
-	}
+	res = multiBulk(val)
 	return
 }
 
-func sDiff(db *mindb.MinDB, args []string) (res string, err error) {
+func sDiff(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) <= 0 {
 		err = ErrSyntaxIncorrect
 		return
@@ -158,12 +151,7 @@ func sDiff(db *mindb.MinDB, args []string) (res string, err error) {
 		keys = append(keys, []byte(v))
 	}
 	val := db.SDiff(keys...)
-	for i, v := range val {
-		res += string(v)
-		if i != len(val)-1 {
-			res += "\n"
-		}
-	}
+	res = multiBulk(val)
 	return
 }
 
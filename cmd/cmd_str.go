@@ -1,14 +1,21 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"mindb"
+	"mindb/cmd/protocol"
 	"strconv"
+	"strings"
+	"time"
 )
 
 var ErrSyntaxIncorrect = errors.New("syntax err")
 
-func set(db *mindb.MinDB, args []string) (res string, err error) {
+// 抽样删除已过期key的间隔
+const activeExpireInterval = 100 * time.Millisecond
+
+func set(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 2 {
 		err = ErrSyntaxIncorrect
 		return
@@ -16,12 +23,12 @@ func set(db *mindb.MinDB, args []string) (res string, err error) {
 
 	key, value := args[0], args[1]
 	if err = db.Set([]byte(key), []byte(value)); err == nil {
-		res = "OK"
+		res = okReply
 	}
 	return
 }
 
-func get(db *mindb.MinDB, args []string) (res string, err error) {
+func get(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 1 {
 		err = ErrSyntaxIncorrect
 		return
@@ -29,14 +36,17 @@ func get(db *mindb.MinDB, args []string) (res string, err error) {
 	key := args[0]
 	var val []byte
 	if val, err = db.Get([]byte(key)); err == nil {
-		res = string(val)
+		res = protocol.Bulk(val)
+	}
+	if err == mindb.ErrKeyNotExist { // key不存在时返回空值
+		res, err = protocol.Bulk(nil), nil
 	}
 	return
 }
 
 //  todo other commands
 
-func setNx(db *mindb.MinDB, args []string) (res string, err error) {
+func setNx(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 2 {
 		err = ErrSyntaxIncorrect
 		return
@@ -44,12 +54,12 @@ func setNx(db *mindb.MinDB, args []string) (res string, err error) {
 
 	key, value := args[0], args[1]
 	if err = db.SetNx([]byte(key), []byte(value)); err == nil {
-		res = "OK"
+		res = okReply
 	}
 	return
 }
 
-func getSet(db *mindb.MinDB, args []string) (res string, err error) {
+func getSet(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 2 {
 		err = ErrSyntaxIncorrect
 		return
@@ -57,58 +67,120 @@ func getSet(db *mindb.MinDB, args []string) (res string, err error) {
 	key, value := args[0], args[1]
 	var val []byte
 	if val, err = db.GetSet([]byte(key), []byte(value)); err == nil {
-		res = string(val)
+		res = protocol.Bulk(val)
 	}
 	return
 }
 
-func appendStr(db *mindb.MinDB, args []string) (res string, err error) {
+func appendStr(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 2 {
 		err = ErrSyntaxIncorrect
 		return
 	}
 	key, value := args[0], args[1]
 	if err = db.Append([]byte(key), []byte(value)); err == nil {
-		res = "OK"
+		res = okReply
 	}
 	return
 }
 
-func strLen(db *mindb.MinDB, args []string) (res string, err error) {
+func setRange(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) != 3 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	offset, err := strconv.Atoi(args[1])
+	if err != nil {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	var length int
+	if length, err = db.SetRange([]byte(args[0]), offset, []byte(args[2])); err == nil {
+		res = protocol.Integer(length)
+	}
+	return
+}
+
+func strLen(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 1 {
 		err = ErrSyntaxIncorrect
 		return
 	}
 	length := db.StrLen([]byte(args[0]))
-	res = strconv.Itoa(length)
+	res = protocol.Integer(length)
 	return
 }
 
-func strExists(db *mindb.MinDB, args []string) (res string, err error) {
+func strExists(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 1 {
 		err = ErrSyntaxIncorrect
 		return
 	}
-	if exists := db.StrExists([]byte(args[0])); exists {
-		res = "1"
-	} else {
-		res = "0"
-	}
+	res = boolReply(db.StrExists([]byte(args[0])))
 	return
 }
 
-func strRem(db *mindb.MinDB, args []string) (res string, err error) {
+func strRem(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 1 {
 		err = ErrSyntaxIncorrect
 		return
 	}
 	if err = db.StrRem([]byte(args[0])); err == nil {
-		res = "OK"
+		res = okReply
+	}
+	return
+}
+
+func undelete(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) != 1 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	var ok bool
+	if ok, err = db.Undelete([]byte(args[0])); err == nil {
+		res = boolReply(ok)
+	}
+	return
+}
+
+func purge(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) == 0 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	keys := make([][]byte, len(args))
+	for i, arg := range args {
+		keys[i] = []byte(arg)
+	}
+	var n int
+	if n, err = db.Purge(keys...); err == nil {
+		res = protocol.Integer(n)
+	}
+	return
+}
+
+// TRASH [PURGE] 列出回收站中的key，PURGE 时清空回收站，返回删除的数量
+func trash(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	switch {
+	case len(args) == 0:
+		keys := protocol.Array{}
+		for _, key := range db.TrashKeys() {
+			keys = append(keys, protocol.Bulk(key))
+		}
+		res = keys
+	case len(args) == 1 && strings.EqualFold(args[0], "purge"):
+		var n int
+		if n, err = db.Purge(); err == nil {
+			res = protocol.Integer(n)
+		}
+	default:
+		err = ErrSyntaxIncorrect
 	}
 	return
 }
 
-func prefixScan(db *mindb.MinDB, args []string) (res string, err error) {
+func prefixScan(ctx context.Context, db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 3 {
 		err = ErrSyntaxIncorrect
 		return
@@ -125,68 +197,124 @@ func prefixScan(db *mindb.MinDB, args []string) (res string, err error) {
 	}
 
 	var val [][]byte
-	if val, err = db.PrefixScan(args[0], limit, offset); err == nil {
-		for i, v := range val {
-			res += string(v)
-			if i != len(val)-1 {
-				res += "\n"
-			}
-		}
+	if val, err = db.PrefixScanContext(ctx, args[0], limit, offset); err == nil {
+		res = multiBulk(val)
 	}
 	return
 }
 
-func rangeScan(db *mindb.MinDB, args []string) (res string, err error) {
+func rangeScan(ctx context.Context, db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 2 {
 		err = ErrSyntaxIncorrect
 		return
 	}
 
 	var val [][]byte
-	if val, err = db.RangeScan([]byte(args[0]), []byte(args[1])); err == nil {
-		for i, v := range val {
-			res += string(v)
-			if i != len(val)-1 {
-				res += "\n"
-			}
+	if val, err = db.RangeScanContext(ctx, []byte(args[0]), []byte(args[1])); err == nil {
+		res = multiBulk(val)
+	}
+	return
+}
+
+// EXPIRE key seconds [key seconds ...]，只有一个key时返回 OK，多个key时在一次写入中全部设置，返回设置了过期时间的key数量
+func expire(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) < 2 || len(args)%2 != 0 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	ttls := make(map[string]uint32, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		seconds, err := strconv.Atoi(args[i+1])
+		if err != nil {
+			return nil, ErrSyntaxIncorrect
 		}
+		ttls[args[i]] = uint32(seconds)
+	}
+
+	if len(args) == 2 {
+		if err = db.Expire([]byte(args[0]), ttls[args[0]]); err == nil {
+			res = okReply
+		}
+		return
+	}
+	n, err := db.ExpireMulti(ttls)
+	if err == nil {
+		res = protocol.Integer(n)
 	}
 	return
 }
 
-func expire(db *mindb.MinDB, args []string) (res string, err error) {
+// EXPIREAT key timestamp，timestamp 为 Unix 秒，已经过去时删除key
+func expireAt(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 2 {
 		err = ErrSyntaxIncorrect
 		return
 	}
-	seconds, err := strconv.Atoi(args[1])
+	deadline, err := strconv.ParseUint(args[1], 10, 32)
 	if err != nil {
+		return nil, ErrSyntaxIncorrect
+	}
+	if err = db.ExpireAt([]byte(args[0]), uint32(deadline)); err == nil {
+		res = okReply
+	}
+	return
+}
+
+// PERSIST key [key ...]，只有一个key时返回 OK，多个key时返回清除了过期时间的key数量
+func persist(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) == 0 {
 		err = ErrSyntaxIncorrect
 		return
 	}
-	if err = db.Expire([]byte(args[0]), uint32(seconds)); err == nil {
-		res = "OK"
+	if len(args) == 1 {
+		db.Persist([]byte(args[0]))
+		res = okReply
+		return
+	}
+
+	keys := make([][]byte, len(args))
+	for i, arg := range args {
+		keys[i] = []byte(arg)
+	}
+	n, err := db.PersistMulti(keys...)
+	if err == nil {
+		res = protocol.Integer(n)
 	}
 	return
 }
 
-func persist(db *mindb.MinDB, args []string) (res string, err error) {
+func ttl(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 1 {
 		err = ErrSyntaxIncorrect
 		return
 	}
-	db.Persist([]byte(args[0]))
-	res = "OK"
+
+	ttl := db.TTL([]byte(args[0]))
+	res = protocol.Integer(ttl)
 	return
 }
 
-func ttl(db *mindb.MinDB, args []string) (res string, err error) {
-	if len(args) != 1 {
+// RATELIMIT key limit window_seconds，返回 [是否允许(1/0), 剩余令牌数, 需要等待的毫秒数]
+func rateLimit(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) != 3 {
 		err = ErrSyntaxIncorrect
+		return
+	}
+	limit, err := strconv.ParseUint(args[1], 10, 32)
+	if err != nil {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	window, err := strconv.ParseUint(args[2], 10, 32)
+	if err != nil {
+		err = ErrSyntaxIncorrect
+		return
 	}
 
-	ttl := db.TTL([]byte(args[0]))
-	res = strconv.FormatInt(int64(ttl), 10)
+	var r mindb.RateLimitResult
+	if r, err = db.RateLimit([]byte(args[0]), uint32(limit), time.Duration(window)*time.Second); err == nil {
+		res = protocol.Array{boolReply(r.Allowed), protocol.Integer(r.Remaining), protocol.Integer(r.RetryAfter.Milliseconds())}
+	}
 	return
 }
 
@@ -196,12 +324,18 @@ func init() {
 	addExecCommand("setnx", setNx)
 	addExecCommand("getset", getSet)
 	addExecCommand("append", appendStr)
+	addExecCommand("setrange", setRange)
 	addExecCommand("strlen", strLen)
 	addExecCommand("strexists", strExists)
 	addExecCommand("strrem", strRem)
-	addExecCommand("prefixscan", prefixScan)
-	addExecCommand("rangescan", rangeScan)
+	addExecCommand("undelete", undelete)
+	addExecCommand("purge", purge)
+	addExecCommand("trash", trash)
+	addExecCommandContext("prefixscan", prefixScan)
+	addExecCommandContext("rangescan", rangeScan)
 	addExecCommand("expire", expire)
+	addExecCommand("expireat", expireAt)
 	addExecCommand("persist", persist)
 	addExecCommand("ttl", ttl)
+	addExecCommand("ratelimit", rateLimit)
 }
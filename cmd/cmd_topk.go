@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"mindb"
+	"mindb/cmd/protocol"
+	"strconv"
+	"strings"
+)
+
+// TOPK.RESERVE key topk [width depth]
+func topKReserve(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) != 2 && len(args) != 4 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	params := []uint32{0, mindb.DefaultTopKWidth, mindb.DefaultTopKDepth}
+	for i, arg := range args[1:] {
+		v, e := strconv.ParseUint(arg, 10, 32)
+		if e != nil {
+			err = ErrSyntaxIncorrect
+			return
+		}
+		params[i] = uint32(v)
+	}
+
+	if err = db.TopKReserve([]byte(args[0]), params[0], params[1], params[2]); err == nil {
+		res = okReply
+	}
+	return
+}
+
+// TOPK.ADD key item [item...]，返回每个元素加入后被挤出的元素
+func topKAdd(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) <= 1 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	var expelled [][]byte
+	if expelled, err = db.TopKAdd([]byte(args[0]), toBytes(args[1:])...); err == nil {
+		res = multiBulk(expelled)
+	}
+	return
+}
+
+func topKQuery(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) <= 1 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	var exists []bool
+	if exists, err = db.TopKQuery([]byte(args[0]), toBytes(args[1:])...); err == nil {
+		arr := make(protocol.Array, 0, len(exists))
+		for _, ok := range exists {
+			arr = append(arr, boolReply(ok))
+		}
+		res = arr
+	}
+	return
+}
+
+func topKCount(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) <= 1 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	var counts []uint32
+	if counts, err = db.TopKCount([]byte(args[0]), toBytes(args[1:])...); err == nil {
+		arr := make(protocol.Array, 0, len(counts))
+		for _, c := range counts {
+			arr = append(arr, protocol.Integer(c))
+		}
+		res = arr
+	}
+	return
+}
+
+// TOPK.LIST key [WITHCOUNT]
+func topKList(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) != 1 && (len(args) != 2 || strings.ToLower(args[1]) != "withcount") {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	items, err := db.TopKList([]byte(args[0]))
+	if err != nil {
+		return
+	}
+	arr := make(protocol.Array, 0, len(items))
+	for _, item := range items {
+		arr = append(arr, protocol.Bulk(item.Key))
+		if len(args) == 2 {
+			arr = append(arr, protocol.Integer(item.Count))
+		}
+	}
+	res = arr
+	return
+}
+
+func toBytes(args []string) [][]byte {
+	values := make([][]byte, 0, len(args))
+	for _, arg := range args {
+		values = append(values, []byte(arg))
+	}
+	return values
+}
+
+func init() {
+	addExecCommand("topk.reserve", topKReserve)
+	addExecCommand("topk.add", topKAdd)
+	addExecCommand("topk.query", topKQuery)
+	addExecCommand("topk.count", topKCount)
+	addExecCommand("topk.list", topKList)
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"mindb"
+	"mindb/cmd/protocol"
+	"strings"
+)
+
+// 视图类型在命令中的名称
+var viewKinds = map[string]mindb.ViewKind{"leaderboard": mindb.ViewLeaderboard, "count": mindb.ViewCount}
+
+// VIEW CREATE name LEADERBOARD target prefix field | VIEW CREATE name COUNT target prefix | VIEW DROP name | VIEW LIST
+func view(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
+	if len(args) == 0 {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "create":
+		if len(args) < 5 {
+			err = ErrSyntaxIncorrect
+			return
+		}
+		kind, ok := viewKinds[strings.ToLower(args[2])]
+		if !ok || (kind == mindb.ViewLeaderboard && len(args) != 6) || (kind == mindb.ViewCount && len(args) != 5) {
+			err = ErrSyntaxIncorrect
+			return
+		}
+		v := mindb.View{Name: args[1], Kind: kind, Target: []byte(args[3]), Prefix: []byte(args[4])}
+		if kind == mindb.ViewLeaderboard {
+			v.Field = []byte(args[5])
+		}
+		if err = db.CreateView(v); err == nil {
+			res = okReply
+		}
+	case "drop":
+		if len(args) != 2 {
+			err = ErrSyntaxIncorrect
+			return
+		}
+		if err = db.DropView(args[1]); err == nil {
+			res = okReply
+		}
+	case "list":
+		if len(args) != 1 {
+			err = ErrSyntaxIncorrect
+			return
+		}
+		arr := protocol.Array{}
+		for _, v := range db.Views() {
+			arr = append(arr, viewReply(v))
+		}
+		res = arr
+	default:
+		err = ErrSyntaxIncorrect
+	}
+	return
+}
+
+// 视图的定义按照 VIEW CREATE 的参数顺序返回
+func viewReply(v mindb.View) protocol.Array {
+	var kind string
+	for name, k := range viewKinds {
+		if k == v.Kind {
+			kind = strings.ToUpper(name)
+		}
+	}
+	arr := protocol.Array{protocol.Bulk(v.Name), protocol.Bulk(kind), protocol.Bulk(v.Target), protocol.Bulk(v.Prefix)}
+	if v.Kind == mindb.ViewLeaderboard {
+		arr = append(arr, protocol.Bulk(v.Field))
+	}
+	return arr
+}
+
+func init() {
+	addExecCommand("view", view)
+}
@@ -3,11 +3,12 @@ package cmd
 import (
 	"fmt"
 	"mindb"
+	"mindb/cmd/protocol"
 	"mindb/utils"
 	"strconv"
 )
 
-func zAdd(db *mindb.MinDB, args []string) (res string, err error) {
+func zAdd(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 3 {
 		err = ErrSyntaxIncorrect
 		return
@@ -18,52 +19,52 @@ func zAdd(db *mindb.MinDB, args []string) (res string, err error) {
 		return
 	}
 	if err = db.ZAdd([]byte(args[0]), score, []byte(args[2])); err == nil {
-		res = "OK"
+		res = okReply
 	}
 	return
 }
 
-func zScore(db *mindb.MinDB, args []string) (res string, err error) {
+func zScore(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 2 {
 		err = ErrSyntaxIncorrect
 		return
 	}
 	score := db.ZScore([]byte(args[0]), []byte(args[1]))
-	res = utils.Float64ToStr(score)
+	res = protocol.Bulk(utils.Float64ToStr(score))
 	return
 }
 
-func zCard(db *mindb.MinDB, args []string) (res string, err error) {
+func zCard(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 1 {
 		err = ErrSyntaxIncorrect
 		return
 	}
 	card := db.ZCard([]byte(args[0]))
-	res = strconv.Itoa(card)
+	res = protocol.Integer(card)
 	return
 }
 
-func zRank(db *mindb.MinDB, args []string) (res string, err error) {
+func zRank(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 2 {
 		err = ErrSyntaxIncorrect
 		return
 	}
 	rank := db.ZRank([]byte(args[0]), []byte(args[1]))
-	res = strconv.FormatInt(rank, 10)
+	res = protocol.Integer(rank)
 	return
 }
 
-func zRevRank(db *mindb.MinDB, args []string) (res string, err error) {
+func zRevRank(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 2 {
 		err = ErrSyntaxIncorrect
 		return
 	}
 	rank := db.ZRevRank([]byte(args[0]), []byte(args[1]))
-	res = strconv.FormatInt(rank, 10)
+	res = protocol.Integer(rank)
 	return
 }
 
-func zIncrBy(db *mindb.MinDB, args []string) (res string, err error) {
+func zIncrBy(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 3 {
 		err = ErrSyntaxIncorrect
 		return
@@ -75,22 +76,23 @@ func zIncrBy(db *mindb.MinDB, args []string) (res string, err error) {
 	}
 	var val float64
 	if val, err = db.ZIncrBy([]byte(args[0]), incr, []byte(args[2])); err == nil {
-		res = utils.Float64ToStr(val)
+		res = protocol.Bulk(utils.Float64ToStr(val))
 	}
 	return
 }
 
-func zRange(db *mindb.MinDB, args []string) (res string, err error) {
+func zRange(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	return zRawRange(db, args, false)
 }
 
-func zRevRange(db *mindb.MinDB, args []string) (res string, err error) {
+func zRevRange(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	return zRawRange(db, args, true)
 }
 
 // for zRange and zRevRange
-func zRawRange(db *mindb.MinDB, args []string, rev bool) (res string, err error) {
-	if len(args) != 3 {
+// ZRANGE key start stop [LIMIT offset count]，LIMIT 在 start 到 stop 的范围内再取从 offset 开始的 count 个元素
+func zRawRange(db *mindb.MinDB, args []string, rev bool) (res protocol.Reply, err error) {
+	if len(args) < 3 {
 		err = ErrSyntaxIncorrect
 		return
 	}
@@ -104,6 +106,15 @@ func zRawRange(db *mindb.MinDB, args []string, rev bool) (res string, err error)
 		err = ErrSyntaxIncorrect
 		return
 	}
+	offset, count, paged, err := parseLimit(args[3:])
+	if err != nil {
+		return
+	}
+	if paged {
+		if start, end = limitRange(db.ZCard([]byte(args[0])), start, end, offset, count); start > end {
+			return protocol.Array{}, nil
+		}
+	}
 
 	var val []interface{}
 	if rev {
@@ -112,41 +123,58 @@ func zRawRange(db *mindb.MinDB, args []string, rev bool) (res string, err error)
 		val = db.ZRange([]byte(args[0]), start, end)
 	}
 
-	for i, v := range val {
-		res += fmt.Sprintf("%v", v)
-		if i != len(val)-1 {
-			res += "\n"
-		}
+	items := make(protocol.Array, 0, len(val))
+	for _, v := range val {
+		items = append(items, protocol.Bulk(fmt.Sprintf("%v", v)))
 	}
+	res = items
 	return
 }
 
-func zRem(db *mindb.MinDB, args []string) (res string, err error) {
+// 将 start 到 stop 的排名范围（可以为负数，表示倒数）换算为其中从 offset 开始的 count 个元素的排名范围，
+// 范围为空时返回的 start 大于 stop
+func limitRange(card, start, stop, offset, count int) (int, int) {
+	if start < 0 {
+		start += card
+	}
+	if stop < 0 {
+		stop += card
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= card {
+		stop = card - 1
+	}
+	start += offset
+	if count >= 0 && start+count-1 < stop {
+		stop = start + count - 1
+	}
+	return start, stop
+}
+
+func zRem(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	if len(args) != 2 {
 		err = ErrSyntaxIncorrect
 		return
 	}
 	var ok bool
 	if ok, err = db.ZRem([]byte(args[0]), []byte(args[1])); err == nil {
-		if ok {
-			res = "1"
-		} else {
-			res = "0"
-		}
+		res = boolReply(ok)
 	}
 	return
 }
 
-func zGetByRank(db *mindb.MinDB, args []string) (res string, err error) {
+func zGetByRank(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	return zRawGetByRank(db, args, false)
 }
 
-func zRevGetByRank(db *mindb.MinDB, args []string) (res string, err error) {
+func zRevGetByRank(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	return zRawGetByRank(db, args, true)
 }
 
 // for zGetByRank and zRevGetByRank
-func zRawGetByRank(db *mindb.MinDB, args []string, rev bool) (res string, err error) {
+func zRawGetByRank(db *mindb.MinDB, args []string, rev bool) (res protocol.Reply, err error) {
 	if len(args) != 2 {
 		err = ErrSyntaxIncorrect
 		return
@@ -163,25 +191,24 @@ func zRawGetByRank(db *mindb.MinDB, args []string, rev bool) (res string, err er
 	} else {
 		val = db.ZGetByRank([]byte(args[0]), rank)
 	}
-	for i, v := range val {
-		res += fmt.Sprintf("%v", v)
-		if i != len(val)-1 {
-			res += "\n"
-		}
+	items := make(protocol.Array, 0, len(val))
+	for _, v := range val {
+		items = append(items, protocol.Bulk(fmt.Sprintf("%v", v)))
 	}
+	res = items
 	return
 }
 
-func zScoreRange(db *mindb.MinDB, args []string) (res string, err error) {
+func zScoreRange(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	return zRawScoreRange(db, args, false)
 }
 
-func zSRevScoreRange(db *mindb.MinDB, args []string) (res string, err error) {
+func zSRevScoreRange(db *mindb.MinDB, args []string) (res protocol.Reply, err error) {
 	return zRawScoreRange(db, args, true)
 }
 
 // for zScoreRange and zSRevScoreRange
-func zRawScoreRange(db *mindb.MinDB, args []string, rev bool) (res string, err error) {
+func zRawScoreRange(db *mindb.MinDB, args []string, rev bool) (res protocol.Reply, err error) {
 	if len(args) != 3 {
 		err = ErrSyntaxIncorrect
 		return
@@ -202,12 +229,11 @@ func zRawScoreRange(db *mindb.MinDB, args []string, rev bool) (res string, err e
 	} else {
 		val = db.ZScoreRange([]byte(args[0]), param1, param2)
 	}
-	for i, v := range val {
-		res += fmt.Sprintf("%v", v)
-		if i != len(val)-1 {
-			res += "\n"
-		}
+	items := make(protocol.Array, 0, len(val))
+	for _, v := range val {
+		items = append(items, protocol.Bulk(fmt.Sprintf("%v", v)))
 	}
+	res = items
 	return
 }
 
@@ -0,0 +1,312 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"mindb"
+	"mindb/cmd/protocol"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnknownConfig = errors.New("unknown config parameter")
+
+	ErrConfigReadOnly = errors.New("config parameter can not be changed at runtime")
+
+	ErrInvalidConfigValue = errors.New("invalid config value")
+)
+
+// CONFIG 命令可以访问的配置项
+type configParam struct {
+	name string
+	db   bool                                 // 是否为数据库的配置，否则为服务端的配置
+	get  func(c *mindb.Config) string         // 读取配置项
+	set  func(c *mindb.Config, v string) bool // 修改配置项，值不合法时返回 false，为 nil 时配置项只读
+}
+
+// 所有可以通过 CONFIG 命令访问的配置项，按 CONFIG GET 的输出顺序排列
+// 决定磁盘数据格式的配置项、监听地址在运行时不能修改
+var configParams = []configParam{
+	readOnlyParam("addr", func(c *mindb.Config) interface{} { return c.Addr }),
+	readOnlyParam("resp_addr", func(c *mindb.Config) interface{} { return c.RespAddr }),
+	readOnlyParam("ws_addr", func(c *mindb.Config) interface{} { return c.WsAddr }),
+	readOnlyParam("debug_addr", func(c *mindb.Config) interface{} { return c.DebugAddr }),
+	readOnlyParam("upgrade_socket", func(c *mindb.Config) interface{} { return c.UpgradeSocket }),
+	readOnlyParam("dir_path", func(c *mindb.Config) interface{} { return c.DirPath }),
+	readOnlyParam("block_size", func(c *mindb.Config) interface{} { return c.BlockSize }),
+	readOnlyParam("rw_method", func(c *mindb.Config) interface{} { return c.RwMethod }),
+	readOnlyParam("idx_mode", func(c *mindb.Config) interface{} { return c.IdxMode }),
+	readOnlyParam("checksum", func(c *mindb.Config) interface{} { return c.Checksum }),
+	readOnlyParam("verify_value_on_read", func(c *mindb.Config) interface{} { return c.VerifyValueOnRead }),
+	readOnlyParam("recovery_mode", func(c *mindb.Config) interface{} { return c.RecoveryMode }),
+	readOnlyParam("worker_pool_size", func(c *mindb.Config) interface{} { return c.WorkerPoolSize }),
+
+	{
+		name: "sync", db: true,
+		get: func(c *mindb.Config) string { return strconv.FormatBool(c.Sync) },
+		set: func(c *mindb.Config, v string) bool {
+			b, err := strconv.ParseBool(v)
+			c.Sync = b
+			return err == nil
+		},
+	},
+	uint32Param("max_key_size", true, func(c *mindb.Config) *uint32 { return &c.MaxKeySize }),
+	uint32Param("max_value_size", true, func(c *mindb.Config) *uint32 { return &c.MaxValueSize }),
+	{
+		name: "str_patch_min_size", db: true,
+		get: func(c *mindb.Config) string { return strconv.FormatUint(uint64(c.StrPatchMinSize), 10) },
+		set: func(c *mindb.Config, v string) bool {
+			n, err := strconv.ParseUint(v, 10, 32)
+			c.StrPatchMinSize = uint32(n)
+			return err == nil
+		},
+	},
+	{
+		name: "hotkey_sample_rate", db: true,
+		get: func(c *mindb.Config) string { return strconv.FormatFloat(c.HotKeySampleRate, 'f', -1, 64) },
+		set: func(c *mindb.Config, v string) bool {
+			f, err := strconv.ParseFloat(v, 64)
+			c.HotKeySampleRate = f
+			return err == nil && f >= 0 && f <= 1
+		},
+	},
+	int64Param("hotkey_window", true, func(c *mindb.Config) *int64 { return &c.HotKeyWindow }),
+	intParam("change_backlog", true, func(c *mindb.Config) *int { return &c.ChangeBacklog }),
+	int64Param("trash_ttl", true, func(c *mindb.Config) *int64 { return &c.TrashTTL }),
+	intParam("list_max_len", true, func(c *mindb.Config) *int { return &c.ListMaxLen }),
+	intParam("hash_max_len", true, func(c *mindb.Config) *int { return &c.HashMaxLen }),
+	intParam("set_max_len", true, func(c *mindb.Config) *int { return &c.SetMaxLen }),
+	intParam("zset_max_len", true, func(c *mindb.Config) *int { return &c.ZSetMaxLen }),
+	{
+		name: "sorted_reads", db: true,
+		get: func(c *mindb.Config) string { return strconv.FormatBool(c.SortedReads) },
+		set: func(c *mindb.Config, v string) bool {
+			b, err := strconv.ParseBool(v)
+			c.SortedReads = b
+			return err == nil
+		},
+	},
+	intParam("reclaim_threshold", true, func(c *mindb.Config) *int { return &c.ReclaimThreshold }),
+	int64Param("reclaim_min_bytes", true, func(c *mindb.Config) *int64 { return &c.ReclaimMinBytes }),
+	{
+		name: "reclaim_ratio", db: true,
+		get: func(c *mindb.Config) string { return strconv.FormatFloat(c.ReclaimRatio, 'f', -1, 64) },
+		set: func(c *mindb.Config, v string) bool {
+			f, err := strconv.ParseFloat(v, 64)
+			c.ReclaimRatio = f
+			return err == nil && f >= 0 && f <= 1
+		},
+	},
+	intParam("reclaim_workers", true, func(c *mindb.Config) *int { return &c.ReclaimWorkers }),
+	intParam("reclaim_buf_size", true, func(c *mindb.Config) *int { return &c.ReclaimBufSize }),
+	{
+		name: "reclaim_tmp_dir", db: true,
+		get: func(c *mindb.Config) string { return c.ReclaimTmpDir },
+		set: func(c *mindb.Config, v string) bool {
+			c.ReclaimTmpDir = v
+			return true
+		},
+	},
+
+	intParam("max_clients", false, func(c *mindb.Config) *int { return &c.MaxClients }),
+	intParam("max_request_size", false, func(c *mindb.Config) *int { return &c.MaxRequestSize }),
+	{
+		name: "client_rate_limit",
+		get:  func(c *mindb.Config) string { return strconv.FormatFloat(c.ClientRateLimit, 'f', -1, 64) },
+		set: func(c *mindb.Config, v string) bool {
+			f, err := strconv.ParseFloat(v, 64)
+			c.ClientRateLimit = f
+			return err == nil && f >= 0
+		},
+	},
+	intParam("client_rate_burst", false, func(c *mindb.Config) *int { return &c.ClientRateBurst }),
+	{
+		name: "ws_allowed_origins",
+		get:  func(c *mindb.Config) string { return c.WsAllowedOrigins },
+		set: func(c *mindb.Config, v string) bool {
+			c.WsAllowedOrigins = v
+			return true
+		},
+	},
+	int64Param("conn_idle_timeout", false, func(c *mindb.Config) *int64 { return &c.ConnIdleTimeout }),
+	int64Param("conn_read_timeout", false, func(c *mindb.Config) *int64 { return &c.ConnReadTimeout }),
+	int64Param("conn_write_timeout", false, func(c *mindb.Config) *int64 { return &c.ConnWriteTimeout }),
+	int64Param("command_timeout", false, func(c *mindb.Config) *int64 { return &c.CommandTimeout }),
+	{
+		name: "tcp_keepalive",
+		get:  func(c *mindb.Config) string { return strconv.FormatInt(c.TCPKeepAlive, 10) },
+		set: func(c *mindb.Config, v string) bool {
+			n, err := strconv.ParseInt(v, 10, 64)
+			c.TCPKeepAlive = n
+			return err == nil
+		},
+	},
+	{
+		name: "tcp_nodelay",
+		get:  func(c *mindb.Config) string { return strconv.FormatBool(c.TCPNoDelay) },
+		set: func(c *mindb.Config, v string) bool {
+			b, err := strconv.ParseBool(v)
+			c.TCPNoDelay = b
+			return err == nil
+		},
+	},
+	intParam("tcp_read_buffer", false, func(c *mindb.Config) *int { return &c.TCPReadBuffer }),
+	intParam("tcp_write_buffer", false, func(c *mindb.Config) *int { return &c.TCPWriteBuffer }),
+	int64Param("shutdown_timeout", false, func(c *mindb.Config) *int64 { return &c.ShutdownTimeout }),
+	{
+		name: "slowlog_threshold",
+		get:  func(c *mindb.Config) string { return strconv.FormatInt(c.SlowlogThreshold, 10) },
+		set: func(c *mindb.Config, v string) bool {
+			n, err := strconv.ParseInt(v, 10, 64)
+			c.SlowlogThreshold = n
+			return err == nil
+		},
+	},
+	intParam("slowlog_max_len", false, func(c *mindb.Config) *int { return &c.SlowlogMaxLen }),
+}
+
+func readOnlyParam(name string, field func(c *mindb.Config) interface{}) configParam {
+	return configParam{name: name, get: func(c *mindb.Config) string { return fmt.Sprint(field(c)) }}
+}
+
+// 非负整数类型的配置项
+func intParam(name string, db bool, field func(c *mindb.Config) *int) configParam {
+	return configParam{
+		name: name, db: db,
+		get: func(c *mindb.Config) string { return strconv.Itoa(*field(c)) },
+		set: func(c *mindb.Config, v string) bool {
+			n, err := strconv.Atoi(v)
+			*field(c) = n
+			return err == nil && n >= 0
+		},
+	}
+}
+
+func int64Param(name string, db bool, field func(c *mindb.Config) *int64) configParam {
+	return configParam{
+		name: name, db: db,
+		get: func(c *mindb.Config) string { return strconv.FormatInt(*field(c), 10) },
+		set: func(c *mindb.Config, v string) bool {
+			n, err := strconv.ParseInt(v, 10, 64)
+			*field(c) = n
+			return err == nil && n >= 0
+		},
+	}
+}
+
+// 正整数类型的配置项
+func uint32Param(name string, db bool, field func(c *mindb.Config) *uint32) configParam {
+	return configParam{
+		name: name, db: db,
+		get: func(c *mindb.Config) string { return strconv.FormatUint(uint64(*field(c)), 10) },
+		set: func(c *mindb.Config, v string) bool {
+			n, err := strconv.ParseUint(v, 10, 32)
+			*field(c) = uint32(n)
+			return err == nil && n > 0
+		},
+	}
+}
+
+// 服务端当前的配置
+func (s *Server) conf() mindb.Config {
+	return s.config.Load().(mindb.Config)
+}
+
+// 处理 CONFIG GET pattern [pattern...]、CONFIG SET parameter value 及 CONFIG REWRITE 命令
+func (s *Server) configCmd(args []string) protocol.Reply {
+	if len(args) == 0 {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "get":
+		if len(args) < 2 {
+			return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+		}
+		return s.configGet(args[1:])
+	case "set":
+		if len(args) != 3 {
+			return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+		}
+		if err := s.configSet(strings.ToLower(args[1]), args[2]); err != nil {
+			return protocol.Error("ERR " + err.Error())
+		}
+		return okReply
+	case "rewrite":
+		if len(args) != 1 {
+			return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+		}
+		if err := s.configRewrite(); err != nil {
+			return protocol.Error("ERR " + err.Error())
+		}
+		return okReply
+	}
+	return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+}
+
+// 返回名称与任一模式匹配的配置项，以 名称、值 交替排列
+func (s *Server) configGet(patterns []string) protocol.Reply {
+	serverConf, dbConf := s.conf(), s.db.Config()
+
+	res := protocol.Array{}
+	for _, p := range configParams {
+		for _, pattern := range patterns {
+			if !matchPattern(strings.ToLower(pattern), p.name) {
+				continue
+			}
+			conf := &serverConf
+			if p.db {
+				conf = &dbConf
+			}
+			res = append(res, protocol.Bulk(p.name), protocol.Bulk(p.get(conf)))
+			break
+		}
+	}
+	return res
+}
+
+// 修改配置项，数据库的配置项同时修改数据库的配置，服务端的配置项对之后的连接和请求生效
+func (s *Server) configSet(name, value string) error {
+	var param *configParam
+	for i := range configParams {
+		if configParams[i].name == name {
+			param = &configParams[i]
+		}
+	}
+	if param == nil {
+		return ErrUnknownConfig
+	}
+	if param.set == nil {
+		return ErrConfigReadOnly
+	}
+
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	conf := s.conf()
+	if !param.set(&conf, value) {
+		return ErrInvalidConfigValue
+	}
+	if param.db {
+		if err := s.db.SetConfig(func(c *mindb.Config) { param.set(c, value) }); err != nil {
+			return err
+		}
+	}
+	s.config.Store(conf)
+	return nil
+}
+
+// 处理 SHUTDOWN 命令，通知服务端退出，退出时会等待正在执行的命令完成
+func (s *Server) shutdownCmd(args []string) protocol.Reply {
+	if len(args) != 0 {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+	s.shutdownOnce.Do(func() { close(s.shutdown) })
+	return okReply
+}
+
+// ShutdownRequested 客户端通过 SHUTDOWN 命令请求关闭服务时，返回的 channel 会被关闭
+func (s *Server) ShutdownRequested() <-chan struct{} {
+	return s.shutdown
+}
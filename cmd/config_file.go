@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/pelletier/go-toml"
+	"io/ioutil"
+	"log"
+	"mindb"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var ErrNoConfigFile = errors.New("the server is not running with a config file")
+
+// 配置文件中 key = value 格式的一行
+var configLineReg = regexp.MustCompile(`^\s*([A-Za-z0-9_]+)\s*=`)
+
+// LoadConfigFile 读取 toml 格式的配置文件，文件中没有的配置项使用默认值
+func LoadConfigFile(path string) (mindb.Config, error) {
+	cfg := mindb.DefaultConfig()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	err = toml.Unmarshal(data, &cfg)
+	return cfg, err
+}
+
+// SetConfigFile 设置服务启动时加载的配置文件，ReloadConfig 及 CONFIG REWRITE 读写这个文件
+func (s *Server) SetConfigFile(path string) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	s.configFile = path
+}
+
+func (s *Server) getConfigFile() string {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	return s.configFile
+}
+
+// ReloadConfig 重新读取配置文件，应用其中可以在运行时修改的配置项（与 CONFIG SET 相同），一般在收到 SIGHUP 时调用
+// 监听地址、数据目录等不能在运行时修改的配置项被修改时只记录日志，需要重启才能生效
+func (s *Server) ReloadConfig() error {
+	path := s.getConfigFile()
+	if path == "" {
+		return ErrNoConfigFile
+	}
+	fileConf, err := LoadConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	serverConf, dbConf := s.conf(), s.db.Config()
+	var changed []string
+	for _, p := range configParams {
+		conf := &serverConf
+		if p.db {
+			conf = &dbConf
+		}
+		value := p.get(&fileConf)
+		if value == p.get(conf) {
+			continue
+		}
+		if p.set == nil {
+			log.Printf("config %s changed in %s, restart the server to apply it\n", p.name, path)
+			continue
+		}
+		if err = s.configSet(p.name, value); err != nil {
+			return fmt.Errorf("%s: %v", p.name, err)
+		}
+		changed = append(changed, p.name+"="+value)
+	}
+	log.Printf("reloaded config from %s, changed: [%s]\n", path, strings.Join(changed, " "))
+	return nil
+}
+
+// 处理 CONFIG REWRITE 命令，将运行时修改过的配置写回配置文件
+// 文件中已有的配置项原地修改其值，保留注释及其他行；文件中没有且与默认值不同的配置项追加到文件末尾
+func (s *Server) configRewrite() error {
+	path := s.getConfigFile()
+	if path == "" {
+		return ErrNoConfigFile
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// 当前配置中每个可以在运行时修改的配置项在 toml 中的写法
+	current, dbConf, defaults := s.conf(), s.db.Config(), mindb.DefaultConfig()
+	settable := make(map[string]bool)
+	for _, p := range configParams {
+		if p.set == nil {
+			continue
+		}
+		if p.db {
+			p.set(&current, p.get(&dbConf))
+		}
+		settable[p.name] = true
+	}
+	encoded, err := toml.Marshal(current)
+	if err != nil {
+		return err
+	}
+	lines := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(encoded))
+	for scanner.Scan() {
+		if m := configLineReg.FindStringSubmatch(scanner.Text()); m != nil && settable[m[1]] {
+			lines[m[1]] = scanner.Text()
+		}
+	}
+
+	var buf bytes.Buffer
+	written := make(map[string]bool)
+	scanner = bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := configLineReg.FindStringSubmatch(line); m != nil && lines[m[1]] != "" {
+			line = lines[m[1]]
+			written[m[1]] = true
+		}
+		buf.WriteString(line + "\n")
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	var appended bool
+	for _, p := range configParams {
+		if lines[p.name] == "" || written[p.name] || p.get(&current) == p.get(&defaults) {
+			continue
+		}
+		if !appended {
+			buf.WriteString("\n# 以下配置项由 CONFIG REWRITE 添加\n")
+			appended = true
+		}
+		buf.WriteString(lines[p.name] + "\n")
+	}
+
+	// 先写入同目录下的临时文件再替换，写入过程中出错时原来的配置文件不受影响
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if info, statErr := os.Stat(path); statErr == nil {
+		mode = info.Mode().Perm()
+	}
+	if err = tmp.Chmod(mode); err == nil {
+		_, err = tmp.Write(buf.Bytes())
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,252 @@
+package cmd
+
+import (
+	"context"
+	"mindb"
+	"mindb/cmd/protocol"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errNoAuth     = protocol.Error("NOAUTH Authentication required.")
+	errMaxClients = protocol.Error("ERR max number of clients reached")
+
+	errShuttingDown = protocol.Error("ERR server is shutting down")
+
+	errNoWrites = protocol.Error("READONLY the connection is in no-writes mode, use CLIENT NO-WRITES OFF to allow writes")
+)
+
+// 客户端连接的状态
+type connState struct {
+	addr string       // 客户端地址
+	sub  *subscriber  // 连接的订阅信息
+	user atomic.Value // 已认证的用户名，同一连接的命令按顺序逐个执行，但可能在工作池的不同goroutine中执行，因此使用原子操作
+	tx   transaction  // MULTI 之后排队的命令
+
+	proto      connProto     // 连接使用的协议
+	browser    bool          // 是否为浏览器网页发起的 WebSocket 连接，默认用户不需要密码时也必须先认证
+	done       chan struct{} // 连接关闭时被关闭
+	streaming  int32         // 是否正在持续推送消息（如 CHANGES），推送期间不受空闲超时限制
+	limiter    tokenBucket   // 连接的命令速率限制
+	noWrites   int32         // 是否拒绝修改数据的命令，由 CLIENT NO-WRITES 设置
+	ctx        context.Context
+	cancel     context.CancelFunc // 客户端断开连接时取消 ctx，正在执行的可取消命令随之停止
+	replyMu    sync.Mutex
+	afterReply []func() // 命令的响应写入之后执行的操作
+	idleMu     sync.Mutex
+	inflight   int  // 已读取但响应还未写入的请求数，期间不计算空闲超时
+	waiting    bool // 读循环是否正在等待下一个请求
+}
+
+func newConnState(addr string, push func(protocol.Reply) error) *connState {
+	state := &connState{addr: addr, sub: newSubscriber(push), done: make(chan struct{})}
+	state.ctx, state.cancel = context.WithCancel(context.Background())
+	state.user.Store("")
+	return state
+}
+
+// 连接关闭时清理连接的订阅、监视、副本信息及 WATCH 的key
+func (s *Server) closeConnState(state *connState) {
+	s.pubsub.removeSubscriber(state.sub)
+	s.removeMonitor(state)
+	s.replicas.remove(state)
+	state.tx.mu.Lock()
+	s.unwatch(&state.tx)
+	state.tx.mu.Unlock()
+	state.cancel()
+	close(state.done)
+}
+
+// 在当前命令的响应写入连接之后执行 fn，开始推送消息前使用，保证客户端先收到命令本身的响应
+func (c *connState) onReplied(fn func()) {
+	c.replyMu.Lock()
+	defer c.replyMu.Unlock()
+	c.afterReply = append(c.afterReply, fn)
+}
+
+// 响应写入连接之后调用，执行通过 onReplied 登记的操作
+func (c *connState) replied() {
+	c.replyMu.Lock()
+	fns := c.afterReply
+	c.afterReply = nil
+	c.replyMu.Unlock()
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// 已认证的用户名，未认证时为空
+func (c *connState) username() string {
+	return c.user.Load().(string)
+}
+
+func (c *connState) setUser(name string) {
+	c.user.Store(name)
+}
+
+// 处理 AUTH 命令，AUTH password 认证为默认用户，AUTH username password 认证为指定用户
+func (s *Server) auth(state *connState, args []string) protocol.Reply {
+	var name, password string
+	switch len(args) {
+	case 1:
+		name, password = DefaultUser, args[0]
+	case 2:
+		name, password = args[0], args[1]
+	default:
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+
+	if !s.acl.Authenticate(name, password) {
+		return protocol.Error("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+	state.setUser(name)
+	return okReply
+}
+
+// 会修改数据或服务端状态的管理命令的子命令，为 nil 时所有子命令都会修改
+var adminWrites = map[string][]string{
+	"shutdown": nil,
+	"config":   {"set", "rewrite"},
+	"acl":      {"setuser", "deluser"},
+	"slowlog":  {"reset"},
+	"view":     {"create", "drop"},
+	"trash":    {"purge"},
+}
+
+// 判断命令是否会修改数据：写命令，以及修改服务端状态的管理命令
+func isMutating(cmd string, args []string) bool {
+	if specOf(cmd).group == WriteGroup {
+		return true
+	}
+	subs, ok := adminWrites[cmd]
+	if !ok {
+		return false
+	}
+	if subs == nil {
+		return true
+	}
+	for _, sub := range subs {
+		if len(args) > 0 && strings.EqualFold(args[0], sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// CLIENT NO-WRITES ON|OFF，开启后这个连接上修改数据的命令都被拒绝，用于在生产环境中安全地查看数据
+// 只影响当前连接，任何用户都可以执行；开启前已经在 MULTI 中排队的命令不受影响
+func (s *Server) clientCmd(state *connState, args []string) protocol.Reply {
+	if len(args) != 2 || !strings.EqualFold(args[0], "no-writes") {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+	switch strings.ToLower(args[1]) {
+	case "on":
+		atomic.StoreInt32(&state.noWrites, 1)
+	case "off":
+		atomic.StoreInt32(&state.noWrites, 0)
+	default:
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+	return okReply
+}
+
+// 占用一个客户端连接数，超过最大连接数时返回 false
+func (s *Server) acquireClient() bool {
+	n := atomic.AddInt64(&s.clients, 1)
+	if max := int64(s.conf().MaxClients); max > 0 && n > max {
+		atomic.AddInt64(&s.clients, -1)
+		return false
+	}
+	return true
+}
+
+// 释放一个客户端连接数
+func (s *Server) releaseClient() {
+	atomic.AddInt64(&s.clients, -1)
+}
+
+// ConnectedClients 当前的客户端连接数
+func (s *Server) ConnectedClients() int64 {
+	return atomic.LoadInt64(&s.clients)
+}
+
+// 自定义协议单个请求中命令的最大字节数
+func (s *Server) maxRequestSize() int {
+	if n := s.conf().MaxRequestSize; n > 0 {
+		return n
+	}
+	return mindb.DefaultMaxRequestSize
+}
+
+// 将配置中的秒数转换为时间
+func seconds(n int64) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// 根据超时时间计算截止时间，超时时间不大于0时不设置截止时间
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// 等待连接上的下一个请求，wait 返回时请求的数据已到达
+// 等待期间使用空闲超时（订阅了频道或正在推送消息的连接不受空闲超时限制），数据到达后使用读取超时，避免卡住的客户端一直占用连接
+// 连接上有请求正在执行（如阻塞的 BQPOP）时不计算空闲超时，响应写入之后由 requestFinished 重新开始计算
+func (s *Server) waitRequest(conn net.Conn, state *connState, wait func() error) error {
+	state.idleMu.Lock()
+	idle := s.idleTimeout(state)
+	if state.inflight > 0 {
+		idle = 0
+	}
+	state.waiting = true
+	_ = conn.SetReadDeadline(deadline(idle))
+	state.idleMu.Unlock()
+
+	err := wait()
+	state.idleMu.Lock()
+	state.waiting = false
+	state.idleMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return conn.SetReadDeadline(deadline(seconds(s.conf().ConnReadTimeout)))
+}
+
+// 等待下一个请求时的空闲超时，订阅了频道或正在推送消息的连接不受空闲超时限制
+func (s *Server) idleTimeout(state *connState) time.Duration {
+	if s.pubsub.subscribed(state.sub) || atomic.LoadInt32(&state.streaming) == 1 {
+		return 0
+	}
+	return seconds(s.conf().ConnIdleTimeout)
+}
+
+// 连接上开始执行一个请求，响应写入之前读循环不计算空闲超时
+func (c *connState) requestStarted() {
+	c.idleMu.Lock()
+	c.inflight++
+	c.idleMu.Unlock()
+}
+
+// 请求的响应已经写入，没有其他正在执行的请求且读循环正在等待时，重新开始计算空闲超时
+func (s *Server) requestFinished(conn net.Conn, state *connState) {
+	state.idleMu.Lock()
+	defer state.idleMu.Unlock()
+
+	state.inflight--
+	if state.inflight == 0 && state.waiting {
+		_ = conn.SetReadDeadline(deadline(s.idleTimeout(state)))
+	}
+}
+
+// 在写入超时内向连接写入数据，客户端长时间不读取响应时写入失败
+func (s *Server) write(conn net.Conn, b []byte) error {
+	_ = conn.SetWriteDeadline(deadline(seconds(s.conf().ConnWriteTimeout)))
+	_, err := conn.Write(b)
+	return err
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"encoding/json"
+	"expvar"
+	"log"
+	"mindb"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 通过数据库的事件监听统计的指标，供调试服务的 expvar 输出
+type metrics struct {
+	mindb.NopHooks
+	bytesWritten    int64 // 写入数据文件的字节数
+	reclaimRuns     int64 // 回收磁盘空间的次数
+	reclaimFailures int64 // 失败的回收次数
+}
+
+func (m *metrics) OnWrite(e mindb.WriteEvent) {
+	atomic.AddInt64(&m.bytesWritten, int64(e.Size))
+}
+
+func (m *metrics) OnReclaimEnd(_ []mindb.DataType, _ time.Duration, err error) {
+	atomic.AddInt64(&m.reclaimRuns, 1)
+	if err != nil {
+		atomic.AddInt64(&m.reclaimFailures, 1)
+	}
+}
+
+var (
+	publishOnce sync.Once
+	debugServer atomic.Value // 输出 expvar 指标的服务，进程中只有一组 expvar，以最后开启调试服务的为准
+)
+
+// ListenDebug 监听调试用的 HTTP 服务，/debug/pprof/ 下为 net/http/pprof 的性能分析接口，/debug/vars 为 expvar 指标，
+// /debug/keys 为按前缀统计的key数量及大小
+// 性能分析可以获取进程的内存等信息，应只监听在本机或内网地址上
+func (s *Server) ListenDebug(addr string) {
+	listeners, err := s.listen(addr)
+	if err != nil {
+		log.Printf("debug listen err: %+v\n", err)
+		return
+	}
+
+	debugServer.Store(s)
+	publishOnce.Do(func() {
+		expvar.Publish("mindb", expvar.Func(func() interface{} {
+			return debugServer.Load().(*Server).debugVars()
+		}))
+		expvar.Publish("mindb_users", expvar.Func(func() interface{} {
+			return debugServer.Load().(*Server).userVars()
+		}))
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/keys", s.serveKeySizes)
+
+	log.Printf("mindb debug server is listening on %s.\n", addr)
+	var wg sync.WaitGroup
+	for _, listener := range listeners {
+		wg.Add(1)
+		go func(listener net.Listener) {
+			defer wg.Done()
+			err := http.Serve(listener, mux)
+			select {
+			case <-s.done: // 服务关闭时监听被关闭，不是错误
+			default:
+				log.Printf("debug serve err: %+v\n", err)
+			}
+		}(listener)
+	}
+	wg.Wait()
+}
+
+// expvar 中输出的指标
+func (s *Server) debugVars() map[string]int64 {
+	return map[string]int64{
+		"commands_processed":    atomic.LoadInt64(&s.commands),
+		"connected_clients":     atomic.LoadInt64(&s.clients),
+		"rate_limited_commands": atomic.LoadInt64(&s.limited),
+		"bytes_written":         atomic.LoadInt64(&s.metrics.bytesWritten),
+		"reclaim_runs":          atomic.LoadInt64(&s.metrics.reclaimRuns),
+		"reclaim_failures":      atomic.LoadInt64(&s.metrics.reclaimFailures),
+	}
+}
+
+// expvar 中按用户输出的指标，以用户名为键
+func (s *Server) userVars() map[string]map[string]int64 {
+	vars := make(map[string]map[string]int64)
+	for _, m := range s.UserMetrics() {
+		vars[m.User] = map[string]int64{
+			"commands_processed": m.Commands,
+			"rejected_commands":  m.Rejected,
+			"bytes_in":           m.BytesIn,
+			"bytes_out":          m.BytesOut,
+		}
+	}
+	return vars
+}
+
+// 以 JSON 输出各key前缀下字符串key的数量及大小，按大小从大到小排列，参数 n 限制返回的前缀数量
+func (s *Server) serveKeySizes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var n int
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "invalid n", http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.db.PrefixSizes(n)); err != nil {
+		log.Printf("write key sizes err: %+v\n", err)
+	}
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"mindb"
+	"mindb/cmd/protocol"
+	"strconv"
+)
+
+// 连接使用的协议及其版本
+type connProto struct {
+	name    string
+	version int
+}
+
+var (
+	protoMinDB     = connProto{"mindb", protocol.Version}
+	protoRESP      = connProto{"resp", 2} // 只支持 RESP2，Redis 客户端 HELLO 3 失败后会回退到 RESP2
+	protoWebSocket = connProto{"websocket", 1}
+)
+
+// 处理 HELLO [protover] 命令，连接建立后的握手
+// 客户端带上自己使用的协议版本，与服务端不一致时返回 NOPROTO 错误，使客户端在解析出错之前就能发现不兼容的服务端；
+// 成功时返回服务端的信息，以 字段 值 交替排列
+func (s *Server) hello(state *connState, args []string) protocol.Reply {
+	if len(args) > 1 {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+	proto := state.proto
+	if len(args) == 1 {
+		v, err := strconv.Atoi(args[0])
+		if err != nil {
+			return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+		}
+		if v != proto.version {
+			return protocol.Error("NOPROTO unsupported protocol version, the server speaks " +
+				proto.name + " protocol version " + strconv.Itoa(proto.version))
+		}
+	}
+
+	user := state.username()
+	if user == "" && s.acl.defaultNoPass() {
+		user = DefaultUser
+	}
+	return protocol.Array{
+		protocol.Bulk("server"), protocol.Bulk("mindb"),
+		protocol.Bulk("version"), protocol.Bulk(mindb.Version),
+		protocol.Bulk("protocol"), protocol.Bulk(proto.name),
+		protocol.Bulk("proto"), protocol.Integer(proto.version),
+		protocol.Bulk("user"), protocol.Bulk(user),
+	}
+}
+
+// 处理 PING [message] 命令，没有参数时返回 PONG，否则原样返回 message
+func ping(args []string) protocol.Reply {
+	switch len(args) {
+	case 0:
+		return protocol.SimpleString("PONG")
+	case 1:
+		return protocol.Bulk(args[0])
+	}
+	return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+}
+
+// 处理 ECHO message 命令
+func echo(args []string) protocol.Reply {
+	if len(args) != 1 {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+	return protocol.Bulk(args[0])
+}
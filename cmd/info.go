@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"fmt"
+	"mindb"
+	"mindb/cmd/protocol"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// INFO 命令的各个部分，按顺序输出
+var infoSections = []struct {
+	name  string
+	title string
+	fn    func(s *Server) [][2]string
+}{
+	{"server", "Server", (*Server).serverInfo},
+	{"clients", "Clients", (*Server).clientsInfo},
+	{"memory", "Memory", (*Server).memoryInfo},
+	{"persistence", "Persistence", (*Server).persistenceInfo},
+	{"replication", "Replication", (*Server).replicationInfo},
+	{"keyspace", "Keyspace", (*Server).keyspaceInfo},
+	{"tenants", "Tenants", (*Server).tenantsInfo},
+}
+
+// 处理 INFO [section] 命令，以 Redis INFO 的格式返回服务端的状态，不指定 section 时返回全部
+func (s *Server) info(args []string) protocol.Reply {
+	if len(args) > 1 {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+	section := "all"
+	if len(args) == 1 {
+		section = strings.ToLower(args[0])
+	}
+
+	var b strings.Builder
+	for _, sec := range infoSections {
+		if section != "all" && section != sec.name {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + sec.title + "\r\n")
+		for _, kv := range sec.fn(s) {
+			b.WriteString(kv[0] + ":" + kv[1] + "\r\n")
+		}
+	}
+	return protocol.Bulk(b.String())
+}
+
+func (s *Server) serverInfo() [][2]string {
+	uptime := time.Since(s.startedAt)
+	return [][2]string{
+		{"mindb_version", mindb.Version},
+		{"go_version", runtime.Version()},
+		{"os", runtime.GOOS + " " + runtime.GOARCH},
+		{"process_id", fmt.Sprint(os.Getpid())},
+		{"addr", s.conf().Addr},
+		{"uptime_in_seconds", fmt.Sprint(int64(uptime.Seconds()))},
+		{"uptime_in_days", fmt.Sprint(int64(uptime.Hours() / 24))},
+	}
+}
+
+func (s *Server) clientsInfo() [][2]string {
+	return [][2]string{
+		{"connected_clients", fmt.Sprint(s.ConnectedClients())},
+		{"maxclients", fmt.Sprint(s.conf().MaxClients)},
+		{"rate_limited_commands", fmt.Sprint(atomic.LoadInt64(&s.limited))},
+	}
+}
+
+// 内存索引及进程的内存使用情况，索引都保存在堆上，堆内存基本即为索引占用的内存
+func (s *Server) memoryInfo() [][2]string {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return [][2]string{
+		{"used_memory", fmt.Sprint(m.HeapAlloc)},
+		{"used_memory_human", humanBytes(m.HeapAlloc)},
+		{"used_memory_sys", fmt.Sprint(m.Sys)},
+		{"used_memory_sys_human", humanBytes(m.Sys)},
+		{"num_gc", fmt.Sprint(m.NumGC)},
+	}
+}
+
+func (s *Server) persistenceInfo() [][2]string {
+	stats := s.db.Stats()
+	info := [][2]string{{"reclaiming", fmt.Sprint(boolReply(stats.Reclaiming))}}
+	var reclaimable int64
+	for _, dType := range mindb.DataTypes {
+		name := typeNames[dType]
+		info = append(info,
+			[2]string{name + "_archived_files", fmt.Sprint(stats.ArchivedFiles[dType])},
+			[2]string{name + "_active_file_offset", fmt.Sprint(stats.ActiveFileOffset[dType])},
+			[2]string{name + "_reclaimable_bytes", fmt.Sprint(stats.ReclaimableBytes[dType])},
+		)
+		reclaimable += stats.ReclaimableBytes[dType]
+	}
+	info = append(info,
+		[2]string{"reclaimable_bytes", fmt.Sprint(reclaimable)},
+		[2]string{"corrupt_reads", fmt.Sprint(stats.CorruptReads)},
+		[2]string{"repaired_keys", fmt.Sprint(stats.RepairedKeys)},
+		[2]string{"checksum_mismatches", fmt.Sprint(stats.ChecksumMismatches)},
+		[2]string{"corrupt_on_load", fmt.Sprint(stats.CorruptOnLoad)},
+	)
+
+	// 最近的回收统计，最近的一次为 reclaim_run_0
+	runs := s.db.ReclaimHistory()
+	for i := len(runs) - 1; i >= 0; i-- {
+		info = append(info, [2]string{fmt.Sprintf("reclaim_run_%d", len(runs)-1-i), reclaimRunInfo(runs[i])})
+	}
+	return info
+}
+
+// 一次回收的统计，格式如 start=1700000000,duration_ms=12,status=ok,string_bytes_before=...,string_bytes_after=...,string_entries_dropped=...
+func reclaimRunInfo(run mindb.ReclaimRun) string {
+	status := "ok"
+	if run.Err != "" {
+		status = "err"
+	}
+	v := fmt.Sprintf("start=%d,duration_ms=%d,status=%s", run.Start.Unix(), run.End.Sub(run.Start).Milliseconds(), status)
+	for _, t := range run.Types {
+		name := typeNames[t.Type]
+		v += fmt.Sprintf(",%s_bytes_before=%d,%s_bytes_after=%d,%s_entries_dropped=%d",
+			name, t.BytesBefore, name, t.BytesAfter, name, t.EntriesDropped)
+	}
+	return v
+}
+
+func (s *Server) keyspaceInfo() [][2]string {
+	stats := s.db.Stats()
+	var info [][2]string
+	for _, dType := range mindb.DataTypes {
+		v := fmt.Sprintf("keys=%d", stats.Keys[dType])
+		if dType == mindb.String {
+			v += fmt.Sprintf(",expires=%d", stats.Expires)
+		}
+		info = append(info, [2]string{typeNames[dType], v})
+	}
+	return info
+}
+
+// 各用户的指标，格式如 user_default:commands=100,rejected=0,bytes_in=2048,bytes_out=4096
+func (s *Server) tenantsInfo() [][2]string {
+	var info [][2]string
+	for _, m := range s.UserMetrics() {
+		info = append(info, [2]string{"user_" + m.User, fmt.Sprintf("commands=%d,rejected=%d,bytes_in=%d,bytes_out=%d",
+			m.Commands, m.Rejected, m.BytesIn, m.BytesOut)})
+	}
+	return info
+}
+
+// 将字节数转换为便于阅读的形式，如 1.50M
+func humanBytes(n uint64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+	f, i := float64(n), 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return fmt.Sprintf("%.2f%s", f, units[i])
+}
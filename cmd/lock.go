@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"mindb"
+	"mindb/cmd/protocol"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 等待锁的连接，锁被释放或租约到期时唤醒，由等待者自己重新尝试获取
+type lockWaiters struct {
+	mu sync.Mutex
+	m  map[string]map[chan struct{}]struct{}
+}
+
+func newLockWaiters() *lockWaiters {
+	return &lockWaiters{m: make(map[string]map[chan struct{}]struct{})}
+}
+
+// 登记一个等待者，返回的 channel 在被唤醒时可读
+func (w *lockWaiters) add(key string) chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ch := make(chan struct{}, 1)
+	if w.m[key] == nil {
+		w.m[key] = make(map[chan struct{}]struct{})
+	}
+	w.m[key][ch] = struct{}{}
+	return ch
+}
+
+func (w *lockWaiters) remove(key string, ch chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.m[key], ch)
+	if len(w.m[key]) == 0 {
+		delete(w.m, key)
+	}
+}
+
+// 唤醒 key 上所有的等待者
+func (w *lockWaiters) wake(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch := range w.m[key] {
+		notify(ch)
+	}
+}
+
+// 不阻塞地向 channel 发送通知，已有未读的通知时直接返回
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// 处理 LOCK key ttl [timeout]、RENEWLOCK key token ttl 及 UNLOCK key token 命令
+func (s *Server) lockCmd(cmd string, args []string) protocol.Reply {
+	if !s.beginCmd() {
+		return errShuttingDown
+	}
+	defer s.endCmd()
+
+	var (
+		reply protocol.Reply
+		err   error
+	)
+	switch cmd {
+	case "lock": // 等待期间不能持有 txMu，每次尝试时再获取
+		reply, err = s.lock(args)
+	case "renewlock":
+		s.shared(func() { reply, err = s.renewLock(args) })
+	case "unlock":
+		s.shared(func() { reply, err = s.unlock(args) })
+	}
+	if err != nil {
+		return protocol.Error("ERR " + err.Error())
+	}
+	return reply
+}
+
+// 获取锁，成功时返回 fencing token
+// 没有指定 timeout 时只尝试一次，锁已被持有则返回空；指定时最多等待 timeout 秒，为 0 表示一直等待
+// 等待期间锁被 UNLOCK 释放或租约到期时会重新尝试，超时的计时由时间轮统一管理
+func (s *Server) lock(args []string) (protocol.Reply, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, ErrSyntaxIncorrect
+	}
+	key := args[0]
+	ttl, err := strconv.ParseUint(args[1], 10, 32)
+	if err != nil {
+		return nil, ErrSyntaxIncorrect
+	}
+	block := len(args) == 3
+	var deadline time.Time
+	if block {
+		timeout, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil || timeout < 0 {
+			return nil, ErrSyntaxIncorrect
+		}
+		if timeout > 0 {
+			deadline = time.Now().Add(seconds(timeout))
+		}
+	}
+
+	for {
+		// 先登记再尝试获取，避免错过两者之间发生的释放
+		ch := s.lockWaiters.add(key)
+		var token uint64
+		s.shared(func() { token, err = s.db.AcquireLock([]byte(key), uint32(ttl)) })
+		if err != mindb.ErrLockHeld || !block {
+			s.lockWaiters.remove(key, ch)
+			if err == mindb.ErrLockHeld {
+				return protocol.Bulk(nil), nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return protocol.Integer(token), nil
+		}
+
+		// 最晚在租约到期或等待超时时醒来，锁的过期时间精确到秒，到期后的下一秒才会失效
+		wait := time.Duration(-1)
+		if lease := s.db.TTL([]byte(key)); lease > 0 {
+			wait = time.Duration(lease+1) * time.Second
+		}
+		if !deadline.IsZero() {
+			if left := time.Until(deadline); wait < 0 || left < wait {
+				wait = left
+			}
+		}
+		var t *timer
+		if wait >= 0 {
+			t = s.timers.afterFunc(wait, func() { notify(ch) })
+		}
+
+		closed := false
+		select {
+		case <-ch:
+		case <-s.done:
+			closed = true
+		}
+		if t != nil {
+			t.stop()
+		}
+		s.lockWaiters.remove(key, ch)
+
+		if closed {
+			return errShuttingDown, nil
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return protocol.Bulk(nil), nil
+		}
+	}
+}
+
+// 持有者续约
+func (s *Server) renewLock(args []string) (protocol.Reply, error) {
+	if len(args) != 3 {
+		return nil, ErrSyntaxIncorrect
+	}
+	token, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return nil, ErrSyntaxIncorrect
+	}
+	ttl, err := strconv.ParseUint(args[2], 10, 32)
+	if err != nil {
+		return nil, ErrSyntaxIncorrect
+	}
+	if err = s.db.RenewLock([]byte(args[0]), token, uint32(ttl)); err != nil {
+		return nil, err
+	}
+	return okReply, nil
+}
+
+// 持有者释放锁，并唤醒等待该锁的连接
+func (s *Server) unlock(args []string) (protocol.Reply, error) {
+	if len(args) != 2 {
+		return nil, ErrSyntaxIncorrect
+	}
+	token, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return nil, ErrSyntaxIncorrect
+	}
+	if err = s.db.ReleaseLock([]byte(args[0]), token); err != nil {
+		return nil, err
+	}
+	s.lockWaiters.wake(args[0])
+	return okReply, nil
+}
@@ -0,0 +1,19 @@
+package cmd
+
+// Middleware 包装通过 ExecCmd 执行的命令，cmd 为小写的命令名称，返回的函数代替 next 执行
+// 可以在执行前后记录日志、统计指标、检查参数，或者不调用 next 直接返回错误来拒绝命令
+// 认证、权限、事务、发布订阅等由服务端直接处理的命令不经过中间件；MULTI 中排队的命令在 EXEC 时经过中间件
+type Middleware func(cmd string, next ExecCmdFunc) ExecCmdFunc
+
+// Use 添加中间件，先添加的在外层，需要在开始监听之前调用
+func (s *Server) Use(mw ...Middleware) {
+	s.middlewares = append(s.middlewares, mw...)
+}
+
+// 用中间件依次包装命令的执行函数
+func (s *Server) wrapCmd(cmd string, exec ExecCmdFunc) ExecCmdFunc {
+	for i := len(s.middlewares) - 1; i >= 0; i-- {
+		exec = s.middlewares[i](cmd, exec)
+	}
+	return exec
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"mindb"
+	"mindb/cmd"
+	"mindb/cmd/protocol"
+	"net"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var listen = flag.String("listen", "127.0.0.1:5300", "the address the mirror proxy listens on")
+var primary = flag.String("primary", "127.0.0.1:5200", "the primary mindb server, its replies are returned to clients")
+var secondary = flag.String("secondary", "", "the secondary mindb server that writes are mirrored to")
+var sample = flag.Float64("sample", 0.01, "the fraction of reads that are also sent to the secondary and compared, 0 to 1")
+var statsInterval = flag.Duration("stats", time.Minute, "how often to log the mirror statistics")
+
+// 每个客户端连接等待发往副节点的命令数量上限，超出时丢弃，不拖慢主节点的响应
+const secondaryQueueSize = 1024
+
+// 与副节点的连接状态相关、需要一起同步的命令
+var txCmds = map[string]bool{"multi": true, "exec": true, "discard": true, "watch": true, "unwatch": true}
+
+// 不转发到副节点的命令：长连接的推送命令，以及只与主节点相关的命令
+var unmirrored = map[string]bool{
+	"subscribe": true, "psubscribe": true, "unsubscribe": true, "punsubscribe": true,
+	"monitor": true, "sync": true, "changes": true, "wait": true, "replconf": true,
+}
+
+// 结果不确定的读命令，不参与比较
+var nondeterministic = map[string]bool{"srandmember": true, "ttl": true, "info": true, "slowlog": true}
+
+// 统计信息
+var stats struct {
+	mirrored   int64 // 转发到副节点的写命令
+	compared   int64 // 比较过的读命令
+	mismatched int64 // 结果不一致的读命令
+	failed     int64 // 在副节点上执行失败的命令
+	dropped    int64 // 队列已满被丢弃的命令
+}
+
+// 双写代理：客户端连接代理，命令发往主节点并返回主节点的结果，写命令同时转发到副节点，
+// 按比例抽样的读命令也发往副节点并与主节点的结果比较，用于版本升级或更换机器时安全地迁移数据
+func main() {
+	flag.Parse()
+	if *secondary == "" {
+		log.Println("the secondary server is required, use -secondary")
+		return
+	}
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Printf("tcp listen err: %+v\n", err)
+		return
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	go func() {
+		<-sig
+		listener.Close()
+	}()
+	go logStats()
+
+	log.Printf("mindb mirror is proxying %s to %s, mirroring writes to %s.\n", *listen, *primary, *secondary)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			break
+		}
+		go serve(conn)
+	}
+	log.Println("mindb mirror is ready to exit, bye...")
+}
+
+func logStats() {
+	for range time.Tick(*statsInterval) {
+		log.Printf("mirrored: %d, compared: %d, mismatched: %d, failed: %d, dropped: %d\n",
+			atomic.LoadInt64(&stats.mirrored), atomic.LoadInt64(&stats.compared), atomic.LoadInt64(&stats.mismatched),
+			atomic.LoadInt64(&stats.failed), atomic.LoadInt64(&stats.dropped))
+	}
+}
+
+// 发往副节点的命令，expect 不为空时为抽样的读命令，需要与主节点的结果比较
+type secondaryJob struct {
+	cmd    string
+	expect protocol.Reply
+}
+
+// 一个客户端连接，对应一个主节点连接和一个副节点连接
+type proxyConn struct {
+	client  net.Conn
+	primary net.Conn
+
+	mu      sync.Mutex
+	sampled map[uint32]string // 抽样的读命令，请求id -> 命令
+
+	jobs chan secondaryJob
+}
+
+func serve(client net.Conn) {
+	defer client.Close()
+	primaryConn, err := net.Dial("tcp", *primary)
+	if err != nil {
+		log.Printf("dial primary err: %+v\n", err)
+		return
+	}
+
+	c := &proxyConn{
+		client:  client,
+		primary: primaryConn,
+		sampled: make(map[uint32]string),
+		jobs:    make(chan secondaryJob, secondaryQueueSize),
+	}
+	relayed := make(chan struct{})
+	go func() {
+		c.relayReplies()
+		close(relayed)
+	}()
+	go c.runSecondary()
+	defer func() {
+		// 主节点的响应都处理完后才不再有新的抽样命令，副节点执行完队列中的命令后退出
+		primaryConn.Close()
+		<-relayed
+		close(c.jobs)
+	}()
+
+	reader := bufio.NewReader(client)
+	for {
+		id, data, err := protocol.ReadRequest(reader, mindb.DefaultMaxRequestSize)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("read cmd err: %+v\n", err)
+			}
+			return
+		}
+
+		cmdAndArgs, _ := protocol.SplitArgs(string(data))
+		var name string
+		if len(cmdAndArgs) > 0 {
+			name = strings.ToLower(cmdAndArgs[0])
+		}
+		switch {
+		case name == "" || unmirrored[name]:
+		case cmd.CommandGroup(name) == cmd.WriteGroup || txCmds[name] || name == "auth":
+			c.enqueue(secondaryJob{cmd: string(data)})
+			if name != "auth" {
+				atomic.AddInt64(&stats.mirrored, 1)
+			}
+		case cmd.CommandGroup(name) == cmd.ReadGroup && !nondeterministic[name] && rand.Float64() < *sample:
+			c.mu.Lock()
+			c.sampled[id] = string(data)
+			c.mu.Unlock()
+		}
+
+		if _, err := primaryConn.Write(protocol.EncodeRequest(id, string(data))); err != nil {
+			log.Printf("write primary err: %+v\n", err)
+			return
+		}
+	}
+}
+
+// 将主节点的响应原样返回给客户端，抽样的读命令在得到主节点的结果后再发往副节点，
+// 保证副节点在执行这个读命令之前已经执行了它之前的写命令
+func (c *proxyConn) relayReplies() {
+	defer c.client.Close()
+	reader := bufio.NewReader(c.primary)
+	for {
+		id, reply, err := protocol.ReadResponse(reader)
+		if err != nil {
+			return
+		}
+
+		c.mu.Lock()
+		data, ok := c.sampled[id]
+		delete(c.sampled, id)
+		c.mu.Unlock()
+		if ok {
+			c.enqueue(secondaryJob{cmd: data, expect: reply})
+		}
+
+		if _, err := c.client.Write(protocol.EncodeResponse(id, reply)); err != nil {
+			return
+		}
+	}
+}
+
+func (c *proxyConn) enqueue(job secondaryJob) {
+	select {
+	case c.jobs <- job:
+	default:
+		atomic.AddInt64(&stats.dropped, 1)
+	}
+}
+
+// 按顺序在副节点上执行命令，连接断开时在下一个命令之前重新连接，并重新执行最近一次的 AUTH
+func (c *proxyConn) runSecondary() {
+	var (
+		conn   net.Conn
+		reader *bufio.Reader
+		reqId  uint32
+		auth   string
+	)
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	call := func(data string) (protocol.Reply, error) {
+		reqId++
+		if reqId == protocol.PushId {
+			reqId++
+		}
+		if _, err := conn.Write(protocol.EncodeRequest(reqId, data)); err != nil {
+			return nil, err
+		}
+		for {
+			id, reply, err := protocol.ReadResponse(reader)
+			if err != nil || id == reqId {
+				return reply, err
+			}
+		}
+	}
+
+	for job := range c.jobs {
+		isAuth := strings.EqualFold(firstWord(job.cmd), "auth")
+		if isAuth {
+			auth = job.cmd
+		}
+		if conn == nil {
+			var err error
+			if conn, err = net.Dial("tcp", *secondary); err != nil {
+				conn = nil
+				atomic.AddInt64(&stats.failed, 1)
+				log.Printf("dial secondary err: %+v\n", err)
+				continue
+			}
+			reader = bufio.NewReader(conn)
+			if auth != "" && !isAuth {
+				if _, err = call(auth); err != nil {
+					conn.Close()
+					conn = nil
+					atomic.AddInt64(&stats.failed, 1)
+					continue
+				}
+			}
+		}
+
+		reply, err := call(job.cmd)
+		if err != nil {
+			conn.Close()
+			conn = nil
+			atomic.AddInt64(&stats.failed, 1)
+			log.Printf("secondary err: %+v, cmd: %s\n", err, job.cmd)
+			continue
+		}
+		if job.expect == nil {
+			if e, ok := reply.(protocol.Error); ok && !isAuth {
+				atomic.AddInt64(&stats.failed, 1)
+				log.Printf("secondary err: %s, cmd: %s\n", e, job.cmd)
+			}
+			continue
+		}
+
+		atomic.AddInt64(&stats.compared, 1)
+		if !reflect.DeepEqual(reply, job.expect) {
+			atomic.AddInt64(&stats.mismatched, 1)
+			log.Printf("mismatch, cmd: %s, primary: %s, secondary: %s\n", job.cmd, format(job.expect), format(reply))
+		}
+	}
+}
+
+func firstWord(s string) string {
+	if fields := strings.Fields(s); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+func format(reply protocol.Reply) string {
+	return fmt.Sprintf("%v", protocol.JSONValue(reply))
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"mindb/cmd/protocol"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 每个监视器最多缓存的未发送命令数，客户端读取太慢时丢弃多出的命令，避免拖慢命令的执行
+const monitorBufferSize = 1024
+
+// 参数中可能包含密码的命令，监视时隐藏其参数（AUTH 在认证阶段处理，不会被监视）
+var monitorRedacted = map[string]bool{"acl": true}
+
+// 执行 MONITOR 命令的连接
+type monitor struct {
+	lines chan string
+}
+
+// 所有的监视器
+type monitors struct {
+	mu sync.RWMutex
+	m  map[*connState]*monitor
+}
+
+func newMonitors() *monitors {
+	return &monitors{m: make(map[*connState]*monitor)}
+}
+
+// 处理 MONITOR 命令，之后服务端执行的每个命令都会推送给该连接
+func (s *Server) monitorCmd(state *connState, args []string) protocol.Reply {
+	if len(args) != 0 {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+
+	s.monitors.mu.Lock()
+	defer s.monitors.mu.Unlock()
+	if _, ok := s.monitors.m[state]; ok {
+		return okReply
+	}
+
+	mon := &monitor{lines: make(chan string, monitorBufferSize)}
+	s.monitors.m[state] = mon
+	go func() {
+		for line := range mon.lines {
+			if err := state.sub.push(protocol.SimpleString(line)); err != nil {
+				break
+			}
+		}
+		for range mon.lines { // 连接出错后丢弃剩余的命令，直到监视器被移除
+		}
+	}()
+	return okReply
+}
+
+// 移除连接的监视器
+func (s *Server) removeMonitor(state *connState) {
+	s.monitors.mu.Lock()
+	defer s.monitors.mu.Unlock()
+	if mon, ok := s.monitors.m[state]; ok {
+		close(mon.lines)
+		delete(s.monitors.m, state)
+	}
+}
+
+// 将执行的命令推送给所有的监视器，格式与 Redis 相同：时间戳 [客户端地址] "命令" "参数"...
+func (s *Server) feedMonitors(state *connState, cmd string, args []string) {
+	s.monitors.mu.RLock()
+	defer s.monitors.mu.RUnlock()
+	if len(s.monitors.m) == 0 {
+		return
+	}
+
+	now := time.Now()
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d.%06d [%s] %s", now.Unix(), now.Nanosecond()/1000, state.addr, strconv.Quote(cmd))
+	if monitorRedacted[cmd] && len(args) > 0 {
+		b.WriteString(` "(redacted)"`)
+	} else {
+		for _, arg := range args {
+			b.WriteString(" " + strconv.Quote(arg))
+		}
+	}
+
+	line := b.String()
+	for _, mon := range s.monitors.m {
+		select {
+		case mon.lines <- line:
+		default:
+		}
+	}
+}
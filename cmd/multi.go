@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"mindb"
+	"mindb/cmd/protocol"
+	"sync"
+)
+
+var (
+	errNestedMulti    = protocol.Error("ERR MULTI calls can not be nested")
+	errExecNoMulti    = protocol.Error("ERR EXEC without MULTI")
+	errDiscardNoMulti = protocol.Error("ERR DISCARD without MULTI")
+	errExecAbort      = protocol.Error("EXECABORT Transaction discarded because of previous errors.")
+	errWatchInMulti   = protocol.Error("ERR WATCH inside MULTI is not allowed")
+)
+
+// 一个连接上的事务：MULTI 之后的命令先排队，EXEC 时一起执行
+type transaction struct {
+	mu     sync.Mutex
+	active bool       // 是否处于 MULTI 状态
+	failed bool       // 排队时出现了错误，EXEC 时放弃整个事务
+	queued [][]string // 排队的命令及其参数
+
+	watched map[string]uint64 // WATCH 的key及其开始监视时的版本，EXEC 时任一key的版本变化则放弃事务
+}
+
+// 处理事务相关的命令，以及事务中需要排队的命令，第二个返回值表示命令是否已被处理
+// 事务中只能使用通过 ExecCmd 执行的数据命令，其他命令会使事务在 EXEC 时被放弃
+func (s *Server) handleTx(state *connState, cmd string, args []string) (protocol.Reply, bool) {
+	tx := &state.tx
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	switch cmd {
+	case "watch":
+		if len(args) == 0 {
+			return protocol.Error("ERR " + ErrSyntaxIncorrect.Error()), true
+		}
+		if tx.active {
+			return errWatchInMulti, true
+		}
+		if tx.watched == nil {
+			tx.watched = make(map[string]uint64)
+		}
+		for _, key := range args {
+			if _, ok := tx.watched[key]; !ok {
+				tx.watched[key] = s.db.Watch([]byte(key))
+			}
+		}
+		return okReply, true
+	case "unwatch":
+		if len(args) != 0 {
+			return protocol.Error("ERR " + ErrSyntaxIncorrect.Error()), true
+		}
+		s.unwatch(tx)
+		return okReply, true
+	case "multi":
+		if len(args) != 0 {
+			return protocol.Error("ERR " + ErrSyntaxIncorrect.Error()), true
+		}
+		if tx.active {
+			return errNestedMulti, true
+		}
+		tx.active = true
+		return okReply, true
+	case "discard":
+		if !tx.active {
+			return errDiscardNoMulti, true
+		}
+		tx.reset()
+		s.unwatch(tx)
+		return okReply, true
+	case "exec":
+		if !tx.active {
+			return errExecNoMulti, true
+		}
+		queued, failed, watched := tx.queued, tx.failed, tx.watched
+		tx.reset()
+		defer s.unwatch(tx)
+		if failed {
+			return errExecAbort, true
+		}
+		return s.exec(state, queued, watched), true
+	}
+
+	if !tx.active {
+		return nil, false
+	}
+	if _, ok := ExecCmd[cmd]; !ok {
+		tx.failed = true
+		return protocol.Error("ERR command '" + cmd + "' can not be used in MULTI"), true
+	}
+	tx.queued = append(tx.queued, append([]string{cmd}, args...))
+	return protocol.SimpleString("QUEUED"), true
+}
+
+// 结束事务，清空排队的命令
+func (tx *transaction) reset() {
+	tx.active, tx.failed, tx.queued = false, false, nil
+}
+
+// 停止监视连接 WATCH 的所有key
+func (s *Server) unwatch(tx *transaction) {
+	for key := range tx.watched {
+		s.db.Unwatch([]byte(key))
+	}
+	tx.watched = nil
+}
+
+// 依次执行事务中的命令，返回每个命令的响应
+// 执行期间独占 txMu，其他连接的命令（见 shared）需要等待；命令在数据库的 Update 中执行，
+// 数据库内部的后台写入（延迟队列的调度）也需要等待，因此事务中的写入在数据变更流中是连续的
+// WATCH 的key在监视之后被修改过时不执行任何命令，返回空
+// 某个命令执行出错时不影响其他命令，与 Redis 一样不会回滚
+func (s *Server) exec(state *connState, queued [][]string, watched map[string]uint64) protocol.Reply {
+	if !s.beginCmd() {
+		return errShuttingDown
+	}
+	defer s.endCmd()
+
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	var replies protocol.Array
+	err := s.db.Update(func(*mindb.Tx) error {
+		for key, version := range watched {
+			if s.db.KeyVersion([]byte(key)) != version {
+				return nil
+			}
+		}
+
+		ctx, cancel := s.cmdContext(state) // 整个事务共用一个超时
+		defer cancel()
+		replies = make(protocol.Array, 0, len(queued))
+		for _, c := range queued {
+			replies = append(replies, s.runCmd(ctx, c[0], c[1:]))
+		}
+		return nil
+	})
+	if err != nil {
+		return protocol.Error("ERR " + err.Error())
+	}
+	if replies == nil {
+		return protocol.Bulk(nil)
+	}
+	return replies
+}
+
+// 在共享 txMu 时执行 fn，不经过 handleCmd 的命令（阻塞的命令、发布订阅、数据变更流）通过它与 EXEC 互斥
+// 阻塞的命令只在每次尝试写入时调用，等待期间不持有 txMu，不会阻止 EXEC 执行
+func (s *Server) shared(fn func()) {
+	s.txMu.RLock()
+	defer s.txMu.RUnlock()
+	fn()
+}
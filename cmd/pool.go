@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"mindb"
+	"net"
+	"strings"
+	"sync"
+)
+
+// 工作池的队列长度为 worker 数量的倍数
+const workerQueueFactor = 4
+
+// 会长时间阻塞的命令，在单独的goroutine中执行，避免占满工作池后等待的命令（如 UNLOCK、REPLCONF）无法执行
+var blockingCmds = map[string]bool{"lock": true, "wait": true, "bqpop": true}
+
+// 执行命令的工作池，所有连接的命令都排队交给固定数量的 worker 执行，
+// 大量连接同时发送命令时命令在队列中等待，队列满时暂停读取连接上的请求，不会无限制地创建goroutine
+type workerPool struct {
+	mu     sync.RWMutex
+	closed bool
+	jobs   chan func()
+}
+
+// 工作池的 worker 数量，没有配置时为默认值
+func workerPoolSize(config mindb.Config) int {
+	if config.WorkerPoolSize > 0 {
+		return config.WorkerPoolSize
+	}
+	return mindb.DefaultWorkerPoolSize
+}
+
+func newWorkerPool(size int) *workerPool {
+	p := &workerPool{jobs: make(chan func(), size*workerQueueFactor)}
+	for i := 0; i < size; i++ {
+		go func() {
+			for job := range p.jobs {
+				job()
+			}
+		}()
+	}
+	return p
+}
+
+// 提交一个任务，队列已满时等待，工作池已停止时返回 false
+func (p *workerPool) submit(job func()) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return false
+	}
+	p.jobs <- job
+	return true
+}
+
+// 停止工作池，不再接受新的任务，已提交的任务执行完后 worker 退出
+func (p *workerPool) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+}
+
+// 异步执行一个命令的任务，阻塞的命令不进入工作池，服务已停止时返回 false
+func (s *Server) runJob(cmd string, job func()) bool {
+	if blockingCmds[strings.ToLower(cmd)] {
+		go job()
+		return true
+	}
+	return s.workers.submit(job)
+}
+
+// 按接收的顺序在工作池中执行一个连接上的请求：前一个请求完成后才提交下一个，
+// 请求执行期间读循环继续等待连接上的数据，客户端断开时可以及时取消正在执行的命令（与 handleConn 相同）
+type orderedJobs struct {
+	wg      sync.WaitGroup
+	running chan struct{} // 正在执行的请求，同一时刻最多一个
+}
+
+func newOrderedJobs() *orderedJobs {
+	return &orderedJobs{running: make(chan struct{}, 1)}
+}
+
+// 等待上一个请求完成后提交 job，job 负责写入响应，服务已停止时返回 false
+func (s *Server) runInOrder(jobs *orderedJobs, conn net.Conn, state *connState, cmd string, job func()) bool {
+	jobs.running <- struct{}{}
+	state.requestStarted()
+	jobs.wg.Add(1)
+	finish := func() {
+		s.requestFinished(conn, state)
+		<-jobs.running
+		jobs.wg.Done()
+	}
+	if !s.runJob(cmd, func() {
+		defer finish()
+		job()
+	}) {
+		finish()
+		return false
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package protocol
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SplitArgs 将一行命令分割为命令及参数，客户端与服务端使用相同的规则
+// 参数以空白字符分隔，包含空白字符的参数可以用引号括起来：
+// 双引号中支持 \" \\ \n \r \t \a \b 及 \xHH 转义，单引号中的内容按原样保留，只支持 \' 转义
+// 引号未闭合、闭合的引号后紧跟其他字符时返回 ErrInvalidSyntax
+func SplitArgs(line string) ([]string, error) {
+	var args []string
+	for i := 0; ; {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var arg strings.Builder
+		quote := line[i]
+		if quote == '"' || quote == '\'' {
+			i++
+		} else {
+			quote = 0
+		}
+		closed := quote == 0
+		for ; i < len(line); i++ {
+			c := line[i]
+			if quote == 0 { // 不在引号中的参数，引号作为普通字符
+				if isSpace(c) {
+					break
+				}
+				arg.WriteByte(c)
+				continue
+			}
+
+			if c == quote {
+				if i++; i < len(line) && !isSpace(line[i]) {
+					return nil, ErrInvalidSyntax
+				}
+				closed = true
+				break
+			}
+			if c == '\\' && i+1 < len(line) {
+				if quote == '"' {
+					i++
+					if line[i] == 'x' && i+2 < len(line) {
+						if b, err := strconv.ParseUint(line[i+1:i+3], 16, 8); err == nil {
+							arg.WriteByte(byte(b))
+							i += 2
+							continue
+						}
+					}
+					arg.WriteByte(unescape(line[i]))
+					continue
+				}
+				if line[i+1] == '\'' {
+					i++
+					arg.WriteByte('\'')
+					continue
+				}
+			}
+			arg.WriteByte(c)
+		}
+		if !closed {
+			return nil, ErrInvalidSyntax
+		}
+		args = append(args, arg.String())
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'a':
+		return '\a'
+	case 'b':
+		return '\b'
+	}
+	return c
+}
@@ -0,0 +1,149 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+)
+
+var ErrInvalidFrame = errors.New("cmd/protocol: invalid frame")
+
+// 自定义协议中响应帧的类型
+const (
+	FrameError byte = iota + 1
+	FrameNil
+	FrameInteger
+	FrameBulk
+	FrameMultiBulk
+)
+
+// 帧头：4字节的长度 + 1字节的类型，长度不包括长度字段本身
+const frameHeaderSize = 5
+
+// EncodeFrame 将响应编码为自定义协议的帧：4字节的长度 + 1字节的类型 + 数据
+// 多值响应的数据部分由每个元素各自的帧依次拼接而成
+func EncodeFrame(reply Reply) []byte {
+	var (
+		t    byte
+		body []byte
+	)
+
+	switch r := reply.(type) {
+	case Error:
+		t, body = FrameError, []byte(r)
+	case Integer:
+		t, body = FrameInteger, []byte(strconv.FormatInt(int64(r), 10))
+	case SimpleString:
+		t, body = FrameBulk, []byte(r)
+	case Bulk:
+		if r == nil {
+			t = FrameNil
+		} else {
+			t, body = FrameBulk, r
+		}
+	case Array:
+		if r == nil {
+			t = FrameNil
+		} else {
+			t = FrameMultiBulk
+			for _, item := range r {
+				body = append(body, EncodeFrame(item)...)
+			}
+		}
+	default:
+		t = FrameNil
+	}
+
+	b := make([]byte, frameHeaderSize+len(body))
+	binary.BigEndian.PutUint32(b[:4], uint32(len(body)+1))
+	b[4] = t
+	copy(b[frameHeaderSize:], body)
+	return b
+}
+
+// ReadFrame 从r中读取一个完整的响应帧并解码
+func ReadFrame(r io.Reader) (Reply, error) {
+	frameType, size, err := ReadFrameHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return decodeFrame(frameType, body)
+}
+
+// ReadFrameHeader 读取响应帧的帧头，返回帧的类型及数据部分的长度
+func ReadFrameHeader(r io.Reader) (frameType byte, size uint32, err error) {
+	header := make([]byte, frameHeaderSize)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+	if size = binary.BigEndian.Uint32(header[:4]); size == 0 {
+		return 0, 0, ErrInvalidFrame
+	}
+	return header[4], size - 1, nil
+}
+
+// StreamFrame 读取一个响应帧，多值响应不在内存中保留整个响应，每读取一个元素就交给 onItem，此时返回的响应为 nil
+// 用于逐个输出很大的多值响应；onItem 返回错误时丢弃剩余的元素，之后的响应仍然可以正常读取；其他类型的响应与 ReadFrame 相同
+func StreamFrame(r io.Reader, onItem func(Reply) error) (Reply, error) {
+	frameType, size, err := ReadFrameHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if frameType != FrameMultiBulk {
+		body := make([]byte, size)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, err
+		}
+		return decodeFrame(frameType, body)
+	}
+
+	lr := &io.LimitedReader{R: r, N: int64(size)}
+	for lr.N > 0 {
+		item, err := ReadFrame(lr)
+		if err != nil {
+			return nil, err
+		}
+		if err = onItem(item); err != nil {
+			_, _ = io.Copy(ioutil.Discard, lr)
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// 解码帧的数据部分
+func decodeFrame(frameType byte, body []byte) (Reply, error) {
+	switch frameType {
+	case FrameError:
+		return Error(body), nil
+	case FrameNil:
+		return Bulk(nil), nil
+	case FrameInteger:
+		n, err := strconv.ParseInt(string(body), 10, 64)
+		if err != nil {
+			return nil, ErrInvalidFrame
+		}
+		return Integer(n), nil
+	case FrameBulk:
+		return Bulk(body), nil
+	case FrameMultiBulk:
+		items := Array{}
+		br := bytes.NewReader(body)
+		for br.Len() > 0 {
+			item, err := ReadFrame(br)
+			if err != nil {
+				return nil, ErrInvalidFrame
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	return nil, ErrInvalidFrame
+}
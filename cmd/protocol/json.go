@@ -0,0 +1,29 @@
+package protocol
+
+// JSONValue 将响应转换为可以 JSON 编码的值
+// 简单字符串、二进制字符串转换为字符串，空值转换为 null，错误转换为 {"error": "..."}，数组按元素依次转换
+func JSONValue(r Reply) interface{} {
+	switch v := r.(type) {
+	case SimpleString:
+		return string(v)
+	case Error:
+		return map[string]string{"error": string(v)}
+	case Integer:
+		return int64(v)
+	case Bulk:
+		if v == nil {
+			return nil
+		}
+		return string(v)
+	case Array:
+		if v == nil {
+			return nil
+		}
+		items := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			items = append(items, JSONValue(item))
+		}
+		return items
+	}
+	return nil
+}
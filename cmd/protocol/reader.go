@@ -0,0 +1,140 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidSyntax = errors.New("cmd/protocol: invalid syntax")
+
+	ErrTooLarge = errors.New("cmd/protocol: request too large")
+)
+
+const (
+	// 一条命令中参数个数的上限
+	maxArgs = 1024 * 1024
+
+	// 单个参数大小的上限：512MB
+	maxBulkSize = 512 * 1024 * 1024
+
+	// 参数个数及参数大小由客户端声明，按声明的大小预先分配的上限，超出的部分随数据到达再增长
+	maxArgsPrealloc = 64
+	maxBulkPrealloc = 64 * 1024
+)
+
+// Reader RESP 请求的读取器
+type Reader struct {
+	r         *bufio.Reader
+	remaining int // 正在读取的命令还可以读取的字节数
+}
+
+// NewReader 新建一个 RESP 请求的读取器
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Wait 等待下一条命令的数据到达，不消费数据
+func (r *Reader) Wait() error {
+	_, err := r.r.Peek(1)
+	return err
+}
+
+// ReadCommand 读取一条命令及其参数
+// 支持客户端库使用的 RESP 数组格式，以及 telnet 等工具直接发送的 inline 格式
+// 命令编码后的总字节数（inline 格式为一行的长度）超过 maxSize 时返回 ErrTooLarge，超出的部分不会被读入内存
+func (r *Reader) ReadCommand(maxSize int) ([]string, error) {
+	r.remaining = maxSize
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) == 0 || line[0] != arrayPrefix { // inline 格式，按空白字符分割，支持引号
+		return SplitArgs(line)
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > maxArgs {
+		return nil, ErrInvalidSyntax
+	}
+
+	capacity := n
+	if capacity > maxArgsPrealloc {
+		capacity = maxArgsPrealloc
+	}
+	args := make([]string, 0, capacity)
+	for i := 0; i < n; i++ {
+		arg, err := r.readBulk()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// 读取一个 $len\r\ndata\r\n 格式的参数
+func (r *Reader) readBulk() (string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return "", err
+	}
+	if len(line) == 0 || line[0] != bulkPrefix {
+		return "", ErrInvalidSyntax
+	}
+
+	size, err := strconv.Atoi(line[1:])
+	if err != nil || size < 0 {
+		return "", ErrInvalidSyntax
+	}
+	if size > maxBulkSize || size+2 > r.remaining {
+		return "", ErrTooLarge
+	}
+	r.remaining -= size + 2
+
+	var buf []byte // 包括结尾的 \r\n
+	if size+2 <= maxBulkPrealloc {
+		buf = make([]byte, size+2)
+		if _, err := io.ReadFull(r.r, buf); err != nil {
+			return "", err
+		}
+	} else {
+		b := bytes.NewBuffer(make([]byte, 0, maxBulkPrealloc))
+		if _, err := io.CopyN(b, r.r, int64(size+2)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		buf = b.Bytes()
+	}
+	if buf[size] != '\r' || buf[size+1] != '\n' {
+		return "", ErrInvalidSyntax
+	}
+	return string(buf[:size]), nil
+}
+
+// 读取一行数据，不包括结尾的 \r\n，一行的长度计入命令的大小，没有读到换行就超出时返回 ErrTooLarge
+func (r *Reader) readLine() (string, error) {
+	var line []byte
+	for {
+		frag, err := r.r.ReadSlice('\n')
+		if len(line)+len(frag) > r.remaining {
+			return "", ErrTooLarge
+		}
+		line = append(line, frag...)
+		if err == nil {
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			return "", err
+		}
+	}
+	r.remaining -= len(line)
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
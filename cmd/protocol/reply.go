@@ -0,0 +1,86 @@
+package protocol
+
+import (
+	"strconv"
+)
+
+// RESP(Redis Serialization Protocol) 各类型数据的首字节
+const (
+	simpleStringPrefix = '+'
+	errorPrefix        = '-'
+	integerPrefix      = ':'
+	bulkPrefix         = '$'
+	arrayPrefix        = '*'
+)
+
+var crlf = []byte("\r\n")
+
+// Reply 带类型的响应，可编码为 RESP 格式
+type Reply interface {
+	// RESP 返回响应的 RESP 编码
+	RESP() []byte
+}
+
+type (
+	// SimpleString 简单字符串，如 OK
+	SimpleString string
+
+	// Error 错误信息
+	Error string
+
+	// Integer 整数
+	Integer int64
+
+	// Bulk 二进制安全的字符串，为 nil 时表示空值
+	Bulk []byte
+
+	// Array 多个响应组成的数组，为 nil 时表示空值
+	Array []Reply
+)
+
+// RESP 编码为 +OK\r\n 的形式
+func (r SimpleString) RESP() []byte {
+	return line(simpleStringPrefix, string(r))
+}
+
+// RESP 编码为 -ERR message\r\n 的形式
+func (r Error) RESP() []byte {
+	return line(errorPrefix, string(r))
+}
+
+// RESP 编码为 :1000\r\n 的形式
+func (r Integer) RESP() []byte {
+	return line(integerPrefix, strconv.FormatInt(int64(r), 10))
+}
+
+// RESP 编码为 $5\r\nhello\r\n 的形式，空值编码为 $-1\r\n
+func (r Bulk) RESP() []byte {
+	if r == nil {
+		return line(bulkPrefix, "-1")
+	}
+
+	b := line(bulkPrefix, strconv.Itoa(len(r)))
+	b = append(b, r...)
+	return append(b, crlf...)
+}
+
+// RESP 编码为 *2\r\n...的形式，空值编码为 *-1\r\n
+func (r Array) RESP() []byte {
+	if r == nil {
+		return line(arrayPrefix, "-1")
+	}
+
+	b := line(arrayPrefix, strconv.Itoa(len(r)))
+	for _, item := range r {
+		b = append(b, item.RESP()...)
+	}
+	return b
+}
+
+// 编码以首字节开头、以 \r\n 结尾的一行数据
+func line(prefix byte, s string) []byte {
+	b := make([]byte, 0, len(s)+3)
+	b = append(b, prefix)
+	b = append(b, s...)
+	return append(b, crlf...)
+}
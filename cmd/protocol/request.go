@@ -0,0 +1,81 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// 请求帧：4字节的长度 + 4字节的请求id + 命令，长度不包括长度字段本身
+// 响应帧：4字节的请求id + 响应帧（见 EncodeFrame）
+// 客户端可以连续发送多个请求而不等待响应，服务端按发送的顺序执行并返回响应，
+// 请求id用于将响应与请求对应起来，并与请求id为 PushId 的推送消息区分
+const (
+	requestHeaderSize = 8
+	requestIdSize     = 4
+)
+
+// Version 自定义协议的版本，请求帧或响应帧的格式发生不兼容的修改时递增，客户端通过 HELLO 协商
+const Version = 1
+
+// PushId 服务端主动推送的消息（如订阅的消息）使用的请求id，客户端的请求id不应使用它
+const PushId uint32 = 0
+
+// EncodeRequest 将命令编码为带请求id的请求帧
+func EncodeRequest(id uint32, cmd string) []byte {
+	b := make([]byte, requestHeaderSize+len(cmd))
+	binary.BigEndian.PutUint32(b[:4], uint32(requestIdSize+len(cmd)))
+	binary.BigEndian.PutUint32(b[4:requestHeaderSize], id)
+	copy(b[requestHeaderSize:], cmd)
+	return b
+}
+
+// ReadRequest 从r中读取一个完整的请求帧，返回请求id和命令
+// 命令长于 maxSize 时不读取命令，返回 ErrTooLarge，长度由客户端声明，需要在分配内存之前检查
+func ReadRequest(r io.Reader, maxSize int) (id uint32, cmd []byte, err error) {
+	header := make([]byte, requestHeaderSize)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+
+	size := binary.BigEndian.Uint32(header[:4])
+	if size < requestIdSize {
+		err = ErrInvalidFrame
+		return
+	}
+	if int64(size-requestIdSize) > int64(maxSize) {
+		err = ErrTooLarge
+		return
+	}
+	id = binary.BigEndian.Uint32(header[4:requestHeaderSize])
+
+	cmd = make([]byte, size-requestIdSize)
+	_, err = io.ReadFull(r, cmd)
+	return
+}
+
+// EncodeResponse 将响应编码为带请求id的响应帧
+func EncodeResponse(id uint32, reply Reply) []byte {
+	frame := EncodeFrame(reply)
+	b := make([]byte, requestIdSize+len(frame))
+	binary.BigEndian.PutUint32(b[:requestIdSize], id)
+	copy(b[requestIdSize:], frame)
+	return b
+}
+
+// ReadResponse 从r中读取一个完整的响应帧，返回请求id和响应
+func ReadResponse(r io.Reader) (id uint32, reply Reply, err error) {
+	if id, err = ReadResponseId(r); err != nil {
+		return
+	}
+	reply, err = ReadFrame(r)
+	return
+}
+
+// ReadResponseId 只读取响应的请求id，之后由调用方用 ReadFrame 或 StreamFrame 读取响应帧
+func ReadResponseId(r io.Reader) (uint32, error) {
+	b := make([]byte, requestIdSize)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
@@ -0,0 +1,272 @@
+package cmd
+
+import (
+	"mindb/cmd/protocol"
+	"strings"
+	"sync"
+)
+
+// PubSub 发布订阅引擎，记录每个频道及模式的订阅者，并将发布的消息分发给它们
+type PubSub struct {
+	mu       sync.RWMutex
+	channels map[string]map[*subscriber]struct{} // 频道 -> 订阅者
+	patterns map[string]map[*subscriber]struct{} // 模式 -> 订阅者
+}
+
+// 订阅者，对应一个客户端连接
+type subscriber struct {
+	push     func(protocol.Reply) error // 向连接推送消息
+	channels map[string]struct{}        // 已订阅的频道
+	patterns map[string]struct{}        // 已订阅的模式
+}
+
+// NewPubSub 创建一个发布订阅引擎
+func NewPubSub() *PubSub {
+	return &PubSub{
+		channels: make(map[string]map[*subscriber]struct{}),
+		patterns: make(map[string]map[*subscriber]struct{}),
+	}
+}
+
+func newSubscriber(push func(protocol.Reply) error) *subscriber {
+	return &subscriber{
+		push:     push,
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+	}
+}
+
+// 订阅者当前订阅的频道及模式总数
+func (sub *subscriber) count() int {
+	return len(sub.channels) + len(sub.patterns)
+}
+
+// 订阅者是否订阅了频道或模式
+func (ps *PubSub) subscribed(sub *subscriber) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return sub.count() > 0
+}
+
+// 订阅及退订的确认消息：类型、频道（或模式）、订阅总数
+func subReply(kind, name string, count int) protocol.Reply {
+	return protocol.Array{protocol.Bulk(kind), protocol.Bulk(name), protocol.Integer(count)}
+}
+
+// Subscribe 订阅指定的频道，每个频道返回一条确认消息
+func (ps *PubSub) Subscribe(sub *subscriber, channels ...string) []protocol.Reply {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	var replies []protocol.Reply
+	for _, ch := range channels {
+		if ps.channels[ch] == nil {
+			ps.channels[ch] = make(map[*subscriber]struct{})
+		}
+		ps.channels[ch][sub] = struct{}{}
+		sub.channels[ch] = struct{}{}
+		replies = append(replies, subReply("subscribe", ch, sub.count()))
+	}
+	return replies
+}
+
+// PSubscribe 按模式订阅频道，模式支持 *、?、[...] 通配
+func (ps *PubSub) PSubscribe(sub *subscriber, patterns ...string) []protocol.Reply {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	var replies []protocol.Reply
+	for _, p := range patterns {
+		if ps.patterns[p] == nil {
+			ps.patterns[p] = make(map[*subscriber]struct{})
+		}
+		ps.patterns[p][sub] = struct{}{}
+		sub.patterns[p] = struct{}{}
+		replies = append(replies, subReply("psubscribe", p, sub.count()))
+	}
+	return replies
+}
+
+// Unsubscribe 退订指定的频道，未指定频道时退订全部频道
+func (ps *PubSub) Unsubscribe(sub *subscriber, channels ...string) []protocol.Reply {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if len(channels) == 0 {
+		for ch := range sub.channels {
+			channels = append(channels, ch)
+		}
+		if len(channels) == 0 {
+			return []protocol.Reply{subReply("unsubscribe", "", sub.count())}
+		}
+	}
+
+	var replies []protocol.Reply
+	for _, ch := range channels {
+		removeSub(ps.channels, ch, sub)
+		delete(sub.channels, ch)
+		replies = append(replies, subReply("unsubscribe", ch, sub.count()))
+	}
+	return replies
+}
+
+// PUnsubscribe 退订指定的模式，未指定模式时退订全部模式
+func (ps *PubSub) PUnsubscribe(sub *subscriber, patterns ...string) []protocol.Reply {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if len(patterns) == 0 {
+		for p := range sub.patterns {
+			patterns = append(patterns, p)
+		}
+		if len(patterns) == 0 {
+			return []protocol.Reply{subReply("punsubscribe", "", sub.count())}
+		}
+	}
+
+	var replies []protocol.Reply
+	for _, p := range patterns {
+		removeSub(ps.patterns, p, sub)
+		delete(sub.patterns, p)
+		replies = append(replies, subReply("punsubscribe", p, sub.count()))
+	}
+	return replies
+}
+
+// 连接断开时移除订阅者的全部订阅
+func (ps *PubSub) removeSubscriber(sub *subscriber) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for ch := range sub.channels {
+		removeSub(ps.channels, ch, sub)
+	}
+	for p := range sub.patterns {
+		removeSub(ps.patterns, p, sub)
+	}
+	sub.channels = make(map[string]struct{})
+	sub.patterns = make(map[string]struct{})
+}
+
+func removeSub(m map[string]map[*subscriber]struct{}, name string, sub *subscriber) {
+	if subs, ok := m[name]; ok {
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(m, name)
+		}
+	}
+}
+
+// Publish 向频道发布消息，返回收到消息的订阅者数量
+// 在读锁内取出匹配的订阅者，释放锁之后再推送，向慢的订阅者推送时不会阻塞订阅、退订及断开连接；
+// 因此发布时刚退订的订阅者仍可能收到这一条消息
+func (ps *PubSub) Publish(channel string, message []byte) int {
+	type delivery struct {
+		sub *subscriber
+		msg protocol.Reply
+	}
+
+	ps.mu.RLock()
+	var deliveries []delivery
+	if subs := ps.channels[channel]; len(subs) > 0 {
+		msg := protocol.Array{protocol.Bulk("message"), protocol.Bulk(channel), protocol.Bulk(message)}
+		for sub := range subs {
+			deliveries = append(deliveries, delivery{sub: sub, msg: msg})
+		}
+	}
+	for p, subs := range ps.patterns {
+		if !matchPattern(p, channel) {
+			continue
+		}
+		msg := protocol.Array{protocol.Bulk("pmessage"), protocol.Bulk(p), protocol.Bulk(channel), protocol.Bulk(message)}
+		for sub := range subs {
+			deliveries = append(deliveries, delivery{sub: sub, msg: msg})
+		}
+	}
+	ps.mu.RUnlock()
+
+	count := 0
+	for _, d := range deliveries {
+		if d.sub.push(d.msg) == nil {
+			count++
+		}
+	}
+	return count
+}
+
+// 按 glob 风格匹配频道名及 SCAN 的key，支持 *、?、[abc]、[a-z]、[^a] 以及 \ 转义
+func matchPattern(pattern, name string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchPattern(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(name) == 0 {
+				return false
+			}
+		case '[':
+			if len(name) == 0 {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 { // 没有闭合的括号，按普通字符处理
+				if name[0] != '[' {
+					return false
+				}
+				break
+			}
+			class := pattern[1 : end+1]
+			if !matchClass(class, name[0]) {
+				return false
+			}
+			pattern = pattern[end+1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(name) == 0 || pattern[0] != name[0] {
+				return false
+			}
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// 判断字符是否属于 [] 中的字符集
+func matchClass(class string, c byte) bool {
+	not := len(class) > 0 && class[0] == '^'
+	if not {
+		class = class[1:]
+	}
+
+	match := false
+	for i := 0; i < len(class); i++ {
+		if i+2 < len(class) && class[i+1] == '-' {
+			lo, hi := class[i], class[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				match = true
+			}
+			i += 2
+		} else if class[i] == c {
+			match = true
+		}
+	}
+	return match != not
+}
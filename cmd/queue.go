@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"mindb"
+	"mindb/cmd/protocol"
+	"strconv"
+	"time"
+)
+
+const (
+	// 将到期的延迟元素移到队列中的间隔
+	queueScheduleInterval = 100 * time.Millisecond
+
+	// 等待队列时检查超时未确认元素的间隔，重新投递不会唤醒等待者
+	queueRedeliverCheck = time.Second
+)
+
+// 处理 BQPOP queue visibility timeout 命令，队列为空时等待，最多等待 timeout 秒，为 0 表示一直等待
+// 添加元素或延迟元素到期时唤醒等待者重新尝试取出，超时的计时由时间轮统一管理
+func (s *Server) bqPop(args []string) protocol.Reply {
+	if !s.beginCmd() {
+		return errShuttingDown
+	}
+	defer s.endCmd()
+
+	if len(args) != 3 {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+	queue := args[0]
+	visibility, err := parseVisibility(args[1])
+	if err != nil {
+		return protocol.Error("ERR " + err.Error())
+	}
+	timeout, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || timeout < 0 {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(seconds(timeout))
+	}
+
+	for {
+		// 先登记再尝试取出，避免错过两者之间添加的元素
+		ch := s.queueWaiters.add(queue)
+		var item *mindb.QueueItem
+		s.shared(func() { item, err = s.db.QPop([]byte(queue), visibility) })
+		if err != nil || item != nil {
+			s.queueWaiters.remove(queue, ch)
+			if err != nil {
+				return protocol.Error("ERR " + err.Error())
+			}
+			return queueItemReply(item)
+		}
+
+		wait := queueRedeliverCheck
+		if !deadline.IsZero() {
+			if left := time.Until(deadline); left < wait {
+				wait = left
+			}
+		}
+		t := s.timers.afterFunc(wait, func() { notify(ch) })
+
+		closed := false
+		select {
+		case <-ch:
+		case <-s.done:
+			closed = true
+		}
+		t.stop()
+		s.queueWaiters.remove(queue, ch)
+
+		if closed {
+			return errShuttingDown
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return protocol.Bulk(nil)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"mindb/cmd/protocol"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// 令牌桶，每秒匀速补充 rate 个令牌，最多积累 burst 个，每个命令消耗一个令牌
+// 速率在每次取令牌时传入，CONFIG SET 或 ACL SETUSER 修改后立即生效
+type tokenBucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// 桶的容量，没有配置时为一秒补充的令牌数，至少为 1
+func bucketBurst(rate float64, burst int) float64 {
+	if burst > 0 {
+		return float64(burst)
+	}
+	return math.Max(1, math.Ceil(rate))
+}
+
+// 取一个令牌，没有可用的令牌时返回 false 及需要等待的时间
+func (b *tokenBucket) take(rate float64, burst int, now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	capacity := bucketBurst(rate, burst)
+	if b.last.IsZero() {
+		b.tokens = capacity
+	} else if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+elapsed.Seconds()*rate)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// 检查连接及用户的命令速率，超出限制时返回错误响应，命令不会被执行
+// 连接的限制由 client_rate_limit 配置，用户的限制由 ACL 规则 ratelimit=<n> 配置，同一用户的所有连接共享
+func (s *Server) rateLimit(state *connState, user string) protocol.Reply {
+	now := time.Now()
+	conf := s.conf()
+	if conf.ClientRateLimit > 0 {
+		if ok, wait := state.limiter.take(conf.ClientRateLimit, conf.ClientRateBurst, now); !ok {
+			return s.rateLimited(wait)
+		}
+	}
+	if bucket, rate := s.acl.limiter(user); bucket != nil {
+		if ok, wait := bucket.take(rate, 0, now); !ok {
+			return s.rateLimited(wait)
+		}
+	}
+	return nil
+}
+
+func (s *Server) rateLimited(wait time.Duration) protocol.Reply {
+	atomic.AddInt64(&s.limited, 1)
+	return protocol.Error(fmt.Sprintf("ERR %s, retry after %d ms", ErrRateLimited.Error(), wait.Milliseconds()+1))
+}
@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"fmt"
+	"mindb/cmd/protocol"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 通过 SYNC 同步数据的副本，偏移量即数据库的写入序号
+type replica struct {
+	addr  string
+	sent  uint64 // 已推送给副本的最大序号
+	ack   uint64 // 副本通过 REPLCONF ACK 确认已应用的最大序号
+	ackAt int64  // 最后一次确认的时间（unix 秒）
+}
+
+// 当前连接的所有副本，以及等待副本确认的 WAIT 命令
+type replicas struct {
+	mu      sync.Mutex
+	m       map[*connState]*replica
+	waiters map[chan struct{}]struct{}
+}
+
+func newReplicas() *replicas {
+	return &replicas{m: make(map[*connState]*replica), waiters: make(map[chan struct{}]struct{})}
+}
+
+// 将连接登记为副本
+func (rs *replicas) add(state *connState) *replica {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	r := &replica{addr: state.addr, ackAt: time.Now().Unix()}
+	rs.m[state] = r
+	return r
+}
+
+// 副本断开或停止同步
+func (rs *replicas) remove(state *connState) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if _, ok := rs.m[state]; ok {
+		delete(rs.m, state)
+		rs.wake()
+	}
+}
+
+func (rs *replicas) get(state *connState) *replica {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.m[state]
+}
+
+// 副本确认已应用到 offset，并唤醒等待的 WAIT 命令
+func (rs *replicas) ack(r *replica, offset uint64) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if offset > r.ack {
+		atomic.StoreUint64(&r.ack, offset)
+	}
+	atomic.StoreInt64(&r.ackAt, time.Now().Unix())
+	rs.wake()
+}
+
+// 已确认到 offset 的副本数
+func (rs *replicas) acked(offset uint64) (n int) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, r := range rs.m {
+		if atomic.LoadUint64(&r.ack) >= offset {
+			n++
+		}
+	}
+	return
+}
+
+// 所有副本的快照，按地址排列
+func (rs *replicas) list() []replica {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	list := make([]replica, 0, len(rs.m))
+	for _, r := range rs.m {
+		list = append(list, replica{
+			addr:  r.addr,
+			sent:  atomic.LoadUint64(&r.sent),
+			ack:   atomic.LoadUint64(&r.ack),
+			ackAt: atomic.LoadInt64(&r.ackAt),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].addr < list[j].addr })
+	return list
+}
+
+func (rs *replicas) addWaiter() chan struct{} {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	ch := make(chan struct{}, 1)
+	rs.waiters[ch] = struct{}{}
+	return ch
+}
+
+func (rs *replicas) removeWaiter(ch chan struct{}) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	delete(rs.waiters, ch)
+}
+
+// 唤醒所有的 WAIT 命令重新检查，调用方需持有锁
+func (rs *replicas) wake() {
+	for ch := range rs.waiters {
+		notify(ch)
+	}
+}
+
+// 处理 REPLCONF ACK offset 命令，副本在重放完变更后确认已应用到的序号
+func (s *Server) replconf(state *connState, args []string) protocol.Reply {
+	if len(args) != 2 || strings.ToLower(args[0]) != "ack" {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+	offset, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+	r := s.replicas.get(state)
+	if r == nil {
+		return protocol.Error("ERR the connection is not a replica, use SYNC first")
+	}
+	s.replicas.ack(r, offset)
+	return okReply
+}
+
+// 处理 WAIT numreplicas timeout 命令，等待至少 numreplicas 个副本确认了当前的所有写入，返回已确认的副本数
+// timeout 为毫秒，为 0 表示一直等待，用于对关键的写入实现半同步的持久性
+func (s *Server) waitCmd(args []string) protocol.Reply {
+	if len(args) != 2 {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+	timeout, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || timeout < 0 {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+
+	if !s.beginCmd() {
+		return errShuttingDown
+	}
+	defer s.endCmd()
+
+	offset := s.db.Sequence()
+	ch := s.replicas.addWaiter() // 先登记再检查，避免错过两者之间的确认
+	defer s.replicas.removeWaiter(ch)
+	expired := make(chan struct{})
+	if timeout > 0 {
+		t := s.timers.afterFunc(time.Duration(timeout)*time.Millisecond, func() { close(expired) })
+		defer t.stop()
+	}
+
+	for {
+		acked := s.replicas.acked(offset)
+		if acked >= n {
+			return protocol.Integer(acked)
+		}
+		select {
+		case <-ch:
+		case <-expired:
+			return protocol.Integer(s.replicas.acked(offset))
+		case <-s.done:
+			return errShuttingDown
+		}
+	}
+}
+
+// INFO replication：主节点的写入序号及各副本的同步进度，lag 为副本确认的序号落后的数量
+func (s *Server) replicationInfo() [][2]string {
+	offset := s.db.Sequence()
+	list := s.replicas.list()
+	info := [][2]string{
+		{"role", "master"},
+		{"connected_replicas", fmt.Sprint(len(list))},
+		{"master_repl_offset", fmt.Sprint(offset)},
+	}
+	now := time.Now().Unix()
+	for i, r := range list {
+		var lag uint64
+		if offset > r.ack {
+			lag = offset - r.ack
+		}
+		info = append(info, [2]string{
+			"replica" + strconv.Itoa(i),
+			fmt.Sprintf("addr=%s,sent=%d,ack=%d,lag=%d,last_ack=%d", r.addr, r.sent, r.ack, lag, now-r.ackAt),
+		})
+	}
+	return info
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"io"
+	"mindb/cmd/protocol"
+	"strconv"
+)
+
+// SEGMENT 命令每次最多读取的字节数
+const maxSegmentChunk = 4 << 20
+
+// 处理 SEGMENTS 命令，供冷备拉取已封存的数据文件
+// 返回的第一个元素为当前的写入序号，之后每个元素为一个数据文件：文件名 大小 crc32
+func (s *Server) segmentsCmd(args []string) protocol.Reply {
+	if len(args) != 0 {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+	segs, seq, err := s.db.Segments()
+	if err != nil {
+		return protocol.Error("ERR " + err.Error())
+	}
+
+	reply := protocol.Array{protocol.Integer(seq)}
+	for _, seg := range segs {
+		reply = append(reply, protocol.Array{
+			protocol.Bulk(seg.Name), protocol.Integer(seg.Size), protocol.Integer(seg.CRC),
+		})
+	}
+	return reply
+}
+
+// 处理 SEGMENT name offset count 命令，读取已封存的数据文件从 offset 开始的最多 count 字节，读到文件末尾时返回的数据少于 count
+func (s *Server) segmentCmd(args []string) protocol.Reply {
+	if len(args) != 3 {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+	offset, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || offset < 0 {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+	count, err := strconv.Atoi(args[2])
+	if err != nil || count < 0 {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+	if count > maxSegmentChunk {
+		count = maxSegmentChunk
+	}
+
+	buf := make([]byte, count)
+	n, err := s.db.ReadSegment(args[0], offset, buf)
+	if err != nil && err != io.EOF {
+		return protocol.Error("ERR " + err.Error())
+	}
+	return protocol.Bulk(buf[:n])
+}
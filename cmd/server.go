@@ -2,24 +2,31 @@ package cmd
 
 import (
 	"bufio"
-	"encoding/binary"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"mindb"
+	"mindb/cmd/protocol"
 	"net"
-	"regexp"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var reg, _ = regexp.Compile(`'.*?'|".*?"|\S+`)
+var ErrCmdNotFound = errors.New("command not found")
 
-const connInterval = 8
+var ErrServerClosed = errors.New("server closed")
+
+var ErrCmdTimeout = errors.New("command timed out")
 
 // ExecCmdFunc func for cmd execute
-type ExecCmdFunc func(*mindb.MinDB, []string) (string, error)
+type ExecCmdFunc func(*mindb.MinDB, []string) (protocol.Reply, error)
 
 // ExecCmd exec cmd map
 var ExecCmd = make(map[string]ExecCmdFunc)
@@ -28,121 +35,670 @@ func addExecCommand(cmd string, cmdFunc ExecCmdFunc) {
 	ExecCmd[strings.ToLower(cmd)] = cmdFunc
 }
 
+// ExecCmdContextFunc 可以被取消的命令的执行函数，客户端断开连接或执行超过 command_timeout 时 ctx 被取消
+type ExecCmdContextFunc func(context.Context, *mindb.MinDB, []string) (protocol.Reply, error)
+
+// ExecCmdContext 可以被取消的命令，执行时优先于 ExecCmd 中的同名命令
+var ExecCmdContext = make(map[string]ExecCmdContextFunc)
+
+// 注册可以被取消的命令，同时以 context.Background() 注册到 ExecCmd 中，供中间件、MULTI 等按 ExecCmd 查找命令
+func addExecCommandContext(cmd string, cmdFunc ExecCmdContextFunc) {
+	ExecCmdContext[strings.ToLower(cmd)] = cmdFunc
+	addExecCommand(cmd, func(db *mindb.MinDB, args []string) (protocol.Reply, error) {
+		return cmdFunc(context.Background(), db, args)
+	})
+}
+
+var okReply = protocol.SimpleString("OK")
+
+// 将 bool 值转换为 1 或 0 的整数响应
+func boolReply(ok bool) protocol.Integer {
+	if ok {
+		return 1
+	}
+	return 0
+}
+
+// 解析命令末尾可选的 LIMIT offset count，没有时 ok 为 false，count 为负数表示 offset 之后的全部
+func parseLimit(args []string) (offset, count int, ok bool, err error) {
+	if len(args) == 0 {
+		return 0, 0, false, nil
+	}
+	if len(args) != 3 || !strings.EqualFold(args[0], "limit") {
+		return 0, 0, false, ErrSyntaxIncorrect
+	}
+	if offset, err = strconv.Atoi(args[1]); err != nil || offset < 0 {
+		return 0, 0, false, ErrSyntaxIncorrect
+	}
+	if count, err = strconv.Atoi(args[2]); err != nil {
+		return 0, 0, false, ErrSyntaxIncorrect
+	}
+	return offset, count, true, nil
+}
+
+// 将多个值转换为多值响应
+func multiBulk(values [][]byte) protocol.Array {
+	items := make(protocol.Array, 0, len(values))
+	for _, v := range values {
+		items = append(items, protocol.Bulk(v))
+	}
+	return items
+}
+
 // Server mindb server
 type Server struct {
-	db       *mindb.MinDB
-	closed   bool
-	mu       sync.Mutex
-	done     chan struct{}
-	listener net.Listener
+	db           *mindb.MinDB
+	closed       bool
+	mu           sync.RWMutex
+	inflight     sync.WaitGroup      // 正在执行的命令
+	txMu         sync.RWMutex        // EXEC 执行事务时独占，其他命令执行时共享
+	listeners    []net.Listener      // 所有协议的所有监听地址，关闭服务时一起关闭
+	sockets      []handoffSocket     // 原始的监听及其地址，热升级时交给新进程
+	inherited    map[string]*os.File // 热升级时从旧进程继承、还没有使用的监听
+	done         chan struct{}
+	pubsub       *PubSub        // 发布订阅
+	monitors     *monitors      // 执行了 MONITOR 的连接
+	slowlog      *slowlog       // 执行时间过长的命令
+	lockWaiters  *lockWaiters   // 等待锁的连接
+	queueWaiters *lockWaiters   // 等待队列中元素的连接
+	timers       *timeWheel     // 阻塞命令的超时及租约到期的定时任务
+	replicas     *replicas      // 通过 SYNC 同步数据的副本
+	workers      *workerPool    // 执行命令的工作池
+	acl          *ACL           // 用户及其权限
+	tlsConfig    *tls.Config    // TLS配置，为nil时不开启TLS
+	clients      int64          // 当前的客户端连接数
+	limited      int64          // 超出速率限制被拒绝的命令数
+	commands     int64          // 执行过的命令数
+	metrics      *metrics       // 通过数据库的事件监听统计的指标
+	tenants      *tenantMetrics // 按用户统计的指标
+	middlewares  []Middleware   // 包装命令执行的中间件
+	config       atomic.Value   // 服务端的配置 mindb.Config，CONFIG SET 修改时整体替换
+	configMu     sync.Mutex     // 修改配置时加锁
+	configFile   string         // 启动时加载的配置文件，SIGHUP 时重新读取，CONFIG REWRITE 时写回
+	shutdown     chan struct{}  // 客户端请求关闭服务
+	shutdownOnce sync.Once
+	startedAt    time.Time // 服务启动的时间
 }
 
 // NewServer new mindb server
 func NewServer(config mindb.Config) (*Server, error) {
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// 热升级时先接管旧进程的监听，旧进程关闭数据库后才能打开
+	inherited, err := takeOver(config.UpgradeSocket)
+	if err != nil {
+		return nil, err
+	}
+
 	db, err := mindb.Open(config)
 	if err != nil {
+		for _, f := range inherited {
+			f.Close()
+		}
 		return nil, err
 	}
-	return &Server{db: db, done: make(chan struct{})}, nil
+	s := &Server{
+		db:           db,
+		inherited:    inherited,
+		done:         make(chan struct{}),
+		pubsub:       NewPubSub(),
+		monitors:     newMonitors(),
+		slowlog:      &slowlog{},
+		lockWaiters:  newLockWaiters(),
+		queueWaiters: newLockWaiters(),
+		replicas:     newReplicas(),
+		workers:      newWorkerPool(workerPoolSize(config)),
+		timers:       newTimeWheel(timeWheelTick, timeWheelSlots),
+		acl:          NewACL(config.Password),
+		tlsConfig:    tlsConfig,
+		shutdown:     make(chan struct{}),
+		startedAt:    time.Now(),
+		metrics:      &metrics{},
+		tenants:      newTenantMetrics(),
+	}
+	s.config.Store(config)
+	db.AddHooks(s.metrics)
+	db.OnQueueReady(func(queue []byte) { s.queueWaiters.wake(string(queue)) })
+	go db.RunQueueScheduler(queueScheduleInterval, s.done)
+	go db.RunActiveExpire(activeExpireInterval, s.done)
+	return s, nil
 }
 
 // Listen listen the server
+// addr 可以是以逗号分隔的多个地址，格式见 listen，所有地址共享同一个数据库
 func (s *Server) Listen(addr string) {
-	var err error
-	s.listener, err = net.Listen("tcp", addr) // 启动一个tcp服务监听端口
+	listeners, err := s.listen(addr) // 启动tcp服务监听端口
 	if err != nil {
 		log.Printf("tcp listen err: %+v\n", err)
 		return
 	}
 
 	log.Println("mindb is running, ready to accept connections.")
+	s.serveAll(listeners, s.handleConn, func(conn net.Conn) {
+		_ = s.write(conn, protocol.EncodeResponse(protocol.PushId, errMaxClients))
+	})
+}
+
+// ListenRESP 以 RESP 协议监听，使 redis-cli 及各语言的 Redis 客户端可以直接访问 mindb
+func (s *Server) ListenRESP(addr string) {
+	listeners, err := s.listen(addr)
+	if err != nil {
+		log.Printf("resp listen err: %+v\n", err)
+		return
+	}
+
+	log.Printf("mindb is accepting RESP connections on %s.\n", addr)
+	s.serveAll(listeners, s.handleRESPConn, func(conn net.Conn) {
+		_ = s.write(conn, errMaxClients.RESP())
+	})
+}
+
+// 监听一个或多个以逗号分隔的地址，任一地址监听失败时关闭已经打开的监听，地址的格式：
+// host:port 监听tcp地址，配置了证书时使用TLS；tcp://host:port 不使用TLS；tls://host:port 使用TLS，需要配置证书；
+// unix:/path/to/socket 监听 unix socket，不使用TLS，已存在的socket文件会被删除
+func (s *Server) listen(addrs string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, addr := range strings.Split(addrs, ",") {
+		listener, err := s.listenOne(strings.TrimSpace(addr))
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	if !s.addListeners(listeners) {
+		return nil, ErrServerClosed
+	}
+	return listeners, nil
+}
+
+func (s *Server) listenOne(addr string) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		return s.netListen("unix", strings.TrimPrefix(strings.TrimPrefix(addr, "unix:"), "//"))
+	case strings.HasPrefix(addr, "tcp://"):
+		return s.listenTCP(strings.TrimPrefix(addr, "tcp://"))
+	case strings.HasPrefix(addr, "tls://"):
+		if s.tlsConfig == nil {
+			return nil, ErrTLSNotConfigured
+		}
+		listener, err := s.listenTCP(strings.TrimPrefix(addr, "tls://"))
+		if err != nil {
+			return nil, err
+		}
+		return tls.NewListener(listener, s.tlsConfig), nil
+	}
+
+	listener, err := s.listenTCP(addr)
+	if err != nil || s.tlsConfig == nil {
+		return listener, err
+	}
+	return tls.NewListener(listener, s.tlsConfig), nil
+}
+
+// 记录监听以便关闭服务时关闭，服务已关闭时关闭监听并返回 false
+func (s *Server) addListeners(listeners []net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+		return false
+	}
+	s.listeners = append(s.listeners, listeners...)
+	return true
+}
+
+// 在每个监听上接收连接，直到服务关闭
+func (s *Server) serveAll(listeners []net.Listener, handle func(net.Conn), reject func(net.Conn)) {
+	var wg sync.WaitGroup
+	for _, listener := range listeners {
+		wg.Add(1)
+		go func(listener net.Listener) {
+			defer wg.Done()
+			s.serve(listener, handle, reject)
+		}(listener)
+	}
+	wg.Wait()
+}
+
+// 接收连接，并为每个连接启动一个goroutine进行处理
+// 连接数超过限制时调用 reject 向客户端返回错误，然后关闭连接
+func (s *Server) serve(listener net.Listener, handle func(net.Conn), reject func(net.Conn)) {
 	for {
 		select {
 		case <-s.done:
 			return
 		default:
-			conn, err := s.listener.Accept() // 获取客户端的连接
+			conn, err := listener.Accept() // 获取客户端的连接
 			if err != nil {
 				continue
 			}
-			go s.handleConn(conn) // 启动一个goroutine异步地处理这个连接
+			if !s.acquireClient() {
+				reject(conn)
+				conn.Close()
+				continue
+			}
+			go func() {
+				defer s.releaseClient()
+				handle(conn) // 启动一个goroutine异步地处理这个连接
+			}()
 		}
 	}
 }
 
 // Stop stop the server
+// 先停止接收新的连接和命令，再等待正在执行的命令完成（最多等待 shutdownTimeout），最后关闭数据库并同步活跃文件
 func (s *Server) Stop() {
+	s.mu.Lock()
 	if s.closed {
+		s.mu.Unlock()
 		return
 	}
-	s.mu.Lock()
 	close(s.done)
 	s.closed = true
-	s.listener.Close()
-	if err := s.db.Close(); err != nil {
-		fmt.Printf("close mindb err: %+v\n", err)
+	for _, listener := range s.listeners {
+		listener.Close()
+	}
+	for _, f := range s.inherited { // 新的配置中不再使用的地址
+		f.Close()
 	}
 	s.mu.Unlock()
+	s.timers.stop()
+
+	drained := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(drained)
+	}()
+	var timeout <-chan time.Time
+	if t := s.conf().ShutdownTimeout; t > 0 {
+		timeout = time.After(seconds(t))
+	}
+	select {
+	case <-drained:
+	case <-timeout:
+		log.Printf("shutdown timeout, some commands are still running\n")
+	}
+
+	if err := s.db.Close(); err != nil { // 关闭时会等待正在进行的写入完成
+		fmt.Printf("close mindb err: %+v\n", err)
+	}
+	s.workers.stop()
+}
+
+// 开始执行一个命令，服务正在关闭时返回 false
+func (s *Server) beginCmd() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return false
+	}
+	s.inflight.Add(1)
+	return true
+}
+
+// 命令执行完成
+func (s *Server) endCmd() {
+	s.inflight.Done()
 }
 
+// 处理自定义协议的连接，读取到的请求交给工作池执行，不同连接的请求并发执行，
+// 同一连接的请求按发送的顺序逐个执行并返回响应：前一个请求返回响应后才提交下一个，
+// 保证 AUTH、MULTI/EXEC 及对同一个key的连续写入等依赖顺序的命令在流水线中的语义
 func (s *Server) handleConn(conn net.Conn) {
-	defer conn.Close()
-	for {
-		_ = conn.SetReadDeadline(time.Now().Add(time.Hour * connInterval)) // 设置读取的截止时间，即一段时间内没有数据就主动断开连接
+	var (
+		wg      sync.WaitGroup
+		writeMu sync.Mutex
+		running = make(chan struct{}, 1) // 正在执行的请求，同一时刻最多一个
+	)
+
+	// 订阅的消息以请求id为0的响应推送给客户端
+	state := newConnState(conn.RemoteAddr().String(), func(reply protocol.Reply) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return s.write(conn, protocol.EncodeResponse(protocol.PushId, reply))
+	})
+	state.proto = protoMinDB
+	defer s.closeConnState(state)
 
-		bufReader := bufio.NewReader(conn)
-		b := make([]byte, 4)
-		_, err := bufReader.Read(b)
+	defer func() {
+		wg.Wait() // 等待已读取的请求处理完成后再关闭连接
+		conn.Close()
+	}()
+
+	bufReader := bufio.NewReader(conn)
+	for {
+		// 一段时间内没有数据，或请求长时间没有读完，就主动断开连接
+		err := s.waitRequest(conn, state, func() error {
+			_, err := bufReader.Peek(1)
+			return err
+		})
 		if err != nil {
 			if err != io.EOF {
-				log.Printf("read cmd size err: %+v\n", err)
+				log.Printf("read cmd err: %+v\n", err)
 			}
+			state.cancel() // 客户端已断开连接，取消正在执行的命令
 			break
 		}
 
-		size := binary.BigEndian.Uint32(b[:4])
-		if size > 0 {
-			data := make([]byte, size)
-			_, err := bufReader.Read(data)
-			if err != nil {
-				log.Printf("read cmd data err: %+v\n", err)
-				break
+		id, data, err := protocol.ReadRequest(bufReader, s.maxRequestSize())
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("read cmd err: %+v\n", err)
 			}
+			state.cancel() // 客户端已断开连接，取消正在执行的命令
+			break
+		}
 
-			cmdAndArgs := reg.FindAllString(string(data), -1)   // 获取到命令
-			reply := s.handleCmd(cmdAndArgs[0], cmdAndArgs[1:]) // 执行命令
-			info := wrapReplyInfo(reply)                        // 返回响应
-			_, err = conn.Write(info)
+		cmdAndArgs, _ := protocol.SplitArgs(string(data)) // 获取到命令，引号不匹配时为空，返回语法错误
+		running <- struct{}{}
+		state.requestStarted()
+		wg.Add(1)
+		job := func() {
+			defer wg.Done()
+			reply := s.handleRequest(state, cmdAndArgs)
+
+			writeMu.Lock()
+			err := s.write(conn, protocol.EncodeResponse(id, reply)) // 返回带请求id和类型的响应
+			writeMu.Unlock()
 			if err != nil {
 				log.Printf("write reply err: %+v\n", err)
 			}
+			state.replied()
+			s.requestFinished(conn, state)
+			<-running
+		}
+		var cmd string
+		if len(cmdAndArgs) > 0 {
+			cmd = cmdAndArgs[0]
+		}
+		if !s.runJob(cmd, job) {
+			wg.Done()
+			s.requestFinished(conn, state)
+			<-running
+			break
+		}
+	}
+}
+
+// 执行一个自定义协议的请求
+func (s *Server) handleRequest(state *connState, cmdAndArgs []string) protocol.Reply {
+	if len(cmdAndArgs) == 0 {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+
+	replies := s.dispatch(state, cmdAndArgs[0], cmdAndArgs[1:]) // 执行命令
+	if len(replies) == 1 {
+		return replies[0]
+	}
+	return protocol.Array(replies) // 一个请求只对应一个响应，多条确认消息合并返回
+}
+
+func (s *Server) handleRESPConn(conn net.Conn) {
+	var writeMu sync.Mutex
+	write := func(reply protocol.Reply) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return s.write(conn, reply.RESP())
+	}
+	state := newConnState(conn.RemoteAddr().String(), write)
+	state.proto = protoRESP
+	defer s.closeConnState(state)
+
+	jobs := newOrderedJobs()
+	defer func() {
+		jobs.wg.Wait() // 等待已读取的命令处理完成后再关闭连接
+		conn.Close()
+	}()
+
+	reader := protocol.NewReader(conn)
+	for {
+		if err := s.waitRequest(conn, state, reader.Wait); err != nil {
+			if err != io.EOF {
+				log.Printf("read resp cmd err: %+v\n", err)
+			}
+			state.cancel() // 客户端已断开连接，取消正在执行的命令
+			break
+		}
+
+		args, err := reader.ReadCommand(s.maxRequestSize())
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("read resp cmd err: %+v\n", err)
+			}
+			state.cancel() // 客户端已断开连接，取消正在执行的命令
+			break
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		if !s.runInOrder(jobs, conn, state, args[0], func() {
+			for _, reply := range s.dispatch(state, args[0], args[1:]) {
+				if err := write(reply); err != nil {
+					log.Printf("write reply err: %+v\n", err)
+				}
+			}
+			state.replied()
+		}) {
+			break
+		}
+	}
+}
+
+// 根据连接的状态分发命令：认证、权限管理、发布订阅命令由服务端处理，其他命令通过 ExecCmd 执行
+func (s *Server) dispatch(state *connState, cmd string, args []string) []protocol.Reply {
+	cmd = strings.ToLower(cmd)
+	if cmd == "auth" {
+		return []protocol.Reply{s.auth(state, args)}
+	}
+	if cmd == "hello" { // 握手在认证之前进行
+		return []protocol.Reply{s.hello(state, args)}
+	}
+
+	user := state.username()
+	if user == "" { // 默认用户不需要密码时，未认证的连接即为默认用户，浏览器发起的连接除外
+		if state.browser || !s.acl.defaultNoPass() {
+			return []protocol.Reply{errNoAuth}
+		}
+		user = DefaultUser
+	}
+	tenant := s.tenants.of(user)
+	atomic.AddInt64(&tenant.bytesIn, argsSize(cmd, args))
+	reject := func(reply protocol.Reply) []protocol.Reply {
+		atomic.AddInt64(&tenant.rejected, 1)
+		atomic.AddInt64(&tenant.bytesOut, respSize(reply))
+		return []protocol.Reply{reply}
+	}
+	if reply := s.acl.check(user, cmd, args); reply != nil { // 检查用户是否有权限执行命令、访问key
+		return reject(reply)
+	}
+	if reply := s.rateLimit(state, user); reply != nil { // 检查连接及用户的命令速率
+		return reject(reply)
+	}
+	if atomic.LoadInt32(&state.noWrites) == 1 && isMutating(cmd, args) {
+		return reject(errNoWrites)
+	}
+
+	s.feedMonitors(state, cmd, args)
+	for _, key := range specOf(cmd).keys(args) { // 按前缀统计key的访问
+		s.db.RecordAccess([]byte(key))
+	}
+
+	atomic.AddInt64(&s.commands, 1)
+	atomic.AddInt64(&tenant.commands, 1)
+	start := time.Now()
+	replies := s.execute(state, cmd, args)
+	s.logSlow(state, cmd, args, time.Since(start))
+	for _, reply := range replies {
+		atomic.AddInt64(&tenant.bytesOut, respSize(reply))
+	}
+	return replies
+}
+
+// 执行已经通过认证和权限检查的命令
+func (s *Server) execute(state *connState, cmd string, args []string) []protocol.Reply {
+	if reply, ok := s.handleTx(state, cmd, args); ok {
+		return []protocol.Reply{reply}
+	}
+	if cmd == "ping" {
+		return []protocol.Reply{ping(args)}
+	}
+	if cmd == "echo" {
+		return []protocol.Reply{echo(args)}
+	}
+	if cmd == "monitor" {
+		return []protocol.Reply{s.monitorCmd(state, args)}
+	}
+	if cmd == "client" {
+		return []protocol.Reply{s.clientCmd(state, args)}
+	}
+	if cmd == "acl" {
+		return []protocol.Reply{s.aclCmd(state, args)}
+	}
+	if cmd == "info" {
+		return []protocol.Reply{s.info(args)}
+	}
+	if cmd == "config" {
+		return []protocol.Reply{s.configCmd(args)}
+	}
+	if cmd == "shutdown" {
+		return []protocol.Reply{s.shutdownCmd(args)}
+	}
+	if cmd == "slowlog" {
+		return []protocol.Reply{s.slowlogCmd(args)}
+	}
+	if cmd == "changes" {
+		return []protocol.Reply{s.changesCmd(state, args)}
+	}
+	if cmd == "sync" {
+		return []protocol.Reply{s.syncCmd(state, args)}
+	}
+	if cmd == "segments" {
+		return []protocol.Reply{s.segmentsCmd(args)}
+	}
+	if cmd == "segment" {
+		return []protocol.Reply{s.segmentCmd(args)}
+	}
+	if cmd == "replconf" {
+		return []protocol.Reply{s.replconf(state, args)}
+	}
+	if cmd == "wait" {
+		return []protocol.Reply{s.waitCmd(args)}
+	}
+	if cmd == "lock" || cmd == "renewlock" || cmd == "unlock" {
+		return []protocol.Reply{s.lockCmd(cmd, args)}
+	}
+	if cmd == "bqpop" {
+		return []protocol.Reply{s.bqPop(args)}
+	}
+
+	var replies []protocol.Reply
+	var ok bool
+	s.shared(func() { replies, ok = s.handlePubSub(state.sub, cmd, args) })
+	if ok {
+		return replies
+	}
+	return []protocol.Reply{s.handleCmd(state, cmd, args)}
+}
+
+// 处理发布订阅相关的命令，这些命令需要知道当前的连接，不通过 ExecCmd 执行
+// 第二个返回值表示是否为发布订阅命令
+func (s *Server) handlePubSub(sub *subscriber, cmd string, args []string) ([]protocol.Reply, bool) {
+	switch strings.ToLower(cmd) {
+	case "subscribe":
+		if len(args) == 0 {
+			return []protocol.Reply{protocol.Error("ERR " + ErrSyntaxIncorrect.Error())}, true
 		}
+		return s.pubsub.Subscribe(sub, args...), true
+	case "psubscribe":
+		if len(args) == 0 {
+			return []protocol.Reply{protocol.Error("ERR " + ErrSyntaxIncorrect.Error())}, true
+		}
+		return s.pubsub.PSubscribe(sub, args...), true
+	case "unsubscribe":
+		return s.pubsub.Unsubscribe(sub, args...), true
+	case "punsubscribe":
+		return s.pubsub.PUnsubscribe(sub, args...), true
+	case "publish":
+		if len(args) != 2 {
+			return []protocol.Reply{protocol.Error("ERR " + ErrSyntaxIncorrect.Error())}, true
+		}
+		return []protocol.Reply{protocol.Integer(s.pubsub.Publish(args[0], []byte(args[1])))}, true
+	}
+	return nil, false
+}
+
+// 执行命令，执行出错时返回错误响应
+func (s *Server) handleCmd(state *connState, cmd string, args []string) protocol.Reply {
+	if !s.beginCmd() {
+		return errShuttingDown
 	}
+	defer s.endCmd()
+
+	s.txMu.RLock()
+	defer s.txMu.RUnlock()
+
+	ctx, cancel := s.cmdContext(state)
+	defer cancel()
+	return s.runCmd(ctx, cmd, args)
 }
 
-func (s *Server) handleCmd(cmd string, args []string) (res string) {
+// 命令执行的 context，连接断开时被取消，配置了 command_timeout 时超时后也被取消
+// 其中保存连接的用户可以访问的key，见 keyFilter
+func (s *Server) cmdContext(state *connState) (context.Context, context.CancelFunc) {
+	user := state.username()
+	if user == "" {
+		user = DefaultUser
+	}
+	ctx := s.acl.withKeyFilter(state.ctx, user)
+	if timeout := s.conf().CommandTimeout; timeout > 0 {
+		return context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	}
+	return context.WithCancel(ctx)
+}
+
+// 执行命令并将执行结果转换为响应
+func (s *Server) runCmd(ctx context.Context, cmd string, args []string) protocol.Reply {
+	reply, err := s.execCmd(ctx, cmd, args)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = ErrCmdTimeout
+	}
+	if err != nil {
+		return protocol.Error("ERR " + err.Error())
+	}
+	if reply == nil {
+		return protocol.Bulk(nil)
+	}
+	return reply
+}
+
+// 执行命令，返回执行结果
+func (s *Server) execCmd(ctx context.Context, cmd string, args []string) (res protocol.Reply, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("panic when handle the cmd: %+v", r)
+			err = fmt.Errorf("panic when handle the cmd: %+v", r)
 		}
 	}()
 
-	exec, exist := ExecCmd[strings.ToLower(cmd)]
+	cmd = strings.ToLower(cmd)
+	exec, exist := ExecCmd[cmd]
 	if !exist {
-		return "command not found"
+		return nil, ErrCmdNotFound
 	}
-
-	if val, err := exec(s.db, args); err != nil {
-		res = fmt.Sprintf("err: %+v", err.Error())
-	} else {
-		res = val
+	if execCtx, ok := ExecCmdContext[cmd]; ok {
+		exec = func(db *mindb.MinDB, args []string) (protocol.Reply, error) {
+			return execCtx(ctx, db, args)
+		}
 	}
-	return
-}
 
-func wrapReplyInfo(reply string) []byte {
-	b := make([]byte, len(reply)+4)
-	binary.BigEndian.PutUint32(b[:4], uint32(len(reply)))
-	copy(b[4:], reply)
-	return b
+	return s.wrapCmd(cmd, exec)(s.db, args)
 }
@@ -3,7 +3,6 @@ package main
 import (
 	"flag"
 	"fmt"
-	"github.com/pelletier/go-toml"
 	"io/ioutil"
 	"log"
 	"mindb"
@@ -31,12 +30,12 @@ func main() {
 		log.Println("no config set, using the default config.")
 		cfg = mindb.DefaultConfig()
 	} else {
-		c, err := newConfigFromFile(*config)
+		c, err := cmd.LoadConfigFile(*config)
 		if err != nil {
 			log.Printf("load config err : %+v\n", err)
 			return
 		}
-		cfg = *c
+		cfg = c
 	}
 
 	if *dirPath == "" {
@@ -47,31 +46,44 @@ func main() {
 
 	// 监听中断事件
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt, os.Kill, syscall.SIGHUP,
-		syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	signal.Notify(sig, os.Interrupt, os.Kill, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	reload := make(chan os.Signal, 1) // SIGHUP 时重新加载配置文件
+	signal.Notify(reload, syscall.SIGHUP)
 
 	server, err := cmd.NewServer(cfg) // 新建一个server
 	if err != nil {
 		log.Printf("create mindb server err: %+v\n", err)
 		return
 	}
+	if *config != "" {
+		server.SetConfigFile(*config)
+	}
 	go server.Listen(cfg.Addr) // 启动一个goroutine处理server
-
-	<-sig
-	server.Stop()
-	log.Println("mindb is ready to exit, bye...")
-}
-
-func newConfigFromFile(config string) (*mindb.Config, error) {
-	data, err := ioutil.ReadFile(config)
-	if err != nil {
-		return nil, err
+	if cfg.RespAddr != "" {    // 同时支持 RESP 协议的客户端访问
+		go server.ListenRESP(cfg.RespAddr)
+	}
+	if cfg.WsAddr != "" { // 浏览器等客户端通过 WebSocket 访问
+		go server.ListenWebSocket(cfg.WsAddr)
+	}
+	if cfg.DebugAddr != "" { // 性能分析及运行指标
+		go server.ListenDebug(cfg.DebugAddr)
+	}
+	if cfg.UpgradeSocket != "" { // 等待新版本的进程接管监听
+		go server.ListenUpgrade(cfg.UpgradeSocket)
 	}
 
-	var cfg = new(mindb.Config)
-	err = toml.Unmarshal(data, &cfg)
-	if err != nil {
-		return nil, err
+	for running := true; running; {
+		select {
+		case <-reload:
+			if err := server.ReloadConfig(); err != nil {
+				log.Printf("reload config err: %+v\n", err)
+			}
+		case <-sig:
+			running = false
+		case <-server.ShutdownRequested(): // 客户端执行了 SHUTDOWN 命令
+			running = false
+		}
 	}
-	return cfg, nil
+	server.Stop()
+	log.Println("mindb is ready to exit, bye...")
 }
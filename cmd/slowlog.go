@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"mindb/cmd/protocol"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 慢日志中每条记录最多保存的参数个数及每个参数的最大长度，超出的部分会被截断
+const (
+	slowlogMaxArgc   = 32
+	slowlogMaxArgLen = 128
+)
+
+// 慢日志：记录执行时间超过阈值的命令，只保存最近的若干条
+type slowlog struct {
+	mu      sync.Mutex
+	entries []slowlogEntry // 按时间从新到旧排列
+	nextId  int64
+}
+
+// 慢日志中的一条记录
+type slowlogEntry struct {
+	id       int64
+	time     time.Time
+	duration time.Duration
+	args     []string
+	addr     string
+	user     string
+}
+
+// 记录执行时间超过阈值的命令
+func (s *Server) logSlow(state *connState, cmd string, args []string, duration time.Duration) {
+	config := s.conf()
+	if config.SlowlogThreshold < 0 || duration < time.Duration(config.SlowlogThreshold)*time.Microsecond {
+		return
+	}
+
+	argv := append([]string{cmd}, args...)
+	if monitorRedacted[cmd] && len(args) > 0 { // 不记录密码等敏感参数
+		argv = []string{cmd, "(redacted)"}
+	}
+	if len(argv) > slowlogMaxArgc {
+		more := len(argv) - slowlogMaxArgc + 1
+		argv = append(argv[:slowlogMaxArgc-1:slowlogMaxArgc-1], "... ("+strconv.Itoa(more)+" more arguments)")
+	}
+	for i, arg := range argv {
+		if len(arg) > slowlogMaxArgLen {
+			more := len(arg) - slowlogMaxArgLen
+			argv[i] = arg[:slowlogMaxArgLen] + "... (" + strconv.Itoa(more) + " more bytes)"
+		}
+	}
+
+	user := state.username()
+	if user == "" { // 未认证的连接即为默认用户
+		user = DefaultUser
+	}
+
+	l := s.slowlog
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry := slowlogEntry{
+		id:       l.nextId,
+		time:     time.Now(),
+		duration: duration,
+		args:     argv,
+		addr:     state.addr,
+		user:     user,
+	}
+	l.nextId++
+	l.entries = append([]slowlogEntry{entry}, l.entries...)
+	if len(l.entries) > config.SlowlogMaxLen {
+		l.entries = l.entries[:config.SlowlogMaxLen]
+	}
+}
+
+// 处理 SLOWLOG GET [count]、SLOWLOG LEN 及 SLOWLOG RESET 命令
+func (s *Server) slowlogCmd(args []string) protocol.Reply {
+	if len(args) == 0 {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+
+	l := s.slowlog
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch strings.ToLower(args[0]) {
+	case "get":
+		count := 10
+		if len(args) > 2 {
+			return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+		}
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+			}
+			count = n
+		}
+		if count < 0 || count > len(l.entries) { // 负数表示返回所有记录
+			count = len(l.entries)
+		}
+
+		res := make(protocol.Array, 0, count)
+		for _, e := range l.entries[:count] {
+			argv := make(protocol.Array, 0, len(e.args))
+			for _, arg := range e.args {
+				argv = append(argv, protocol.Bulk(arg))
+			}
+			res = append(res, protocol.Array{
+				protocol.Integer(e.id),
+				protocol.Integer(e.time.Unix()),
+				protocol.Integer(e.duration.Microseconds()),
+				argv,
+				protocol.Bulk(e.addr),
+				protocol.Bulk(e.user),
+			})
+		}
+		return res
+	case "len":
+		if len(args) != 1 {
+			return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+		}
+		return protocol.Integer(len(l.entries))
+	case "reset":
+		if len(args) != 1 {
+			return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+		}
+		l.entries = nil
+		return okReply
+	}
+	return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mindb"
+	"mindb/cmd/protocol"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var host = flag.String("h", "127.0.0.1", "the primary mindb server host, default 127.0.0.1")
+var port = flag.Int("p", 5200, "the primary mindb server port, default 5200")
+var password = flag.String("a", "", "password to use when connecting to the primary")
+var user = flag.String("user", "", "username to authenticate with, default user if empty")
+var dir = flag.String("dir", "", "the standby dir, can be opened as a mindb dir path at any time")
+var source = flag.String("source", "", "pull segments from this dir (e.g. a mounted object storage) instead of the primary")
+var interval = flag.Duration("interval", time.Minute, "how often to pull newly sealed segments")
+
+// 每次 SEGMENT 读取的字节数
+const chunkSize = 1 << 20
+
+// 冷备：定期从主节点（或一个目录）拉取新封存的数据文件，保持一个随时可以打开的数据目录
+func main() {
+	flag.Parse()
+	if *dir == "" {
+		log.Println("the standby dir is required, use -dir")
+		return
+	}
+
+	var src mindb.SegmentSource
+	if *source != "" {
+		src = mindb.DirSource(*source)
+	} else {
+		src = &primarySource{addr: fmt.Sprintf("%s:%d", *host, *port)}
+	}
+	standby, err := mindb.NewStandby(*dir, src)
+	if err != nil {
+		log.Printf("create standby err: %+v\n", err)
+		return
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	stop := make(chan struct{})
+	go func() {
+		<-sig
+		close(stop)
+	}()
+
+	log.Printf("mindb standby is pulling segments into %s every %s.\n", *dir, *interval)
+	standby.Run(*interval, stop)
+	log.Println("mindb standby is ready to exit, bye...")
+}
+
+// 通过 SEGMENTS、SEGMENT 命令从主节点拉取数据文件，连接断开后在下一次请求时重新连接
+type primarySource struct {
+	addr string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	reqId  uint32
+}
+
+func (s *primarySource) Segments() ([]mindb.Segment, uint64, error) {
+	reply, err := s.call("segments")
+	if err != nil {
+		return nil, 0, err
+	}
+	arr, ok := reply.(protocol.Array)
+	if !ok || len(arr) == 0 {
+		return nil, 0, errors.New("unexpected reply of SEGMENTS")
+	}
+	seq, _ := arr[0].(protocol.Integer)
+
+	segs := make([]mindb.Segment, 0, len(arr)-1)
+	for _, item := range arr[1:] {
+		fields, ok := item.(protocol.Array)
+		if !ok || len(fields) != 3 {
+			return nil, 0, errors.New("unexpected reply of SEGMENTS")
+		}
+		name, _ := fields[0].(protocol.Bulk)
+		size, _ := fields[1].(protocol.Integer)
+		crc, _ := fields[2].(protocol.Integer)
+		segs = append(segs, mindb.Segment{Name: string(name), Size: int64(size), CRC: uint32(crc)})
+	}
+	return segs, uint64(seq), nil
+}
+
+func (s *primarySource) Fetch(seg mindb.Segment) (io.ReadCloser, error) {
+	return io.NopCloser(&segmentReader{source: s, name: seg.Name}), nil
+}
+
+// 按块读取主节点上的一个数据文件
+type segmentReader struct {
+	source *primarySource
+	name   string
+	offset int64
+	buf    []byte
+}
+
+func (r *segmentReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		reply, err := r.source.call("segment", r.name, strconv.FormatInt(r.offset, 10), strconv.Itoa(chunkSize))
+		if err != nil {
+			return 0, err
+		}
+		data, ok := reply.(protocol.Bulk)
+		if !ok {
+			return 0, errors.New("unexpected reply of SEGMENT")
+		}
+		if len(data) == 0 {
+			return 0, io.EOF
+		}
+		r.buf = data
+		r.offset += int64(len(data))
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// 向主节点发送一个命令并读取响应，错误响应转换为 error
+func (s *primarySource) call(args ...string) (protocol.Reply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := s.request(strings.Join(args, " "))
+	if err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return nil, err
+	}
+	if e, ok := reply.(protocol.Error); ok {
+		return nil, errors.New(string(e))
+	}
+	return reply, nil
+}
+
+// 连接主节点，握手并认证
+func (s *primarySource) connect() error {
+	conn, err := net.Dial("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	s.conn, s.reader = conn, bufio.NewReader(conn)
+
+	handshake := []string{"hello " + strconv.Itoa(protocol.Version)}
+	if *password != "" {
+		auth := "auth " + *password
+		if *user != "" {
+			auth = "auth " + *user + " " + *password
+		}
+		handshake = append(handshake, auth)
+	}
+	for _, cmd := range handshake {
+		reply, err := s.request(cmd)
+		if err == nil {
+			if e, ok := reply.(protocol.Error); ok {
+				err = errors.New(string(e))
+			}
+		}
+		if err != nil {
+			conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *primarySource) request(cmd string) (protocol.Reply, error) {
+	s.reqId++
+	if s.reqId == protocol.PushId {
+		s.reqId++
+	}
+	if _, err := s.conn.Write(protocol.EncodeRequest(s.reqId, cmd)); err != nil {
+		return nil, err
+	}
+	for {
+		id, reply, err := protocol.ReadResponse(s.reader)
+		if err != nil || id == s.reqId {
+			return reply, err
+		}
+	}
+}
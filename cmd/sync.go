@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"mindb"
+	"mindb/cmd/protocol"
+	"os"
+	"sync/atomic"
+)
+
+const (
+	// 全量同步时每次推送的文件数据大小
+	snapshotChunkSize = 1 << 20
+
+	// 传输快照期间最多缓冲的数据变更数，超出后同步失败，避免副本过慢时占用过多内存
+	syncBufferLimit = 1 << 20
+)
+
+var errSyncBufferFull = errors.New("too many changes buffered while transferring the snapshot")
+
+// 处理 SYNC 命令，供副本全量同步数据
+//
+// 命令先创建数据库的快照（短暂阻止写入，将数据文件硬链接冻结下来），返回 OK 后在连接上依次推送：
+//
+//	"snapshot" 序号 文件数
+//	"snapshot-file" 文件名 大小 crc32      每个文件一条，之后跟着该文件的数据
+//	"snapshot-data" 数据                  文件的一段数据，按顺序拼接即为完整的文件
+//	"snapshot-end" 序号
+//
+// 副本将文件写入自己的数据目录并校验 crc32，快照目录可以直接作为数据库目录打开。
+// 传输文件期间快照之后的变更被缓冲下来，快照传输完成后以与 CHANGES 相同的格式推送，之后继续推送新的变更，
+// 副本依次重放即可与主节点保持一致，并通过 REPLCONF ACK 确认已应用到的序号。
+// 出错时推送 "snapshot-error" 或 "changes-error" 及错误信息后停止推送
+func (s *Server) syncCmd(state *connState, args []string) protocol.Reply {
+	if len(args) != 0 {
+		return protocol.Error("ERR " + ErrSyntaxIncorrect.Error())
+	}
+	if !atomic.CompareAndSwapInt32(&state.streaming, 0, 1) {
+		return protocol.Error("ERR the connection is already streaming changes")
+	}
+
+	dir, err := s.db.TempSnapshotDir()
+	if err != nil {
+		atomic.StoreInt32(&state.streaming, 0)
+		return protocol.Error("ERR " + err.Error())
+	}
+	var snap *mindb.Snapshot
+	s.shared(func() { snap, err = s.db.Snapshot(dir) }) // 快照不包含 EXEC 执行的事务的一部分
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		atomic.StoreInt32(&state.streaming, 0)
+		return protocol.Error("ERR " + err.Error())
+	}
+
+	r := s.replicas.add(state)
+	state.onReplied(func() {
+		go s.streamSnapshot(state, snap, r)
+	})
+	return okReply
+}
+
+// 推送快照中的文件，同时缓冲快照之后的变更，完成后推送缓冲的变更并继续推送新的变更
+func (s *Server) streamSnapshot(state *connState, snap *mindb.Snapshot, r *replica) {
+	defer s.replicas.remove(state)
+	stop := make(chan struct{})
+	buffered := make(chan struct{})
+	var (
+		changes []mindb.Change
+		bufErr  error
+	)
+	go func() {
+		defer close(buffered)
+		for {
+			c, err := snap.Changes.Next(stop)
+			if err != nil {
+				if err != mindb.ErrChangesStopped {
+					bufErr = err
+				}
+				return
+			}
+			if changes = append(changes, c); len(changes) > syncBufferLimit {
+				bufErr = errSyncBufferFull
+				return
+			}
+		}
+	}()
+
+	err := s.sendSnapshot(state, snap)
+	close(stop)
+	<-buffered
+	_ = snap.Remove()
+	if err != nil {
+		atomic.StoreInt32(&state.streaming, 0)
+		return
+	}
+	atomic.StoreUint64(&r.sent, snap.Seq)
+
+	for _, c := range changes {
+		if state.sub.push(changeReply(c)) != nil {
+			atomic.StoreInt32(&state.streaming, 0)
+			return
+		}
+		atomic.StoreUint64(&r.sent, c.Seq)
+	}
+	if bufErr != nil {
+		_ = state.sub.push(protocol.Array{protocol.Bulk("changes-error"), protocol.Bulk(bufErr.Error())})
+		atomic.StoreInt32(&state.streaming, 0)
+		return
+	}
+	s.streamChanges(state, snap.Changes, r)
+}
+
+// 推送快照中的所有文件，推送失败（连接关闭）时返回错误
+func (s *Server) sendSnapshot(state *connState, snap *mindb.Snapshot) error {
+	push := state.sub.push
+	if err := push(protocol.Array{
+		protocol.Bulk("snapshot"), protocol.Integer(snap.Seq), protocol.Integer(len(snap.Files)),
+	}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, snapshotChunkSize)
+	for _, f := range snap.Files {
+		if err := push(protocol.Array{
+			protocol.Bulk("snapshot-file"), protocol.Bulk(f.Name), protocol.Integer(f.Size), protocol.Integer(f.CRC),
+		}); err != nil {
+			return err
+		}
+
+		r, err := snap.Open(f)
+		if err != nil {
+			_ = push(protocol.Array{protocol.Bulk("snapshot-error"), protocol.Bulk(err.Error())})
+			return err
+		}
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				if err := push(protocol.Array{protocol.Bulk("snapshot-data"), protocol.Bulk(buf[:n])}); err != nil {
+					_ = r.Close()
+					return err
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				_ = r.Close()
+				_ = push(protocol.Array{protocol.Bulk("snapshot-error"), protocol.Bulk(err.Error())})
+				return err
+			}
+		}
+		_ = r.Close()
+	}
+
+	return push(protocol.Array{protocol.Bulk("snapshot-end"), protocol.Integer(snap.Seq)})
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"log"
+	"net"
+	"time"
+)
+
+// 为接收的每个TCP连接应用 tcp_keepalive、tcp_nodelay 及缓冲区大小的配置
+// 配置在运行时修改后只对新的连接生效
+type tcpListener struct {
+	*net.TCPListener
+	s *Server
+}
+
+func (l *tcpListener) Accept() (net.Conn, error) {
+	conn, err := l.AcceptTCP()
+	if err != nil {
+		return nil, err
+	}
+	if err = l.s.tuneConn(conn); err != nil {
+		log.Printf("set tcp options err: %+v\n", err)
+	}
+	return conn, nil
+}
+
+// 在指定地址上监听TCP连接
+func (s *Server) listenTCP(addr string) (net.Listener, error) {
+	listener, err := s.netListen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &tcpListener{TCPListener: listener.(*net.TCPListener), s: s}, nil
+}
+
+func (s *Server) tuneConn(conn *net.TCPConn) error {
+	conf := s.conf()
+	if err := conn.SetNoDelay(conf.TCPNoDelay); err != nil {
+		return err
+	}
+	switch {
+	case conf.TCPKeepAlive < 0:
+		if err := conn.SetKeepAlive(false); err != nil {
+			return err
+		}
+	case conf.TCPKeepAlive > 0:
+		if err := conn.SetKeepAlive(true); err != nil {
+			return err
+		}
+		if err := conn.SetKeepAlivePeriod(time.Duration(conf.TCPKeepAlive) * time.Second); err != nil {
+			return err
+		}
+	}
+	if conf.TCPReadBuffer > 0 {
+		if err := conn.SetReadBuffer(conf.TCPReadBuffer); err != nil {
+			return err
+		}
+	}
+	if conf.TCPWriteBuffer > 0 {
+		if err := conn.SetWriteBuffer(conf.TCPWriteBuffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
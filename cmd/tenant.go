@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"mindb/cmd/protocol"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// 一个用户的指标，多个租户以不同的 ACL 用户共用服务时，用于把负载及异常的访问归属到租户
+type userMetrics struct {
+	commands int64 // 执行的命令数
+	rejected int64 // 因权限、速率限制或只读模式被拒绝的命令数
+	bytesIn  int64 // 命令及参数的字节数
+	bytesOut int64 // 响应按 RESP 编码的字节数
+}
+
+// UserMetrics 一个用户的指标快照
+type UserMetrics struct {
+	User     string
+	Commands int64
+	Rejected int64
+	BytesIn  int64
+	BytesOut int64
+}
+
+// 按用户统计的指标，用户被删除后保留其指标，同名用户重新创建时继续累加
+type tenantMetrics struct {
+	mu    sync.RWMutex
+	users map[string]*userMetrics
+}
+
+func newTenantMetrics() *tenantMetrics {
+	return &tenantMetrics{users: make(map[string]*userMetrics)}
+}
+
+// 获取用户的指标，不存在时创建，只对通过认证的用户调用，用户数量有限
+func (t *tenantMetrics) of(user string) *userMetrics {
+	t.mu.RLock()
+	m := t.users[user]
+	t.mu.RUnlock()
+	if m != nil {
+		return m
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if m = t.users[user]; m == nil {
+		m = &userMetrics{}
+		t.users[user] = m
+	}
+	return m
+}
+
+// 所有用户的指标，按用户名排列
+func (t *tenantMetrics) snapshot() []UserMetrics {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	res := make([]UserMetrics, 0, len(t.users))
+	for name, m := range t.users {
+		res = append(res, UserMetrics{
+			User:     name,
+			Commands: atomic.LoadInt64(&m.commands),
+			Rejected: atomic.LoadInt64(&m.rejected),
+			BytesIn:  atomic.LoadInt64(&m.bytesIn),
+			BytesOut: atomic.LoadInt64(&m.bytesOut),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].User < res[j].User })
+	return res
+}
+
+// UserMetrics 各用户执行的命令数及读写的字节数
+func (s *Server) UserMetrics() []UserMetrics {
+	return s.tenants.snapshot()
+}
+
+// 命令及参数的字节数
+func argsSize(cmd string, args []string) int64 {
+	n := len(cmd)
+	for _, arg := range args {
+		n += len(arg)
+	}
+	return int64(n)
+}
+
+// 响应按 RESP 编码后的字节数，只计算长度，不实际编码
+func respSize(reply protocol.Reply) int64 {
+	switch r := reply.(type) {
+	case protocol.SimpleString:
+		return int64(len(r)) + 3
+	case protocol.Error:
+		return int64(len(r)) + 3
+	case protocol.Integer:
+		return int64(len(strconv.FormatInt(int64(r), 10))) + 3
+	case protocol.Bulk:
+		if r == nil {
+			return 5
+		}
+		return int64(len(strconv.Itoa(len(r))) + 3 + len(r) + 2)
+	case protocol.Array:
+		if r == nil {
+			return 5
+		}
+		n := int64(len(strconv.Itoa(len(r))) + 3)
+		for _, item := range r {
+			n += respSize(item)
+		}
+		return n
+	default:
+		return int64(len(reply.RESP()))
+	}
+}
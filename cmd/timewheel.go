@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// 时间轮每格的时长，定时任务最多延后这么久执行
+	timeWheelTick = 100 * time.Millisecond
+
+	// 时间轮的格数，转一圈为 timeWheelTick * timeWheelSlots
+	timeWheelSlots = 512
+)
+
+// 时间轮，管理阻塞命令的超时及租约的到期时间
+// 定时任务按到期时间放入环形的格子中，由一个goroutine每个tick推进一格并执行当前格中到期的任务，
+// 大量等待中的命令只需要一个goroutine，不必为每个等待者各开一个goroutine或定时器
+type timeWheel struct {
+	mu    sync.Mutex
+	tick  time.Duration
+	slots []map[*timer]struct{}
+	pos   int // 当前指向的格子
+	done  chan struct{}
+	once  sync.Once
+}
+
+// 时间轮中的一个定时任务
+type timer struct {
+	tw     *timeWheel
+	slot   int
+	rounds int // 指针还需要经过所在格子多少次才到期
+	fn     func()
+}
+
+func newTimeWheel(tick time.Duration, slots int) *timeWheel {
+	tw := &timeWheel{
+		tick:  tick,
+		slots: make([]map[*timer]struct{}, slots),
+		done:  make(chan struct{}),
+	}
+	for i := range tw.slots {
+		tw.slots[i] = make(map[*timer]struct{})
+	}
+	go tw.run()
+	return tw
+}
+
+func (tw *timeWheel) run() {
+	ticker := time.NewTicker(tw.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, fn := range tw.advance() {
+				fn()
+			}
+		case <-tw.done:
+			return
+		}
+	}
+}
+
+// 指针前进一格，取出其中到期的任务
+func (tw *timeWheel) advance() (expired []func()) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	tw.pos = (tw.pos + 1) % len(tw.slots)
+	for t := range tw.slots[tw.pos] {
+		if t.rounds > 0 {
+			t.rounds--
+			continue
+		}
+		delete(tw.slots[tw.pos], t)
+		expired = append(expired, t.fn)
+	}
+	return
+}
+
+// 在 d 之后执行 fn，fn 在时间轮的goroutine中执行，不能阻塞
+func (tw *timeWheel) afterFunc(d time.Duration, fn func()) *timer {
+	ticks := int((d + tw.tick - 1) / tw.tick)
+	if ticks < 1 {
+		ticks = 1
+	}
+
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	t := &timer{
+		tw:     tw,
+		slot:   (tw.pos + ticks) % len(tw.slots),
+		rounds: (ticks - 1) / len(tw.slots),
+		fn:     fn,
+	}
+	tw.slots[t.slot][t] = struct{}{}
+	return t
+}
+
+// 取消定时任务，任务已经执行或已被取消时返回 false
+func (t *timer) stop() bool {
+	t.tw.mu.Lock()
+	defer t.tw.mu.Unlock()
+	if _, ok := t.tw.slots[t.slot][t]; !ok {
+		return false
+	}
+	delete(t.tw.slots[t.slot], t)
+	return true
+}
+
+// 停止时间轮，尚未到期的任务不再执行
+func (tw *timeWheel) stop() {
+	tw.once.Do(func() { close(tw.done) })
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"mindb"
+)
+
+var ErrInvalidCACert = errors.New("no valid certificate found in the ca file")
+
+var ErrTLSNotConfigured = errors.New("tls address requires tls_cert_file and tls_key_file")
+
+// 根据配置创建 TLS 配置，没有配置证书时返回 nil，即不开启 TLS
+// 配置了客户端 CA 证书时会校验客户端提供的证书，TLSAuthClients 为 true 时客户端必须提供证书
+func newTLSConfig(config mindb.Config) (*tls.Config, error) {
+	if config.TLSCertFile == "" && config.TLSKeyFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if config.TLSClientCAFile != "" {
+		pem, err := ioutil.ReadFile(config.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, ErrInvalidCACert
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if config.TLSAuthClients {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return tlsConfig, nil
+}
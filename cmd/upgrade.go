@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// 热升级：配置了 upgrade_socket 时，新进程在打开数据库之前连接该 unix socket，
+// 旧进程把所有监听的 socket 通过 SCM_RIGHTS 传给新进程，然后停止服务、关闭数据库，完成后通知新进程，
+// 新进程再打开数据库，并在继承的 socket 上继续接收连接。交接期间新的连接在内核的 backlog 中等待，不会被拒绝，
+// 旧进程上已有的连接在它退出时断开；新进程仍然从数据文件重建索引，数据量大时连接需要等待更长的时间
+
+var (
+	// ErrHandoffIncomplete 旧进程没有完成关闭就断开了热升级的连接，此时不能打开数据库
+	ErrHandoffIncomplete = errors.New("the old server did not finish shutting down during handoff")
+
+	// ErrUpgradeUnsupported 当前平台不支持通过 unix socket 传递文件描述符
+	ErrUpgradeUnsupported = errors.New("upgrade_socket is not supported on this platform")
+)
+
+const (
+	handoffDone    byte = 1  // 旧进程关闭数据库后发送给新进程的确认
+	maxHandoffFds       = 64 // 一次最多交接的监听数
+	handoffMsgSize      = 64 << 10
+)
+
+// 交接的一个监听，地址为监听时使用的地址，新进程按相同的配置监听时据此找到继承的socket
+type handoffSocket struct {
+	Network  string       `json:"network"`
+	Address  string       `json:"address"`
+	listener net.Listener // 未经TLS等包装的原始监听
+}
+
+// 在 address 上监听，有从旧进程继承的相同地址的socket时直接使用
+func (s *Server) netListen(network, address string) (net.Listener, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var listener net.Listener
+	var err error
+	key := network + " " + address
+	if f, ok := s.inherited[key]; ok {
+		delete(s.inherited, key)
+		listener, err = net.FileListener(f)
+		f.Close()
+	} else {
+		if network == "unix" { // 上次退出时没有删除的socket文件，继承的socket仍在使用其文件，不能删除
+			if info, err := os.Stat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+				_ = os.Remove(address)
+			}
+		}
+		listener, err = net.Listen(network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.sockets = append(s.sockets, handoffSocket{Network: network, Address: address, listener: listener})
+	return listener, nil
+}
@@ -0,0 +1,21 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+
+package cmd
+
+import (
+	"log"
+	"os"
+)
+
+// 不支持传递文件描述符的平台上不能热升级，配置了 upgrade_socket 时启动失败，避免误以为可以热升级
+func takeOver(path string) (map[string]*os.File, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return nil, ErrUpgradeUnsupported
+}
+
+// ListenUpgrade 当前平台不支持热升级
+func (s *Server) ListenUpgrade(path string) {
+	log.Printf("upgrade listen err: %+v\n", ErrUpgradeUnsupported)
+}
@@ -0,0 +1,155 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"syscall"
+)
+
+// 连接旧进程的 upgrade_socket 接管其监听，等待旧进程关闭数据库后返回继承的socket，key为 network+" "+address
+// 没有正在运行的旧进程时返回 nil，正常启动
+func takeOver(path string) (map[string]*os.File, error) {
+	if path == "" {
+		return nil, nil
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, nil
+	}
+	defer conn.Close()
+
+	msg, oob := make([]byte, handoffMsgSize), make([]byte, syscall.CmsgSpace(maxHandoffFds*4))
+	n, oobn, _, _, err := conn.(*net.UnixConn).ReadMsgUnix(msg, oob)
+	if err != nil {
+		return nil, err
+	}
+	var fds []int
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range msgs {
+		rights, err := syscall.ParseUnixRights(&m)
+		if err != nil {
+			return nil, err
+		}
+		fds = append(fds, rights...)
+	}
+	files := make(map[string]*os.File, len(fds))
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+
+	var sockets []handoffSocket
+	if err = json.Unmarshal(msg[:n], &sockets); err != nil || len(sockets) != len(fds) {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+		if err == nil {
+			err = errors.New("the number of handed off sockets does not match")
+		}
+		return nil, err
+	}
+	for i, sock := range sockets {
+		files[sock.Network+" "+sock.Address] = os.NewFile(uintptr(fds[i]), sock.Address)
+	}
+
+	// 旧进程会等待正在执行的命令完成，没有超时
+	done := make([]byte, 1)
+	if _, err = conn.Read(done); err != nil || done[0] != handoffDone {
+		closeAll()
+		return nil, ErrHandoffIncomplete
+	}
+	log.Printf("took over %d listeners from the old server.\n", len(files))
+	return files, nil
+}
+
+// ListenUpgrade 在 unix socket path 上等待新进程的热升级请求，交接所有的监听后关闭服务并请求退出
+func (s *Server) ListenUpgrade(path string) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		log.Printf("upgrade listen err: %+v\n", err)
+		return
+	}
+	if !s.addListeners([]net.Listener{listener}) {
+		return
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			default:
+				continue
+			}
+		}
+		if err = s.handOff(conn.(*net.UnixConn)); err != nil {
+			log.Printf("hand off listeners err: %+v\n", err)
+			continue
+		}
+		return
+	}
+}
+
+// 把所有的监听交给新进程，停止服务并关闭数据库后通知新进程
+func (s *Server) handOff(conn *net.UnixConn) error {
+	defer conn.Close()
+
+	s.mu.RLock()
+	sockets := append([]handoffSocket(nil), s.sockets...)
+	s.mu.RUnlock()
+	if len(sockets) > maxHandoffFds {
+		return errors.New("too many listeners to hand off")
+	}
+
+	var fds []int
+	for _, sock := range sockets {
+		f, ok := sock.listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			return errors.New("can not hand off listener on " + sock.Address)
+		}
+		file, err := f.File() // 复制的文件描述符，关闭旧进程的监听不影响新进程
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		fds = append(fds, int(file.Fd()))
+	}
+	msg, err := json.Marshal(sockets)
+	if err != nil {
+		return err
+	}
+	var oob []byte
+	if len(fds) > 0 {
+		oob = syscall.UnixRights(fds...)
+	}
+	if _, _, err = conn.WriteMsgUnix(msg, oob, nil); err != nil {
+		return err
+	}
+
+	// socket文件已交给新进程，关闭监听时不能删除
+	for _, sock := range sockets {
+		if l, ok := sock.listener.(*net.UnixListener); ok {
+			l.SetUnlinkOnClose(false)
+		}
+	}
+	log.Println("handed off listeners to the new server, shutting down.")
+	s.Stop()
+	if _, err = conn.Write([]byte{handoffDone}); err != nil {
+		log.Printf("notify the new server err: %+v\n", err)
+	}
+	s.shutdownOnce.Do(func() { close(s.shutdown) })
+	return nil
+}
@@ -0,0 +1,372 @@
+package cmd
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mindb/cmd/protocol"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebSocket 协议（RFC 6455）的帧类型
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// 计算 Sec-WebSocket-Accept 时使用的固定 GUID
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// 一条 WebSocket 消息的最大长度：8MB
+const wsMaxMessageSize = 8 << 20
+
+var (
+	ErrWsHandshake    = errors.New("websocket: bad handshake")
+	ErrWsProtocol     = errors.New("websocket: protocol error")
+	ErrWsMessageLarge = errors.New("websocket: message too large")
+	ErrWsOrigin       = errors.New("websocket: origin not allowed")
+)
+
+// WebSocket 上的请求：{"id": 1, "cmd": "set", "args": ["key", "value"]}
+type wsRequest struct {
+	Id   interface{} `json:"id,omitempty"`
+	Cmd  string      `json:"cmd"`
+	Args []string    `json:"args"`
+}
+
+// WebSocket 上的响应：{"id": 1, "result": "OK"} 或 {"id": 1, "result": null, "error": "ERR ..."}
+type wsResponse struct {
+	Id     interface{} `json:"id,omitempty"`
+	Result interface{} `json:"result"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// 订阅的消息没有请求id，以 {"push": ["message", "channel", "hello"]} 的形式推送
+type wsPush struct {
+	Push interface{} `json:"push"`
+}
+
+// WebSocket 连接
+type wsConn struct {
+	conn         net.Conn
+	reader       *bufio.Reader
+	writeMu      sync.Mutex
+	writeTimeout time.Duration // 写入一个帧的超时，0表示不限制
+}
+
+// ListenWebSocket 监听 WebSocket 连接，浏览器等客户端可以通过 JSON 消息执行命令、订阅频道
+func (s *Server) ListenWebSocket(addr string) {
+	listeners, err := s.listen(addr)
+	if err != nil {
+		log.Printf("websocket listen err: %+v\n", err)
+		return
+	}
+
+	log.Printf("mindb is accepting websocket connections on %s.\n", addr)
+	var wg sync.WaitGroup
+	for _, listener := range listeners {
+		wg.Add(1)
+		go func(listener net.Listener) {
+			defer wg.Done()
+			err := http.Serve(listener, http.HandlerFunc(s.handleWebSocket))
+			select {
+			case <-s.done: // 服务关闭时监听被关闭，不是错误
+			default:
+				log.Printf("websocket serve err: %+v\n", err)
+			}
+		}(listener)
+	}
+	wg.Wait()
+}
+
+// 将 HTTP 请求升级为 WebSocket 连接并处理
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !s.acquireClient() {
+		http.Error(w, string(errMaxClients), http.StatusServiceUnavailable)
+		return
+	}
+	defer s.releaseClient()
+
+	// 浏览器总是带上发起连接的网页的 Origin，不检查时任何网页都可以用访问者的身份执行命令（跨站 WebSocket 劫持）
+	origin := r.Header.Get("Origin")
+	if origin != "" && !originAllowed(s.conf().WsAllowedOrigins, origin) {
+		http.Error(w, ErrWsOrigin.Error(), http.StatusForbidden)
+		return
+	}
+
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ws.writeTimeout = seconds(s.conf().ConnWriteTimeout)
+
+	state := newConnState(r.RemoteAddr, func(reply protocol.Reply) error {
+		return ws.writeJSON(wsPush{Push: protocol.JSONValue(reply)})
+	})
+	state.proto = protoWebSocket
+	state.browser = origin != ""
+	defer s.closeConnState(state)
+
+	jobs := newOrderedJobs()
+	defer func() {
+		jobs.wg.Wait() // 等待已读取的命令处理完成后再关闭连接
+		ws.conn.Close()
+	}()
+
+	for {
+		err := s.waitRequest(ws.conn, state, func() error {
+			_, err := ws.reader.Peek(1)
+			return err
+		})
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("read websocket message err: %+v\n", err)
+			}
+			state.cancel() // 客户端已断开连接，取消正在执行的命令
+			return
+		}
+
+		msg, err := ws.readMessage()
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("read websocket message err: %+v\n", err)
+			}
+			state.cancel() // 客户端已断开连接，取消正在执行的命令
+			return
+		}
+
+		var req wsRequest
+		if err = json.Unmarshal(msg, &req); err != nil {
+			req.Cmd = ""
+		}
+		// 格式错误的请求也按顺序返回响应
+		if !s.runInOrder(jobs, ws.conn, state, req.Cmd, func() {
+			resp := wsResponse{Id: req.Id, Error: "ERR " + ErrSyntaxIncorrect.Error()}
+			if req.Cmd != "" {
+				resp = wsReply(req.Id, s.dispatch(state, req.Cmd, req.Args))
+			}
+			if err := ws.writeJSON(resp); err != nil {
+				log.Printf("write websocket message err: %+v\n", err)
+				ws.conn.Close() // 读循环随之退出
+				return
+			}
+			state.replied()
+		}) {
+			return
+		}
+	}
+}
+
+// 将命令的响应转换为 WebSocket 响应，多条响应（如订阅多个频道的确认消息）合并为一个数组
+func wsReply(id interface{}, replies []protocol.Reply) wsResponse {
+	reply := protocol.Reply(protocol.Array(replies))
+	if len(replies) == 1 {
+		reply = replies[0]
+	}
+	if e, ok := reply.(protocol.Error); ok {
+		return wsResponse{Id: id, Error: string(e)}
+	}
+	return wsResponse{Id: id, Result: protocol.JSONValue(reply)}
+}
+
+// 校验握手请求，接管底层连接并返回握手响应
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, ErrWsHandshake
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, ErrWsHandshake
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, ErrWsHandshake
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha1.New()
+	h.Write([]byte(key + wsGUID))
+	accept := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + accept + "\r\n\r\n"
+	if _, err = conn.Write([]byte(resp)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{}) // 清除 http.Server 设置的超时
+	return &wsConn{conn: conn, reader: rw.Reader}, nil
+}
+
+// 网页来源是否在逗号分隔的允许列表中，比较时不区分大小写，忽略末尾的 /
+func originAllowed(allowed, origin string) bool {
+	origin = strings.TrimSuffix(origin, "/")
+	for _, o := range strings.Split(allowed, ",") {
+		if o = strings.TrimSuffix(strings.TrimSpace(o), "/"); o != "" && strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断逗号分隔的请求头中是否包含指定的值，不区分大小写
+func headerContains(header http.Header, name, value string) bool {
+	for _, v := range header.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 读取一条完整的文本或二进制消息，分片的消息会被拼接，ping 会自动回复 pong
+// 收到关闭帧时回复关闭帧并返回 io.EOF
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	started := false
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case wsClose:
+			_ = c.writeFrame(wsClose, payload)
+			return nil, io.EOF
+		case wsPing:
+			if err = c.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsText, wsBinary:
+			if started {
+				return nil, ErrWsProtocol
+			}
+			started = true
+		case wsContinuation:
+			if !started {
+				return nil, ErrWsProtocol
+			}
+		default:
+			return nil, ErrWsProtocol
+		}
+
+		if len(msg)+len(payload) > wsMaxMessageSize {
+			return nil, ErrWsMessageLarge
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// 读取一个帧，客户端发送的帧必须带有掩码
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(c.reader, header); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0f
+	if header[1]&0x80 == 0 {
+		err = ErrWsProtocol
+		return
+	}
+
+	size := uint64(header[1] & 0x7f)
+	switch size {
+	case 126:
+		b := make([]byte, 2)
+		if _, err = io.ReadFull(c.reader, b); err != nil {
+			return
+		}
+		size = uint64(binary.BigEndian.Uint16(b))
+	case 127:
+		b := make([]byte, 8)
+		if _, err = io.ReadFull(c.reader, b); err != nil {
+			return
+		}
+		size = binary.BigEndian.Uint64(b)
+	}
+	if size > wsMaxMessageSize {
+		err = ErrWsMessageLarge
+		return
+	}
+
+	mask := make([]byte, 4)
+	if _, err = io.ReadFull(c.reader, mask); err != nil {
+		return
+	}
+	payload = make([]byte, size)
+	if _, err = io.ReadFull(c.reader, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// 写入一个不分片的帧，服务端发送的帧不带掩码
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = header[:4]
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = header[:10]
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_ = c.conn.SetWriteDeadline(deadline(c.writeTimeout))
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+// 以文本帧写入 JSON 编码的响应
+func (c *wsConn) writeJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(wsText, b)
+}
@@ -0,0 +1,68 @@
+package mindb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// Codec 对象与字节数组之间的编解码，用于直接存取 Go 中的结构体等对象
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type (
+	// JSONCodec 使用 JSON 编解码，编码结果便于其他语言的客户端读取
+	JSONCodec struct{}
+
+	// GobCodec 使用 gob 编解码，编码结果只能由 Go 程序读取，但能保留数值的精确类型
+	GobCodec struct{}
+)
+
+// DefaultCodec 存取对象时未指定编解码方式时使用的默认方式
+var DefaultCodec Codec = JSONCodec{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// SetObject 将对象编码后作为字符串的值保存，codec 为 nil 时使用 DefaultCodec
+func (db *MinDB) SetObject(key []byte, v interface{}, codec Codec) error {
+	if codec == nil {
+		codec = DefaultCodec
+	}
+	value, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return db.Set(key, value)
+}
+
+// GetObject 读取 key 的值并解码到 v 中，v 必须是指针，codec 需与保存时使用的一致
+func (db *MinDB) GetObject(key []byte, v interface{}, codec Codec) error {
+	if codec == nil {
+		codec = DefaultCodec
+	}
+	value, err := db.Get(key)
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(value, v)
+}
@@ -15,6 +15,21 @@ const (
 	KeyOnlyRamMode
 )
 
+// RecoveryMode 打开数据库重建索引时遇到损坏的entry的处理方式
+type RecoveryMode int
+
+const (
+	// RecoveryStrict 遇到损坏的entry时打开失败，返回 ErrCorruptData
+	RecoveryStrict RecoveryMode = iota
+
+	// RecoveryTolerant 跳过损坏的entry并记录警告，继续加载之后的数据；
+	// 无法确定下一条entry的位置时（如读取出错）跳过该文件中剩余的数据
+	RecoveryTolerant
+
+	// RecoveryRepair 在损坏的entry处截断所在的数据文件，丢弃同一文件中之后的数据，修复后的文件中只有完整有效的entry
+	RecoveryRepair
+)
+
 const (
 	// DefaultAddr 默认服务器地址
 	DefaultAddr = "127.0.0.1:5200"
@@ -31,21 +46,92 @@ const (
 	// DefaultMaxValueSize 默认的value最大值 1MB
 	DefaultMaxValueSize = uint32(1 * 1024 * 1024)
 
+	// DefaultConnIdleTimeout 默认的连接空闲超时：8小时
+	DefaultConnIdleTimeout = 8 * 3600
+
+	// DefaultConnReadTimeout 默认读取一个请求的超时：30秒
+	DefaultConnReadTimeout = 30
+
+	// DefaultConnWriteTimeout 默认写入一个响应的超时：30秒
+	DefaultConnWriteTimeout = 30
+
+	// DefaultShutdownTimeout 默认关闭时等待正在执行的命令完成的时间：10秒
+	DefaultShutdownTimeout = 10
+
+	// DefaultSlowlogThreshold 默认执行时间超过 10 毫秒的命令记录到慢日志
+	DefaultSlowlogThreshold = 10000
+
+	// DefaultMaxRequestSize 默认单个请求的最大字节数：4MB
+	DefaultMaxRequestSize = 4 * 1024 * 1024
+
+	// DefaultSlowlogMaxLen 默认慢日志最多保存 128 条
+	DefaultSlowlogMaxLen = 128
+
+	// DefaultHotKeyWindow 默认统计key访问时每个时间窗口为 60 秒
+	DefaultHotKeyWindow = 60
+
+	// DefaultWorkerPoolSize 默认执行命令的工作池大小
+	DefaultWorkerPoolSize = 256
+
 	// DefaultReclaimThreshold 默认回收磁盘空间的阈值，当已封存文件个数到达 4 时，可进行回收
 	DefaultReclaimThreshold = 4
 )
 
 // Config 数据库配置
 type Config struct {
-	Addr             string               `json:"addr" toml:"addr"`             //服务器地址
-	DirPath          string               `json:"dir_path" toml:"dir_path"`     //数据库数据存储目录
-	BlockSize        int64                `json:"block_size" toml:"block_size"` //每个数据块文件的大小
-	RwMethod         storage.FileRWMethod `json:"rw_method" toml:"rw_method"`   //数据读写模式
-	IdxMode          DataIndexMode        `json:"idx_mode" toml:"idx_mode"`     //数据索引模式
-	MaxKeySize       uint32               `json:"max_key_size" toml:"max_key_size"`
-	MaxValueSize     uint32               `json:"max_value_size" toml:"max_value_size"`
-	Sync             bool                 `json:"sync" toml:"sync"`                           //每次写数据是否持久化
-	ReclaimThreshold int                  `json:"reclaim_threshold" toml:"reclaim_threshold"` //回收磁盘空间的阈值
+	Addr              string               `json:"addr" toml:"addr"`                                 //服务器地址，多个地址以逗号分隔，支持 unix:/path 及 tls://host:port
+	RespAddr          string               `json:"resp_addr" toml:"resp_addr"`                       //RESP协议的监听地址，多个地址以逗号分隔，为空时不开启
+	WsAddr            string               `json:"ws_addr" toml:"ws_addr"`                           //WebSocket的监听地址，为空时不开启
+	WsAllowedOrigins  string               `json:"ws_allowed_origins" toml:"ws_allowed_origins"`     //允许连接WebSocket的网页来源，多个以逗号分隔，如 "https://app.example.com"；带有其他 Origin 的握手被拒绝，浏览器的连接必须先认证
+	DebugAddr         string               `json:"debug_addr" toml:"debug_addr"`                     //调试HTTP服务的监听地址，提供 pprof、expvar 及key统计，为空时不开启
+	UpgradeSocket     string               `json:"upgrade_socket" toml:"upgrade_socket"`             //热升级时交接监听的unix socket路径，为空时不开启
+	Password          string               `json:"password" toml:"password"`                         //访问密码，为空时不需要认证
+	TLSCertFile       string               `json:"tls_cert_file" toml:"tls_cert_file"`               //TLS证书文件，与私钥文件均配置时开启TLS
+	TLSKeyFile        string               `json:"tls_key_file" toml:"tls_key_file"`                 //TLS私钥文件
+	TLSClientCAFile   string               `json:"tls_client_ca_file" toml:"tls_client_ca_file"`     //校验客户端证书的CA文件
+	TLSAuthClients    bool                 `json:"tls_auth_clients" toml:"tls_auth_clients"`         //是否要求客户端必须提供证书
+	MaxClients        int                  `json:"max_clients" toml:"max_clients"`                   //最大客户端连接数，0表示不限制
+	MaxRequestSize    int                  `json:"max_request_size" toml:"max_request_size"`         //单个请求的最大字节数（自定义协议及RESP协议），超过时断开连接，0表示使用默认值
+	ClientRateLimit   float64              `json:"client_rate_limit" toml:"client_rate_limit"`       //每个连接每秒最多执行的命令数，0表示不限制
+	ClientRateBurst   int                  `json:"client_rate_burst" toml:"client_rate_burst"`       //每个连接允许的突发命令数，0表示与每秒的命令数相同
+	ConnIdleTimeout   int64                `json:"conn_idle_timeout" toml:"conn_idle_timeout"`       //连接空闲多少秒后关闭，0表示不关闭
+	ConnReadTimeout   int64                `json:"conn_read_timeout" toml:"conn_read_timeout"`       //收到请求的第一个字节后，需要在多少秒内读完整个请求，0表示不限制
+	ConnWriteTimeout  int64                `json:"conn_write_timeout" toml:"conn_write_timeout"`     //写入一个响应的超时秒数，0表示不限制
+	CommandTimeout    int64                `json:"command_timeout" toml:"command_timeout"`           //可取消的命令（如 PREFIXSCAN、RANGESCAN）最长执行的秒数，超时后返回错误，0表示不限制
+	TCPKeepAlive      int64                `json:"tcp_keepalive" toml:"tcp_keepalive"`               //TCP保活探测的间隔秒数，0表示使用默认值（15秒），负数表示关闭保活
+	TCPNoDelay        bool                 `json:"tcp_nodelay" toml:"tcp_nodelay"`                   //是否关闭Nagle算法，立即发送小的响应
+	TCPReadBuffer     int                  `json:"tcp_read_buffer" toml:"tcp_read_buffer"`           //连接的内核接收缓冲区字节数，0表示使用系统默认值
+	TCPWriteBuffer    int                  `json:"tcp_write_buffer" toml:"tcp_write_buffer"`         //连接的内核发送缓冲区字节数，0表示使用系统默认值
+	ShutdownTimeout   int64                `json:"shutdown_timeout" toml:"shutdown_timeout"`         //关闭时等待正在执行的命令完成的最长秒数，0表示一直等待
+	SlowlogThreshold  int64                `json:"slowlog_threshold" toml:"slowlog_threshold"`       //执行时间超过多少微秒的命令记录到慢日志，0表示记录所有命令，负数表示不记录
+	SlowlogMaxLen     int                  `json:"slowlog_max_len" toml:"slowlog_max_len"`           //慢日志最多保存的条数
+	DirPath           string               `json:"dir_path" toml:"dir_path"`                         //数据库数据存储目录
+	BlockSize         int64                `json:"block_size" toml:"block_size"`                     //每个数据块文件的大小
+	RwMethod          storage.FileRWMethod `json:"rw_method" toml:"rw_method"`                       //数据读写模式
+	IdxMode           DataIndexMode        `json:"idx_mode" toml:"idx_mode"`                         //数据索引模式
+	Checksum          storage.ChecksumType `json:"checksum" toml:"checksum"`                         //新数据文件的校验和算法
+	VerifyValueOnRead bool                 `json:"verify_value_on_read" toml:"verify_value_on_read"` //读取字符串的值时再用索引中保存的校验和检查一次，发现损坏时修复
+	RecoveryMode      RecoveryMode         `json:"recovery_mode" toml:"recovery_mode"`               //打开时遇到损坏的entry的处理方式
+	StrPatchMinSize   uint32               `json:"str_patch_min_size" toml:"str_patch_min_size"`     //字符串的值不小于此大小时，部分修改以增量方式写入，0表示不启用
+	HotKeySampleRate  float64              `json:"hotkey_sample_rate" toml:"hotkey_sample_rate"`     //按前缀统计key访问次数的采样率（0~1），0表示不统计
+	HotKeyWindow      int64                `json:"hotkey_window" toml:"hotkey_window"`               //统计key访问次数的时间窗口秒数
+	ChangeBacklog     int                  `json:"change_backlog" toml:"change_backlog"`             //保留最近多少条数据变更供 CHANGES 命令订阅，0表示不保留
+	TrashTTL          int64                `json:"trash_ttl" toml:"trash_ttl"`                       //被删除的字符串在回收站中保留的秒数，期间可以恢复，0表示直接删除
+	ListMaxLen        int                  `json:"list_max_len" toml:"list_max_len"`                 //列表的最大长度，超出时删除最早添加的元素，0表示不限制
+	HashMaxLen        int                  `json:"hash_max_len" toml:"hash_max_len"`                 //哈希的最大域数量，达到后不能添加新的域，0表示不限制
+	SetMaxLen         int                  `json:"set_max_len" toml:"set_max_len"`                   //集合的最大元素数量，达到后不能添加新的元素，0表示不限制
+	ZSetMaxLen        int                  `json:"zset_max_len" toml:"zset_max_len"`                 //有序集合的最大元素数量，超出时删除分值最低的元素，0表示不限制
+	SortedReads       bool                 `json:"sorted_reads" toml:"sorted_reads"`                 //返回哈希的所有域、集合的所有元素时是否按字节序排列，关闭时顺序不固定
+	WorkerPoolSize    int                  `json:"worker_pool_size" toml:"worker_pool_size"`         //服务端执行命令的worker数量
+	MaxKeySize        uint32               `json:"max_key_size" toml:"max_key_size"`
+	MaxValueSize      uint32               `json:"max_value_size" toml:"max_value_size"`
+	Sync              bool                 `json:"sync" toml:"sync"`                           //每次写数据是否持久化
+	ReclaimThreshold  int                  `json:"reclaim_threshold" toml:"reclaim_threshold"` //回收磁盘空间的阈值
+	ReclaimMinBytes   int64                `json:"reclaim_min_bytes" toml:"reclaim_min_bytes"` //可回收空间达到此大小时回收，0表示不按大小判断
+	ReclaimRatio      float64              `json:"reclaim_ratio" toml:"reclaim_ratio"`         //可回收空间占已封存文件大小的比例达到此值时回收，0表示不按比例判断
+	ReclaimWorkers    int                  `json:"reclaim_workers" toml:"reclaim_workers"`     //回收时同时处理的数据类型数量，0表示所有类型同时回收
+	ReclaimBufSize    int                  `json:"reclaim_buf_size" toml:"reclaim_buf_size"`   //回收时每个类型读写文件的缓冲区字节数，0表示使用默认值
+	ReclaimTmpDir     string               `json:"reclaim_tmp_dir" toml:"reclaim_tmp_dir"`     //回收时暂存新数据文件的目录，为空时在数据目录下，需要与数据目录在同一文件系统才能直接移动文件
 }
 
 // DefaultConfig 获取默认配置
@@ -60,5 +146,15 @@ func DefaultConfig() Config {
 		MaxValueSize:     DefaultMaxValueSize,
 		Sync:             false,
 		ReclaimThreshold: DefaultReclaimThreshold,
+		ConnIdleTimeout:  DefaultConnIdleTimeout,
+		ConnReadTimeout:  DefaultConnReadTimeout,
+		ConnWriteTimeout: DefaultConnWriteTimeout,
+		ShutdownTimeout:  DefaultShutdownTimeout,
+		TCPNoDelay:       true,
+		SlowlogThreshold: DefaultSlowlogThreshold,
+		SlowlogMaxLen:    DefaultSlowlogMaxLen,
+		MaxRequestSize:   DefaultMaxRequestSize,
+		HotKeyWindow:     DefaultHotKeyWindow,
+		WorkerPoolSize:   DefaultWorkerPoolSize,
 	}
 }
@@ -0,0 +1,453 @@
+package mindb
+
+import (
+	"errors"
+	"log"
+	"mindb/index"
+	"mindb/storage"
+	"mindb/utils"
+	"strconv"
+)
+
+// ErrBatchCommitted 批量写入已经提交过，不能再添加操作或再次提交
+var ErrBatchCommitted = errors.New("mindb: the write batch is already committed")
+
+// WriteBatch 跨key、跨数据类型的批量写入，提交时作为一个整体写入并持久化，崩溃后重放时要么全部生效，要么全部不生效
+//
+// 提交时按类型的固定顺序持有涉及的所有类型的索引锁，分两个阶段写入：
+//  1. 每种类型的操作按添加的顺序写入该类型的数据文件，除列表外以意向记录的形式写入（见 intent.go），
+//     列表按操作序号重放，其操作在 Extra 中记录批次id，写入后持久化所有涉及类型的活跃文件；
+//  2. 在每种类型中写入 commit，列表的 commit 最先写入并单独持久化，之后写入其他类型的 commit 并持久化。
+//
+// 所有操作及 commit 写入并持久化之后才修改内存中的索引，写入失败时索引保持不变，与重放时丢弃该批次的结果一致；
+// 修改索引时不会中途停止，某个操作应用失败时仍然应用其余的操作，与重放时的结果一致。期间其他操作被阻塞，不会读到批量写入的中间状态。
+// 重建索引时任何一种类型中有 commit 的批次视为已提交，写 commit 的中途崩溃时缺少的 commit 在打开时补写。
+// 不论是否开启 Sync，提交时都会持久化
+//
+// 批量写入中的操作不检查值是否发生了变化，每个操作都会写入一条entry；
+// 列表及有序集合超出长度上限时的删除、字符串的回收站、物化视图的更新在提交之后另行写入，不属于批量写入的整体，
+// 但仍在释放锁之前完成，其他操作不会看到来源已修改而视图没有更新的状态
+type WriteBatch struct {
+	db        *MinDB
+	ops       []*storage.Entry
+	committed bool
+}
+
+// NewWriteBatch 新建一个批量写入，添加操作后调用 Commit 提交，WriteBatch 不是并发安全的
+func (db *MinDB) NewWriteBatch() *WriteBatch {
+	return &WriteBatch{db: db}
+}
+
+// Len 批量写入中的操作数
+func (b *WriteBatch) Len() int {
+	return len(b.ops)
+}
+
+// 添加一条操作，key、value、extra 会被复制，添加之后调用方可以继续修改
+func (b *WriteBatch) add(key, value, extra []byte, dataType DataType, mark uint16) error {
+	if b.committed {
+		return ErrBatchCommitted
+	}
+	if err := b.db.checkKeyValue(key, value); err != nil {
+		return err
+	}
+	b.ops = append(b.ops, storage.NewEntry(cloneBytes(key), cloneBytes(value), cloneBytes(extra), dataType, mark))
+	return nil
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+// Set 设置字符串的值，与 MinDB.Set 一样清除原有的过期时间
+func (b *WriteBatch) Set(key, value []byte) error {
+	return b.add(key, value, nil, String, StringSet)
+}
+
+// StrRem 删除字符串
+func (b *WriteBatch) StrRem(key []byte) error {
+	return b.add(key, nil, nil, String, StringRem)
+}
+
+// LPush 在列表的头部添加元素
+func (b *WriteBatch) LPush(key []byte, values ...[]byte) error {
+	for _, val := range values {
+		if err := b.add(key, val, nil, List, ListLPush); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RPush 在列表的尾部添加元素
+func (b *WriteBatch) RPush(key []byte, values ...[]byte) error {
+	for _, val := range values {
+		if err := b.add(key, val, nil, List, ListRPush); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HSet 设置哈希表中域的值
+func (b *WriteBatch) HSet(key, field, value []byte) error {
+	return b.add(key, value, field, Hash, HashHSet)
+}
+
+// HDel 删除哈希表中的域
+func (b *WriteBatch) HDel(key []byte, fields ...[]byte) error {
+	for _, f := range fields {
+		if err := b.add(key, nil, f, Hash, HashHDel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SAdd 向集合中添加元素
+func (b *WriteBatch) SAdd(key []byte, members ...[]byte) error {
+	for _, m := range members {
+		if err := b.add(key, m, nil, Set, SetSAdd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SRem 删除集合中的元素
+func (b *WriteBatch) SRem(key []byte, members ...[]byte) error {
+	for _, m := range members {
+		if err := b.add(key, m, nil, Set, SetSRem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ZAdd 设置有序集合中元素的分值
+func (b *WriteBatch) ZAdd(key []byte, score float64, member []byte) error {
+	return b.add(key, member, []byte(utils.Float64ToStr(score)), ZSet, ZSetZAdd)
+}
+
+// ZRem 删除有序集合中的元素
+func (b *WriteBatch) ZRem(key, member []byte) error {
+	return b.add(key, member, nil, ZSet, ZSetZRem)
+}
+
+// Commit 提交批量写入，返回 nil 时所有操作都已持久化并生效
+// 写入数据文件失败时返回错误，所有操作都不生效；commit 已持久化之后的错误不会撤销批量写入，所有操作都已生效
+// 哈希或集合在批量写入后会超出长度上限时返回 ErrCollectionFull，此时不写入任何操作，批量写入可以修改后重新提交
+func (b *WriteBatch) Commit() error {
+	return b.commitIf(nil, nil)
+}
+
+// 提交批量写入，同时持有 extra 中类型的索引锁，持有锁之后 check 返回错误时不写入任何操作，用于事务提交时检查冲突
+func (b *WriteBatch) commitIf(extra []DataType, check func() error) error {
+	if b.committed {
+		return ErrBatchCommitted
+	}
+	if len(b.ops) == 0 {
+		b.committed = true
+		return nil
+	}
+
+	var involved [5]bool
+	for _, e := range b.ops {
+		involved[e.Type] = true
+	}
+	for _, dataType := range extra {
+		involved[dataType] = true
+	}
+	var types []DataType
+	for _, dataType := range DataTypes {
+		if involved[dataType] {
+			types = append(types, dataType)
+		}
+	}
+
+	return b.commit(types, check)
+}
+
+// 持有涉及类型的锁写入并应用所有操作，之后在释放锁之前更新排行榜视图
+func (b *WriteBatch) commit(types []DataType, check func() error) error {
+	db := b.db
+	for _, dataType := range types {
+		db.idxLock(dataType).Lock()
+	}
+	defer func() {
+		for i := len(types) - 1; i >= 0; i-- {
+			db.idxLock(types[i]).Unlock()
+		}
+	}()
+
+	if !db.isOpen() {
+		return ErrDBClosed
+	}
+	if check != nil {
+		if err := check(); err != nil {
+			return err
+		}
+	}
+	if b.collectionFull() {
+		return ErrCollectionFull
+	}
+
+	id, err := db.seqs.next()
+	if err != nil {
+		return err
+	}
+	// 每个操作及每种类型的 intent、commit 各分配一个写入序号
+	n := uint64(len(b.ops) + 2*len(types))
+	if err = db.seqs.reserve(n); err != nil {
+		return err
+	}
+	defer db.seqs.release(n)
+
+	positions, written, err := b.write(id, types)
+	if err != nil {
+		if involvesList(types) { // 已写入的列表操作没有 intent 包裹，需要记录下来，回收时丢弃
+			db.aborted[id] = true
+		}
+		return err
+	}
+	b.committed = true
+	db.emitWrites(written...) // 批量写入已经提交，消费者才能看到其中的操作
+	leaderboards, err := b.apply(positions)
+	b.updateLeaderboards(types, leaderboards)
+	return err
+}
+
+// 在批量写入持有的锁内更新排行榜视图，来源哈希与视图的结果一起对其他操作可见
+// 有序集合在加锁顺序的最后，批量写入没有涉及有序集合时在这里单独加锁
+func (b *WriteBatch) updateLeaderboards(types []DataType, leaderboards []*storage.Entry) {
+	db := b.db
+	zsetLocked := types[len(types)-1] == ZSet
+	for _, e := range leaderboards {
+		if !zsetLocked {
+			db.updateLeaderboards(e.Meta.Key, e.Meta.Extra, e.Meta.Value)
+		} else if views := db.leaderboards(e.Meta.Key, e.Meta.Extra); len(views) > 0 {
+			db.writeLeaderboards(views, e.Meta.Key, e.Meta.Value)
+		}
+	}
+}
+
+func involvesList(types []DataType) bool {
+	for _, dataType := range types {
+		if dataType == List {
+			return true
+		}
+	}
+	return false
+}
+
+// 哈希或集合添加新的域或元素后超出长度上限，批量写入中的删除不计入，调用方需持有哈希及集合索引的锁
+func (b *WriteBatch) collectionFull() bool {
+	db := b.db
+	added := make(map[string]map[string]bool)
+	for _, e := range b.ops {
+		var max, length int
+		var exist bool
+		key := string(e.Meta.Key)
+		switch {
+		case e.Type == Hash && e.Mark == HashHSet:
+			max, length = db.config.HashMaxLen, db.hashIndex.indexes.HLen(key)
+			exist = db.hashIndex.indexes.HExists(key, string(e.Meta.Extra))
+		case e.Type == Set && e.Mark == SetSAdd:
+			max, length = db.config.SetMaxLen, db.setIndex.indexes.SCard(key)
+			exist = db.setIndex.indexes.SIsMember(key, e.Meta.Value)
+		default:
+			continue
+		}
+		if max <= 0 || exist {
+			continue
+		}
+
+		member := string(e.Meta.Value)
+		if e.Type == Hash {
+			member = string(e.Meta.Extra)
+		}
+		typedKey := strconv.Itoa(int(e.Type)) + ":" + key
+		if added[typedKey] == nil {
+			added[typedKey] = make(map[string]bool)
+		}
+		added[typedKey][member] = true
+		if length+len(added[typedKey]) > max {
+			return true
+		}
+	}
+	return false
+}
+
+// entry 写入的位置，字符串的索引需要
+type entryPos struct {
+	fileId uint32
+	offset int64
+}
+
+// 按类型写入所有操作及 commit，返回每个操作写入的位置及按写入顺序的所有entry
+func (b *WriteBatch) write(id uint64, types []DataType) (positions []entryPos, written []*storage.Entry, err error) {
+	db := b.db
+	positions = make([]entryPos, len(b.ops))
+	written = make([]*storage.Entry, 0, len(b.ops)+2*len(types))
+	writeEntry := func(e *storage.Entry) error {
+		if err := db.writeEntry(e); err != nil {
+			return err
+		}
+		written = append(written, e)
+		return nil
+	}
+	first := make(map[DataType][]byte) // 每种类型的第一个操作的key，作为意向记录的key
+
+	for _, dataType := range types {
+		for i, e := range b.ops {
+			if e.Type != dataType {
+				continue
+			}
+			if _, ok := first[dataType]; !ok {
+				first[dataType] = e.Meta.Key
+				if dataType != List {
+					intent, _, _ := intentMarks(dataType)
+					if err := writeEntry(batchEntry(storage.NewEntryNoExtra(e.Meta.Key, nil, dataType, intent), id)); err != nil {
+						return nil, nil, err
+					}
+				}
+			}
+
+			if dataType == List {
+				e.Meta.Extra = []byte(strconv.FormatUint(id, 10))
+				e.Meta.ExtraSize = uint32(len(e.Meta.Extra))
+				e.Seq = db.listIndex.nextSeq(string(e.Meta.Key))
+			} else {
+				e.Seq = id
+			}
+			if err := writeEntry(e); err != nil {
+				return nil, nil, err
+			}
+			activeFile, activeFileId := db.getActiveFile(dataType)
+			positions[i] = entryPos{fileId: activeFileId, offset: activeFile.Offset - int64(e.Size())}
+		}
+		if err := db.syncActiveFile(dataType); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// 列表的 commit 不带序号，在 Extra 中记录批次id，最先写入并持久化，它存在时批次即视为已提交
+	if key, ok := first[List]; ok {
+		e := storage.NewEntry(key, nil, []byte(strconv.FormatUint(id, 10)), List, ListCommit)
+		if err := writeEntry(e); err != nil {
+			return nil, nil, err
+		}
+		if err := db.syncActiveFile(List); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, dataType := range types {
+		if dataType == List {
+			continue
+		}
+		_, commit, _ := intentMarks(dataType)
+		if err := writeEntry(batchEntry(storage.NewEntryNoExtra(first[dataType], nil, dataType, commit), id)); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, dataType := range types {
+		if dataType != List {
+			if err := db.syncActiveFile(dataType); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return positions, written, nil
+}
+
+// 带有批次id的意向记录
+func batchEntry(e *storage.Entry, id uint64) *storage.Entry {
+	e.Seq = id
+	return e
+}
+
+// 持久化某类型的活跃文件，不受 Sync 配置的影响
+func (db *MinDB) syncActiveFile(dataType DataType) error {
+	activeFile, _ := db.getActiveFile(dataType)
+	return activeFile.Sync()
+}
+
+// 按添加的顺序将所有操作应用到内存中的索引，之后处理长度上限，调用方需持有涉及类型的锁
+// 批次已经提交，某个操作失败时继续应用其余的操作，返回第一个错误
+func (b *WriteBatch) apply(positions []entryPos) (leaderboards []*storage.Entry, err error) {
+	db := b.db
+	pushed := make(map[string]bool) // 写入过的列表及最后一次写入是否在头部
+	zsets := make(map[string]bool)
+
+	for i, e := range b.ops {
+		key := string(e.Meta.Key)
+		switch e.Type {
+		case String:
+			if e.Mark == StringSet {
+				if indexErr := db.indexStrEntry(e, positions[i].fileId, positions[i].offset); indexErr != nil && err == nil {
+					err = indexErr
+				}
+			} else {
+				db.applyBatchStrRem(e)
+			}
+		case List:
+			if e.Mark == ListLPush {
+				db.listIndex.indexes.LPush(key, e.Meta.Value)
+			} else {
+				db.listIndex.indexes.RPush(key, e.Meta.Value)
+			}
+			pushed[key] = e.Mark == ListLPush
+		case Hash:
+			if e.Mark == HashHSet {
+				db.hashIndex.indexes.HSet(key, string(e.Meta.Extra), e.Meta.Value)
+				leaderboards = append(leaderboards, e)
+			} else if db.hashIndex.indexes.HDel(key, string(e.Meta.Extra)) {
+				leaderboards = append(leaderboards, storage.NewEntry(e.Meta.Key, nil, e.Meta.Extra, Hash, HashHDel))
+			}
+		case Set:
+			if e.Mark == SetSAdd {
+				db.setIndex.indexes.SAdd(key, e.Meta.Value)
+			} else {
+				db.setIndex.indexes.SRem(key, e.Meta.Value)
+			}
+		case ZSet:
+			if e.Mark == ZSetZAdd {
+				if score, parseErr := utils.StrToFloat64(string(e.Meta.Extra)); parseErr == nil {
+					db.zsetIndex.indexes.ZAdd(key, score, string(e.Meta.Value))
+				}
+				zsets[key] = true
+			} else {
+				db.zsetIndex.indexes.ZRem(key, string(e.Meta.Value))
+			}
+		}
+	}
+
+	for key, head := range pushed {
+		if _, capErr := db.capList([]byte(key), head); capErr != nil && err == nil {
+			err = capErr
+		}
+	}
+	for key := range zsets {
+		if capErr := db.capZset([]byte(key)); capErr != nil && err == nil {
+			err = capErr
+		}
+	}
+	return
+}
+
+// 应用批量写入中的字符串删除，开启了软删除时先将值移到回收站
+func (db *MinDB) applyBatchStrRem(e *storage.Entry) {
+	key := e.Meta.Key
+	if db.config.TrashTTL > 0 && !isTrashKey(key) {
+		if err := db.moveToTrash(key); err != nil && err != ErrKeyNotExist && err != ErrKeyExpired {
+			log.Printf("move key [%s] to trash err: %+v\n", key, err)
+		}
+	}
+	if ele := db.removeStrIndex(key); ele != nil {
+		delete(db.expires, string(key))
+		db.markStrRemoved(ele.Value().(*index.Indexer), e)
+	}
+}
@@ -0,0 +1,81 @@
+package mindb
+
+import "testing"
+
+// 批量写入在写入 commit 之前崩溃时，重新打开后批量写入中的操作都不生效
+func TestBatchCrashBeforeCommit(t *testing.T) {
+	cases := map[string]func() []truncation{
+		"after intent": func() []truncation {
+			return []truncation{
+				afterMark(String, StringIntent),
+				afterMark(Hash, HashIntent),
+				afterMark(Set, SetIntent),
+				afterMark(ZSet, ZSetIntent),
+				afterMark(List, ListRPush),
+			}
+		},
+		"before commit": func() []truncation {
+			return []truncation{
+				atMark(String, StringCommit),
+				atMark(Hash, HashCommit),
+				atMark(Set, SetCommit),
+				atMark(ZSet, ZSetCommit),
+				atMark(List, ListCommit),
+			}
+		},
+	}
+	for name, truncs := range cases {
+		t.Run(name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.DirPath = t.TempDir()
+			db, err := Open(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = db.Set([]byte("k"), []byte("old")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = db.HSet([]byte("h"), []byte("f"), []byte("old")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = db.RPush([]byte("l"), []byte("old")); err != nil {
+				t.Fatal(err)
+			}
+
+			b := db.NewWriteBatch()
+			mustAdd := func(err error) {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			mustAdd(b.Set([]byte("k"), []byte("new")))
+			mustAdd(b.HSet([]byte("h"), []byte("f"), []byte("new")))
+			mustAdd(b.SAdd([]byte("s"), []byte("m")))
+			mustAdd(b.ZAdd([]byte("z"), 1, []byte("m")))
+			mustAdd(b.RPush([]byte("l"), []byte("new1"), []byte("new2")))
+			if err = b.Commit(); err != nil {
+				t.Fatal(err)
+			}
+
+			db = crashTruncated(t, db, truncs()...)
+			if v, err := db.Get([]byte("k")); err != nil || string(v) != "old" {
+				t.Fatalf("Get = %s, %v, want old", v, err)
+			}
+			if v := db.HGet([]byte("h"), []byte("f")); string(v) != "old" {
+				t.Fatalf("HGet = %s, want old", v)
+			}
+			if db.SCard([]byte("s")) != 0 || db.ZCard([]byte("z")) != 0 {
+				t.Fatalf("SCard = %d, ZCard = %d, want 0", db.SCard([]byte("s")), db.ZCard([]byte("z")))
+			}
+			checkList(t, db, "l", "old")
+
+			// 丢弃的列表操作留在文件中，之后的写入及再次打开都不受影响
+			if _, err = db.RPush([]byte("l"), []byte("after")); err != nil {
+				t.Fatal(err)
+			}
+			db = reopen(t, db)
+			defer db.Close()
+			checkList(t, db, "l", "old", "after")
+		})
+	}
+}
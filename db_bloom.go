@@ -0,0 +1,98 @@
+package mindb
+
+import (
+	"errors"
+	"mindb/ds/bloom"
+)
+
+//---------基于字符串的布隆过滤器相关操作接口-----------
+
+var (
+	// ErrNotBloom key上已经存在其他的值
+	ErrNotBloom = errors.New("mindb: the value of key is not a bloom filter")
+
+	// ErrBloomExists key上已经存在布隆过滤器
+	ErrBloomExists = errors.New("mindb: the bloom filter already exists")
+
+	// ErrInvalidBloomParam 布隆过滤器的参数不合法
+	ErrInvalidBloomParam = errors.New("mindb: error rate must be in (0, 1) and capacity must be positive")
+)
+
+// 自动创建布隆过滤器时使用的默认参数
+const (
+	DefaultBloomErrorRate = 0.01
+	DefaultBloomCapacity  = 100
+)
+
+// BFReserve 在 key 上新建一个布隆过滤器，errorRate 为期望的误判率，capacity 为初始容量
+// 添加的元素超过容量后过滤器会自动扩容，整体的误判率仍不超过 errorRate
+// 过滤器整体编码后作为字符串的值保存，随字符串一起持久化和回收，大小不能超过 value 的最大值
+func (db *MinDB) BFReserve(key []byte, errorRate float64, capacity uint64) error {
+	if errorRate <= 0 || errorRate >= 1 || capacity == 0 {
+		return ErrInvalidBloomParam
+	}
+
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return err
+	}
+	if db.strIndex.idxList.Exist(key) && !db.expireIfNeeded(key) {
+		return ErrBloomExists
+	}
+	return db.setKeepTTL(key, bloom.New(errorRate, capacity).Encode())
+}
+
+// BFAdd 向布隆过滤器中添加元素，过滤器不存在时使用默认参数创建，元素可能已存在时返回 false
+func (db *MinDB) BFAdd(key, item []byte) (added bool, err error) {
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	b, err := db.getBloom(key)
+	if err == ErrKeyNotExist {
+		b, err = bloom.New(DefaultBloomErrorRate, DefaultBloomCapacity), nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if added = b.Add(item); added {
+		err = db.setKeepTTL(key, b.Encode())
+	}
+	return
+}
+
+// BFExists 判断元素是否在布隆过滤器中，过滤器不存在时返回 false
+func (db *MinDB) BFExists(key, item []byte) (bool, error) {
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	b, err := db.getBloom(key)
+	if err == ErrKeyNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return b.Exists(item), nil
+}
+
+// 读取并解码 key 上的布隆过滤器，调用方需持有字符串索引的写锁（过期的key会被删除）
+func (db *MinDB) getBloom(key []byte) (*bloom.Bloom, error) {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return nil, err
+	}
+	if !db.strIndex.idxList.Exist(key) || db.expireIfNeeded(key) {
+		return nil, ErrKeyNotExist
+	}
+
+	val, err := db.getVal(key)
+	if err != nil {
+		return nil, err
+	}
+	if !bloom.IsBloom(val) {
+		return nil, ErrNotBloom
+	}
+	return bloom.Decode(val)
+}
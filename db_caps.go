@@ -0,0 +1,62 @@
+package mindb
+
+import (
+	"errors"
+	"mindb/storage"
+)
+
+var (
+	// ErrCollectionFull 哈希或集合的元素数量已达到配置的上限，新的域或元素不能再添加
+	ErrCollectionFull = errors.New("mindb: the collection reached its max length")
+)
+
+// 集合类型的长度上限（ListMaxLen、HashMaxLen、SetMaxLen、ZSetMaxLen），为 0 时不限制
+// 列表及有序集合为固定大小的集合：添加后超出上限时，列表删除另一端最早添加的元素，有序集合删除分值最低的元素，删除同样写入数据文件；
+// 哈希及集合的元素没有先后，超出上限时拒绝添加新的域或元素，返回 ErrCollectionFull
+
+// 列表超出长度上限时，保留最新添加的元素，head 表示新元素添加在头部，调用方需持有列表索引的写锁
+func (db *MinDB) capList(key []byte, head bool) (int, error) {
+	length := db.listIndex.indexes.LLen(string(key))
+	max := db.config.ListMaxLen
+	if max <= 0 || length <= max {
+		return length, nil
+	}
+	start, end := 0, max-1
+	if !head {
+		start, end = length-max, length-1
+	}
+	return max, db.ltrim(key, start, end)
+}
+
+// 有序集合超出长度上限时删除分值最低的元素，调用方需持有有序集合索引的写锁
+func (db *MinDB) capZset(key []byte) error {
+	max := db.config.ZSetMaxLen
+	if max <= 0 {
+		return nil
+	}
+	for db.zsetIndex.indexes.ZCard(string(key)) > max {
+		val := db.zsetIndex.indexes.ZGetByRank(string(key), 0)
+		if len(val) == 0 {
+			return nil
+		}
+		member := val[0].(string)
+		db.zsetIndex.indexes.ZRem(string(key), member)
+		e := storage.NewEntryNoExtra(key, []byte(member), ZSet, ZSetZRem)
+		if err := db.store(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 哈希已达到长度上限且 field 为新的域，调用方需持有哈希索引的锁
+func (db *MinDB) hashFull(key, field []byte) bool {
+	max := db.config.HashMaxLen
+	return max > 0 && db.hashIndex.indexes.HLen(string(key)) >= max && !db.hashIndex.indexes.HExists(string(key), string(field))
+}
+
+// 集合已达到长度上限且 member 为新的元素，调用方需持有集合索引的锁
+func (db *MinDB) setFull(key, member []byte) bool {
+	max := db.config.SetMaxLen
+	return max > 0 && db.setIndex.indexes.SCard(string(key)) >= max && !db.setIndex.indexes.SIsMember(string(key), member)
+}
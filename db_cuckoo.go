@@ -0,0 +1,114 @@
+package mindb
+
+import (
+	"errors"
+	"mindb/ds/cuckoo"
+)
+
+//---------基于字符串的布谷鸟过滤器相关操作接口-----------
+
+var (
+	// ErrNotCuckoo key上已经存在其他的值
+	ErrNotCuckoo = errors.New("mindb: the value of key is not a cuckoo filter")
+
+	// ErrCuckooExists key上已经存在布谷鸟过滤器
+	ErrCuckooExists = errors.New("mindb: the cuckoo filter already exists")
+
+	// ErrInvalidCuckooParam 布谷鸟过滤器的参数不合法
+	ErrInvalidCuckooParam = errors.New("mindb: capacity must be positive")
+)
+
+// DefaultCuckooCapacity 自动创建布谷鸟过滤器时的默认容量
+const DefaultCuckooCapacity = 1024
+
+// CFReserve 在 key 上新建一个布谷鸟过滤器，capacity 为初始容量，添加的元素超过容量后过滤器会自动扩容
+// 与布隆过滤器相比支持删除元素，每个元素占用约 2 字节，误判率约为 0.01%
+// 过滤器整体编码后作为字符串的值保存，随字符串一起持久化和回收，大小不能超过 value 的最大值
+func (db *MinDB) CFReserve(key []byte, capacity uint64) error {
+	if capacity == 0 {
+		return ErrInvalidCuckooParam
+	}
+
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return err
+	}
+	if db.strIndex.idxList.Exist(key) && !db.expireIfNeeded(key) {
+		return ErrCuckooExists
+	}
+	return db.setKeepTTL(key, cuckoo.New(capacity).Encode())
+}
+
+// CFAdd 向布谷鸟过滤器中添加元素，过滤器不存在时使用默认容量创建
+// 同一个元素可以添加多次，需要删除相同的次数才不再存在
+func (db *MinDB) CFAdd(key, item []byte) error {
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	c, err := db.getCuckoo(key)
+	if err == ErrKeyNotExist {
+		c, err = cuckoo.New(DefaultCuckooCapacity), nil
+	}
+	if err != nil {
+		return err
+	}
+
+	c.Add(item)
+	return db.setKeepTTL(key, c.Encode())
+}
+
+// CFDel 从布谷鸟过滤器中删除一次添加的元素，元素不存在时返回 false
+// 只能删除确实添加过的元素，删除误判存在的元素会使其他元素被误删
+func (db *MinDB) CFDel(key, item []byte) (deleted bool, err error) {
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	c, err := db.getCuckoo(key)
+	if err == ErrKeyNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if deleted = c.Delete(item); deleted {
+		err = db.setKeepTTL(key, c.Encode())
+	}
+	return
+}
+
+// CFExists 判断元素是否在布谷鸟过滤器中，过滤器不存在时返回 false
+func (db *MinDB) CFExists(key, item []byte) (bool, error) {
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	c, err := db.getCuckoo(key)
+	if err == ErrKeyNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return c.Exists(item), nil
+}
+
+// 读取并解码 key 上的布谷鸟过滤器，调用方需持有字符串索引的写锁（过期的key会被删除）
+func (db *MinDB) getCuckoo(key []byte) (*cuckoo.Cuckoo, error) {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return nil, err
+	}
+	if !db.strIndex.idxList.Exist(key) || db.expireIfNeeded(key) {
+		return nil, ErrKeyNotExist
+	}
+
+	val, err := db.getVal(key)
+	if err != nil {
+		return nil, err
+	}
+	if !cuckoo.IsCuckoo(val) {
+		return nil, ErrNotCuckoo
+	}
+	return cuckoo.Decode(val)
+}
@@ -2,13 +2,19 @@ package mindb
 
 import (
 	"bytes"
+	"errors"
 	"mindb/ds/hash"
 	"mindb/storage"
+	"sort"
+	"strconv"
 	"sync"
 )
 
 //hash相关操作接口
 
+// ErrHashValueNotInteger 域的值不是整数，不能增加
+var ErrHashValueNotInteger = errors.New("mindb: hash value is not an integer")
+
 // HashIdx hash idx
 type HashIdx struct {
 	mu      sync.RWMutex
@@ -38,12 +44,17 @@ func (db *MinDB) HSet(key, field, value []byte) (res int, err error) {
 	db.hashIndex.mu.Lock()
 	defer db.hashIndex.mu.Unlock()
 
+	if db.hashFull(key, field) {
+		return 0, ErrCollectionFull
+	}
+
 	e := storage.NewEntry(key, value, field, Hash, HashHSet) // 构造一个entry写入到文件中
 	if err = db.store(e); err != nil {
 		return
 	}
 
 	res = db.hashIndex.indexes.HSet(string(key), string(field), value) // 写入到内存的哈希索引中
+	db.updateLeaderboards(key, field, value)
 	return
 }
 
@@ -59,16 +70,49 @@ func (db *MinDB) HSetNx(key, field, value []byte) (res bool, err error) {
 	db.hashIndex.mu.Lock()
 	defer db.hashIndex.mu.Unlock()
 
+	if db.hashFull(key, field) {
+		return false, ErrCollectionFull
+	}
 	if res = db.hashIndex.indexes.HSetNx(string(key), string(field), value); res {
 		e := storage.NewEntry(key, value, field, Hash, HashHSet)
 		if err = db.store(e); err != nil {
 			return
 		}
+		db.updateLeaderboards(key, field, value)
 	}
 
 	return
 }
 
+// HIncrBy 为哈希表 key 中的域 field 的值加上增量 increment，域不存在时先设置为 0，返回增加后的值
+func (db *MinDB) HIncrBy(key, field []byte, increment int64) (res int64, err error) {
+	if err = db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+
+	db.hashIndex.mu.Lock()
+	defer db.hashIndex.mu.Unlock()
+
+	if db.hashFull(key, field) {
+		return 0, ErrCollectionFull
+	}
+	if old := db.hashIndex.indexes.HGet(string(key), string(field)); old != nil {
+		if res, err = strconv.ParseInt(string(old), 10, 64); err != nil {
+			return 0, ErrHashValueNotInteger
+		}
+	}
+
+	res += increment
+	value := []byte(strconv.FormatInt(res, 10))
+	e := storage.NewEntry(key, value, field, Hash, HashHSet)
+	if err = db.store(e); err != nil {
+		return
+	}
+	db.hashIndex.indexes.HSet(string(key), string(field), value)
+	db.updateLeaderboards(key, field, value)
+	return
+}
+
 // HGet 返回哈希表中给定域的值
 func (db *MinDB) HGet(key, field []byte) []byte {
 
@@ -76,6 +120,8 @@ func (db *MinDB) HGet(key, field []byte) []byte {
 		return nil
 	}
 
+	db.notifyRead(Hash, key)
+
 	db.hashIndex.mu.RLock()
 	defer db.hashIndex.mu.RUnlock()
 
@@ -89,9 +135,14 @@ func (db *MinDB) HGetAll(key []byte) [][]byte {
 		return nil
 	}
 
+	db.notifyRead(Hash, key)
+
 	db.hashIndex.mu.RLock()
 	defer db.hashIndex.mu.RUnlock()
 
+	if db.config.SortedReads {
+		return sortFieldValues(db.hashIndex.indexes.HGetAll(string(key)))
+	}
 	return db.hashIndex.indexes.HGetAll(string(key))
 }
 
@@ -116,6 +167,7 @@ func (db *MinDB) HDel(key []byte, field ...[]byte) (res int, err error) {
 			if err = db.store(e); err != nil {
 				return
 			}
+			db.updateLeaderboards(key, f, nil)
 			res++
 		}
 	}
@@ -156,7 +208,11 @@ func (db *MinDB) HKeys(key []byte) (val []string) {
 	db.hashIndex.mu.RLock()
 	defer db.hashIndex.mu.RUnlock()
 
-	return db.hashIndex.indexes.HKeys(string(key))
+	val = db.hashIndex.indexes.HKeys(string(key))
+	if db.config.SortedReads {
+		sort.Strings(val)
+	}
+	return
 }
 
 // HValues 返回哈希表 key 中的所有域对应的值
@@ -168,5 +224,12 @@ func (db *MinDB) HValues(key []byte) (val [][]byte) {
 	db.hashIndex.mu.RLock()
 	defer db.hashIndex.mu.RUnlock()
 
+	if db.config.SortedReads { // 按对应的域排列
+		fvs := sortFieldValues(db.hashIndex.indexes.HGetAll(string(key)))
+		for i := 1; i < len(fvs); i += 2 {
+			val = append(val, fvs[i])
+		}
+		return
+	}
 	return db.hashIndex.indexes.HValues(string(key))
 }
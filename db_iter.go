@@ -0,0 +1,228 @@
+package mindb
+
+import (
+	"bytes"
+	"sort"
+	"time"
+)
+
+// 迭代器每次从索引中取出的key数的默认值
+const defaultIterBatchSize = 100
+
+// IteratorOptions NewIterator 的选项，Prefix、Start、End 可以同时使用，返回的key需同时满足
+type IteratorOptions struct {
+	Prefix    []byte     // 只返回有此前缀的key
+	Start     []byte     // 只返回不小于 Start 的key，为空时不限制
+	End       []byte     // 只返回小于 End 的key，为空时不限制
+	Reverse   bool       // 按key从大到小的顺序遍历
+	Types     []DataType // 依次遍历的类型，为空时只遍历字符串
+	BatchSize int        // 每次持有索引读锁时取出的key数，不大于0时为 100
+}
+
+// Iterator 按key的顺序遍历一种或多种类型的key，用法：
+//
+//	it := db.NewIterator(IteratorOptions{Prefix: []byte("user:")})
+//	for it.Next() {
+//		key := it.Key()
+//	}
+//
+// 迭代器不在两次调用之间持有锁，每次从索引中按批取出少量的key，不会把所有匹配的key放入内存；
+// 遍历期间一直存在的key一定会返回且只返回一次，遍历期间新增或删除的key可能返回也可能不返回
+// 字符串的索引是有序的，可以直接从上一批之后继续读取，其他类型的索引没有顺序，每一批都需要遍历该类型的所有key
+// 迭代器不能在多个goroutine中同时使用
+type Iterator struct {
+	db       *MinDB
+	opts     IteratorOptions
+	lower    []byte // 返回的key不小于 lower
+	upper    []byte // 返回的key小于 upper，为 nil 时不限制
+	typeIdx  int    // 正在遍历的类型在 opts.Types 中的位置
+	keys     [][]byte
+	pos      int
+	last     []byte // 已检查过的最后一个key，下一批从它之后继续
+	drained  bool   // 当前类型的key已经全部取出
+	key      []byte
+	dataType DataType
+}
+
+// NewIterator 返回按 opts 遍历key的迭代器
+func (db *MinDB) NewIterator(opts IteratorOptions) *Iterator {
+	if len(opts.Types) == 0 {
+		opts.Types = []DataType{String}
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultIterBatchSize
+	}
+
+	it := &Iterator{db: db, opts: opts, lower: opts.Start, upper: opts.End}
+	if bytes.Compare(opts.Prefix, it.lower) > 0 {
+		it.lower = opts.Prefix
+	}
+	if prefixEnd := prefixSuccessor(opts.Prefix); prefixEnd != nil && (it.upper == nil || bytes.Compare(prefixEnd, it.upper) < 0) {
+		it.upper = prefixEnd
+	}
+	return it
+}
+
+// 返回大于所有以 prefix 开头的key的最小的key，prefix 为空或全为 0xff 时返回 nil
+func prefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			end := make([]byte, i+1)
+			copy(end, prefix)
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
+
+// Next 移动到下一个key，没有更多的key时返回 false
+func (it *Iterator) Next() bool {
+	for it.typeIdx < len(it.opts.Types) {
+		if it.pos < len(it.keys) {
+			it.key = it.keys[it.pos]
+			it.dataType = it.opts.Types[it.typeIdx]
+			it.pos++
+			return true
+		}
+		if it.drained {
+			it.typeIdx++
+			it.keys, it.pos, it.last, it.drained = nil, 0, nil, false
+			continue
+		}
+
+		it.keys, it.pos = it.fetch(it.opts.Types[it.typeIdx], it.opts.BatchSize), 0
+	}
+	it.key = nil
+	return false
+}
+
+// Key 返回当前的key
+func (it *Iterator) Key() []byte {
+	return it.key
+}
+
+// Type 返回当前key的类型
+func (it *Iterator) Type() DataType {
+	return it.dataType
+}
+
+// Value 返回当前字符串key的值，其他类型返回 ErrInvalidDataType，key在取出后被删除或过期时返回 ErrKeyNotExist
+func (it *Iterator) Value() ([]byte, error) {
+	if it.dataType != String {
+		return nil, ErrInvalidDataType
+	}
+	return it.db.Get(it.key)
+}
+
+// 判断key是否在遍历的范围内
+func (it *Iterator) inRange(key []byte) bool {
+	if bytes.Compare(key, it.lower) < 0 || (it.upper != nil && bytes.Compare(key, it.upper) >= 0) {
+		return false
+	}
+	return bytes.HasPrefix(key, it.opts.Prefix)
+}
+
+// 取出当前类型中上一批之后的最多 n 个key，已过期的字符串key会被跳过，没有更多的key时设置 drained
+func (it *Iterator) fetch(dataType DataType, n int) (keys [][]byte) {
+	if dataType == String {
+		return it.fetchStr(n)
+	}
+
+	lock := it.db.idxLock(dataType)
+	if lock == nil {
+		return nil
+	}
+	lock.RLock()
+	var names []string
+	switch dataType {
+	case List:
+		names = it.db.listIndex.indexes.Keys()
+	case Hash:
+		names = it.db.hashIndex.indexes.Keys()
+	case Set:
+		names = it.db.setIndex.indexes.Keys()
+	case ZSet:
+		names = it.db.zsetIndex.indexes.Keys()
+	}
+	lock.RUnlock()
+
+	last := string(it.last)
+	rest := names[:0]
+	for _, name := range userKeys(names) {
+		if it.last != nil && (it.opts.Reverse && name >= last || !it.opts.Reverse && name <= last) {
+			continue
+		}
+		if it.inRange([]byte(name)) {
+			rest = append(rest, name)
+		}
+	}
+	if it.opts.Reverse {
+		sort.Sort(sort.Reverse(sort.StringSlice(rest)))
+	} else {
+		sort.Strings(rest)
+	}
+	if len(rest) > n {
+		rest = rest[:n]
+	} else {
+		it.drained = true
+	}
+	if len(rest) > 0 {
+		it.last = []byte(rest[len(rest)-1])
+	}
+	for _, name := range rest {
+		keys = append(keys, []byte(name))
+	}
+	return
+}
+
+// 从字符串的有序索引中取出上一批之后的最多 n 个key，过期的key及内部key也计入 n，因此可能返回少于 n 个key
+func (it *Iterator) fetchStr(n int) (keys [][]byte) {
+	db := it.db
+	db.strIndex.mu.RLock()
+	defer db.strIndex.mu.RUnlock()
+
+	now := time.Now().Unix()
+	add := func(key []byte) {
+		if isInternalKey(key) {
+			return
+		}
+		if deadline, exist := db.expires[string(key)]; !exist || now <= int64(deadline) {
+			keys = append(keys, key)
+		}
+	}
+
+	if it.opts.Reverse {
+		// 逆序时每次查找比上一个key小的最后一个key
+		before := it.upper
+		if it.last != nil {
+			before = it.last
+		}
+		for i := 0; i < n; i++ {
+			e := db.strIndex.idxList.SeekBefore(before)
+			if e == nil || bytes.Compare(e.Key(), it.lower) < 0 {
+				it.drained = true
+				break
+			}
+			add(e.Key())
+			before, it.last = e.Key(), e.Key()
+		}
+		return
+	}
+
+	e := db.strIndex.idxList.Seek(it.lower)
+	if it.last != nil {
+		if e = db.strIndex.idxList.Seek(it.last); e != nil && bytes.Equal(e.Key(), it.last) {
+			e = e.Next()
+		}
+	}
+	for i := 0; i < n; i, e = i+1, e.Next() {
+		if e == nil || !it.inRange(e.Key()) {
+			it.drained = true
+			break
+		}
+		add(e.Key())
+		it.last = e.Key()
+	}
+	return
+}
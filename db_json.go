@@ -0,0 +1,114 @@
+package mindb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mindb/ds/jsonpath"
+)
+
+//---------基于字符串的 JSON 文档相关操作接口-----------
+
+var (
+	// ErrNotJSON key上的值不是合法的 JSON
+	ErrNotJSON = errors.New("mindb: the value of key is not a valid json")
+
+	// ErrInvalidJSON 写入的值不是合法的 JSON
+	ErrInvalidJSON = errors.New("mindb: the value is not a valid json")
+
+	// ErrJSONNewDocNotRoot 新建文档时路径必须是根路径
+	ErrJSONNewDocNotRoot = errors.New("mindb: new json document must be set at the root path")
+)
+
+// JSONGet 获取 key 上 JSON 文档中 path 指向的值，返回该值的 JSON 编码
+// path 的格式为 $.a.b[0]，$ 或空字符串表示整个文档，路径不存在时返回 jsonpath.ErrPathNotFound
+func (db *MinDB) JSONGet(key []byte, path string) ([]byte, error) {
+	p, err := jsonpath.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	doc, err := db.getJSON(key)
+	if err != nil {
+		return nil, err
+	}
+	v, err := p.Get(doc)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// JSONSet 将 key 上 JSON 文档中 path 指向的位置设置为 value，value 必须是合法的 JSON
+// 只会在服务端修改文档的一部分，客户端不需要读取和写回整个文档，key 不存在时只能在根路径上新建文档
+func (db *MinDB) JSONSet(key []byte, path string, value []byte) error {
+	p, err := jsonpath.Parse(path)
+	if err != nil {
+		return err
+	}
+	v, err := decodeJSON(value)
+	if err != nil {
+		return ErrInvalidJSON
+	}
+
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	doc, err := db.getJSON(key)
+	if err == ErrKeyNotExist {
+		if len(p) != 0 {
+			return ErrJSONNewDocNotRoot
+		}
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if doc, err = p.Set(doc, v); err != nil {
+		return err
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return db.setKeepTTL(key, data)
+}
+
+// 读取并解码 key 上的 JSON 文档，调用方需持有字符串索引的写锁（过期的key会被删除）
+func (db *MinDB) getJSON(key []byte) (interface{}, error) {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return nil, err
+	}
+	if !db.strIndex.idxList.Exist(key) || db.expireIfNeeded(key) {
+		return nil, ErrKeyNotExist
+	}
+
+	val, err := db.getVal(key)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := decodeJSON(val)
+	if err != nil {
+		return nil, ErrNotJSON
+	}
+	return doc, nil
+}
+
+// 解码 JSON，数字保留为 json.Number，避免大整数丢失精度
+func decodeJSON(data []byte) (interface{}, error) {
+	if !json.Valid(data) {
+		return nil, ErrInvalidJSON
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
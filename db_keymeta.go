@@ -0,0 +1,63 @@
+package mindb
+
+import (
+	"mindb/index"
+	"time"
+)
+
+// KeyMeta 一个key的元信息，不包含key的值，供备份校验、分片均衡等外部工具使用
+type KeyMeta struct {
+	Key          []byte
+	Type         DataType
+	Count        int    // 元素个数，字符串为 1
+	Size         int64  // key与所有元素占用的字节数，同 KeyUsage
+	TTL          int64  // 剩余的过期时间（秒），-1 表示不过期，只有字符串可以设置过期时间
+	LastModified uint64 // 最后一次修改的写入序号，为 0 表示无法得到
+	Locations    []EntryLocation
+}
+
+// EntryLocation 一条entry在数据文件中的位置
+type EntryLocation struct {
+	FileId uint32
+	Offset int64
+	Size   uint32
+}
+
+// KeyMeta 获取key的元信息，key不存在时返回 ErrKeyNotExist，同一个key存在于多个类型中时按照 DataTypes 的顺序返回第一个类型
+//
+// 写入序号不为每个key保存，LastModified 只有在key被监视（见 Watch）或最后一次修改还在保留的变更中（见 ChangeBacklog）时才能得到，
+// 查找保留的变更需要遍历整个缓冲区。Locations 为字符串的值及之后的增量修改所在的位置，
+// 其他类型的元素分散在各自的写入中，索引中没有记录它们的位置，Locations 为空
+func (db *MinDB) KeyMeta(key []byte) (*KeyMeta, error) {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return nil, err
+	}
+
+	db.rLockAllIdx()
+	now := time.Now().Unix()
+	dataType := db.typeOf(key, now)
+	if dataType == None {
+		db.rUnlockAllIdx()
+		return nil, ErrKeyNotExist
+	}
+
+	meta := &KeyMeta{Key: key, Type: dataType, TTL: -1}
+	meta.Count, meta.Size = db.keyUsage(dataType, key)
+	if dataType == String {
+		if deadline, exist := db.expires[string(key)]; exist {
+			meta.TTL = int64(deadline) - now
+		}
+		idx := db.strIndex.idxList.Get(key).Value().(*index.Indexer)
+		meta.Locations = append(meta.Locations, EntryLocation{FileId: idx.FileId, Offset: idx.Offset, Size: idx.EntrySize})
+		for _, patch := range db.strIndex.patches[string(key)] {
+			meta.Locations = append(meta.Locations, EntryLocation{FileId: patch.FileId, Offset: patch.Offset, Size: patch.EntrySize})
+		}
+	}
+	db.rUnlockAllIdx()
+
+	meta.LastModified = db.KeyVersion(key)
+	if seq := db.changes.lastSeqOf(key); seq > meta.LastModified {
+		meta.LastModified = seq
+	}
+	return meta, nil
+}
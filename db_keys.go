@@ -0,0 +1,406 @@
+package mindb
+
+import (
+	"bytes"
+	"math/rand"
+	"mindb/index"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//跨数据类型的key相关操作接口
+
+// DataTypes 所有的数据类型，遍历整个键空间时按照此顺序进行
+var DataTypes = []DataType{String, List, Hash, Set, ZSet}
+
+// None key在所有类型中都不存在时 BatchType 返回的类型
+const None DataType = 1<<16 - 1
+
+// InternalKeyPrefix 内部key的前缀，回收站、队列、物化视图的定义等以内部key保存在各类型的索引中
+// 遍历、扫描、抽样及统计key时跳过内部key，过期时也不回调；服务端拒绝客户端使用以此开头的key
+const InternalKeyPrefix = "\x00"
+
+func isInternalKey(key []byte) bool {
+	return bytes.HasPrefix(key, []byte(InternalKeyPrefix))
+}
+
+func isInternalName(key string) bool {
+	return strings.HasPrefix(key, InternalKeyPrefix)
+}
+
+// 去掉其中的内部key，原地修改
+func userKeys(names []string) []string {
+	res := names[:0]
+	for _, name := range names {
+		if !isInternalName(name) {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+// IterateAll 按照 String、List、Hash、Set、ZSet 的顺序遍历所有类型的key，同一类型内的key按字典序排列
+// fn 返回 false 时停止遍历
+// 遍历的是每种类型在开始遍历时的key快照，因此在 fn 中可以安全地调用 db 的其他方法
+func (db *MinDB) IterateAll(fn func(dataType DataType, key []byte) bool) {
+	for _, dataType := range DataTypes {
+		for _, key := range db.keysOf(dataType) {
+			if !fn(dataType, key) {
+				return
+			}
+		}
+	}
+}
+
+// 获取某一类型当前所有key的有序快照，已过期的字符串key及内部key会被跳过
+func (db *MinDB) keysOf(dataType DataType) (keys [][]byte) {
+	var names []string
+	switch dataType {
+	case String:
+		db.strIndex.mu.RLock()
+		defer db.strIndex.mu.RUnlock()
+
+		now := time.Now().Unix()
+		db.strIndex.idxList.Foreach(func(e *index.Element) bool {
+			if isInternalKey(e.Key()) {
+				return true
+			}
+			if deadline, exist := db.expires[string(e.Key())]; !exist || now <= int64(deadline) {
+				keys = append(keys, e.Key())
+			}
+			return true
+		})
+		return // 跳表中的key本身就是有序的
+	case List:
+		db.listIndex.mu.RLock()
+		names = db.listIndex.indexes.Keys()
+		db.listIndex.mu.RUnlock()
+	case Hash:
+		db.hashIndex.mu.RLock()
+		names = db.hashIndex.indexes.Keys()
+		db.hashIndex.mu.RUnlock()
+	case Set:
+		db.setIndex.mu.RLock()
+		names = db.setIndex.indexes.Keys()
+		db.setIndex.mu.RUnlock()
+	case ZSet:
+		db.zsetIndex.mu.RLock()
+		names = db.zsetIndex.indexes.Keys()
+		db.zsetIndex.mu.RUnlock()
+	}
+
+	names = userKeys(names)
+	sort.Strings(names)
+	for _, name := range names {
+		keys = append(keys, []byte(name))
+	}
+	return
+}
+
+// BatchExists 判断多个key是否存在（任意类型），只加一次锁，适合一次预取大量key的场景
+func (db *MinDB) BatchExists(keys ...[]byte) []bool {
+	exists := make([]bool, len(keys))
+	for i, t := range db.BatchType(keys...) {
+		exists[i] = t != None
+	}
+	return exists
+}
+
+// BatchType 获取多个key的类型，只加一次锁，不存在的key返回 None
+// 不同类型的key互不影响，同一个key存在于多个类型中时，按照 DataTypes 的顺序返回第一个类型
+func (db *MinDB) BatchType(keys ...[]byte) []DataType {
+	db.rLockAllIdx()
+	defer db.rUnlockAllIdx()
+
+	now := time.Now().Unix()
+	types := make([]DataType, len(keys))
+	for i, key := range keys {
+		types[i] = db.typeOf(key, now)
+	}
+	return types
+}
+
+// 获取key的类型，调用方需持有所有类型索引的读锁，已过期的字符串视为不存在
+func (db *MinDB) typeOf(key []byte, now int64) DataType {
+	if db.strIndex.idxList.Exist(key) {
+		if deadline, exist := db.expires[string(key)]; !exist || now <= int64(deadline) {
+			return String
+		}
+	}
+
+	k := string(key)
+	switch {
+	case db.listIndex.indexes.LLen(k) > 0:
+		return List
+	case db.hashIndex.indexes.HLen(k) > 0:
+		return Hash
+	case db.setIndex.indexes.SCard(k) > 0:
+		return Set
+	case db.zsetIndex.indexes.ZCard(k) > 0:
+		return ZSet
+	}
+	return None
+}
+
+// SampledKey 随机选取的key及其类型、大小
+type SampledKey struct {
+	Key  []byte
+	Type DataType
+	Size int64 // 字符串为 value 的字节数，其他类型为元素个数
+}
+
+// SampleKeys 随机选取最多 n 个key，types 为空时从所有类型中选取，否则只从指定的类型中选取
+// 各类型被选中的key数与其key的数量成正比，不需要遍历所有的key，结果是近似均匀的，可用于分析大key、估算容量等
+func (db *MinDB) SampleKeys(n int, types ...DataType) []SampledKey {
+	if n <= 0 {
+		return nil
+	}
+	if len(types) == 0 {
+		types = DataTypes
+	}
+
+	db.rLockAllIdx()
+	defer db.rUnlockAllIdx()
+
+	// 按各类型key的数量分配每个类型选取的个数
+	weights := make([]int, len(types))
+	total := 0
+	for i, dataType := range types {
+		switch dataType {
+		case String:
+			weights[i] = db.strIndex.idxList.Len
+		case List:
+			weights[i] = db.listIndex.indexes.Len()
+		case Hash:
+			weights[i] = db.hashIndex.indexes.Len()
+		case Set:
+			weights[i] = db.setIndex.indexes.Len()
+		case ZSet:
+			weights[i] = db.zsetIndex.indexes.Len()
+		}
+		total += weights[i]
+	}
+	if total == 0 {
+		return nil
+	}
+	counts := make([]int, len(types))
+	for i := 0; i < n; i++ {
+		r := rand.Intn(total)
+		for j, w := range weights {
+			if r < w {
+				counts[j]++
+				break
+			}
+			r -= w
+		}
+	}
+
+	var res []SampledKey
+	now := time.Now().Unix()
+	for i, dataType := range types {
+		if counts[i] == 0 {
+			continue
+		}
+		if dataType == String {
+			for _, e := range db.strIndex.idxList.Sample(counts[i]) {
+				if isInternalKey(e.Key()) {
+					continue
+				}
+				if deadline, exist := db.expires[string(e.Key())]; exist && now > int64(deadline) {
+					continue
+				}
+				size := int64(e.Value().(*index.Indexer).Meta.ValueSize)
+				res = append(res, SampledKey{Key: e.Key(), Type: String, Size: size})
+			}
+			continue
+		}
+
+		var keys []string
+		var sizeOf func(key string) int
+		switch dataType {
+		case List:
+			keys, sizeOf = db.listIndex.indexes.SampleKeys(counts[i]), db.listIndex.indexes.LLen
+		case Hash:
+			keys, sizeOf = db.hashIndex.indexes.SampleKeys(counts[i]), db.hashIndex.indexes.HLen
+		case Set:
+			keys, sizeOf = db.setIndex.indexes.SampleKeys(counts[i]), db.setIndex.indexes.SCard
+		case ZSet:
+			keys, sizeOf = db.zsetIndex.indexes.SampleKeys(counts[i]), db.zsetIndex.indexes.ZCard
+		}
+		for _, key := range userKeys(keys) {
+			res = append(res, SampledKey{Key: []byte(key), Type: dataType, Size: int64(sizeOf(key))})
+		}
+	}
+
+	rand.Shuffle(len(res), func(i, j int) {
+		res[i], res[j] = res[j], res[i]
+	})
+	return res
+}
+
+// ExpiredFunc key过期被删除时的回调，key 是调用方独占的副本
+type ExpiredFunc func(key []byte, dataType DataType)
+
+// OnExpired 注册key过期时的回调，可以注册多个，按注册的顺序依次调用
+// key 在读取时发现已过期（包括打开数据库时已经过期的key），或被 RunActiveExpire 抽样检查到已过期时删除并回调，
+// 过期的key在持有索引写锁时被删除，每个key每次过期只会回调一次，即使多个读取同时发现它已过期。
+// 回调在数据库的一个专门的goroutine中按过期的顺序依次执行，不持有数据库的锁，因此可以在回调中调用 db 的其他方法（如级联删除其他key），
+// 但与发生过期的那次操作之间不保证先后顺序；回调执行缓慢时后面的通知排队等待。
+// Close 等待已经排队的回调执行完成，数据库关闭之后过期的key不再回调
+func (db *MinDB) OnExpired(fn ExpiredFunc) {
+	if fn == nil {
+		return
+	}
+	db.hookMu.Lock()
+	defer db.hookMu.Unlock()
+	db.expiredHooks = append(db.expiredHooks, fn)
+}
+
+// 通知key已过期，调用方持有索引的写锁，因此只将通知放入队列，由 expiryNotifier 执行回调
+// 内部key（如回收站中的key）过期时不回调
+func (db *MinDB) notifyExpired(key []byte, dataType DataType) {
+	if isInternalKey(key) {
+		return
+	}
+	db.hookMu.RLock()
+	hooks, listeners := db.expiredHooks, db.hooks
+	db.hookMu.RUnlock()
+	if len(hooks) == 0 && len(listeners) == 0 {
+		return
+	}
+
+	k := make([]byte, len(key))
+	copy(k, key)
+	db.expiry.push(expiredKey{key: k, dataType: dataType})
+}
+
+// 执行回调
+func (db *MinDB) fireExpired(ev expiredKey) {
+	db.hookMu.RLock()
+	hooks, listeners := db.expiredHooks, db.hooks
+	db.hookMu.RUnlock()
+	for _, fn := range hooks {
+		fn(ev.key, ev.dataType)
+	}
+	for _, h := range listeners {
+		h.OnExpire(ev.dataType, ev.key)
+	}
+}
+
+type expiredKey struct {
+	key      []byte
+	dataType DataType
+}
+
+// 在一个goroutine中依次执行过期回调，通知者持有索引锁而回调可能需要同一把锁，因此队列不限长度，入队不会阻塞
+type expiryNotifier struct {
+	mu      sync.Mutex
+	pending []expiredKey
+	closed  bool
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+func newExpiryNotifier(fire func(expiredKey)) *expiryNotifier {
+	n := &expiryNotifier{wake: make(chan struct{}, 1), done: make(chan struct{})}
+	go n.run(fire)
+	return n
+}
+
+func (n *expiryNotifier) push(ev expiredKey) {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return
+	}
+	n.pending = append(n.pending, ev)
+	n.mu.Unlock()
+	n.signal()
+}
+
+func (n *expiryNotifier) signal() {
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (n *expiryNotifier) run(fire func(expiredKey)) {
+	defer close(n.done)
+	for {
+		n.mu.Lock()
+		pending, closed := n.pending, n.closed
+		n.pending = nil
+		n.mu.Unlock()
+
+		for _, ev := range pending {
+			fire(ev)
+		}
+		if len(pending) == 0 {
+			if closed {
+				return
+			}
+			<-n.wake
+		}
+	}
+}
+
+// 不再接受新的通知，等待已经排队的回调执行完成
+func (n *expiryNotifier) close() {
+	n.mu.Lock()
+	n.closed = true
+	n.mu.Unlock()
+	n.signal()
+	<-n.done
+}
+
+// 每次抽样检查的key数量，过期的比例超过 activeExpireRepeat 时立即继续抽样
+const (
+	activeexpireSamples = 20
+	activeExpireRepeat  = 0.25
+)
+
+// 从设置了过期时间的字符串key中抽样检查最多 n 个，删除其中已过期的key并触发 OnExpired 的回调，返回检查及删除的数量
+func (db *MinDB) expireSample(n int) (sampled, expired int) {
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	keys := make([][]byte, 0, n)
+	for key := range db.expires { // map 的遍历从随机的位置开始
+		if len(keys) >= n {
+			break
+		}
+		keys = append(keys, []byte(key))
+	}
+	for _, key := range keys {
+		if db.expireIfNeeded(key) {
+			expired++
+		}
+	}
+	return len(keys), expired
+}
+
+// RunActiveExpire 每隔 interval 抽样删除已过期的字符串key，直到 stop 被关闭或数据库被关闭，
+// 之后不再被读取的key也会及时释放空间并触发 OnExpired 的回调；Update 执行期间不删除
+func (db *MinDB) RunActiveExpire(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for db.isOpen() {
+		for {
+			db.txMu.RLock() // 与 Update 互斥，事务执行期间不写入
+			sampled, expired := db.expireSample(activeexpireSamples)
+			db.txMu.RUnlock()
+			if sampled == 0 || float64(expired) <= float64(sampled)*activeExpireRepeat || !db.isOpen() {
+				break
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
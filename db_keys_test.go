@@ -0,0 +1,154 @@
+package mindb
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func openTestDB(t *testing.T, mode DataIndexMode) *MinDB {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.DirPath = t.TempDir()
+	cfg.IdxMode = mode
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestOnExpiredFiresOnce(t *testing.T) {
+	db := openTestDB(t, KeyValueRamMode)
+
+	var mu sync.Mutex
+	fired := make(map[string]int)
+	var total int32
+	db.OnExpired(func(key []byte, dataType DataType) {
+		mu.Lock()
+		fired[string(key)]++
+		mu.Unlock()
+		atomic.AddInt32(&total, 1)
+	})
+
+	const n = 50
+	deadline := uint32(time.Now().Unix() + 1)
+	for i := 0; i < n; i++ {
+		key := []byte("k" + strconv.Itoa(i))
+		if err := db.Set(key, []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.ExpireAt(key, deadline); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Until(time.Unix(int64(deadline)+1, 0)) + 100*time.Millisecond)
+
+	// 多个goroutine同时读取已过期的key
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				key := []byte("k" + strconv.Itoa(i))
+				if _, err := db.Get(key); err != ErrKeyExpired && err != ErrKeyNotExist {
+					t.Errorf("get %s: %v", key, err)
+				}
+				db.StrExists(key)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for start := time.Now(); atomic.LoadInt32(&total) < n && time.Since(start) < 5*time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond) // 多余的回调也有机会执行
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(fired) != n {
+		t.Fatalf("callback fired for %d keys, want %d", len(fired), n)
+	}
+	for key, count := range fired {
+		if count != 1 {
+			t.Errorf("callback fired %d times for %s, want 1", count, key)
+		}
+	}
+}
+
+// 设置了过期时间之后不再被读取的key由 RunActiveExpire 删除并回调
+func TestActiveExpireNotifies(t *testing.T) {
+	db := openTestDB(t, KeyValueRamMode)
+	fired := make(chan string, 1)
+	db.OnExpired(func(key []byte, dataType DataType) { fired <- string(key) })
+
+	if err := db.Set([]byte("k"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ExpireAt([]byte("k"), uint32(time.Now().Unix()+1)); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go db.RunActiveExpire(10*time.Millisecond, stop)
+
+	select {
+	case key := <-fired:
+		if key != "k" {
+			t.Fatalf("expired key = %s, want k", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback not fired for an expired key that was never read")
+	}
+	if db.StrExists([]byte("k")) {
+		t.Fatal("expired key still exists")
+	}
+}
+
+// 重新打开时已经过期的key同样回调，Close 等待已经排队的回调执行完成
+func TestExpiredAtLoadNotifies(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DirPath = t.TempDir()
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 10
+	deadline := uint32(time.Now().Unix() + 1)
+	for i := 0; i < n; i++ {
+		key := []byte("k" + strconv.Itoa(i))
+		if err = db.Set(key, []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		if err = db.ExpireAt(key, deadline); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Until(time.Unix(int64(deadline)+1, 0)))
+
+	if db, err = Open(cfg); err != nil {
+		t.Fatal(err)
+	}
+	var total int32
+	db.OnExpired(func(key []byte, dataType DataType) {
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&total, 1)
+	})
+	if sampled, expired := db.expireSample(2 * n); sampled != n || expired != n {
+		t.Fatalf("expireSample = %d, %d, want %d, %d", sampled, expired, n, n)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(&total); got != n {
+		t.Fatalf("callbacks finished before Close returned = %d, want %d", got, n)
+	}
+}
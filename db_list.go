@@ -15,10 +15,24 @@ import (
 type ListIdx struct {
 	mu      sync.RWMutex
 	indexes *list.List
+	seqs    map[string]uint64 // 每个列表已分配的最大操作序号
 }
 
 func newListIdx() *ListIdx {
-	return &ListIdx{indexes: list.New()}
+	return &ListIdx{indexes: list.New(), seqs: make(map[string]uint64)}
+}
+
+// 分配列表下一个操作的序号，调用方需持有写锁
+func (idx *ListIdx) nextSeq(key string) uint64 {
+	idx.seqs[key]++
+	return idx.seqs[key]
+}
+
+// 加载索引时记录列表已有的最大操作序号
+func (idx *ListIdx) observeSeq(key string, seq uint64) {
+	if seq > idx.seqs[key] {
+		idx.seqs[key] = seq
+	}
 }
 
 // LPush 在列表的头部添加元素，返回添加后的列表长度
@@ -32,6 +46,7 @@ func (db *MinDB) LPush(key []byte, values ...[]byte) (res int, err error) {
 
 	for _, val := range values {
 		e := storage.NewEntryNoExtra(key, val, List, ListLPush) // 构建相应操作的entry
+		e.Seq = db.listIndex.nextSeq(string(key))               // 记录列表的操作序号
 
 		if err = db.store(e); err != nil { // 将entry写入到active file中
 			return
@@ -39,7 +54,7 @@ func (db *MinDB) LPush(key []byte, values ...[]byte) (res int, err error) {
 		res = db.listIndex.indexes.LPush(string(key), val)
 	}
 
-	return
+	return db.capList(key, true)
 }
 
 // RPush 在列表的尾部添加元素，返回添加后的列表长度
@@ -53,13 +68,14 @@ func (db *MinDB) RPush(key []byte, values ...[]byte) (res int, err error) {
 
 	for _, val := range values {
 		e := storage.NewEntryNoExtra(key, val, List, ListRPush)
+		e.Seq = db.listIndex.nextSeq(string(key))
 		if err = db.store(e); err != nil {
 			return
 		}
 		res = db.listIndex.indexes.RPush(string(key), val)
 	}
 
-	return
+	return db.capList(key, false)
 }
 
 // LPop 取出列表头部的元素
@@ -76,6 +92,7 @@ func (db *MinDB) LPop(key []byte) ([]byte, error) {
 
 	if val != nil {
 		e := storage.NewEntryNoExtra(key, val, List, ListLPop)
+		e.Seq = db.listIndex.nextSeq(string(key))
 		if err := db.store(e); err != nil {
 			return nil, err
 		}
@@ -98,6 +115,7 @@ func (db *MinDB) RPop(key []byte) ([]byte, error) {
 
 	if val != nil {
 		e := storage.NewEntryNoExtra(key, val, List, ListRPop)
+		e.Seq = db.listIndex.nextSeq(string(key))
 		if err := db.store(e); err != nil {
 			return nil, err
 		}
@@ -113,6 +131,8 @@ func (db *MinDB) LIndex(key []byte, idx int) []byte {
 		return nil
 	}
 
+	db.notifyRead(List, key)
+
 	db.listIndex.mu.RLock()
 	defer db.listIndex.mu.RUnlock()
 
@@ -138,6 +158,7 @@ func (db *MinDB) LRem(key, value []byte, count int) (int, error) {
 	if res > 0 {
 		c := strconv.Itoa(count)
 		e := storage.NewEntry(key, value, []byte(c), List, ListLRem)
+		e.Seq = db.listIndex.nextSeq(string(key))
 		if err := db.store(e); err != nil {
 			return res, err
 		}
@@ -170,6 +191,7 @@ func (db *MinDB) LInsert(key string, option list.InsertOption, pivot, val []byte
 		buf.Write([]byte(opt))
 
 		e := storage.NewEntry([]byte(key), val, buf.Bytes(), List, ListLInsert)
+		e.Seq = db.listIndex.nextSeq(string(key))
 		if err = db.store(e); err != nil {
 			return
 		}
@@ -191,6 +213,7 @@ func (db *MinDB) LSet(key []byte, idx int, val []byte) (bool, error) {
 
 	i := strconv.Itoa(idx)
 	e := storage.NewEntry(key, val, []byte(i), List, ListLSet)
+	e.Seq = db.listIndex.nextSeq(string(key))
 	if err := db.store(e); err != nil {
 		return false, err
 	}
@@ -209,6 +232,11 @@ func (db *MinDB) LTrim(key []byte, start, end int) error {
 	db.listIndex.mu.Lock()
 	defer db.listIndex.mu.Unlock()
 
+	return db.ltrim(key, start, end)
+}
+
+// 修剪列表并写入修剪记录，调用方需持有列表索引的写锁
+func (db *MinDB) ltrim(key []byte, start, end int) error {
 	if res := db.listIndex.indexes.LTrim(string(key), start, end); res {
 		var buf bytes.Buffer
 		buf.Write([]byte(strconv.Itoa(start)))
@@ -216,6 +244,7 @@ func (db *MinDB) LTrim(key []byte, start, end int) error {
 		buf.Write([]byte(strconv.Itoa(end)))
 
 		e := storage.NewEntry(key, nil, buf.Bytes(), List, ListLTrim)
+		e.Seq = db.listIndex.nextSeq(string(key))
 		if err := db.store(e); err != nil {
 			return err
 		}
@@ -233,6 +262,8 @@ func (db *MinDB) LRange(key []byte, start, end int) ([][]byte, error) {
 		return nil, err
 	}
 
+	db.notifyRead(List, key)
+
 	db.listIndex.mu.RLock()
 	defer db.listIndex.mu.RUnlock()
 
@@ -0,0 +1,170 @@
+package mindb
+
+import (
+	"fmt"
+	"testing"
+
+	"mindb/storage"
+)
+
+func reopen(t *testing.T, db *MinDB) *MinDB {
+	t.Helper()
+	cfg := db.config
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func checkList(t *testing.T, db *MinDB, key string, want ...string) {
+	t.Helper()
+	vals, err := db.LRange([]byte(key), 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprintf("%q", vals); got != fmt.Sprintf("%q", want) {
+		t.Fatalf("LRange(%s) = %s, want %q", key, got, want)
+	}
+}
+
+// 文件中序号倒退的列表在加载和回收时按序号重放，其他列表按文件中的顺序重放
+func TestListReplayBySeq(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DirPath = t.TempDir()
+	cfg.ReclaimThreshold = 1
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ops := []struct {
+		key, val string
+		seq      uint64
+	}{
+		{"l", "b", 2},
+		{"m", "x", 1},
+		{"l", "a", 1},
+		{"m", "y", 2},
+	}
+	for _, op := range ops {
+		e := storage.NewEntryNoExtra([]byte(op.key), []byte(op.val), List, ListRPush)
+		e.Seq = op.seq
+		if err = db.store(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db = reopen(t, db)
+	checkList(t, db, "l", "a", "b")
+	checkList(t, db, "m", "x", "y")
+
+	if err = db.RotateActiveFile(List); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.RPush([]byte("l"), []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	db = reopen(t, db)
+	defer db.Close()
+	checkList(t, db, "l", "a", "b", "c")
+	checkList(t, db, "m", "x", "y")
+}
+
+// 回收已封存的列表文件，并确认回收确实丢弃了列表中失效的操作、减小了文件
+func reclaimLists(t *testing.T, db *MinDB) {
+	t.Helper()
+	if err := db.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+	runs := db.ReclaimHistory()
+	if len(runs) > 0 {
+		for _, stats := range runs[len(runs)-1].Types {
+			if stats.Type == List && stats.EntriesDropped > 0 && stats.BytesAfter < stats.BytesBefore {
+				return
+			}
+		}
+	}
+	t.Fatalf("list files were not reclaimed: %+v", runs)
+}
+
+// 列表的 push、pop 分布在多个文件中，回收之后继续操作，重新打开后的列表与关闭前一致
+func TestListPopReplayAcrossReclaim(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DirPath = t.TempDir()
+	cfg.ReclaimThreshold = 1
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	step := func(round int) {
+		for i, key := range keys {
+			for j := 0; j < 4; j++ {
+				val := []byte(fmt.Sprintf("%d-%d", round, j))
+				if j%2 == 0 {
+					_, err = db.LPush(key, val)
+				} else {
+					_, err = db.RPush(key, val)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err = db.LPop(key); err != nil {
+				t.Fatal(err)
+			}
+			if i != 1 {
+				if _, err = db.RPop(key); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	snapshot := func() map[string][]string {
+		res := make(map[string][]string)
+		for _, key := range keys {
+			vals, err := db.LRange(key, 0, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range vals {
+				res[string(key)] = append(res[string(key)], string(v))
+			}
+		}
+		return res
+	}
+
+	for round := 0; round < 3; round++ {
+		step(round)
+		if err = db.RotateActiveFile(List); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reclaimLists(t, db)
+	step(3) // 回收之后在活跃文件中继续 pop 回收前的元素
+	want := snapshot()
+
+	db = reopen(t, db)
+	if got := snapshot(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("after reopen = %v, want %v", got, want)
+	}
+
+	// 再次回收包含回收结果及回收之后操作的文件
+	if err = db.RotateActiveFile(List); err != nil {
+		t.Fatal(err)
+	}
+	reclaimLists(t, db)
+	db = reopen(t, db)
+	defer db.Close()
+	if got := snapshot(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("after second reclaim = %v, want %v", got, want)
+	}
+}
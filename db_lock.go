@@ -0,0 +1,118 @@
+package mindb
+
+import (
+	"encoding/binary"
+	"errors"
+	"mindb/index"
+	"mindb/storage"
+	"time"
+)
+
+//---------基于字符串的锁（租约）相关操作接口-----------
+
+var (
+	// ErrLockHeld 锁已被持有
+	ErrLockHeld = errors.New("mindb: the lock is held by others")
+
+	// ErrLockNotHeld 锁不存在、已过期或者已被其他 token 持有
+	ErrLockNotHeld = errors.New("mindb: the lock is not held by the token")
+)
+
+// 锁的值为 8 字节的 fencing token
+const lockValueSize = 8
+
+// AcquireLock 获取 key 上的锁，锁在 ttl 秒后自动释放
+// 成功时返回一个 fencing token，token 在整个数据库内单调递增，异常退出并重新打开后也不会重复或变小（见 seqLimit），
+// 访问受保护的资源时可以用它拒绝已失效的持有者
+// 锁的值、过期时间及 token 写在同一条entry中，不会出现 SETNX 成功而 EXPIRE 失败时锁永不释放的情况
+func (db *MinDB) AcquireLock(key []byte, ttl uint32) (token uint64, err error) {
+	if err = db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+	if ttl == 0 {
+		return 0, ErrInvalidTTL
+	}
+
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	if db.strIndex.idxList.Exist(key) && !db.expireIfNeeded(key) {
+		return 0, ErrLockHeld
+	}
+
+	if token, err = db.seqs.next(); err != nil {
+		return 0, err
+	}
+	if err = db.writeLock(key, token, ttl); err != nil {
+		return 0, err
+	}
+	return
+}
+
+// RenewLock 持有者续约，将锁的过期时间重新设置为 ttl 秒之后，token 保持不变
+func (db *MinDB) RenewLock(key []byte, token uint64, ttl uint32) error {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return err
+	}
+	if ttl == 0 {
+		return ErrInvalidTTL
+	}
+
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	if !db.holdsLock(key, token) {
+		return ErrLockNotHeld
+	}
+	return db.writeLock(key, token, ttl)
+}
+
+// ReleaseLock 持有者释放锁，token 不匹配时不做任何操作
+func (db *MinDB) ReleaseLock(key []byte, token uint64) error {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return err
+	}
+
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	if !db.holdsLock(key, token) {
+		return ErrLockNotHeld
+	}
+
+	ele := db.removeStrIndex(key)
+	delete(db.expires, string(key))
+	e := storage.NewEntryNoExtra(key, nil, String, StringRem)
+	if err := db.store(e); err != nil {
+		return err
+	}
+	db.markStrRemoved(ele.Value().(*index.Indexer), e)
+	return nil
+}
+
+// 判断 token 是否持有 key 上未过期的锁，调用方需持有字符串索引的写锁
+func (db *MinDB) holdsLock(key []byte, token uint64) bool {
+	if !db.strIndex.idxList.Exist(key) || db.expireIfNeeded(key) {
+		return false
+	}
+	val, err := db.getVal(key)
+	if err != nil || len(val) != lockValueSize {
+		return false
+	}
+	return binary.BigEndian.Uint64(val) == token
+}
+
+// 写入锁的entry，token 同时记录为entry的序号，重新打开数据库时据此恢复已分配的最大序号
+func (db *MinDB) writeLock(key []byte, token uint64, ttl uint32) error {
+	val := make([]byte, lockValueSize)
+	binary.BigEndian.PutUint64(val, token)
+
+	deadline, ok := deadlineAfter(uint32(time.Now().Unix()), ttl)
+	if !ok {
+		return ErrInvalidTTL
+	}
+	e := storage.NewEntryNoExtra(key, val, String, StringSet)
+	e.Deadline = uint64(deadline)
+	e.Seq = token
+	return db.setEntry(e)
+}
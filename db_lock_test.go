@@ -0,0 +1,41 @@
+package mindb
+
+import "testing"
+
+// 锁被释放并回收之后异常退出，重新打开后分配的 token 仍然大于之前的所有 token
+func TestLockTokenAfterCrash(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DirPath = t.TempDir()
+	cfg.ReclaimThreshold = 1
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("lock")
+	token, err := db.AcquireLock(key, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.ReleaseLock(key, token); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.RotateActiveFile(String); err != nil { // 封存后回收丢弃已释放的锁
+		t.Fatal(err)
+	}
+	if err = db.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, db)
+
+	if db, err = Open(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	next, err := db.AcquireLock(key, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next <= token {
+		t.Fatalf("token after crash = %d, want > %d", next, token)
+	}
+}
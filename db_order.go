@@ -0,0 +1,75 @@
+package mindb
+
+import (
+	"bytes"
+	"sort"
+)
+
+// 哈希的域及集合的元素保存在 map 中，遍历的顺序是随机的，同样的数据每次读取的顺序都可能不同
+// 开启 SortedReads 后，HGetAll、HKeys、HValues、SMembers、SUnion、SDiff 在读取时按字节序排列结果（HValues 按对应的域排列），
+// 便于比较结果、测试及分页，代价是每次读取多一次排序。HGetAllPage、SMembersPage 不论是否开启都按字节序分页
+
+// 按域的字节序排列 field、value 交替的结果
+func sortFieldValues(fvs [][]byte) [][]byte {
+	pairs := make([][2][]byte, 0, len(fvs)/2)
+	for i := 0; i+1 < len(fvs); i += 2 {
+		pairs = append(pairs, [2][]byte{fvs[i], fvs[i+1]})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return bytes.Compare(pairs[i][0], pairs[j][0]) < 0 })
+	for i, p := range pairs {
+		fvs[2*i], fvs[2*i+1] = p[0], p[1]
+	}
+	return fvs
+}
+
+// 按字节序排列域或元素
+func sortValues(vals [][]byte) [][]byte {
+	sort.Slice(vals, func(i, j int) bool { return bytes.Compare(vals[i], vals[j]) < 0 })
+	return vals
+}
+
+// 排序后的 n 个结果中从 offset 开始的 count 个的下标范围 [start, end)，count 为负数时到最后
+func pageRange(n, offset, count int) (start, end int) {
+	start, end = offset, n
+	if start > n {
+		start = n
+	}
+	if count >= 0 && start+count < end {
+		end = start + count
+	}
+	return
+}
+
+// HGetAllPage 按域的字节序返回哈希表 key 中从第 offset 个域开始的最多 count 个域和值，count 为负数时返回之后的所有域
+// 每次读取都会对所有的域排序；分页读取期间哈希被修改时，之后的页可能重复或遗漏部分域
+func (db *MinDB) HGetAllPage(key []byte, offset, count int) [][]byte {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return nil
+	}
+
+	db.notifyRead(Hash, key)
+
+	db.hashIndex.mu.RLock()
+	fvs := db.hashIndex.indexes.HGetAll(string(key))
+	db.hashIndex.mu.RUnlock()
+
+	start, end := pageRange(len(fvs)/2, offset, count)
+	return sortFieldValues(fvs)[2*start : 2*end]
+}
+
+// SMembersPage 按字节序返回集合中从第 offset 个元素开始的最多 count 个元素，count 为负数时返回之后的所有元素
+// 每次读取都会对所有的元素排序；分页读取期间集合被修改时，之后的页可能重复或遗漏部分元素
+func (db *MinDB) SMembersPage(key []byte, offset, count int) [][]byte {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return nil
+	}
+
+	db.notifyRead(Set, key)
+
+	db.setIndex.mu.RLock()
+	members := db.setIndex.indexes.SMembers(string(key))
+	db.setIndex.mu.RUnlock()
+
+	start, end := pageRange(len(members), offset, count)
+	return sortValues(members)[start:end]
+}
@@ -0,0 +1,439 @@
+package mindb
+
+import (
+	"log"
+	"math"
+	"mindb/storage"
+	"mindb/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//---------基于列表、哈希、有序集合的队列相关操作接口-----------
+
+// 队列使用的内部key的前缀，同一个key在三种类型中分别保存：
+// 列表为待投递元素的id，哈希为 id -> 元素的内容，有序集合为已取出未确认元素的 id -> 可见性超时的时间（unix 毫秒）
+const queuePrefix = "\x00queue\x00"
+
+// 延迟投递的元素保存在以此为前缀的有序集合中，id -> 投递的时间（unix 毫秒），到期后由 RunQueueScheduler 移到待投递列表
+const queueDelayPrefix = "\x00qdelay\x00"
+
+// 队列的哈希中保存已预留的元素id上限的字段，元素的id都是数字，不会与之冲突
+const queueIdField = "\x00next"
+
+// 每次预留的元素id数量，预留的上限写入队列的哈希后才分配其中的id
+const queueIdBatch = 128
+
+// DefaultQueueVisibility 取出元素时默认的可见性超时
+const DefaultQueueVisibility = 30 * time.Second
+
+// QueueItem 从队列中取出的元素
+type QueueItem struct {
+	Id      []byte // 元素的id，确认时使用
+	Payload []byte // 元素的内容
+}
+
+// QueueHook 队列中有新的元素可以取出时的回调
+type QueueHook func(queue []byte)
+
+func queueKey(queue []byte) []byte {
+	return append([]byte(queuePrefix), queue...)
+}
+
+func queueDelayKey(queue []byte) []byte {
+	return append([]byte(queueDelayPrefix), queue...)
+}
+
+// 转换为有序集合中保存的 unix 毫秒
+func queueScore(t time.Time) float64 {
+	return float64(t.UnixNano() / int64(time.Millisecond))
+}
+
+// 队列提供至少一次的投递：取出的元素在可见性超时之前需要通过 QAck 确认，超时未确认的元素重新回到队列头部，再次被取出
+// 元素的内容先于id写入，取出时先记录超时时间再从待投递列表中删除，因此中途崩溃时元素不会丢失，但可能被重复投递
+// 所有队列操作持有 queueMu 依次执行，各类型索引的锁只在单次读写时持有
+
+// QPush 向队列的尾部添加元素，返回元素的id
+func (db *MinDB) QPush(queue []byte, payloads ...[]byte) (ids [][]byte, err error) {
+	if err = db.checkKeyValue(queue, payloads...); err != nil {
+		return
+	}
+
+	db.queueMu.Lock()
+	defer db.queueMu.Unlock()
+
+	key := queueKey(queue)
+	for _, payload := range payloads {
+		var id []byte
+		if id, err = db.queueNextId(key); err != nil {
+			return
+		}
+		if err = db.rawHSet(key, id, payload); err != nil {
+			return
+		}
+		if err = db.queueListPush(key, id, false); err != nil {
+			return
+		}
+		ids = append(ids, id)
+	}
+	db.notifyQueueReady(queue)
+	return
+}
+
+// EnqueueAt 添加一个在 deliverAt 时刻才能被取出的元素，返回元素的id，deliverAt 不晚于当前时间时与 QPush 相同
+// 元素到期后由 RunQueueScheduler 按投递时间的先后移到队列的尾部，因此实际可以取出的时间最多延后一个调度间隔
+func (db *MinDB) EnqueueAt(queue, payload []byte, deliverAt time.Time) (id []byte, err error) {
+	if !deliverAt.After(time.Now()) {
+		ids, err := db.QPush(queue, payload)
+		if err != nil || len(ids) == 0 {
+			return nil, err
+		}
+		return ids[0], nil
+	}
+	if err = db.checkKeyValue(queue, payload); err != nil {
+		return
+	}
+
+	db.queueMu.Lock()
+	defer db.queueMu.Unlock()
+
+	key := queueKey(queue)
+	if id, err = db.queueNextId(key); err != nil {
+		return nil, err
+	}
+	if err = db.rawHSet(key, id, payload); err != nil {
+		return nil, err
+	}
+	if err = db.rawZAdd(queueDelayKey(queue), id, queueScore(deliverAt)); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// PromoteDue 将所有队列中投递时间不晚于 now 的延迟元素移到队列的尾部，返回有元素到期的队列
+func (db *MinDB) PromoteDue(now time.Time) (queues [][]byte, err error) {
+	db.zsetIndex.mu.RLock()
+	var keys []string
+	for _, k := range db.zsetIndex.indexes.Keys() {
+		if strings.HasPrefix(k, queueDelayPrefix) {
+			keys = append(keys, k)
+		}
+	}
+	db.zsetIndex.mu.RUnlock()
+
+	for _, k := range keys {
+		queue := []byte(k[len(queueDelayPrefix):])
+		n, err := db.promoteQueue(queue, now)
+		if err != nil {
+			return queues, err
+		}
+		if n > 0 {
+			queues = append(queues, queue)
+			db.notifyQueueReady(queue)
+		}
+	}
+	return
+}
+
+// 将一个队列中到期的延迟元素移到队列的尾部，返回移动的数量
+// 先写入待投递列表再从延迟集合中删除，中途崩溃时元素可能被投递两次，但不会丢失
+func (db *MinDB) promoteQueue(queue []byte, now time.Time) (n int, err error) {
+	db.queueMu.Lock()
+	defer db.queueMu.Unlock()
+
+	key, delayKey := queueKey(queue), queueDelayKey(queue)
+	due := db.queueDue(delayKey, now)
+
+	for i := 0; i < len(due); i += 2 {
+		id := []byte(due[i].(string))
+		if err = db.queueListPush(key, id, false); err != nil {
+			return
+		}
+		if _, err = db.rawZRem(delayKey, id); err != nil {
+			return
+		}
+		n++
+	}
+	return
+}
+
+// RunQueueScheduler 每隔 interval 将到期的延迟元素移到队列中，直到 stop 被关闭或数据库被关闭，出错时记录日志后在下一轮重试
+// Update 执行期间不移动元素，事务中读写的队列不会被调度打断
+// 有元素到期的队列通过 OnQueueReady 注册的回调通知等待的消费者
+func (db *MinDB) RunQueueScheduler(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for db.isOpen() {
+		db.txMu.RLock() // 与 Update 互斥，事务执行期间不写入
+		_, err := db.PromoteDue(time.Now())
+		db.txMu.RUnlock()
+		if err != nil {
+			log.Printf("promote delayed queue items err: %+v\n", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// OnQueueReady 注册队列中有新的元素可以取出（添加、延迟元素到期）时的回调，可以注册多个，按注册的顺序依次调用
+// 回调在持有队列锁时同步调用，应尽快返回且不能调用队列的方法，一般只用来唤醒等待的消费者；超时未确认被重新投递的元素不会触发回调
+func (db *MinDB) OnQueueReady(fn QueueHook) {
+	if fn == nil {
+		return
+	}
+	db.hookMu.Lock()
+	defer db.hookMu.Unlock()
+	db.queueHooks = append(db.queueHooks, fn)
+}
+
+func (db *MinDB) notifyQueueReady(queue []byte) {
+	db.hookMu.RLock()
+	hooks := db.queueHooks
+	db.hookMu.RUnlock()
+	for _, fn := range hooks {
+		fn(queue)
+	}
+}
+
+// QPop 从队列的头部取出一个元素，元素在 visibility 时间内没有确认时会被重新投递，队列为空时返回 nil
+func (db *MinDB) QPop(queue []byte, visibility time.Duration) (*QueueItem, error) {
+	if err := db.checkKeyValue(queue, nil); err != nil {
+		return nil, err
+	}
+	if visibility <= 0 {
+		return nil, ErrInvalidTTL
+	}
+
+	db.queueMu.Lock()
+	defer db.queueMu.Unlock()
+
+	key := queueKey(queue)
+	now := time.Now()
+	if err := db.queueRedeliver(key, now); err != nil {
+		return nil, err
+	}
+
+	for {
+		db.listIndex.mu.RLock()
+		id := db.listIndex.indexes.LIndex(string(key), 0)
+		db.listIndex.mu.RUnlock()
+		if id == nil {
+			return nil, nil
+		}
+
+		db.hashIndex.mu.RLock()
+		payload := db.hashIndex.indexes.HGet(string(key), string(id))
+		db.hashIndex.mu.RUnlock()
+
+		if payload != nil { // 内容不存在时元素已被确认，是重复投递留下的id，直接丢弃
+			if err := db.rawZAdd(key, id, queueScore(now.Add(visibility))); err != nil {
+				return nil, err
+			}
+		}
+		if err := db.queueListPop(key); err != nil {
+			return nil, err
+		}
+		if payload != nil {
+			return &QueueItem{Id: id, Payload: payload}, nil
+		}
+	}
+}
+
+// QAck 确认已取出的元素，确认后元素从队列中删除，返回确认成功的数量，未取出或已确认的id被忽略
+func (db *MinDB) QAck(queue []byte, ids ...[]byte) (n int, err error) {
+	if err = db.checkKeyValue(queue, nil); err != nil {
+		return
+	}
+
+	db.queueMu.Lock()
+	defer db.queueMu.Unlock()
+
+	key := queueKey(queue)
+	for _, id := range ids {
+		var ok bool
+		if ok, err = db.rawZRem(key, id); err != nil {
+			return
+		}
+		if !ok {
+			continue
+		}
+		if err = db.rawHDel(key, id); err != nil {
+			return
+		}
+		n++
+	}
+	return
+}
+
+// QLen 返回队列中待投递、已取出未确认及延迟投递的元素数量
+func (db *MinDB) QLen(queue []byte) (ready, unacked, delayed int) {
+	key := string(queueKey(queue))
+
+	db.listIndex.mu.RLock()
+	ready = db.listIndex.indexes.LLen(key)
+	db.listIndex.mu.RUnlock()
+
+	db.zsetIndex.mu.RLock()
+	unacked = db.zsetIndex.indexes.ZCard(key)
+	delayed = db.zsetIndex.indexes.ZCard(string(queueDelayKey(queue)))
+	db.zsetIndex.mu.RUnlock()
+	return
+}
+
+// 将可见性超时仍未确认的元素放回待投递列表的头部，调用方需持有 queueMu
+func (db *MinDB) queueRedeliver(key []byte, now time.Time) error {
+	expired := db.queueDue(key, now)
+
+	// 按超时时间从晚到早依次放到头部，最早超时的元素最先被再次取出
+	for i := len(expired) - 2; i >= 0; i -= 2 {
+		id := []byte(expired[i].(string))
+		if err := db.queueListPush(key, id, true); err != nil {
+			return err
+		}
+		if _, err := db.rawZRem(key, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 返回有序集合 key 中分数（unix 毫秒）不晚于 now 的成员及分数
+func (db *MinDB) queueDue(key []byte, now time.Time) []interface{} {
+	db.zsetIndex.mu.RLock()
+	defer db.zsetIndex.mu.RUnlock()
+	return db.zsetIndex.indexes.ZScoreRange(string(key), math.Inf(-1), queueScore(now))
+}
+
+// 以下为队列、物化视图等内部使用的读写，不受集合长度上限的限制，各自持有对应索引的写锁
+
+// 队列已分配的元素id，next 为上一个分配的id，limit 为已持久化的预留上限
+type queueIdRange struct {
+	next, limit uint64
+}
+
+type queueIdRanges map[string]*queueIdRange
+
+// 分配队列中新元素的id，调用方需持有 queueMu
+// id 在队列内单调递增，不会重复使用：预留的上限持久化在队列的哈希中，重新打开数据库后从上限继续，
+// 崩溃时丢弃的只是预留但没有分配的id；旧版本没有保存上限，此时从哈希中已有的最大id继续
+func (db *MinDB) queueNextId(key []byte) ([]byte, error) {
+	r := db.queueIds[string(key)]
+	if r == nil {
+		r = &queueIdRange{}
+		db.hashIndex.mu.RLock()
+		if v := db.hashIndex.indexes.HGet(string(key), queueIdField); v != nil {
+			r.limit, _ = strconv.ParseUint(string(v), 10, 64)
+		}
+		for _, field := range db.hashIndex.indexes.HKeys(string(key)) {
+			if id, err := strconv.ParseUint(field, 10, 64); err == nil && id > r.limit {
+				r.limit = id
+			}
+		}
+		db.hashIndex.mu.RUnlock()
+		r.next = r.limit
+		db.queueIds[string(key)] = r
+	}
+
+	if r.next >= r.limit {
+		limit := r.next + queueIdBatch
+		if err := db.rawHSet(key, []byte(queueIdField), []byte(strconv.FormatUint(limit, 10))); err != nil {
+			return nil, err
+		}
+		r.limit = limit
+	}
+	r.next++
+	return []byte(strconv.FormatUint(r.next, 10)), nil
+}
+
+func (db *MinDB) queueListPush(key, id []byte, head bool) error {
+	db.listIndex.mu.Lock()
+	defer db.listIndex.mu.Unlock()
+
+	mark := ListRPush
+	if head {
+		mark = ListLPush
+	}
+	e := storage.NewEntryNoExtra(key, id, List, mark)
+	e.Seq = db.listIndex.nextSeq(string(key))
+	if err := db.store(e); err != nil {
+		return err
+	}
+	if head {
+		db.listIndex.indexes.LPush(string(key), id)
+	} else {
+		db.listIndex.indexes.RPush(string(key), id)
+	}
+	return nil
+}
+
+func (db *MinDB) queueListPop(key []byte) error {
+	db.listIndex.mu.Lock()
+	defer db.listIndex.mu.Unlock()
+
+	val := db.listIndex.indexes.LPop(string(key))
+	if val == nil {
+		return nil
+	}
+	e := storage.NewEntryNoExtra(key, val, List, ListLPop)
+	e.Seq = db.listIndex.nextSeq(string(key))
+	return db.store(e)
+}
+
+func (db *MinDB) rawHSet(key, field, value []byte) error {
+	db.hashIndex.mu.Lock()
+	defer db.hashIndex.mu.Unlock()
+
+	e := storage.NewEntry(key, value, field, Hash, HashHSet)
+	if err := db.store(e); err != nil {
+		return err
+	}
+	db.hashIndex.indexes.HSet(string(key), string(field), value)
+	return nil
+}
+
+func (db *MinDB) rawHDel(key, field []byte) error {
+	db.hashIndex.mu.Lock()
+	defer db.hashIndex.mu.Unlock()
+
+	if !db.hashIndex.indexes.HDel(string(key), string(field)) {
+		return nil
+	}
+	e := storage.NewEntry(key, nil, field, Hash, HashHDel)
+	return db.store(e)
+}
+
+func (db *MinDB) rawZAdd(key, member []byte, score float64) error {
+	db.zsetIndex.mu.Lock()
+	defer db.zsetIndex.mu.Unlock()
+	return db.storeZAdd(key, member, score)
+}
+
+func (db *MinDB) rawZRem(key, member []byte) (bool, error) {
+	db.zsetIndex.mu.Lock()
+	defer db.zsetIndex.mu.Unlock()
+	return db.storeZRem(key, member)
+}
+
+// 调用方需持有有序集合索引的写锁
+func (db *MinDB) storeZAdd(key, member []byte, score float64) error {
+	e := storage.NewEntry(key, member, []byte(utils.Float64ToStr(score)), ZSet, ZSetZAdd)
+	if err := db.store(e); err != nil {
+		return err
+	}
+	db.zsetIndex.indexes.ZAdd(string(key), score, string(member))
+	return nil
+}
+
+// 调用方需持有有序集合索引的写锁
+func (db *MinDB) storeZRem(key, member []byte) (bool, error) {
+	if !db.zsetIndex.indexes.ZRem(string(key), string(member)) {
+		return false, nil
+	}
+	e := storage.NewEntryNoExtra(key, member, ZSet, ZSetZRem)
+	return true, db.store(e)
+}
@@ -0,0 +1,179 @@
+package mindb
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// 确认后已取出未确认的有序集合为空，再次取出时不能 panic，也不能一直持有有序集合索引的锁
+func TestQPopAfterAck(t *testing.T) {
+	db := openTestDB(t, KeyValueRamMode)
+	queue := []byte("q")
+
+	if _, err := db.QPush(queue, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	item, err := db.QPop(queue, time.Minute)
+	if err != nil || item == nil {
+		t.Fatalf("QPop = %v, %v", item, err)
+	}
+	if n, err := db.QAck(queue, item.Id); err != nil || n != 1 {
+		t.Fatalf("QAck = %d, %v", n, err)
+	}
+
+	if item, err = db.QPop(queue, time.Minute); err != nil || item != nil {
+		t.Fatalf("QPop on empty queue = %v, %v", item, err)
+	}
+	if err = db.ZAdd([]byte("z"), 1, []byte("m")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 待投递列表取空并回收后重新打开数据库，新元素的id不能与仍未确认的元素相同
+func TestQueueIdsAfterReopen(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DirPath = t.TempDir()
+	cfg.ReclaimThreshold = 1
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := []byte("q")
+
+	if _, err = db.QPush(queue, []byte("unacked"), []byte("acked")); err != nil {
+		t.Fatal(err)
+	}
+	unacked, err := db.QPop(queue, time.Hour)
+	if err != nil || unacked == nil {
+		t.Fatalf("QPop = %v, %v", unacked, err)
+	}
+	acked, err := db.QPop(queue, time.Hour)
+	if err != nil || acked == nil {
+		t.Fatalf("QPop = %v, %v", acked, err)
+	}
+	if _, err = db.QAck(queue, acked.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.RotateActiveFile(List); err != nil { // 封存列表的文件，回收时丢弃空列表的操作
+		t.Fatal(err)
+	}
+	if err = db.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if db, err = Open(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ids, err := db.QPush(queue, []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, old := range [][]byte{unacked.Id, acked.Id} {
+		if string(ids[0]) == string(old) {
+			t.Fatalf("QPush reused id %s", old)
+		}
+	}
+	// 确认旧的id不会确认新的元素
+	if n, err := db.QAck(queue, acked.Id); err != nil || n != 0 {
+		t.Fatalf("QAck stale id = %d, %v", n, err)
+	}
+	if n, err := db.QAck(queue, unacked.Id); err != nil || n != 1 {
+		t.Fatalf("QAck unacked = %d, %v", n, err)
+	}
+}
+
+// 延迟元素的id在重新打开数据库后不能被新元素重复使用，否则延迟元素的内容会被覆盖
+func TestEnqueueAtIdAfterReopen(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DirPath = t.TempDir()
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := []byte("q")
+
+	delayed, err := db.EnqueueAt(queue, []byte("delayed"), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if db, err = Open(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ids, err := db.QPush(queue, []byte("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ids[0]) == string(delayed) {
+		t.Fatalf("QPush reused the delayed item's id %s", delayed)
+	}
+
+	if _, err = db.PromoteDue(time.Now().Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for {
+		item, err := db.QPop(queue, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item == nil {
+			break
+		}
+		got = append(got, string(item.Payload))
+	}
+	if len(got) != 2 || got[0] != "second" || got[1] != "delayed" {
+		t.Fatalf("popped %q, want [second delayed]", got)
+	}
+}
+
+// 队列保存在内部key中，不出现在遍历、扫描、抽样及统计中
+func TestQueueKeysHidden(t *testing.T) {
+	db := openTestDB(t, KeyValueRamMode)
+	queue := []byte("jobs")
+	if _, err := db.QPush(queue, []byte("now")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.EnqueueAt(queue, []byte("later"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.QPop(queue, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	db.IterateAll(func(dataType DataType, key []byte) bool {
+		keys = append(keys, "iterate:"+string(key))
+		return true
+	})
+	for _, s := range db.SampleKeys(10) {
+		keys = append(keys, "sample:"+string(s.Key))
+	}
+	_, scanned, err := db.Scan(nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range scanned {
+		keys = append(keys, "scan:"+string(s.Key))
+	}
+	for it := db.NewIterator(IteratorOptions{Types: DataTypes}); it.Next(); {
+		keys = append(keys, "iterator:"+string(it.Key()))
+	}
+	for dataType, n := range db.Stats().Keys {
+		if n > 0 {
+			keys = append(keys, "stats:"+strconv.Itoa(int(dataType)))
+		}
+	}
+	if len(keys) != 0 {
+		t.Fatalf("queue keys visible: %q", keys)
+	}
+}
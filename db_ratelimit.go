@@ -0,0 +1,80 @@
+package mindb
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"mindb/storage"
+	"time"
+)
+
+//---------基于字符串的限流相关操作接口-----------
+
+// ErrNotRateLimiter key上已经存在其他的值
+var ErrNotRateLimiter = errors.New("mindb: the value of key is not a rate limiter")
+
+// 限流器的值：8 字节的剩余令牌数（float64）+ 8 字节的上次更新时间（unix 纳秒）
+const rateLimitValueSize = 16
+
+// RateLimitResult 一次限流检查的结果
+type RateLimitResult struct {
+	Allowed    bool          // 本次请求是否被允许
+	Remaining  uint32        // 剩余可用的令牌数
+	RetryAfter time.Duration // 被拒绝时，需要等待多久才会有可用的令牌
+}
+
+// RateLimit 基于令牌桶的限流，桶的容量为 limit，每 window 时间内匀速补充 limit 个令牌，每次允许的请求消耗一个令牌
+// 令牌桶的状态和过期时间写在同一条entry中，检查与消耗在一次加锁内完成，没有 INCR 与 EXPIRE 之间的竞争
+// 请求被拒绝时不写入数据，桶补满之后key自动过期
+func (db *MinDB) RateLimit(key []byte, limit uint32, window time.Duration) (res RateLimitResult, err error) {
+	if err = db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+	if limit == 0 || window <= 0 {
+		return res, ErrInvalidTTL
+	}
+
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	now := time.Now()
+	rate := float64(limit) / window.Seconds() // 每秒补充的令牌数
+	tokens := float64(limit)
+
+	if db.strIndex.idxList.Exist(key) && !db.expireIfNeeded(key) {
+		val, err := db.getVal(key)
+		if err != nil {
+			return res, err
+		}
+		if len(val) != rateLimitValueSize {
+			return res, ErrNotRateLimiter
+		}
+		last := time.Unix(0, int64(binary.BigEndian.Uint64(val[8:])))
+		tokens = math.Float64frombits(binary.BigEndian.Uint64(val[:8]))
+		if elapsed := now.Sub(last); elapsed > 0 {
+			tokens += elapsed.Seconds() * rate
+		}
+		tokens = math.Min(tokens, float64(limit))
+	}
+
+	if tokens < 1 {
+		res.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+		return
+	}
+
+	tokens--
+	val := make([]byte, rateLimitValueSize)
+	binary.BigEndian.PutUint64(val[:8], math.Float64bits(tokens))
+	binary.BigEndian.PutUint64(val[8:], uint64(now.UnixNano()))
+
+	e := storage.NewEntryNoExtra(key, val, String, StringSet)
+	full := math.Ceil((float64(limit) - tokens) / rate) // 桶补满还需要的秒数
+	e.Deadline = uint64(now.Unix()) + uint64(full) + 1
+	if err = db.setEntry(e); err != nil {
+		return
+	}
+
+	res.Allowed = true
+	res.Remaining = uint32(tokens)
+	return
+}
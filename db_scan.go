@@ -0,0 +1,185 @@
+package mindb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"mindb/index"
+	"sort"
+	"time"
+)
+
+// ErrInvalidCursor SCAN 的游标不是之前返回的游标
+var ErrInvalidCursor = errors.New("mindb: invalid scan cursor")
+
+// ScannedKey Scan 返回的一个key及其类型，不同类型中可以有同名的key
+type ScannedKey struct {
+	Type DataType
+	Key  []byte
+}
+
+// Scan 从游标 cursor 处开始按类型、再按key的顺序返回最多 count 个key，以及下一次调用使用的游标
+// cursor 为空时从头开始，返回的游标为空时遍历结束；types 为空时遍历所有类型
+// 遍历期间一直存在的key一定会返回且只返回一次，遍历期间新增或删除的key可能返回也可能不返回
+// 每次调用只持有一种类型索引的读锁，不会长时间阻塞其他操作：字符串直接从游标处开始读取，
+// 其他类型的索引没有顺序，需要遍历该类型的所有key选出游标之后最小的 count 个
+func (db *MinDB) Scan(cursor []byte, count int, types ...DataType) (next []byte, keys []ScannedKey, err error) {
+	if count <= 0 {
+		count = 10
+	}
+	if len(types) == 0 {
+		types = DataTypes
+	}
+
+	start, after := 0, []byte(nil)
+	if len(cursor) > 0 {
+		if len(cursor) < 2 {
+			return nil, nil, ErrInvalidCursor
+		}
+		dataType := DataType(binary.BigEndian.Uint16(cursor))
+		if start = indexOfType(types, dataType); start < 0 {
+			return nil, nil, ErrInvalidCursor
+		}
+		after = cursor[2:]
+	}
+
+	for i := start; i < len(types); i++ {
+		names := db.scanType(types[i], after, count-len(keys))
+		for _, name := range names {
+			keys = append(keys, ScannedKey{Type: types[i], Key: name})
+		}
+		if len(keys) == count { // 这一类型可能还有剩余的key，下次从最后一个key之后继续
+			last := keys[len(keys)-1]
+			next = make([]byte, 2+len(last.Key))
+			binary.BigEndian.PutUint16(next, last.Type)
+			copy(next[2:], last.Key)
+			return
+		}
+		after = nil
+	}
+	return
+}
+
+func indexOfType(types []DataType, dataType DataType) int {
+	for i, t := range types {
+		if t == dataType {
+			return i
+		}
+	}
+	return -1
+}
+
+// 返回某类型中大于 after 的最小的 n 个key，after 为 nil 时从第一个key开始，已过期的字符串key及内部key会被跳过
+func (db *MinDB) scanType(dataType DataType, after []byte, n int) (keys [][]byte) {
+	if dataType == String {
+		db.strIndex.mu.RLock()
+		defer db.strIndex.mu.RUnlock()
+
+		e := db.strIndex.idxList.Front()
+		if after != nil {
+			// 没有不小于 after 的key时 FindPrefix 返回第一个key，因此需要再比较一次
+			if e = db.strIndex.idxList.FindPrefix(after); e != nil && bytes.Compare(e.Key(), after) <= 0 {
+				if bytes.Equal(e.Key(), after) {
+					e = e.Next()
+				} else {
+					e = nil
+				}
+			}
+		}
+		now := time.Now().Unix()
+		for ; e != nil && len(keys) < n; e = e.Next() {
+			if isInternalKey(e.Key()) {
+				continue
+			}
+			if deadline, exist := db.expires[string(e.Key())]; !exist || now <= int64(deadline) {
+				keys = append(keys, e.Key())
+			}
+		}
+		return
+	}
+
+	lock := db.idxLock(dataType)
+	if lock == nil {
+		return nil
+	}
+	lock.RLock()
+	var names []string
+	switch dataType {
+	case List:
+		names = db.listIndex.indexes.Keys()
+	case Hash:
+		names = db.hashIndex.indexes.Keys()
+	case Set:
+		names = db.setIndex.indexes.Keys()
+	case ZSet:
+		names = db.zsetIndex.indexes.Keys()
+	}
+	lock.RUnlock()
+
+	rest := names[:0]
+	for _, name := range userKeys(names) {
+		if after == nil || name > string(after) {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	if len(rest) > n {
+		rest = rest[:n]
+	}
+	for _, name := range rest {
+		keys = append(keys, []byte(name))
+	}
+	return
+}
+
+// KeyUsage 返回某类型的key中元素的个数，及key与所有元素占用的字节数，key不存在时都为 0
+// 字符串的元素个数为 1；哈希的元素包括域和值，有序集合的每个分数计为 8 字节
+// 需要读取集合中的所有元素，元素很多时有一定的耗时
+func (db *MinDB) KeyUsage(dataType DataType, key []byte) (count int, size int64) {
+	lock := db.idxLock(dataType)
+	if lock == nil {
+		return
+	}
+	lock.RLock()
+	defer lock.RUnlock()
+	return db.keyUsage(dataType, key)
+}
+
+// 同 KeyUsage，调用方需持有该类型索引的读锁
+func (db *MinDB) keyUsage(dataType DataType, key []byte) (count int, size int64) {
+	k := string(key)
+	switch dataType {
+	case String:
+		node := db.strIndex.idxList.Get(key)
+		if node == nil {
+			return
+		}
+		return 1, strSize(node.Value().(*index.Indexer))
+	case List:
+		for _, v := range db.listIndex.indexes.LRange(k, 0, -1) {
+			count++
+			size += int64(len(v))
+		}
+	case Hash:
+		fields := db.hashIndex.indexes.HGetAll(k)
+		for _, v := range fields {
+			size += int64(len(v))
+		}
+		count = len(fields) / 2
+	case Set:
+		for _, v := range db.setIndex.indexes.SMembers(k) {
+			count++
+			size += int64(len(v))
+		}
+	case ZSet:
+		items := db.zsetIndex.indexes.ZRange(k, 0, -1)
+		for i := 0; i < len(items); i += 2 {
+			count++
+			size += int64(len(items[i].(string))) + 8
+		}
+	}
+	if count > 0 {
+		size += int64(len(key))
+	}
+	return
+}
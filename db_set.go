@@ -31,6 +31,9 @@ func (db *MinDB) SAdd(key []byte, members ...[]byte) (res int, err error) {
 	for _, m := range members {
 		exist := db.setIndex.indexes.SIsMember(string(key), m)
 		if !exist {
+			if db.setFull(key, m) {
+				return res, ErrCollectionFull
+			}
 			e := storage.NewEntryNoExtra(key, m, Set, SetSAdd)
 			if err = db.store(e); err != nil {
 				return
@@ -66,6 +69,8 @@ func (db *MinDB) SPop(key []byte, count int) (values [][]byte, err error) {
 // SIsMember 判断 member 元素是不是集合 key 的成员
 func (db *MinDB) SIsMember(key, member []byte) bool {
 
+	db.notifyRead(Set, key)
+
 	db.setIndex.mu.RLock()
 	defer db.setIndex.mu.RUnlock()
 
@@ -114,12 +119,24 @@ func (db *MinDB) SMove(src, dst, member []byte) error {
 	db.setIndex.mu.Lock()
 	defer db.setIndex.mu.Unlock()
 
-	if ok := db.setIndex.indexes.SMove(string(src), string(dst), member); ok {
-		e := storage.NewEntry(src, member, dst, Set, SetSMove)
-		if err := db.store(e); err != nil {
-			return err
-		}
+	if !db.setIndex.indexes.SIsMember(string(src), member) {
+		return nil
+	}
+	if db.setFull(dst, member) {
+		return ErrCollectionFull
+	}
+
+	// 源集合上的 SRem 和目标集合上的 SAdd 以意向记录写入，重放及回收时都作为一个整体
+	err := db.writeIntent(
+		storage.NewEntry(src, member, dst, Set, SetIntent),
+		storage.NewEntry(src, member, dst, Set, SetCommit),
+		storage.NewEntryNoExtra(src, member, Set, SetSRem),
+		storage.NewEntryNoExtra(dst, member, Set, SetSAdd),
+	)
+	if err != nil {
+		return err
 	}
+	db.setIndex.indexes.SMove(string(src), string(dst), member)
 
 	return nil
 }
@@ -144,9 +161,14 @@ func (db *MinDB) SMembers(key []byte) (val [][]byte) {
 		return
 	}
 
+	db.notifyRead(Set, key)
+
 	db.setIndex.mu.RLock()
 	defer db.setIndex.mu.RUnlock()
 
+	if db.config.SortedReads {
+		return sortValues(db.setIndex.indexes.SMembers(string(key)))
+	}
 	return db.setIndex.indexes.SMembers(string(key))
 }
 
@@ -165,6 +187,9 @@ func (db *MinDB) SUnion(keys ...[]byte) (val [][]byte) {
 		s = append(s, string(k))
 	}
 
+	if db.config.SortedReads {
+		return sortValues(db.setIndex.indexes.SUnion(s...))
+	}
 	return db.setIndex.indexes.SUnion(s...)
 }
 
@@ -183,5 +208,8 @@ func (db *MinDB) SDiff(keys ...[]byte) (val [][]byte) {
 		s = append(s, string(k))
 	}
 
+	if db.config.SortedReads {
+		return sortValues(db.setIndex.indexes.SDiff(s...))
+	}
 	return db.setIndex.indexes.SDiff(s...)
 }
@@ -2,9 +2,12 @@ package mindb
 
 import (
 	"bytes"
+	"context"
 	"log"
+	"math"
 	"mindb/index"
 	"mindb/storage"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -12,14 +15,19 @@ import (
 
 //---------字符串相关操作接口-----------
 
+// 扫描、回收等耗时的操作每处理多少个key或entry检查一次 ctx 是否已被取消
+const cancelCheckInterval = 256
+
 // StrIdx string idx
 type StrIdx struct {
 	mu      sync.RWMutex
 	idxList *index.SkipList
+	patches map[string][]*index.Indexer // 以增量方式写入的部分修改在文件中的位置，按写入顺序排列
+	sizes   prefixSizes                 // 按前缀统计的key数量及大小
 }
 
 func newStrIdx() *StrIdx {
-	return &StrIdx{idxList: index.NewSkipList()}
+	return &StrIdx{idxList: index.NewSkipList(), patches: make(map[string][]*index.Indexer), sizes: make(prefixSizes)}
 }
 
 // Set 将字符串值 value 关联到 key
@@ -80,6 +88,22 @@ func (db *MinDB) Get(key []byte) ([]byte, error) {
 		return nil, ErrEmptyKey
 	}
 
+	db.notifyRead(String, key)
+
+	db.strIndex.mu.RLock()
+	val, err := db.getVal(key)
+	db.strIndex.mu.RUnlock()
+	if err == ErrKeyExpired { // 读锁下只判断是否过期，释放读锁之后再删除
+		db.removeExpired(key)
+	} else if err != nil && isCorruption(err) { // 磁盘中的数据已损坏，修复索引后返回可以读取的值
+		return db.repairStr(key)
+	}
+	return val, err
+}
+
+// 获取key对应的值，调用方需持有字符串索引的锁，只持有读锁即可
+// 已过期的key返回 ErrKeyExpired 但不会被删除，调用方需在持有写锁时删除（见 removeExpired）
+func (db *MinDB) getVal(key []byte) ([]byte, error) {
 	node := db.strIndex.idxList.Get(key) // 从索引（跳表）中查找
 	if node == nil {
 		return nil, ErrKeyNotExist
@@ -90,33 +114,33 @@ func (db *MinDB) Get(key []byte) ([]byte, error) {
 		return nil, ErrNilIndexer
 	}
 
-	db.strIndex.mu.RLock()
-	defer db.strIndex.mu.RUnlock()
-
 	//判断是否过期
-	if db.expireIfNeeded(key) {
+	if db.isExpired(key) {
 		return nil, ErrKeyExpired
 	}
 
 	//如果key和value均在内存中，则取内存中的value
 	if db.config.IdxMode == KeyValueRamMode {
+		if db.config.VerifyValueOnRead {
+			if err := db.verifyValue(idx, idx.Meta.Value); err != nil {
+				return nil, err
+			}
+		}
 		return idx.Meta.Value, nil
 	}
 
 	//如果只有key在内存中，那么需要从db file中获取value
 	if db.config.IdxMode == KeyOnlyRamMode {
-		df := db.activeFile[String]
-		if idx.FileId != db.activeFileIds[String] {
-			df = db.archFiles[String][idx.FileId]
+		if !db.isOpen() { // 数据文件可能已经关闭
+			return nil, ErrDBClosed
 		}
 
-		e, err := df.Read(idx.Offset)
+		e, err := db.readStrEntryOf(key, idx)
 		if err != nil {
 			return nil, err
 		}
 
-		return e.Meta.Value, nil
-
+		return db.applyStrPatches(key, e.Meta.Value)
 	}
 
 	return nil, ErrKeyNotExist
@@ -144,14 +168,11 @@ func (db *MinDB) Append(key, value []byte) error {
 		return err
 	}
 
-	e, err := db.Get(key)
+	e, err := db.Get(key) // 已过期的key在 Get 中返回 ErrKeyExpired
 	if err != nil && err != ErrKeyNotExist {
 		return err
 	}
 
-	if db.expireIfNeeded(key) {
-		return ErrKeyExpired
-	}
 	appendExist := false
 
 	if e != nil {
@@ -172,6 +193,54 @@ func (db *MinDB) Append(key, value []byte) error {
 	return nil
 }
 
+// SetRange 从 offset 处开始用 value 覆盖 key 存储的字符串，返回修改后字符串的长度
+// offset 超过原来的长度时，中间的部分以 0 填充；key 不存在时视为空字符串，原有的过期时间保持不变
+// 配置了 StrPatchMinSize 且原来的值不小于该大小时，只将本次修改作为增量写入磁盘，不再重写整个值
+func (db *MinDB) SetRange(key []byte, offset int, value []byte) (int, error) {
+	if offset < 0 {
+		return 0, ErrInvalidOffset
+	}
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return 0, err
+	}
+
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	size := 0
+	node := db.strIndex.idxList.Get(key)
+	if node != nil && !db.expireIfNeeded(key) {
+		size = int(node.Value().(*index.Indexer).Meta.ValueSize)
+	} else {
+		node = nil
+	}
+	if len(value) == 0 { // 没有需要写入的内容，不创建key
+		return size, nil
+	}
+
+	newSize := offset + len(value)
+	if uint64(newSize) > uint64(db.config.MaxValueSize) {
+		return 0, ErrValueTooLarge
+	}
+	if newSize < size {
+		newSize = size
+	}
+
+	if node != nil && db.config.StrPatchMinSize > 0 && size >= int(db.config.StrPatchMinSize) &&
+		len(db.strIndex.patches[string(key)]) < maxStrPatches {
+		return newSize, db.setPatch(key, offset, value)
+	}
+
+	var old []byte
+	if node != nil {
+		var err error
+		if old, err = db.getVal(key); err != nil {
+			return 0, err
+		}
+	}
+	return newSize, db.setKeepTTL(key, patchValue(old, offset, value))
+}
+
 // StrLen 返回key存储的字符串值的长度
 func (db *MinDB) StrLen(key []byte) int {
 
@@ -180,18 +249,21 @@ func (db *MinDB) StrLen(key []byte) int {
 	}
 
 	db.strIndex.mu.RLock()
-	defer db.strIndex.mu.RUnlock()
-
 	e := db.strIndex.idxList.Get(key)
-	if e != nil {
-		if db.expireIfNeeded(key) {
-			return 0
-		}
-		idx := e.Value().(*index.Indexer)
-		return int(idx.Meta.ValueSize)
+	if e == nil {
+		db.strIndex.mu.RUnlock()
+		return 0
+	}
+	if db.isExpired(key) {
+		db.strIndex.mu.RUnlock()
+		db.removeExpired(key)
+		return 0
 	}
+	idx := e.Value().(*index.Indexer)
+	size := int(idx.Meta.ValueSize)
+	db.strIndex.mu.RUnlock()
 
-	return 0
+	return size
 }
 
 // StrExists 判断key是否存在
@@ -202,16 +274,17 @@ func (db *MinDB) StrExists(key []byte) bool {
 	}
 
 	db.strIndex.mu.RLock()
-	defer db.strIndex.mu.RUnlock()
-
 	exist := db.strIndex.idxList.Exist(key)
-	if exist && !db.expireIfNeeded(key) {
-		return true
+	expired := exist && db.isExpired(key)
+	db.strIndex.mu.RUnlock()
+
+	if expired {
+		db.removeExpired(key)
 	}
-	return false
+	return exist && !expired
 }
 
-// StrRem 删除key及其数据
+// StrRem 删除key及其数据，开启了软删除（TrashTTL）时key会先移到回收站，可以通过 Undelete 恢复
 func (db *MinDB) StrRem(key []byte) error {
 	if err := db.checkKeyValue(key, nil); err != nil {
 		return err
@@ -220,21 +293,26 @@ func (db *MinDB) StrRem(key []byte) error {
 	db.strIndex.mu.Lock()
 	defer db.strIndex.mu.Unlock()
 
-	if ele := db.strIndex.idxList.Remove(key); ele != nil {
-		delete(db.expires, string(key))
-		e := storage.NewEntryNoExtra(key, nil, String, StringRem)
-		if err := db.store(e); err != nil {
+	// 开启了软删除时先将值移到回收站，已过期的key不再保留
+	if db.config.TrashTTL > 0 && !isTrashKey(key) {
+		if err := db.moveToTrash(key); err != nil && err != ErrKeyNotExist && err != ErrKeyExpired {
 			return err
 		}
 	}
-
-	return nil
+	_, err := db.removeStr(key)
+	return err
 }
 
 // PrefixScan 根据前缀查找所有匹配的 key 对应的 value
 //参数 limit 和 offset 控制取数据的范围，类似关系型数据库中的分页操作
 //如果 limit 为负数，则返回所有满足条件的结果
+//某个值在磁盘中已损坏时返回损坏的错误，之后 Get 该key时会修复
 func (db *MinDB) PrefixScan(prefix string, limit, offset int) (val [][]byte, err error) {
+	return db.PrefixScanContext(context.Background(), prefix, limit, offset)
+}
+
+// PrefixScanContext 与 PrefixScan 相同，ctx 被取消或超时后停止扫描，返回 ctx 的错误
+func (db *MinDB) PrefixScanContext(ctx context.Context, prefix string, limit, offset int) (val [][]byte, err error) {
 
 	if limit == 0 {
 		return
@@ -248,6 +326,8 @@ func (db *MinDB) PrefixScan(prefix string, limit, offset int) (val [][]byte, err
 	if err = db.checkKeyValue([]byte(prefix), nil); err != nil {
 		return
 	}
+	var expired [][]byte // 扫描到的已过期的key，释放读锁之后删除
+	defer func() { db.removeExpired(expired...) }()
 	// 对索引加读锁
 	db.strIndex.mu.RLock()
 	defer db.strIndex.mu.RUnlock()
@@ -255,32 +335,36 @@ func (db *MinDB) PrefixScan(prefix string, limit, offset int) (val [][]byte, err
 	e := db.strIndex.idxList.FindPrefix([]byte(prefix))
 
 	if limit > 0 { // 往后偏移offset个满足前缀的key
-		for i := 0; i < offset && e != nil && strings.HasPrefix(string(e.Key()), prefix); i++ {
-			e = e.Next()
+		for i := 0; i < offset && e != nil && strings.HasPrefix(string(e.Key()), prefix); e = e.Next() {
+			if !isInternalKey(e.Key()) {
+				i++
+			}
 		}
 	}
 
-	for e != nil && strings.HasPrefix(string(e.Key()), prefix) && limit != 0 {
-		item := e.Value().(*index.Indexer)  //item为e相应的索引信息
-		var value []byte
-
-		if db.config.IdxMode == KeyOnlyRamMode {  // 如果只有key存在内存
-			value, err = db.Get(e.Key())   // 就去磁盘中相应位置拿到value值
-			if err != nil {
-				return
-			}
-		} else {      // 如果键值都在内存，直接从索引信息中拿到value值
-			if item != nil {
-				value = item.Meta.Value
+	for i := 0; e != nil && strings.HasPrefix(string(e.Key()), prefix) && limit != 0; i, e = i+1, e.Next() {
+		if i%cancelCheckInterval == 0 {
+			if err = ctx.Err(); err != nil {
+				return nil, err
 			}
 		}
 
-		expired := db.expireIfNeeded(e.Key())  // 检查key是否过期
-		if !expired {       // 如果没有过期就加入到结果集中
-			val = append(val, value)
-			e = e.Next()
+		if isInternalKey(e.Key()) { // 内部key不返回，也不计入 limit
+			continue
 		}
-		if limit > 0 && !expired {   // limit减一然后进入下一个循环
+
+		// 已持有读锁，不能经过 Get：值损坏时 Get 修复需要写锁，此处直接返回损坏的错误，之后 Get 该key时会修复
+		value, getErr := db.getVal(e.Key())
+		if getErr == ErrKeyExpired { // 过期的key跳过，不计入 limit
+			expired = append(expired, e.Key())
+			continue
+		}
+		if getErr != nil {
+			return nil, getErr
+		}
+
+		val = append(val, value)
+		if limit > 0 {   // limit减一然后进入下一个循环
 			limit--
 		}
 	}
@@ -289,32 +373,44 @@ func (db *MinDB) PrefixScan(prefix string, limit, offset int) (val [][]byte, err
 
 // RangeScan 范围扫描，查找 key 从 start 到 end 之间的数据
 func (db *MinDB) RangeScan(start, end []byte) (val [][]byte, err error) {
+	return db.RangeScanContext(context.Background(), start, end)
+}
+
+// RangeScanContext 与 RangeScan 相同，ctx 被取消或超时后停止扫描，返回 ctx 的错误
+func (db *MinDB) RangeScanContext(ctx context.Context, start, end []byte) (val [][]byte, err error) {
+
+	var expired [][]byte // 扫描到的已过期的key，释放读锁之后删除
+	defer func() { db.removeExpired(expired...) }()
+
+	db.strIndex.mu.RLock()    // 加读锁对跳表进行操作
+	defer db.strIndex.mu.RUnlock()
 
 	node := db.strIndex.idxList.Get(start)  // 通过跳表的查找接口直接找到start对应的节点
 	if node == nil {    // 如果节点为空，则返回错误
 		return nil, ErrKeyNotExist
 	}
 
-	db.strIndex.mu.RLock()    // 加读锁对跳表进行操作
-	defer db.strIndex.mu.RUnlock()
+	for i := 0; node != nil && bytes.Compare(node.Key(), end) <= 0; i, node = i+1, node.Next() {  // 从start节点开始往后遍历，直接和end节点比较
+		if i%cancelCheckInterval == 0 {
+			if err = ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
 
-	for node != nil && bytes.Compare(node.Key(), end) <= 0 {  // 从start节点开始往后遍历，直接和end节点比较
-		if db.expireIfNeeded(node.Key()) {   // 如果中间某个节点过期了，就跳过该节点
-			node = node.Next()
+		// 与 PrefixScanContext 相同，持有读锁时不经过 Get，过期的key及内部key跳过，值损坏时返回错误
+		if isInternalKey(node.Key()) {
 			continue
 		}
-		var value []byte
-		if db.config.IdxMode == KeyOnlyRamMode {  // 仍然是要判断配置的是键值都在内存中还是另一种
-			value, err = db.Get(node.Key())
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			value = node.Value().(*index.Indexer).Meta.Value
+		value, getErr := db.getVal(node.Key())
+		if getErr == ErrKeyExpired {
+			expired = append(expired, node.Key())
+			continue
+		}
+		if getErr != nil {
+			return nil, getErr
 		}
 
 		val = append(val, value)    // 将查出来的value放入结果集中
-		node = node.Next()
 	}
 
 	return
@@ -322,8 +418,8 @@ func (db *MinDB) RangeScan(start, end []byte) (val [][]byte, err error) {
 
 // Expire 设置key的过期时间
 func (db *MinDB) Expire(key []byte, seconds uint32) (err error) {
-	if exist := db.StrExists(key); !exist {
-		return ErrKeyNotExist
+	if err = db.checkKeyValue(key, nil); err != nil {
+		return
 	}
 	if seconds <= 0 {
 		return ErrInvalidTTL
@@ -332,24 +428,132 @@ func (db *MinDB) Expire(key []byte, seconds uint32) (err error) {
 	db.strIndex.mu.Lock()
 	defer db.strIndex.mu.Unlock()
 
-	deadline := uint32(time.Now().Unix()) + seconds
+	// 在写锁内检查key是否存在，避免检查之后、写入之前key被删除，为已删除的key写入过期时间
+	if !db.strIndex.idxList.Exist(key) || db.expireIfNeeded(key) {
+		return ErrKeyNotExist
+	}
+	deadline, ok := deadlineAfter(uint32(time.Now().Unix()), seconds)
+	if !ok {
+		return ErrInvalidTTL
+	}
+	e := storage.NewEntryNoExtra(key, nil, String, StringExpire) // 过期时间随entry一起持久化
+	e.Deadline = uint64(deadline)
+	if err = db.store(e); err != nil {
+		return
+	}
+
 	db.expires[string(key)] = deadline
 	return
 }
 
+// 计算 now 之后 seconds 秒的过期时间，超出 uint32 的表示范围时返回 false，避免回绕成一个已经过去的时间
+func deadlineAfter(now, seconds uint32) (uint32, bool) {
+	if seconds > math.MaxUint32-now {
+		return 0, false
+	}
+	return now + seconds, true
+}
+
+// ExpireAt 设置key在 deadline（Unix 秒）时过期，deadline 已经过去时直接删除key
+// 用于导入带有绝对过期时间的数据，保证key与导出前在同一时刻过期
+func (db *MinDB) ExpireAt(key []byte, deadline uint32) (err error) {
+	if exist := db.StrExists(key); !exist {
+		return ErrKeyNotExist
+	}
+	now := uint32(time.Now().Unix())
+	if deadline <= now {
+		return db.StrRem(key)
+	}
+	return db.Expire(key, deadline-now)
+}
+
 // Persist 清除key的过期时间
 func (db *MinDB) Persist(key []byte) {
 
 	db.strIndex.mu.Lock()
 	defer db.strIndex.mu.Unlock()
 
+	if _, exist := db.expires[string(key)]; !exist {
+		return
+	}
+
+	e := storage.NewEntryNoExtra(key, nil, String, StringPersist)
+	if err := db.store(e); err != nil {
+		log.Printf("persist key err [%+v] [%+v]\n", key, err)
+		return
+	}
 	delete(db.expires, string(key))
 }
 
+// ExpireMulti 为多个key设置过期时间，ttls 为key到过期秒数的映射，返回设置了过期时间的key数量，不存在的key被忽略
+// 所有key在一次加锁内完成，开启 Sync 时只在最后持久化一次，适合缓存预热等一次设置大量过期时间的场景
+func (db *MinDB) ExpireMulti(ttls map[string]uint32) (n int, err error) {
+	now := uint32(time.Now().Unix())
+	deadlines := make(map[string]uint32, len(ttls))
+	for key, seconds := range ttls {
+		deadline, ok := deadlineAfter(now, seconds)
+		if seconds <= 0 || !ok {
+			return 0, ErrInvalidTTL
+		}
+		deadlines[key] = deadline
+	}
+
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	for key, deadline := range deadlines {
+		k := []byte(key)
+		if !db.strIndex.idxList.Exist(k) || db.expireIfNeeded(k) {
+			continue
+		}
+		e := storage.NewEntryNoExtra(k, nil, String, StringExpire)
+		e.Deadline = uint64(deadline)
+		if err = db.write(e); err != nil {
+			break
+		}
+		db.expires[key] = deadline
+		n++
+	}
+	if syncErr := db.syncActive(String, n); err == nil {
+		err = syncErr
+	}
+	return
+}
+
+// PersistMulti 清除多个key的过期时间，返回清除了过期时间的key数量，持久化方式与 ExpireMulti 相同
+func (db *MinDB) PersistMulti(keys ...[]byte) (n int, err error) {
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	for _, key := range keys {
+		if _, exist := db.expires[string(key)]; !exist || db.expireIfNeeded(key) {
+			continue
+		}
+		if err = db.write(storage.NewEntryNoExtra(key, nil, String, StringPersist)); err != nil {
+			break
+		}
+		delete(db.expires, string(key))
+		n++
+	}
+	if syncErr := db.syncActive(String, n); err == nil {
+		err = syncErr
+	}
+	return
+}
+
+// 写入 n 条entry后，开启 Sync 时持久化该类型的活跃文件
+func (db *MinDB) syncActive(dataType DataType, n int) error {
+	if n == 0 || !db.config.Sync {
+		return nil
+	}
+	activeFile, _ := db.getActiveFile(dataType)
+	return activeFile.Sync()
+}
+
 // TTL 获取key的过期时间
 func (db *MinDB) TTL(key []byte) (ttl uint32) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	db.strIndex.mu.Lock() // 与其他字符串操作一样使用字符串索引的锁，过期时会删除key
+	defer db.strIndex.mu.Unlock()
 
 	if db.expireIfNeeded(key) {
 		return
@@ -366,29 +570,59 @@ func (db *MinDB) TTL(key []byte) (ttl uint32) {
 	return
 }
 
-//检查key是否过期并删除相应的值
-func (db *MinDB) expireIfNeeded(key []byte) (expired bool) {
+// 判断key是否已过期，不删除key，持有字符串索引的读锁即可调用
+func (db *MinDB) isExpired(key []byte) bool {
 	deadline := db.expires[string(key)]
-	if deadline <= 0 {
+	return deadline > 0 && time.Now().Unix() > int64(deadline)
+}
+
+// 持有读锁时发现的过期key，释放读锁之后通过它删除，加写锁之后会重新检查过期时间
+func (db *MinDB) removeExpired(keys ...[]byte) {
+	if len(keys) == 0 {
+		return
+	}
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	if !db.isOpen() {
 		return
 	}
+	for _, key := range keys {
+		db.expireIfNeeded(key)
+	}
+}
 
-	if time.Now().Unix() > int64(deadline) {
+//检查key是否过期并删除相应的值，调用方需持有字符串索引的写锁
+func (db *MinDB) expireIfNeeded(key []byte) (expired bool) {
+	if db.isExpired(key) {
 		expired = true
 		//删除过期字典对应的key
 		delete(db.expires, string(key))
 
-		//删除索引及数据
-		if ele := db.strIndex.idxList.Remove(key); ele != nil {
+		//删除索引及数据，只有真正删除了key的调用通知过期，同一个key只会通知一次
+		if ele := db.removeStrIndex(key); ele != nil {
 			e := storage.NewEntryNoExtra(key, nil, String, StringRem)
 			if err := db.store(e); err != nil {
 				log.Printf("remove expired key err [%+v] [%+v]\n", key, err)
+			} else {
+				db.markStrRemoved(ele.Value().(*index.Indexer), e)
 			}
+			db.notifyExpired(key, String)
 		}
 	}
 	return
 }
 
+// 删除字符串后，原来的数据和删除记录本身都成为可回收的空间，同时更新计数视图
+func (db *MinDB) markStrRemoved(old *index.Indexer, rem *storage.Entry) {
+	db.markDead(String, old.FileId, old.EntrySize)
+	db.markStrPatchesDead(rem.Meta.Key)
+	delete(db.strIndex.patches, string(rem.Meta.Key))
+	_, activeFileId := db.getActiveFile(String)
+	db.markDead(String, activeFileId, rem.Size())
+	db.updateCountViews(rem.Meta.Key, -1)
+}
+
 func (db *MinDB) doSet(key, value []byte) (err error) {
 	if err = db.checkKeyValue(key, value); err != nil {
 		return err
@@ -396,7 +630,10 @@ func (db *MinDB) doSet(key, value []byte) (err error) {
 
 	// 如果新增的 value 和设置的 value 一样，则不做任何操作
 	if db.config.IdxMode == KeyValueRamMode {
-		if existVal, _ := db.Get(key); existVal != nil && bytes.Compare(existVal, value) == 0 {
+		db.strIndex.mu.RLock()
+		existVal, _ := db.getVal(key) // 不经过 Get，不触发读取的监听
+		db.strIndex.mu.RUnlock()
+		if existVal != nil && bytes.Compare(existVal, value) == 0 {
 			return
 		}
 	}
@@ -405,23 +642,183 @@ func (db *MinDB) doSet(key, value []byte) (err error) {
 	defer db.strIndex.mu.Unlock()
 
 	e := storage.NewEntryNoExtra(key, value, String, StringSet)
+	e.Deadline = db.liveDeadline(key) // 保留当前的过期时间
+	return db.setEntry(e)
+}
+
+// 写入字符串的值并保留原有的过期时间，调用方需持有字符串索引的写锁
+func (db *MinDB) setKeepTTL(key, value []byte) error {
+	if err := db.checkKeyValue(key, value); err != nil {
+		return err
+	}
+
+	e := storage.NewEntryNoExtra(key, value, String, StringSet)
+	e.Deadline = db.liveDeadline(key)
+	return db.setEntry(e)
+}
+
+// 返回key仍然有效的过期时间，没有过期时间或已经过期时返回0，已过期的key先按过期删除，调用方需持有字符串索引的写锁
+// 不能把已经过去的过期时间写入新的值，否则 KeyOnlyRamMode 下或重新打开后新的值会立即被当作过期
+func (db *MinDB) liveDeadline(key []byte) uint64 {
+	if db.expireIfNeeded(key) {
+		return 0
+	}
+	return uint64(db.expires[string(key)])
+}
+
+// 写入一条 StringSet 的entry并更新索引，entry 中的过期时间同时生效，调用方需持有字符串索引的写锁
+func (db *MinDB) setEntry(e *storage.Entry) (err error) {
 	if err := db.store(e); err != nil {
 		return err
 	}
+	activeFile, activeFileId := db.getActiveFile(String)
+	return db.indexStrEntry(e, activeFileId, activeFile.Offset-int64(e.Size()))
+}
+
+// 为已写入文件 fileId 中 offset 处的 StringSet entry 更新索引，调用方需持有字符串索引的写锁
+func (db *MinDB) indexStrEntry(e *storage.Entry, fileId uint32, offset int64) (err error) {
+	key := e.Meta.Key
+	node := db.strIndex.idxList.Get(key)
+	if node != nil { // 旧的数据被覆盖，成为可回收的空间
+		old := node.Value().(*index.Indexer)
+		db.markDead(String, old.FileId, old.EntrySize)
+		db.markStrPatchesDead(key)
+	}
 
 	//数据索引  store in skiplist.
 	idx := &index.Indexer{
 		Meta: &storage.Meta{
-			KeySize: uint32(len(e.Meta.Key)),
-			Key:     e.Meta.Key,
+			KeySize:   uint32(len(e.Meta.Key)),
+			Key:       e.Meta.Key,
+			ValueSize: uint32(len(e.Meta.Value)),
 		},
-		FileId:    db.activeFileIds[String],
+		FileId:    fileId,
 		EntrySize: e.Size(),
-		Offset:    db.activeFile[String].Offset - int64(e.Size()),
+		Offset:    offset,
 	}
 
 	if err = db.buildIndex(e, idx); err != nil {
 		return err
 	}
+	if node == nil {
+		db.updateCountViews(key, 1)
+	}
 	return
 }
+
+// 从数据文件中读取索引指向的字符串entry
+func (db *MinDB) readStrEntry(idx *index.Indexer) (*storage.Entry, error) {
+	df, activeFileId := db.getActiveFile(String)
+	if idx.FileId != activeFileId {
+		df = db.archFiles[String][idx.FileId]
+	}
+	return df.Read(idx.Offset)
+}
+
+// 单个key最多累积的增量修改数，达到后下一次修改写入完整的值，避免读取时需要合并过多的修改
+const maxStrPatches = 64
+
+// 将一次部分修改作为增量写入并更新索引，调用方需持有字符串索引的写锁
+func (db *MinDB) setPatch(key []byte, offset int, value []byte) error {
+	e := storage.NewEntry(key, value, []byte(strconv.Itoa(offset)), String, StringPatch)
+	if err := db.store(e); err != nil {
+		return err
+	}
+
+	activeFile, activeFileId := db.getActiveFile(String)
+	idx := &index.Indexer{
+		Meta: &storage.Meta{
+			KeySize: uint32(len(key)),
+			Key:     key,
+		},
+		FileId:    activeFileId,
+		EntrySize: e.Size(),
+		Offset:    activeFile.Offset - int64(e.Size()),
+	}
+	return db.buildIndex(e, idx)
+}
+
+// 在key原来的值上应用一条增量修改，key 不存在（已被删除或覆盖）时忽略
+func (db *MinDB) buildStrPatch(e *storage.Entry, idx *index.Indexer) {
+	if db.strIndex == nil || idx == nil {
+		return
+	}
+	node := db.strIndex.idxList.Get(e.Meta.Key)
+	if node == nil {
+		return
+	}
+	offset, err := strconv.Atoi(string(e.Meta.Extra))
+	if err != nil {
+		return
+	}
+
+	key := string(e.Meta.Key)
+	db.strIndex.patches[key] = append(db.strIndex.patches[key], idx)
+
+	base := node.Value().(*index.Indexer)
+	if db.config.IdxMode == KeyValueRamMode {
+		base.Meta.Value = patchValue(base.Meta.Value, offset, e.Meta.Value)
+		if db.config.VerifyValueOnRead { // 内存中的值已经合并了修改，校验和随之更新
+			base.Checksum = db.config.Checksum.Sum(base.Meta.Value)
+		}
+	}
+	if size := uint32(offset + len(e.Meta.Value)); size > base.Meta.ValueSize {
+		db.strIndex.sizes.add(e.Meta.Key, 0, int64(size-base.Meta.ValueSize))
+		base.Meta.ValueSize = size
+	}
+}
+
+// 依次读取key的所有增量修改并应用到value上，用于只有key在内存中的模式
+func (db *MinDB) applyStrPatches(key, value []byte) ([]byte, error) {
+	for _, idx := range db.strIndex.patches[string(key)] {
+		e, err := db.readStrEntryOf(key, idx)
+		if err != nil {
+			return nil, err
+		}
+		offset, err := strconv.Atoi(string(e.Meta.Extra))
+		if err != nil {
+			return nil, err
+		}
+		value = patchValue(value, offset, e.Meta.Value)
+	}
+	return value, nil
+}
+
+// key的值被覆盖或删除后，它的增量修改都成为可回收的空间
+func (db *MinDB) markStrPatchesDead(key []byte) {
+	for _, idx := range db.strIndex.patches[string(key)] {
+		db.markDead(String, idx.FileId, idx.EntrySize)
+	}
+}
+
+// 回收时将字符串的增量修改合并到其完整的值中，合并后的值写入新文件
+func (db *MinDB) materializeStr(e *storage.Entry) error {
+	if len(db.strIndex.patches[string(e.Meta.Key)]) == 0 {
+		return nil
+	}
+
+	var value []byte
+	if db.config.IdxMode == KeyValueRamMode {
+		value = db.strIndex.idxList.Get(e.Meta.Key).Value().(*index.Indexer).Meta.Value
+	} else {
+		var err error
+		if value, err = db.applyStrPatches(e.Meta.Key, e.Meta.Value); err != nil {
+			return err
+		}
+	}
+	e.Meta.Value = value
+	e.Meta.ValueSize = uint32(len(value))
+	return nil
+}
+
+// 将patch写入value的offset处，返回新的字节数组，不修改原来的value（读取方可能还在使用）
+func patchValue(value []byte, offset int, patch []byte) []byte {
+	size := len(value)
+	if end := offset + len(patch); end > size {
+		size = end
+	}
+	v := make([]byte, size)
+	copy(v, value)
+	copy(v[offset:], patch)
+	return v
+}
@@ -0,0 +1,132 @@
+package mindb
+
+import (
+	"fmt"
+	"math"
+	"mindb/index"
+	"mindb/storage"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 损坏一个字符串的值，返回其索引
+func corruptStrValue(t *testing.T, db *MinDB, key []byte) *index.Indexer {
+	t.Helper()
+	node := db.strIndex.idxList.Get(key)
+	if node == nil {
+		t.Fatalf("key %s not found", key)
+	}
+	idx := node.Value().(*index.Indexer)
+
+	if db.config.IdxMode == KeyValueRamMode {
+		idx.Meta.Value[0] ^= 0xff
+		return idx
+	}
+	name := fmt.Sprintf(storage.DBFileFormatNames[String], idx.FileId)
+	f, err := os.OpenFile(filepath.Join(db.config.DirPath, name), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// 值位于entry的末尾
+	b := make([]byte, 1)
+	off := idx.Offset + int64(idx.EntrySize) - 1
+	if _, err = f.ReadAt(b, off); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xff
+	if _, err = f.WriteAt(b, off); err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+// 扫描中遇到损坏的值时不能因为修复需要写锁而死锁
+func TestPrefixScanCorruptValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   DataIndexMode
+		verify bool
+	}{
+		{"KeyOnlyRamMode", KeyOnlyRamMode, false},
+		{"KeyValueRamModeVerify", KeyValueRamMode, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.DirPath = t.TempDir()
+			cfg.IdxMode = tt.mode
+			cfg.VerifyValueOnRead = tt.verify
+			db, err := Open(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			deadlocked := false
+			defer func() {
+				if !deadlocked { // 死锁时 Close 也会被阻塞
+					db.Close()
+				}
+			}()
+
+			for i := 1; i <= 3; i++ {
+				if err := db.Set([]byte(fmt.Sprintf("p:%d", i)), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			corruptStrValue(t, db, []byte("p:2"))
+
+			done := make(chan error, 1)
+			go func() {
+				_, err := db.PrefixScan("p:", -1, 0)
+				done <- err
+			}()
+			select {
+			case err = <-done:
+			case <-time.After(5 * time.Second):
+				deadlocked = true
+				t.Fatal("PrefixScan deadlocked on a corrupt value")
+			}
+			if !isCorruption(err) {
+				t.Fatalf("PrefixScan err = %v, want a corruption error", err)
+			}
+
+			// 之后的读取不受影响，Get 修复损坏的key
+			if v, err := db.Get([]byte("p:1")); err != nil || string(v) != "value-1" {
+				t.Fatalf("Get p:1 = %q, %v", v, err)
+			}
+			if _, err := db.Get([]byte("p:2")); err != nil && err != ErrKeyNotExist {
+				t.Fatalf("Get p:2 after repair: %v", err)
+			}
+			if _, err := db.PrefixScan("p:", -1, 0); err != nil {
+				t.Fatalf("PrefixScan after repair: %v", err)
+			}
+		})
+	}
+}
+
+// 过期时间超出 uint32 的表示范围时返回 ErrInvalidTTL，不能回绕成一个已经过去的时间
+func TestExpireTTLOverflow(t *testing.T) {
+	db := openTestDB(t, KeyValueRamMode)
+	key := []byte("k")
+	if err := db.Set(key, []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Expire(key, math.MaxUint32); err != ErrInvalidTTL {
+		t.Fatalf("Expire = %v, want %v", err, ErrInvalidTTL)
+	}
+	if _, err := db.ExpireMulti(map[string]uint32{"k": math.MaxUint32}); err != ErrInvalidTTL {
+		t.Fatalf("ExpireMulti = %v, want %v", err, ErrInvalidTTL)
+	}
+	if _, err := db.AcquireLock([]byte("lock"), math.MaxUint32); err != ErrInvalidTTL {
+		t.Fatalf("AcquireLock = %v, want %v", err, ErrInvalidTTL)
+	}
+	if !db.StrExists(key) || db.TTL(key) != 0 {
+		t.Fatalf("key should still exist without ttl, ttl = %d", db.TTL(key))
+	}
+	if err := db.Expire([]byte("missing"), 10); err != ErrKeyNotExist {
+		t.Fatalf("Expire missing key = %v, want %v", err, ErrKeyNotExist)
+	}
+}
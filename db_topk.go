@@ -0,0 +1,131 @@
+package mindb
+
+import (
+	"errors"
+	"mindb/ds/topk"
+)
+
+//---------基于字符串的 Top-K 相关操作接口-----------
+
+var (
+	// ErrNotTopK key上已经存在其他的值
+	ErrNotTopK = errors.New("mindb: the value of key is not a top-k")
+
+	// ErrTopKExists key上已经存在 Top-K
+	ErrTopKExists = errors.New("mindb: the top-k already exists")
+
+	// ErrInvalidTopKParam Top-K 的参数不合法
+	ErrInvalidTopKParam = errors.New("mindb: k, width and depth must be positive")
+)
+
+// Top-K 默认的 Count-Min Sketch 大小
+const (
+	DefaultTopKWidth = 1000
+	DefaultTopKDepth = 5
+)
+
+// TopKReserve 在 key 上新建一个统计出现次数最多的 k 个元素的 Top-K，width、depth 为 Count-Min Sketch 的列数和行数
+// Top-K 整体编码后作为字符串的值保存，占用的空间约为 width * depth * 4 字节，不能超过 value 的最大值
+func (db *MinDB) TopKReserve(key []byte, k, width, depth uint32) error {
+	if k == 0 || width == 0 || depth == 0 {
+		return ErrInvalidTopKParam
+	}
+
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	if db.strIndex.idxList.Exist(key) && !db.expireIfNeeded(key) {
+		return ErrTopKExists
+	}
+	return db.saveTopK(key, topk.New(k, width, depth))
+}
+
+// TopKAdd 增加元素的出现次数，返回每个元素加入后被挤出 top-k 的元素，没有被挤出的元素时对应位置为 nil
+func (db *MinDB) TopKAdd(key []byte, items ...[]byte) (expelled [][]byte, err error) {
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	t, err := db.getTopK(key)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		var out []byte
+		if e := t.Add(item); e != nil {
+			out = []byte(e.Key)
+		}
+		expelled = append(expelled, out)
+	}
+	if err = db.saveTopK(key, t); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// TopKQuery 判断元素是否在 top-k 中
+func (db *MinDB) TopKQuery(key []byte, items ...[]byte) ([]bool, error) {
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	t, err := db.getTopK(key)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]bool, len(items))
+	for i, item := range items {
+		res[i] = t.Query(item)
+	}
+	return res, nil
+}
+
+// TopKCount 返回元素估计的出现次数，估计值不会小于实际次数
+func (db *MinDB) TopKCount(key []byte, items ...[]byte) ([]uint32, error) {
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	t, err := db.getTopK(key)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]uint32, len(items))
+	for i, item := range items {
+		res[i] = t.Count(item)
+	}
+	return res, nil
+}
+
+// TopKList 返回 top-k 中的元素，按估计次数从大到小排列
+func (db *MinDB) TopKList(key []byte) ([]topk.Item, error) {
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	t, err := db.getTopK(key)
+	if err != nil {
+		return nil, err
+	}
+	return t.List(), nil
+}
+
+// 读取并解码 key 上的 Top-K，调用方需持有字符串索引的写锁（过期的key会被删除）
+func (db *MinDB) getTopK(key []byte) (*topk.TopK, error) {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return nil, err
+	}
+	if !db.strIndex.idxList.Exist(key) || db.expireIfNeeded(key) {
+		return nil, ErrKeyNotExist
+	}
+
+	val, err := db.getVal(key)
+	if err != nil {
+		return nil, err
+	}
+	if !topk.IsTopK(val) {
+		return nil, ErrNotTopK
+	}
+	return topk.Decode(val)
+}
+
+// 编码并写入 Top-K，保留 key 原有的过期时间，调用方需持有字符串索引的写锁
+func (db *MinDB) saveTopK(key []byte, t *topk.TopK) error {
+	return db.setKeepTTL(key, t.Encode())
+}
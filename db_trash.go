@@ -0,0 +1,147 @@
+package mindb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"mindb/index"
+	"mindb/storage"
+	"time"
+)
+
+var (
+	// ErrUndeleteKeyExists 要恢复的key已经重新写入了值
+	ErrUndeleteKeyExists = errors.New("mindb: the key already exists, can not undelete it")
+)
+
+// 回收站中key的前缀，是内部key（见 InternalKeyPrefix），不会与用户的key冲突
+const trashPrefix = "\x00trash\x00"
+
+// 回收站中保存的值：原来的过期时间（8字节）+ 原来的值
+const trashDeadlineSize = 8
+
+// 开启软删除（TrashTTL > 0）时，StrRem 删除的字符串先移到回收站中保留 TrashTTL 秒，期间可以通过 Undelete 恢复，
+// 到期后与普通的key一样过期删除，但不会触发 OnExpired 的回调；回收站中的key在同一个字符串索引中，以 trashPrefix 开头，
+// 只能通过 TrashKeys、Undelete、Purge 访问，遍历及统计key时都会跳过
+// 目前只有字符串支持删除整个key，其他类型的删除针对的是其中的元素，不经过回收站
+
+func trashKey(key []byte) []byte {
+	return append([]byte(trashPrefix), key...)
+}
+
+func isTrashKey(key []byte) bool {
+	return bytes.HasPrefix(key, []byte(trashPrefix))
+}
+
+// 将要删除的字符串移到回收站，同名的key已经在回收站中时被覆盖，调用方需持有字符串索引的写锁
+func (db *MinDB) moveToTrash(key []byte) error {
+	value, err := db.getVal(key)
+	if err != nil {
+		return err
+	}
+
+	v := make([]byte, trashDeadlineSize+len(value))
+	binary.BigEndian.PutUint64(v, uint64(db.expires[string(key)]))
+	copy(v[trashDeadlineSize:], value)
+	e := storage.NewEntryNoExtra(trashKey(key), v, String, StringSet)
+	e.Deadline = uint64(time.Now().Unix() + db.config.TrashTTL)
+	return db.setEntry(e)
+}
+
+// 删除字符串key及其数据，返回key是否存在，调用方需持有字符串索引的写锁
+func (db *MinDB) removeStr(key []byte) (bool, error) {
+	ele := db.removeStrIndex(key)
+	if ele == nil {
+		return false, nil
+	}
+	delete(db.expires, string(key))
+	e := storage.NewEntryNoExtra(key, nil, String, StringRem)
+	if err := db.store(e); err != nil {
+		return true, err
+	}
+	db.markStrRemoved(ele.Value().(*index.Indexer), e)
+	return true, nil
+}
+
+// Undelete 从回收站中恢复被删除的字符串，值及原来的过期时间一起恢复，返回回收站中是否有这个key
+// key 在删除后又被写入了值时返回 ErrUndeleteKeyExists，回收站中的值保持不变
+func (db *MinDB) Undelete(key []byte) (bool, error) {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return false, err
+	}
+
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	tk := trashKey(key)
+	v, err := db.getVal(tk)
+	if err == ErrKeyNotExist || err == ErrKeyExpired {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if db.strIndex.idxList.Exist(key) && !db.expireIfNeeded(key) {
+		return false, ErrUndeleteKeyExists
+	}
+	if len(v) < trashDeadlineSize {
+		return false, storage.ErrInvalidEntry
+	}
+
+	deadline := binary.BigEndian.Uint64(v)
+	if deadline == 0 || int64(deadline) > time.Now().Unix() { // 原来的过期时间已经过了时不再恢复
+		e := storage.NewEntryNoExtra(key, v[trashDeadlineSize:], String, StringSet)
+		e.Deadline = deadline
+		if err = db.setEntry(e); err != nil {
+			return false, err
+		}
+	}
+	_, err = db.removeStr(tk)
+	return err == nil, err
+}
+
+// Purge 从回收站中永久删除指定的key，不指定时清空回收站，返回删除的数量
+func (db *MinDB) Purge(keys ...[]byte) (n int, err error) {
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	var trashKeys [][]byte
+	if len(keys) == 0 {
+		for e := db.strIndex.idxList.FindPrefix([]byte(trashPrefix)); e != nil && isTrashKey(e.Key()); e = e.Next() {
+			trashKeys = append(trashKeys, e.Key())
+		}
+	} else {
+		for _, key := range keys {
+			trashKeys = append(trashKeys, trashKey(key))
+		}
+	}
+
+	for _, tk := range trashKeys {
+		if db.expireIfNeeded(tk) {
+			continue
+		}
+		removed, err := db.removeStr(tk)
+		if err != nil {
+			return n, err
+		}
+		if removed {
+			n++
+		}
+	}
+	return
+}
+
+// TrashKeys 返回回收站中的key（删除前的名称）
+func (db *MinDB) TrashKeys() (keys [][]byte) {
+	db.strIndex.mu.RLock()
+	defer db.strIndex.mu.RUnlock()
+
+	now := uint32(time.Now().Unix())
+	for e := db.strIndex.idxList.FindPrefix([]byte(trashPrefix)); e != nil && isTrashKey(e.Key()); e = e.Next() {
+		if deadline, ok := db.expires[string(e.Key())]; ok && deadline <= now {
+			continue
+		}
+		keys = append(keys, append([]byte{}, e.Key()[len(trashPrefix):]...))
+	}
+	return
+}
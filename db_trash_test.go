@@ -0,0 +1,89 @@
+package mindb
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 回收站中的key不出现在遍历、扫描、抽样及统计中，恢复后重新可见，回收站到期删除时不回调 OnExpired
+func TestTrashKeysHidden(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DirPath = t.TempDir()
+	cfg.TrashTTL = 1
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var fired int32
+	db.OnExpired(func(key []byte, dataType DataType) { atomic.AddInt32(&fired, 1) })
+
+	key := []byte("foo")
+	if err = db.Set(key, []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.StrRem(key); err != nil {
+		t.Fatal(err)
+	}
+
+	visible := func() (keys []string) {
+		db.IterateAll(func(dataType DataType, key []byte) bool {
+			keys = append(keys, "iterate:"+string(key))
+			return true
+		})
+		for _, s := range db.SampleKeys(10) {
+			keys = append(keys, "sample:"+string(s.Key))
+		}
+		_, scanned, err := db.Scan(nil, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range scanned {
+			keys = append(keys, "scan:"+string(s.Key))
+		}
+		for it := db.NewIterator(IteratorOptions{}); it.Next(); {
+			keys = append(keys, "iterator:"+string(it.Key()))
+		}
+		vals, err := db.PrefixScan(trashPrefix, -1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range vals {
+			keys = append(keys, "prefix:"+string(v))
+		}
+		if n := db.Stats().Keys[String]; n > 0 {
+			keys = append(keys, "stats")
+		}
+		return
+	}
+	if keys := visible(); len(keys) != 0 {
+		t.Fatalf("deleted key visible: %q", keys)
+	}
+	if trash := db.TrashKeys(); len(trash) != 1 || string(trash[0]) != "foo" {
+		t.Fatalf("TrashKeys = %q, want [foo]", trash)
+	}
+
+	if ok, err := db.Undelete(key); err != nil || !ok {
+		t.Fatalf("Undelete = %v, %v", ok, err)
+	}
+	if keys := visible(); len(keys) != 5 {
+		t.Fatalf("undeleted key visible as %q, want it in all 5 paths", keys)
+	}
+
+	if err = db.StrRem(key); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Second)
+	if _, expired := db.expireSample(10); expired != 1 {
+		t.Fatalf("expired %d keys, want the trash key", expired)
+	}
+	if len(db.TrashKeys()) != 0 {
+		t.Fatal("trash key not removed after TrashTTL")
+	}
+	db.expiry.close() // 等待排队的回调执行完成
+	if n := atomic.LoadInt32(&fired); n != 0 {
+		t.Fatalf("OnExpired fired %d times for a trash key", n)
+	}
+}
@@ -0,0 +1,573 @@
+package mindb
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"mindb/storage"
+	"mindb/utils"
+	"sort"
+)
+
+var (
+	// ErrTxNotWritable 在 View 的只读事务中写入
+	ErrTxNotWritable = errors.New("mindb: tx not writable")
+
+	// ErrTxClosed 事务的函数返回后继续使用事务
+	ErrTxClosed = errors.New("mindb: tx closed")
+
+	// ErrTxConflict 事务读取过的key在事务结束之前被其他写入修改，事务中的写入没有提交
+	ErrTxConflict = errors.New("mindb: tx conflict, a key read by the tx was modified")
+)
+
+// Tx 嵌入使用时的事务，通过 Update 或 View 获得，只能在传入的函数中使用
+//
+// 写入先缓冲在事务中，函数返回 nil 后作为一个批量写入（见 WriteBatch）提交，函数返回错误或 panic 时全部丢弃；
+// 读写事务中的读取在数据库中已提交的数据之上叠加本事务还没有提交的写入，不包括提交后按长度上限的删除。
+//
+// 事务之间：Update 之间互斥，Update 与 View 互斥，多个 View 可以并发执行。
+// 与不经过事务的写入（直接调用 MinDB 的方法）之间使用乐观的并发控制：事务在读取每个key之前开始监视它的版本（见 Watch），
+// 结束时检查读取过的key是否被修改过，被修改过时返回 ErrTxConflict，Update 不提交任何写入，调用方可以重新执行事务。
+// View 成功返回时其中的所有读取都是同一时刻的一致的数据；Update 的检查与提交在同一次持有涉及类型（读取及写入的类型）的索引锁时完成，
+// 成功提交时读取的数据在提交的时刻仍然有效，不会覆盖事务期间其他的写入。
+// 版本按key记录，同名的不同类型的key之间、读取时恰好过期的字符串也会被视为冲突；事务中没有读取的key不检查
+type Tx struct {
+	db        *MinDB
+	batch     *WriteBatch       // 只读事务为 nil
+	reads     map[string]uint64 // 读取过的key及读取之前的版本
+	readTypes [5]bool           // 读取过的数据类型
+	closed    bool
+}
+
+// Update 在读写事务中执行 fn，fn 返回 nil 时提交事务中的写入并返回提交的结果，否则丢弃写入并返回 fn 的错误
+// 事务读取过的key在提交之前被修改过时不提交，返回 ErrTxConflict，见 Tx
+func (db *MinDB) Update(fn func(tx *Tx) error) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	tx := &Tx{db: db, batch: db.NewWriteBatch()}
+	defer tx.unwatch()
+	if err := tx.run(fn); err != nil {
+		return err
+	}
+	if tx.batch.Len() == 0 {
+		return tx.validate()
+	}
+	return tx.batch.commitIf(tx.readTypeList(), tx.validate)
+}
+
+// View 在只读事务中执行 fn，期间其他的 Update 不会执行
+// fn 返回 nil 但读取过的key在此期间被修改过时返回 ErrTxConflict，此时 fn 读到的数据可能不一致，见 Tx
+func (db *MinDB) View(fn func(tx *Tx) error) error {
+	db.txMu.RLock()
+	defer db.txMu.RUnlock()
+
+	tx := &Tx{db: db}
+	defer tx.unwatch()
+	if err := tx.run(fn); err != nil {
+		return err
+	}
+	return tx.validate()
+}
+
+// 执行事务的函数，函数返回或 panic 后事务不能再使用
+func (tx *Tx) run(fn func(tx *Tx) error) error {
+	defer func() {
+		tx.closed = true
+	}()
+	return fn(tx)
+}
+
+// 记录事务读取的key，在读取之前开始监视key的版本，事务结束时据此检查key在读取之后是否被修改过
+// 读取已过期的字符串会删除key并使版本变化，因此先删除已过期的key，避免事务与自己的读取冲突
+func (tx *Tx) watch(dataType DataType, key []byte) {
+	if dataType == String {
+		tx.db.StrExists(key)
+	}
+	tx.readTypes[dataType] = true
+	if _, ok := tx.reads[string(key)]; ok {
+		return
+	}
+	if tx.reads == nil {
+		tx.reads = make(map[string]uint64)
+	}
+	tx.reads[string(key)] = tx.db.Watch(key)
+}
+
+// 检查读取过的key在读取之后是否被修改过
+func (tx *Tx) validate() error {
+	if !tx.db.versions.unchanged(tx.reads) {
+		return ErrTxConflict
+	}
+	return nil
+}
+
+// 停止监视事务读取过的key
+func (tx *Tx) unwatch() {
+	for key := range tx.reads {
+		tx.db.Unwatch([]byte(key))
+	}
+	tx.reads = nil
+}
+
+// 读取过的数据类型，提交时需要同时持有这些类型的索引锁
+func (tx *Tx) readTypeList() (types []DataType) {
+	for _, dataType := range DataTypes {
+		if tx.readTypes[dataType] {
+			types = append(types, dataType)
+		}
+	}
+	return
+}
+
+// Writable 是否为读写事务
+func (tx *Tx) Writable() bool {
+	return tx.batch != nil
+}
+
+// 检查事务是否可以写入
+func (tx *Tx) writable() error {
+	if tx.closed {
+		return ErrTxClosed
+	}
+	if tx.batch == nil {
+		return ErrTxNotWritable
+	}
+	return nil
+}
+
+//---------事务中的读取，与 MinDB 的同名方法相同，读写事务中包含本事务还没有提交的写入-----------
+
+// 本事务中还没有提交的对某类型的 key 的写入，按添加的顺序
+func (tx *Tx) pending(dataType DataType, key []byte) (ops []*storage.Entry) {
+	if tx.batch == nil {
+		return nil
+	}
+	for _, e := range tx.batch.ops {
+		if e.Type == dataType && bytes.Equal(e.Meta.Key, key) {
+			ops = append(ops, e)
+		}
+	}
+	return
+}
+
+// Get 获取字符串的值
+func (tx *Tx) Get(key []byte) ([]byte, error) {
+	if tx.closed {
+		return nil, ErrTxClosed
+	}
+	tx.watch(String, key)
+	if ops := tx.pending(String, key); len(ops) > 0 {
+		if last := ops[len(ops)-1]; last.Mark == StringSet {
+			return last.Meta.Value, nil
+		}
+		return nil, ErrKeyNotExist
+	}
+	return tx.db.Get(key)
+}
+
+// StrExists 判断字符串key是否存在
+func (tx *Tx) StrExists(key []byte) bool {
+	if tx.closed {
+		return false
+	}
+	tx.watch(String, key)
+	if ops := tx.pending(String, key); len(ops) > 0 {
+		return ops[len(ops)-1].Mark == StringSet
+	}
+	return tx.db.StrExists(key)
+}
+
+// 列表的所有元素，包括本事务中添加的元素
+func (tx *Tx) listValues(key []byte) ([][]byte, error) {
+	values, err := tx.db.LRange(key, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range tx.pending(List, key) {
+		if e.Mark == ListLPush {
+			values = append([][]byte{e.Meta.Value}, values...)
+		} else {
+			values = append(values, e.Meta.Value)
+		}
+	}
+	return values, nil
+}
+
+// LRange 返回列表在 [start, end] 范围内的元素
+func (tx *Tx) LRange(key []byte, start, end int) ([][]byte, error) {
+	if tx.closed {
+		return nil, ErrTxClosed
+	}
+	tx.watch(List, key)
+	if len(tx.pending(List, key)) == 0 {
+		return tx.db.LRange(key, start, end)
+	}
+	values, err := tx.listValues(key)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(values)
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end >= n {
+		end = n - 1
+	}
+	if start > end || start >= n {
+		return nil, nil
+	}
+	return values[start : end+1], nil
+}
+
+// LLen 返回列表的长度
+func (tx *Tx) LLen(key []byte) int {
+	if tx.closed {
+		return 0
+	}
+	tx.watch(List, key)
+	return tx.db.LLen(key) + len(tx.pending(List, key))
+}
+
+// 本事务中最后一次对哈希表中的域的写入，没有写入时返回 nil
+func (tx *Tx) lastHashOp(key, field []byte) (last *storage.Entry) {
+	for _, e := range tx.pending(Hash, key) {
+		if bytes.Equal(e.Meta.Extra, field) {
+			last = e
+		}
+	}
+	return
+}
+
+// HGet 返回哈希表中域的值
+func (tx *Tx) HGet(key, field []byte) []byte {
+	if tx.closed {
+		return nil
+	}
+	tx.watch(Hash, key)
+	if last := tx.lastHashOp(key, field); last != nil {
+		if last.Mark == HashHSet {
+			return last.Meta.Value
+		}
+		return nil
+	}
+	return tx.db.HGet(key, field)
+}
+
+// HGetAll 返回哈希表中所有的域和值
+func (tx *Tx) HGetAll(key []byte) [][]byte {
+	if tx.closed {
+		return nil
+	}
+	tx.watch(Hash, key)
+	ops := tx.pending(Hash, key)
+	fvs := tx.db.HGetAll(key)
+	if len(ops) == 0 {
+		return fvs
+	}
+
+	values := make(map[string][]byte, len(fvs)/2)
+	var fields []string // 保持原有的域的顺序，新的域排在最后
+	for i := 0; i+1 < len(fvs); i += 2 {
+		fields = append(fields, string(fvs[i]))
+		values[string(fvs[i])] = fvs[i+1]
+	}
+	for _, e := range ops {
+		f := string(e.Meta.Extra)
+		if _, exist := values[f]; !exist {
+			fields = append(fields, f)
+		}
+		if e.Mark == HashHSet {
+			values[f] = e.Meta.Value
+		} else {
+			values[f] = nil
+		}
+	}
+
+	var res [][]byte
+	for _, f := range fields {
+		if v := values[f]; v != nil {
+			res = append(res, []byte(f), v)
+		}
+	}
+	if tx.db.config.SortedReads {
+		return sortFieldValues(res)
+	}
+	return res
+}
+
+// HExists 判断哈希表中是否存在域
+func (tx *Tx) HExists(key, field []byte) bool {
+	if tx.closed {
+		return false
+	}
+	tx.watch(Hash, key)
+	if last := tx.lastHashOp(key, field); last != nil {
+		return last.Mark == HashHSet
+	}
+	return tx.db.HExists(key, field)
+}
+
+// HLen 返回哈希表中域的数量
+func (tx *Tx) HLen(key []byte) int {
+	if tx.closed {
+		return 0
+	}
+	tx.watch(Hash, key)
+	if len(tx.pending(Hash, key)) == 0 {
+		return tx.db.HLen(key)
+	}
+	return len(tx.HGetAll(key)) / 2
+}
+
+// SIsMember 判断元素是否在集合中
+func (tx *Tx) SIsMember(key, member []byte) bool {
+	if tx.closed {
+		return false
+	}
+	tx.watch(Set, key)
+	ops := tx.pending(Set, key)
+	for i := len(ops) - 1; i >= 0; i-- {
+		if bytes.Equal(ops[i].Meta.Value, member) {
+			return ops[i].Mark == SetSAdd
+		}
+	}
+	return tx.db.SIsMember(key, member)
+}
+
+// SMembers 返回集合中的所有元素
+func (tx *Tx) SMembers(key []byte) [][]byte {
+	if tx.closed {
+		return nil
+	}
+	tx.watch(Set, key)
+	ops := tx.pending(Set, key)
+	members := tx.db.SMembers(key)
+	if len(ops) == 0 {
+		return members
+	}
+
+	exist := make(map[string]bool, len(members))
+	for _, m := range members {
+		exist[string(m)] = true
+	}
+	var added [][]byte
+	for _, e := range ops {
+		m := string(e.Meta.Value)
+		if e.Mark == SetSAdd {
+			if !exist[m] {
+				added = append(added, e.Meta.Value)
+			}
+			exist[m] = true
+		} else {
+			exist[m] = false
+		}
+	}
+
+	var res [][]byte
+	for _, m := range append(members, added...) {
+		if exist[string(m)] {
+			res = append(res, m)
+			exist[string(m)] = false // 先添加、删除再添加的元素只返回一次
+		}
+	}
+	if tx.db.config.SortedReads {
+		return sortValues(res)
+	}
+	return res
+}
+
+// SCard 返回集合中的元素个数
+func (tx *Tx) SCard(key []byte) int {
+	if tx.closed {
+		return 0
+	}
+	tx.watch(Set, key)
+	if len(tx.pending(Set, key)) == 0 {
+		return tx.db.SCard(key)
+	}
+	return len(tx.SMembers(key))
+}
+
+// ZScore 返回有序集合中元素的分值，不存在时返回 math.MinInt64
+func (tx *Tx) ZScore(key, member []byte) float64 {
+	if tx.closed {
+		return math.MinInt64
+	}
+	tx.watch(ZSet, key)
+	ops := tx.pending(ZSet, key)
+	for i := len(ops) - 1; i >= 0; i-- {
+		if bytes.Equal(ops[i].Meta.Value, member) {
+			if ops[i].Mark == ZSetZRem {
+				return math.MinInt64
+			}
+			score, _ := utils.StrToFloat64(string(ops[i].Meta.Extra))
+			return score
+		}
+	}
+	return tx.db.ZScore(key, member)
+}
+
+// 有序集合的所有元素及分值，包括本事务中的写入，按分值从小到大、分值相同时按元素排列
+func (tx *Tx) zsetItems(key []byte) []interface{} {
+	items := tx.db.ZRange(key, 0, -1)
+	scores := make(map[string]float64, len(items)/2)
+	for i := 0; i+1 < len(items); i += 2 {
+		scores[items[i].(string)] = items[i+1].(float64)
+	}
+	for _, e := range tx.pending(ZSet, key) {
+		if e.Mark == ZSetZRem {
+			delete(scores, string(e.Meta.Value))
+			continue
+		}
+		score, _ := utils.StrToFloat64(string(e.Meta.Extra))
+		scores[string(e.Meta.Value)] = score
+	}
+
+	members := make([]string, 0, len(scores))
+	for m := range scores {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if scores[members[i]] != scores[members[j]] {
+			return scores[members[i]] < scores[members[j]]
+		}
+		return members[i] < members[j]
+	})
+	res := make([]interface{}, 0, 2*len(members))
+	for _, m := range members {
+		res = append(res, m, scores[m])
+	}
+	return res
+}
+
+// ZCard 返回有序集合中的元素个数
+func (tx *Tx) ZCard(key []byte) int {
+	if tx.closed {
+		return 0
+	}
+	tx.watch(ZSet, key)
+	if len(tx.pending(ZSet, key)) == 0 {
+		return tx.db.ZCard(key)
+	}
+	return len(tx.zsetItems(key)) / 2
+}
+
+// ZRange 返回有序集合中排名在 [start, stop] 范围内的元素及分值
+func (tx *Tx) ZRange(key []byte, start, stop int) []interface{} {
+	if tx.closed {
+		return nil
+	}
+	tx.watch(ZSet, key)
+	if len(tx.pending(ZSet, key)) == 0 {
+		return tx.db.ZRange(key, start, stop)
+	}
+
+	items := tx.zsetItems(key)
+	n := len(items) / 2
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop || start >= n {
+		return nil
+	}
+	return items[2*start : 2*stop+2]
+}
+
+//---------事务中的写入，提交时生效-----------
+
+// Set 设置字符串的值
+func (tx *Tx) Set(key, value []byte) error {
+	if err := tx.writable(); err != nil {
+		return err
+	}
+	return tx.batch.Set(key, value)
+}
+
+// StrRem 删除字符串
+func (tx *Tx) StrRem(key []byte) error {
+	if err := tx.writable(); err != nil {
+		return err
+	}
+	return tx.batch.StrRem(key)
+}
+
+// LPush 在列表的头部添加元素
+func (tx *Tx) LPush(key []byte, values ...[]byte) error {
+	if err := tx.writable(); err != nil {
+		return err
+	}
+	return tx.batch.LPush(key, values...)
+}
+
+// RPush 在列表的尾部添加元素
+func (tx *Tx) RPush(key []byte, values ...[]byte) error {
+	if err := tx.writable(); err != nil {
+		return err
+	}
+	return tx.batch.RPush(key, values...)
+}
+
+// HSet 设置哈希表中域的值
+func (tx *Tx) HSet(key, field, value []byte) error {
+	if err := tx.writable(); err != nil {
+		return err
+	}
+	return tx.batch.HSet(key, field, value)
+}
+
+// HDel 删除哈希表中的域
+func (tx *Tx) HDel(key []byte, fields ...[]byte) error {
+	if err := tx.writable(); err != nil {
+		return err
+	}
+	return tx.batch.HDel(key, fields...)
+}
+
+// SAdd 向集合中添加元素
+func (tx *Tx) SAdd(key []byte, members ...[]byte) error {
+	if err := tx.writable(); err != nil {
+		return err
+	}
+	return tx.batch.SAdd(key, members...)
+}
+
+// SRem 删除集合中的元素
+func (tx *Tx) SRem(key []byte, members ...[]byte) error {
+	if err := tx.writable(); err != nil {
+		return err
+	}
+	return tx.batch.SRem(key, members...)
+}
+
+// ZAdd 设置有序集合中元素的分值
+func (tx *Tx) ZAdd(key []byte, score float64, member []byte) error {
+	if err := tx.writable(); err != nil {
+		return err
+	}
+	return tx.batch.ZAdd(key, score, member)
+}
+
+// ZRem 删除有序集合中的元素
+func (tx *Tx) ZRem(key, member []byte) error {
+	if err := tx.writable(); err != nil {
+		return err
+	}
+	return tx.batch.ZRem(key, member)
+}
@@ -0,0 +1,108 @@
+package mindb
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// 事务读取key之后，不经过事务的写入修改了它，事务提交时检测到冲突而不会覆盖这次写入
+func TestUpdateConflictWithDirectWrite(t *testing.T) {
+	db := openTestDB(t, KeyValueRamMode)
+	if err := db.Set([]byte("k"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	err := db.Update(func(tx *Tx) error {
+		if _, err := tx.Get([]byte("k")); err != nil {
+			return err
+		}
+		done := make(chan error)
+		go func() { done <- db.Set([]byte("k"), []byte("direct")) }()
+		if err := <-done; err != nil {
+			return err
+		}
+		return tx.Set([]byte("k"), []byte("tx"))
+	})
+	if err != ErrTxConflict {
+		t.Fatalf("Update err = %v, want ErrTxConflict", err)
+	}
+	if v, _ := db.Get([]byte("k")); string(v) != "direct" {
+		t.Fatalf("k = %q, want the direct write to be kept", v)
+	}
+}
+
+// View 读取的key在读取之后被修改时返回冲突，不会把不同时刻的数据当作一致的结果
+func TestViewConflictWithDirectWrite(t *testing.T) {
+	db := openTestDB(t, KeyValueRamMode)
+	if _, err := db.HSet([]byte("h"), []byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	err := db.View(func(tx *Tx) error {
+		tx.HGet([]byte("h"), []byte("a"))
+		done := make(chan error)
+		go func() {
+			_, err := db.HSet([]byte("h"), []byte("a"), []byte("2"))
+			done <- err
+		}()
+		if err := <-done; err != nil {
+			return err
+		}
+		tx.HGet([]byte("h"), []byte("b"))
+		return nil
+	})
+	if err != ErrTxConflict {
+		t.Fatalf("View err = %v, want ErrTxConflict", err)
+	}
+
+	// 没有并发的写入时不冲突
+	if err = db.View(func(tx *Tx) error {
+		tx.HGet([]byte("h"), []byte("a"))
+		return nil
+	}); err != nil {
+		t.Fatalf("View err = %v", err)
+	}
+}
+
+// 事务中的读取-修改-写入与不经过事务的原子写入并发执行，冲突时重试，不会丢失任何一方的写入
+func TestUpdateConcurrentWriter(t *testing.T) {
+	db := openTestDB(t, KeyValueRamMode)
+	key, field := []byte("counter"), []byte("n")
+	const n = 200
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			if _, err := db.HIncrBy(key, field, 1); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			for {
+				err := db.Update(func(tx *Tx) error {
+					v, _ := strconv.Atoi(string(tx.HGet(key, field)))
+					return tx.HSet(key, field, []byte(strconv.Itoa(v+1)))
+				})
+				if err == nil {
+					break
+				}
+				if err != ErrTxConflict {
+					t.Error(err)
+					return
+				}
+			}
+		}
+	}()
+	wg.Wait()
+
+	if v := string(db.HGet(key, field)); v != strconv.Itoa(2*n) {
+		t.Fatalf("counter = %s, want %d", v, 2*n)
+	}
+}
@@ -41,12 +41,14 @@ func (db *MinDB) ZAdd(key []byte, score float64, member []byte) error {
 	}
 
 	db.zsetIndex.indexes.ZAdd(string(key), score, string(member))
-	return nil
+	return db.capZset(key)
 }
 
 // ZScore 返回集合key中对应member的score值，如果不存在则返回负无穷
 func (db *MinDB) ZScore(key, member []byte) float64 {
 
+	db.notifyRead(ZSet, key)
+
 	db.zsetIndex.mu.RLock()
 	defer db.zsetIndex.mu.RUnlock()
 
@@ -120,6 +122,8 @@ func (db *MinDB) ZRange(key []byte, start, stop int) []interface{} {
 		return nil
 	}
 
+	db.notifyRead(ZSet, key)
+
 	db.zsetIndex.mu.RLock()
 	defer db.zsetIndex.mu.RUnlock()
 
@@ -0,0 +1,31 @@
+package mindb
+
+import (
+	"os"
+)
+
+// 对数据目录中的锁文件加排他锁，防止多个实例同时打开同一个目录、互相写坏活跃文件
+// 进程退出时操作系统会释放锁，异常退出后不需要手动删除锁文件；加锁的方式见各平台的 lockFile
+func lockDir(dirPath string) (*os.File, error) {
+	if err := os.MkdirAll(dirPath, os.ModePerm); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(dirPath+dirLockFile, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// 释放数据目录的锁
+func unlockDir(f *os.File) {
+	if f == nil {
+		return
+	}
+	unlockFile(f)
+	_ = f.Close()
+}
@@ -0,0 +1,12 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris && !windows
+
+package mindb
+
+import "os"
+
+// 其他平台（如 plan9、wasm）不支持文件锁，不会阻止多个实例打开同一个目录，需要使用方自行保证
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) {}
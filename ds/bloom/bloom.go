@@ -0,0 +1,184 @@
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+)
+
+var ErrInvalidBloom = errors.New("ds/bloom: invalid bloom filter data")
+
+// 编码后的数据以此开头，用于区分普通的字符串
+var magic = []byte("BLOM")
+
+const (
+	// 每新增一层，容量扩大的倍数
+	expansion = 2
+
+	// 每新增一层，误判率收紧的比例，使整体的误判率不超过设置值
+	tightening = 0.5
+)
+
+type (
+	// Bloom 可扩容的布隆过滤器（Scalable Bloom Filter）
+	// 由多层普通的布隆过滤器组成，最后一层写满后新增一层容量更大、误判率更低的过滤器，元素只写入最后一层
+	Bloom struct {
+		errorRate float64 // 整体的误判率，第 i 层（从0开始）的误判率为 errorRate * tightening^(i+1)，各层之和不超过它
+		layers    []*layer
+	}
+
+	// 一层普通的布隆过滤器
+	layer struct {
+		capacity uint64 // 可容纳的元素个数
+		count    uint64 // 已写入的元素个数
+		hashes   uint32 // 哈希函数的个数
+		bits     []byte
+	}
+)
+
+// New 新建一个布隆过滤器，capacity 为第一层的容量，errorRate 为期望的误判率
+func New(errorRate float64, capacity uint64) *Bloom {
+	b := &Bloom{errorRate: errorRate}
+	b.layers = append(b.layers, newLayer(errorRate*tightening, capacity))
+	return b
+}
+
+func newLayer(errorRate float64, capacity uint64) *layer {
+	m := math.Ceil(-float64(capacity) * math.Log(errorRate) / (math.Ln2 * math.Ln2)) // 位数
+	k := math.Ceil(m / float64(capacity) * math.Ln2)                                 // 哈希函数个数
+	return &layer{
+		capacity: capacity,
+		hashes:   uint32(math.Max(k, 1)),
+		bits:     make([]byte, (uint64(m)+7)/8),
+	}
+}
+
+// 计算元素在位数组中的位置，使用两个哈希值组合出多个哈希函数
+func (l *layer) positions(h1, h2 uint64) []uint64 {
+	m := uint64(len(l.bits)) * 8
+	pos := make([]uint64, l.hashes)
+	for i := uint32(0); i < l.hashes; i++ {
+		pos[i] = (h1 + uint64(i)*h2) % m
+	}
+	return pos
+}
+
+func (l *layer) test(h1, h2 uint64) bool {
+	for _, p := range l.positions(h1, h2) {
+		if l.bits[p/8]&(1<<(p%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *layer) add(h1, h2 uint64) {
+	for _, p := range l.positions(h1, h2) {
+		l.bits[p/8] |= 1 << (p % 8)
+	}
+	l.count++
+}
+
+// 计算元素的两个独立的哈希值，第二个哈希值为奇数，保证组合出的位置不会重复落在同一位
+func hash(item []byte) (uint64, uint64) {
+	h1, h2 := fnv.New64a(), fnv.New64()
+	h1.Write(item)
+	h2.Write(item)
+	return h1.Sum64(), h2.Sum64() | 1
+}
+
+// Add 添加元素，元素可能已存在时返回 false
+func (b *Bloom) Add(item []byte) bool {
+	h1, h2 := hash(item)
+	if b.exists(h1, h2) {
+		return false
+	}
+
+	last := b.layers[len(b.layers)-1]
+	if last.count >= last.capacity { // 最后一层已满，新增一层
+		rate := b.errorRate * math.Pow(tightening, float64(len(b.layers)+1))
+		last = newLayer(rate, last.capacity*expansion)
+		b.layers = append(b.layers, last)
+	}
+	last.add(h1, h2)
+	return true
+}
+
+// Exists 判断元素是否存在，存在误判的可能，但不存在的判断一定准确
+func (b *Bloom) Exists(item []byte) bool {
+	h1, h2 := hash(item)
+	return b.exists(h1, h2)
+}
+
+func (b *Bloom) exists(h1, h2 uint64) bool {
+	for _, l := range b.layers {
+		if l.test(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// Count 已添加的元素个数
+func (b *Bloom) Count() (n uint64) {
+	for _, l := range b.layers {
+		n += l.count
+	}
+	return
+}
+
+// Encode 编码为字节数组：magic + 误判率 + 层数 + 每层的（容量 + 元素个数 + 哈希函数个数 + 位数组长度 + 位数组）
+func (b *Bloom) Encode() []byte {
+	var buf bytes.Buffer
+	buf.Write(magic)
+	_ = binary.Write(&buf, binary.BigEndian, math.Float64bits(b.errorRate))
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(b.layers)))
+	for _, l := range b.layers {
+		_ = binary.Write(&buf, binary.BigEndian, l.capacity)
+		_ = binary.Write(&buf, binary.BigEndian, l.count)
+		_ = binary.Write(&buf, binary.BigEndian, l.hashes)
+		_ = binary.Write(&buf, binary.BigEndian, uint32(len(l.bits)))
+		buf.Write(l.bits)
+	}
+	return buf.Bytes()
+}
+
+// IsBloom 判断字节数组是否是编码后的布隆过滤器
+func IsBloom(b []byte) bool {
+	return bytes.HasPrefix(b, magic)
+}
+
+// Decode 解码 Encode 编码后的字节数组
+func Decode(data []byte) (*Bloom, error) {
+	if !IsBloom(data) {
+		return nil, ErrInvalidBloom
+	}
+	r := bytes.NewReader(data[len(magic):])
+
+	var rate uint64
+	var n uint32
+	if binary.Read(r, binary.BigEndian, &rate) != nil || binary.Read(r, binary.BigEndian, &n) != nil || n == 0 {
+		return nil, ErrInvalidBloom
+	}
+
+	b := &Bloom{errorRate: math.Float64frombits(rate)}
+	for i := uint32(0); i < n; i++ {
+		l := &layer{}
+		var size uint32
+		if binary.Read(r, binary.BigEndian, &l.capacity) != nil || binary.Read(r, binary.BigEndian, &l.count) != nil ||
+			binary.Read(r, binary.BigEndian, &l.hashes) != nil || binary.Read(r, binary.BigEndian, &size) != nil {
+			return nil, ErrInvalidBloom
+		}
+		if size == 0 || int(size) > r.Len() {
+			return nil, ErrInvalidBloom
+		}
+		l.bits = make([]byte, size)
+		if _, err := r.Read(l.bits); err != nil {
+			return nil, ErrInvalidBloom
+		}
+		b.layers = append(b.layers, l)
+	}
+	return b, nil
+}
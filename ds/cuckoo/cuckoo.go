@@ -0,0 +1,238 @@
+package cuckoo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math/rand"
+)
+
+var ErrInvalidCuckoo = errors.New("ds/cuckoo: invalid cuckoo filter data")
+
+// 编码后的数据以此开头，用于区分普通的字符串
+var magic = []byte("CUKO")
+
+const (
+	// 每个桶中的指纹数
+	bucketSize = 4
+
+	// 插入时最多踢出的次数，超过后认为当前的过滤器已满
+	maxKicks = 500
+
+	// 每新增一个过滤器，容量扩大的倍数
+	expansion = 2
+)
+
+type (
+	// Cuckoo 可扩容的布谷鸟过滤器，与布隆过滤器相比支持删除元素
+	// 每个元素保存一个 16 位的指纹，可以放在两个候选桶之一；元素只写入最后一个过滤器，插入失败后新增一个容量更大的过滤器
+	Cuckoo struct {
+		filters []*filter
+	}
+
+	filter struct {
+		count     uint64               // 已保存的指纹个数，包括 victim
+		buckets   [][bucketSize]uint16 // 桶的个数为 2 的幂，指纹为 0 表示空位
+		victim    uint16               // 插入失败时最后被踢出、没有位置的指纹，为 0 表示没有，有 victim 的过滤器不再写入
+		victimIdx uint64               // victim 所在的候选桶
+	}
+)
+
+// New 新建一个布谷鸟过滤器，capacity 为第一个过滤器的容量
+func New(capacity uint64) *Cuckoo {
+	return &Cuckoo{filters: []*filter{newFilter(capacity)}}
+}
+
+func newFilter(capacity uint64) *filter {
+	n := uint64(1)
+	for n*bucketSize < capacity {
+		n <<= 1
+	}
+	return &filter{buckets: make([][bucketSize]uint16, n)}
+}
+
+// 计算元素的指纹及在各个过滤器中共用的哈希值
+func hash(item []byte) (fp uint16, h uint64) {
+	f := fnv.New64a()
+	f.Write(item)
+	h = f.Sum64()
+	if fp = uint16(h >> 48); fp == 0 {
+		fp = 1
+	}
+	return
+}
+
+// 指纹的另一个候选桶，两个候选桶可以互相计算得到
+func (f *filter) altIndex(i uint64, fp uint16) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte{byte(fp >> 8), byte(fp)})
+	return (i ^ h.Sum64()) & uint64(len(f.buckets)-1)
+}
+
+func (f *filter) indexes(fp uint16, h uint64) (uint64, uint64) {
+	i1 := h & uint64(len(f.buckets)-1)
+	return i1, f.altIndex(i1, fp)
+}
+
+func (f *filter) insertAt(i uint64, fp uint16) bool {
+	for j, v := range f.buckets[i] {
+		if v == 0 {
+			f.buckets[i][j] = fp
+			f.count++
+			return true
+		}
+	}
+	return false
+}
+
+// 插入指纹，两个候选桶都满时随机踢出一个指纹放到它的另一个候选桶，踢出的次数过多时最后没有位置的指纹记为 victim
+func (f *filter) insert(fp uint16, h uint64) {
+	i1, i2 := f.indexes(fp, h)
+	if f.insertAt(i1, fp) || f.insertAt(i2, fp) {
+		return
+	}
+
+	i := i1
+	if rand.Intn(2) == 1 {
+		i = i2
+	}
+	for k := 0; k < maxKicks; k++ {
+		j := rand.Intn(bucketSize)
+		fp, f.buckets[i][j] = f.buckets[i][j], fp
+		i = f.altIndex(i, fp)
+		if f.insertAt(i, fp) {
+			return
+		}
+	}
+	f.victim, f.victimIdx = fp, i
+	f.count++
+}
+
+func (f *filter) contains(fp uint16, h uint64) bool {
+	i1, i2 := f.indexes(fp, h)
+	if f.victim == fp && (f.victimIdx == i1 || f.victimIdx == i2) {
+		return true
+	}
+	for _, i := range []uint64{i1, i2} {
+		for _, v := range f.buckets[i] {
+			if v == fp {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *filter) remove(fp uint16, h uint64) bool {
+	i1, i2 := f.indexes(fp, h)
+	if f.victim == fp && (f.victimIdx == i1 || f.victimIdx == i2) {
+		f.victim = 0
+		f.count--
+		return true
+	}
+	for _, i := range []uint64{i1, i2} {
+		for j, v := range f.buckets[i] {
+			if v == fp {
+				f.buckets[i][j] = 0
+				f.count--
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Add 添加元素，同一个元素可以添加多次，删除时需要删除相同的次数
+func (c *Cuckoo) Add(item []byte) {
+	fp, h := hash(item)
+	last := c.filters[len(c.filters)-1]
+	if last.victim != 0 { // 最后一个过滤器已满，新增一个过滤器
+		last = newFilter(uint64(len(last.buckets)) * bucketSize * expansion)
+		c.filters = append(c.filters, last)
+	}
+	last.insert(fp, h)
+}
+
+// Exists 判断元素是否存在，存在误判的可能，但不存在的判断一定准确
+func (c *Cuckoo) Exists(item []byte) bool {
+	fp, h := hash(item)
+	for _, f := range c.filters {
+		if f.contains(fp, h) {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete 删除一次添加的元素，元素不存在时返回 false
+// 只能删除确实添加过的元素，否则可能误删其他元素的指纹
+func (c *Cuckoo) Delete(item []byte) bool {
+	fp, h := hash(item)
+	for i := len(c.filters) - 1; i >= 0; i-- {
+		if c.filters[i].remove(fp, h) {
+			return true
+		}
+	}
+	return false
+}
+
+// Count 已添加的元素个数
+func (c *Cuckoo) Count() (n uint64) {
+	for _, f := range c.filters {
+		n += f.count
+	}
+	return
+}
+
+// Encode 编码为字节数组：magic + 过滤器个数 + 每个过滤器的（元素个数 + victim + victim 所在的桶 + 桶的个数 + 所有的指纹）
+func (c *Cuckoo) Encode() []byte {
+	var buf bytes.Buffer
+	buf.Write(magic)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(c.filters)))
+	for _, f := range c.filters {
+		_ = binary.Write(&buf, binary.BigEndian, f.count)
+		_ = binary.Write(&buf, binary.BigEndian, f.victim)
+		_ = binary.Write(&buf, binary.BigEndian, f.victimIdx)
+		_ = binary.Write(&buf, binary.BigEndian, uint32(len(f.buckets)))
+		_ = binary.Write(&buf, binary.BigEndian, f.buckets)
+	}
+	return buf.Bytes()
+}
+
+// IsCuckoo 判断字节数组是否是编码后的布谷鸟过滤器
+func IsCuckoo(b []byte) bool {
+	return bytes.HasPrefix(b, magic)
+}
+
+// Decode 解码 Encode 编码后的字节数组
+func Decode(data []byte) (*Cuckoo, error) {
+	if !IsCuckoo(data) {
+		return nil, ErrInvalidCuckoo
+	}
+	r := bytes.NewReader(data[len(magic):])
+
+	var n uint32
+	if binary.Read(r, binary.BigEndian, &n) != nil || n == 0 {
+		return nil, ErrInvalidCuckoo
+	}
+
+	c := &Cuckoo{}
+	for i := uint32(0); i < n; i++ {
+		f := &filter{}
+		var size uint32
+		if binary.Read(r, binary.BigEndian, &f.count) != nil || binary.Read(r, binary.BigEndian, &f.victim) != nil ||
+			binary.Read(r, binary.BigEndian, &f.victimIdx) != nil || binary.Read(r, binary.BigEndian, &size) != nil {
+			return nil, ErrInvalidCuckoo
+		}
+		if size == 0 || size&(size-1) != 0 || int(size)*bucketSize*2 > r.Len() || f.victimIdx >= uint64(size) {
+			return nil, ErrInvalidCuckoo
+		}
+		f.buckets = make([][bucketSize]uint16, size)
+		if binary.Read(r, binary.BigEndian, f.buckets) != nil {
+			return nil, ErrInvalidCuckoo
+		}
+		c.filters = append(c.filters, f)
+	}
+	return c, nil
+}
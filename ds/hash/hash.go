@@ -125,6 +125,51 @@ func (h *Hash) HValues(key string) (val [][]byte) {
 	return
 }
 
+// Keys 返回所有非空哈希表的key，顺序不固定
+func (h *Hash) Keys() (keys []string) {
+	for k, v := range h.record {
+		if len(v) > 0 {
+			keys = append(keys, k)
+		}
+	}
+	return
+}
+
+// KeyCount 非空的key的数量，skip 不为 nil 时不计入 skip 返回 true 的key
+func (h *Hash) KeyCount(skip func(key string) bool) (n int) {
+	for k, v := range h.record {
+		if len(v) > 0 && (skip == nil || !skip(k)) {
+			n++
+		}
+	}
+	return
+}
+
+// SampleKeys 随机选取最多 n 个非空的key，利用 map 遍历顺序的随机性，不需要遍历所有的key
+func (h *Hash) SampleKeys(n int) (keys []string) {
+	for k, v := range h.record {
+		if len(keys) >= n {
+			break
+		}
+		if len(v) > 0 {
+			keys = append(keys, k)
+		}
+	}
+	return
+}
+
+// Len key的数量，可能包含已经为空的key
+func (h *Hash) Len() int {
+	return len(h.record)
+}
+
+// Reserve 为key对应的哈希表预先分配n个域的空间，哈希表已存在时不做任何操作
+func (h *Hash) Reserve(key string, n int) {
+	if !h.exist(key) {
+		h.record[key] = make(map[string][]byte, n)
+	}
+}
+
 // 检查哈希表结构中是否存在key对应的value
 func (h *Hash) exist(key string) bool {
 	_, exist := h.record[key]
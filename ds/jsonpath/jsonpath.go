@@ -0,0 +1,143 @@
+package jsonpath
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidPath  = errors.New("ds/jsonpath: invalid path")
+	ErrPathNotFound = errors.New("ds/jsonpath: path does not exist")
+)
+
+// 路径中的一段，对象的字段或数组的下标
+type segment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// Path 解析后的路径，空路径表示整个文档
+type Path []segment
+
+// Parse 解析路径，支持 $.a.b[0]、a.b[0]、$["a"]["b"] 等形式，$ 或 . 表示整个文档
+// 数组下标可以为负数，-1 表示最后一个元素
+func Parse(path string) (Path, error) {
+	p := strings.TrimPrefix(path, "$")
+	var segs Path
+	for len(p) > 0 {
+		switch p[0] {
+		case '.':
+			p = p[1:]
+			if p == "" && len(segs) == 0 { // 单独的 . 表示整个文档
+				return segs, nil
+			}
+			n := strings.IndexAny(p, ".[")
+			if n < 0 {
+				n = len(p)
+			}
+			if n == 0 {
+				return nil, ErrInvalidPath
+			}
+			segs = append(segs, segment{key: p[:n]})
+			p = p[n:]
+		case '[':
+			end := strings.IndexByte(p, ']')
+			if end < 0 {
+				return nil, ErrInvalidPath
+			}
+			inner := p[1:end]
+			if len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') && inner[len(inner)-1] == inner[0] {
+				segs = append(segs, segment{key: inner[1 : len(inner)-1]})
+			} else {
+				i, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, ErrInvalidPath
+				}
+				segs = append(segs, segment{index: i, isIndex: true})
+			}
+			p = p[end+1:]
+		default:
+			if len(segs) > 0 || strings.HasPrefix(path, "$") { // 只有第一段可以省略开头的 .
+				return nil, ErrInvalidPath
+			}
+			p = "." + p
+		}
+	}
+	return segs, nil
+}
+
+// Get 返回文档中路径指向的值
+func (p Path) Get(doc interface{}) (interface{}, error) {
+	cur := doc
+	for _, seg := range p {
+		next, ok := child(cur, seg)
+		if !ok {
+			return nil, ErrPathNotFound
+		}
+		cur = next
+	}
+	return cur, nil
+}
+
+// Set 将路径指向的位置设置为 value，返回修改后的文档
+// 路径的最后一段为对象中不存在的字段时新增该字段，其余各段必须已经存在，数组下标必须在范围内
+func (p Path) Set(doc, value interface{}) (interface{}, error) {
+	if len(p) == 0 {
+		return value, nil
+	}
+
+	parent, err := p[:len(p)-1].Get(doc)
+	if err != nil {
+		return nil, err
+	}
+	last := p[len(p)-1]
+	switch v := parent.(type) {
+	case map[string]interface{}:
+		if last.isIndex {
+			return nil, ErrPathNotFound
+		}
+		v[last.key] = value
+	case []interface{}:
+		i, ok := arrayIndex(v, last)
+		if !ok {
+			return nil, ErrPathNotFound
+		}
+		v[i] = value
+	default:
+		return nil, ErrPathNotFound
+	}
+	return doc, nil
+}
+
+// 获取对象的字段或数组的元素
+func child(v interface{}, seg segment) (interface{}, bool) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		if seg.isIndex {
+			return nil, false
+		}
+		c, ok := val[seg.key]
+		return c, ok
+	case []interface{}:
+		i, ok := arrayIndex(val, seg)
+		if !ok {
+			return nil, false
+		}
+		return val[i], true
+	}
+	return nil, false
+}
+
+// 将下标转换为数组中的位置，负数从末尾开始计算
+func arrayIndex(arr []interface{}, seg segment) (int, bool) {
+	if !seg.isIndex {
+		return 0, false
+	}
+	i := seg.index
+	if i < 0 {
+		i += len(arr)
+	}
+	return i, i >= 0 && i < len(arr)
+}
@@ -278,6 +278,12 @@ func (lis *List) LTrim(key string, start, end int) bool {
 	return true
 }
 
+// Clear 删除整个列表
+func (lis *List) Clear(key string) {
+	delete(lis.record, key)
+	delete(lis.values, key)
+}
+
 // LLen 返回指定key的列表中的元素个数
 func (lis *List) LLen(key string) int {
 	length := 0
@@ -302,6 +308,44 @@ func (lis *List) LValExists(key string, val []byte) (ok bool) {
 	return
 }
 
+// Keys 返回所有非空列表的key，顺序不固定
+func (lis *List) Keys() (keys []string) {
+	for k, v := range lis.record {
+		if v.Len() > 0 {
+			keys = append(keys, k)
+		}
+	}
+	return
+}
+
+// KeyCount 非空的key的数量，skip 不为 nil 时不计入 skip 返回 true 的key
+func (lis *List) KeyCount(skip func(key string) bool) (n int) {
+	for k, v := range lis.record {
+		if v.Len() > 0 && (skip == nil || !skip(k)) {
+			n++
+		}
+	}
+	return
+}
+
+// SampleKeys 随机选取最多 n 个非空的key，利用 map 遍历顺序的随机性，不需要遍历所有的key
+func (lis *List) SampleKeys(n int) (keys []string) {
+	for k, v := range lis.record {
+		if len(keys) >= n {
+			break
+		}
+		if v.Len() > 0 {
+			keys = append(keys, k)
+		}
+	}
+	return
+}
+
+// Len key的数量，可能包含已经为空的key
+func (lis *List) Len() int {
+	return len(lis.record)
+}
+
 // 查找key对应的list中Value为给定val的element
 func (lis *List) find(key string, val []byte) *list.Element {
 	item := lis.record[key]
@@ -187,6 +187,51 @@ func (s *Set) SDiff(keys ...string) (val [][]byte) {
 	return
 }
 
+// Keys 返回所有非空集合的key，顺序不固定
+func (s *Set) Keys() (keys []string) {
+	for k, v := range s.record {
+		if len(v) > 0 {
+			keys = append(keys, k)
+		}
+	}
+	return
+}
+
+// KeyCount 非空的key的数量，skip 不为 nil 时不计入 skip 返回 true 的key
+func (s *Set) KeyCount(skip func(key string) bool) (n int) {
+	for k, v := range s.record {
+		if len(v) > 0 && (skip == nil || !skip(k)) {
+			n++
+		}
+	}
+	return
+}
+
+// SampleKeys 随机选取最多 n 个非空的key，利用 map 遍历顺序的随机性，不需要遍历所有的key
+func (s *Set) SampleKeys(n int) (keys []string) {
+	for k, v := range s.record {
+		if len(keys) >= n {
+			break
+		}
+		if len(v) > 0 {
+			keys = append(keys, k)
+		}
+	}
+	return
+}
+
+// Len key的数量，可能包含已经为空的key
+func (s *Set) Len() int {
+	return len(s.record)
+}
+
+// Reserve 为key对应的集合预先分配n个元素的空间，集合已存在时不做任何操作
+func (s *Set) Reserve(key string, n int) {
+	if !s.exist(key) {
+		s.record[key] = make(map[string]bool, n)
+	}
+}
+
 // 判断key对应的集合是否存在
 func (s *Set) exist(key string) bool {
 	_, exist := s.record[key]
@@ -0,0 +1,187 @@
+package topk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"sort"
+)
+
+var ErrInvalidTopK = errors.New("ds/topk: invalid top-k data")
+
+// 编码后的数据以此开头，用于区分普通的字符串
+var magic = []byte("TOPK")
+
+type (
+	// TopK 近似统计出现次数最多的 k 个元素
+	// 使用 Count-Min Sketch 估计每个元素的出现次数，并维护估计次数最大的 k 个元素，内存占用与元素的总数无关
+	TopK struct {
+		k        uint32
+		width    uint32
+		depth    uint32
+		counters []uint32 // depth 行 width 列的计数器
+		items    []Item   // 当前的 top-k 元素，按次数从大到小排列
+	}
+
+	// Item top-k 中的元素及其估计的出现次数
+	Item struct {
+		Key   string
+		Count uint32
+	}
+)
+
+// New 新建一个 TopK，width、depth 为 Count-Min Sketch 的列数和行数，越大估计越准确
+func New(k, width, depth uint32) *TopK {
+	return &TopK{
+		k:        k,
+		width:    width,
+		depth:    depth,
+		counters: make([]uint32, width*depth),
+	}
+}
+
+// K 返回 top-k 的元素个数上限
+func (t *TopK) K() uint32 {
+	return t.k
+}
+
+// 计算元素在每一行中的列，使用两个哈希值组合出 depth 个哈希函数
+func (t *TopK) positions(key []byte) []uint32 {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)
+
+	pos := make([]uint32, t.depth)
+	for i := uint32(0); i < t.depth; i++ {
+		pos[i] = i*t.width + (h1+i*h2)%t.width
+	}
+	return pos
+}
+
+// Add 增加元素的出现次数，返回因此被挤出 top-k 的元素，没有时返回 nil
+func (t *TopK) Add(key []byte) (expelled *Item) {
+	count := ^uint32(0)
+	for _, p := range t.positions(key) {
+		if t.counters[p] < ^uint32(0) {
+			t.counters[p]++
+		}
+		if t.counters[p] < count {
+			count = t.counters[p]
+		}
+	}
+
+	if i := t.index(string(key)); i >= 0 {
+		t.items[i].Count = count
+	} else if uint32(len(t.items)) < t.k {
+		t.items = append(t.items, Item{Key: string(key), Count: count})
+	} else if last := t.items[len(t.items)-1]; count > last.Count {
+		expelled = &last
+		t.items[len(t.items)-1] = Item{Key: string(key), Count: count}
+	} else {
+		return nil
+	}
+
+	sort.SliceStable(t.items, func(i, j int) bool {
+		return t.items[i].Count > t.items[j].Count
+	})
+	return
+}
+
+// Count 返回元素估计的出现次数，估计值不会小于实际次数
+func (t *TopK) Count(key []byte) uint32 {
+	count := ^uint32(0)
+	for _, p := range t.positions(key) {
+		if t.counters[p] < count {
+			count = t.counters[p]
+		}
+	}
+	return count
+}
+
+// Query 判断元素是否在 top-k 中
+func (t *TopK) Query(key []byte) bool {
+	return t.index(string(key)) >= 0
+}
+
+// List 返回 top-k 中的元素，按估计次数从大到小排列
+func (t *TopK) List() []Item {
+	items := make([]Item, len(t.items))
+	copy(items, t.items)
+	return items
+}
+
+func (t *TopK) index(key string) int {
+	for i, item := range t.items {
+		if item.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// Encode 编码为字节数组：magic + k + width + depth + 计数器 + 元素个数 + 每个元素的（key长度 + key + 次数）
+func (t *TopK) Encode() []byte {
+	var buf bytes.Buffer
+	buf.Write(magic)
+	for _, v := range []uint32{t.k, t.width, t.depth} {
+		_ = binary.Write(&buf, binary.BigEndian, v)
+	}
+	_ = binary.Write(&buf, binary.BigEndian, t.counters)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(t.items)))
+	for _, item := range t.items {
+		_ = binary.Write(&buf, binary.BigEndian, uint32(len(item.Key)))
+		buf.WriteString(item.Key)
+		_ = binary.Write(&buf, binary.BigEndian, item.Count)
+	}
+	return buf.Bytes()
+}
+
+// IsTopK 判断字节数组是否是编码后的 TopK
+func IsTopK(b []byte) bool {
+	return bytes.HasPrefix(b, magic)
+}
+
+// Decode 解码 Encode 编码后的字节数组
+func Decode(b []byte) (*TopK, error) {
+	if !IsTopK(b) {
+		return nil, ErrInvalidTopK
+	}
+	r := bytes.NewReader(b[len(magic):])
+
+	var header [3]uint32
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, ErrInvalidTopK
+	}
+	k, width, depth := header[0], header[1], header[2]
+	if width == 0 || depth == 0 || uint64(width)*uint64(depth)*4 > uint64(r.Len()) {
+		return nil, ErrInvalidTopK
+	}
+
+	t := New(k, width, depth)
+	if err := binary.Read(r, binary.BigEndian, t.counters); err != nil {
+		return nil, ErrInvalidTopK
+	}
+
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil || n > k {
+		return nil, ErrInvalidTopK
+	}
+	for i := uint32(0); i < n; i++ {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil || uint64(size) > uint64(r.Len()) {
+			return nil, ErrInvalidTopK
+		}
+		key := make([]byte, size)
+		if _, err := r.Read(key); err != nil && size > 0 {
+			return nil, ErrInvalidTopK
+		}
+		var count uint32
+		if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+			return nil, ErrInvalidTopK
+		}
+		t.items = append(t.items, Item{Key: string(key), Count: count})
+	}
+	return t, nil
+}
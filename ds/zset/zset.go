@@ -210,7 +210,7 @@ func (z *SortedSet) ZRevGetByRank(key string, rank int) (val []interface{}) {
 // ZScoreRange 返回有序集 key 中，所有 score 值介于 min 和 max 之间(包括等于 min 或 max )的成员
 // 有序集成员按 score 值递增(从小到大)次序排列
 func (z *SortedSet) ZScoreRange(key string, min, max float64) (val []interface{}) {
-	if !z.exist(key) || min > max {
+	if z.ZCard(key) == 0 || min > max { // 元素全部删除后key仍然存在，跳表为空
 		return
 	}
 
@@ -248,7 +248,7 @@ func (z *SortedSet) ZScoreRange(key string, min, max float64) (val []interface{}
 // ZRevScoreRange 返回有序集 key 中， score 值介于 max 和 min 之间(默认包括等于 max 或 min )的所有的成员
 // 有序集成员按 score 值递减(从大到小)的次序排列
 func (z *SortedSet) ZRevScoreRange(key string, max, min float64) (val []interface{}) {
-	if !z.exist(key) || max < min {
+	if z.ZCard(key) == 0 || max < min {
 		return
 	}
 
@@ -282,6 +282,54 @@ func (z *SortedSet) ZRevScoreRange(key string, max, min float64) (val []interfac
 	return
 }
 
+// Keys 返回所有非空有序集合的key，顺序不固定
+func (z *SortedSet) Keys() (keys []string) {
+	for k, v := range z.record {
+		if len(v.dict) > 0 {
+			keys = append(keys, k)
+		}
+	}
+	return
+}
+
+// KeyCount 非空的key的数量，skip 不为 nil 时不计入 skip 返回 true 的key
+func (z *SortedSet) KeyCount(skip func(key string) bool) (n int) {
+	for k, v := range z.record {
+		if len(v.dict) > 0 && (skip == nil || !skip(k)) {
+			n++
+		}
+	}
+	return
+}
+
+// SampleKeys 随机选取最多 n 个非空的key，利用 map 遍历顺序的随机性，不需要遍历所有的key
+func (z *SortedSet) SampleKeys(n int) (keys []string) {
+	for k, v := range z.record {
+		if len(keys) >= n {
+			break
+		}
+		if len(v.dict) > 0 {
+			keys = append(keys, k)
+		}
+	}
+	return
+}
+
+// Len key的数量，可能包含已经为空的key
+func (z *SortedSet) Len() int {
+	return len(z.record)
+}
+
+// Reserve 为key对应的有序集合预先分配n个成员的空间，有序集合已存在时不做任何操作
+func (z *SortedSet) Reserve(key string, n int) {
+	if !z.exist(key) {
+		z.record[key] = &SortedSetNode{
+			dict: make(map[string]*sklNode, n),
+			skl:  newSkipList(),
+		}
+	}
+}
+
 func (z *SortedSet) exist(key string) bool {
 	_, exist := z.record[key]
 	return exist
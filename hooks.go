@@ -0,0 +1,106 @@
+package mindb
+
+import (
+	"mindb/storage"
+	"time"
+)
+
+// Hooks 数据库事件的监听接口，指标统计、链路追踪、变更订阅等都通过它接入，接入新的系统时不需要再修改各个读写路径
+// 只关心部分事件时可以嵌入 NopHooks，只实现需要的方法
+// 除 OnExpire 外的回调都在触发事件的 goroutine 中同步调用，OnWrite、OnSegmentRotate 调用时持有该类型索引的写锁，
+// 回收的回调调用时持有数据库的锁，因此回调都应尽快返回，且除 OnRead、OnExpire 外不能在回调中调用 db 的方法
+type Hooks interface {
+	// OnWrite 一条entry写入数据文件后调用，seq 为这次写入的序号，key 及 value 在回调返回后不能再使用
+	OnWrite(e WriteEvent)
+
+	// OnRead 读取key时调用
+	OnRead(dataType DataType, key []byte)
+
+	// OnExpire key过期被删除时在执行过期回调的goroutine中调用，与 OnExpired 注册的回调一起依次执行，key 是调用方独占的副本
+	OnExpire(dataType DataType, key []byte)
+
+	// OnReclaimStart 开始回收磁盘空间时调用，types 为需要回收的数据类型
+	OnReclaimStart(types []DataType)
+
+	// OnReclaimEnd 回收结束时调用，err 为回收的结果，只有调用过 OnReclaimStart 的回收才会调用
+	OnReclaimEnd(types []DataType, elapsed time.Duration, err error)
+
+	// OnSegmentRotate 活跃文件写满或者被 RotateActiveFile 封存、新建了活跃文件时调用
+	OnSegmentRotate(dataType DataType, archivedId, activeId uint32)
+}
+
+// WriteEvent 一次写入的信息
+type WriteEvent struct {
+	Seq   uint64   // 写入序号
+	Type  DataType // 数据类型
+	Mark  uint16   // 操作标识，如 StringSet、ListLPush
+	Key   []byte
+	Value []byte
+	Size  uint32 // entry 在数据文件中占用的大小
+}
+
+// NopHooks Hooks 的空实现
+type NopHooks struct{}
+
+func (NopHooks) OnWrite(WriteEvent)                            {}
+func (NopHooks) OnRead(DataType, []byte)                       {}
+func (NopHooks) OnExpire(DataType, []byte)                     {}
+func (NopHooks) OnReclaimStart([]DataType)                     {}
+func (NopHooks) OnReclaimEnd([]DataType, time.Duration, error) {}
+func (NopHooks) OnSegmentRotate(DataType, uint32, uint32)      {}
+
+// AddHooks 注册事件的监听，可以注册多个，按注册的顺序依次调用
+func (db *MinDB) AddHooks(h Hooks) {
+	if h == nil {
+		return
+	}
+	db.hookMu.Lock()
+	defer db.hookMu.Unlock()
+	hooks := make([]Hooks, len(db.hooks), len(db.hooks)+1) // 复制一份，已经取出的列表不受影响
+	copy(hooks, db.hooks)
+	db.hooks = append(hooks, h)
+}
+
+// 取出注册的监听，没有注册时为 nil
+func (db *MinDB) loadHooks() []Hooks {
+	db.hookMu.RLock()
+	defer db.hookMu.RUnlock()
+	return db.hooks
+}
+
+func (db *MinDB) notifyWrite(seq uint64, e *storage.Entry) {
+	hooks := db.loadHooks()
+	if len(hooks) == 0 {
+		return
+	}
+	event := WriteEvent{Seq: seq, Type: e.Type, Mark: e.Mark, Key: e.Meta.Key, Value: e.Meta.Value, Size: e.Size()}
+	for _, h := range hooks {
+		h.OnWrite(event)
+	}
+}
+
+func (db *MinDB) notifyRead(dataType DataType, key []byte) {
+	for _, h := range db.loadHooks() {
+		h.OnRead(dataType, key)
+	}
+}
+
+func (db *MinDB) notifyRotate(dataType DataType, archivedId, activeId uint32) {
+	for _, h := range db.loadHooks() {
+		h.OnSegmentRotate(dataType, archivedId, activeId)
+	}
+}
+
+// 通知开始回收，返回回收结束时调用的通知函数
+func (db *MinDB) notifyReclaim(types []DataType) func(err error) {
+	hooks := db.loadHooks()
+	for _, h := range hooks {
+		h.OnReclaimStart(types)
+	}
+	start := time.Now()
+	return func(err error) {
+		for _, h := range hooks {
+			h.OnReclaimEnd(types, time.Since(start), err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package mindb
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// 按前缀统计key的访问次数时，前缀为key中第一个分隔符及之前的部分，没有分隔符时为整个key
+	hotKeyPrefixSep = ':'
+
+	// 前缀的最大长度，超出的部分被截断
+	hotKeyMaxPrefixLen = 64
+
+	// 保留最近多少个时间窗口的统计
+	hotKeyWindows = 10
+
+	// 一个时间窗口内最多统计的前缀数量，超出后新的前缀计入 HotKeyOther，避免key没有规律时占用过多内存
+	hotKeyMaxPrefixes = 1024
+
+	// HotKeyOther 超出统计数量上限的前缀
+	HotKeyOther = "(other)"
+)
+
+// PrefixAccess 一个key前缀在最近各时间窗口内的访问次数，由采样的次数按采样率估算
+type PrefixAccess struct {
+	Prefix string
+	Total  int64   // 所有时间窗口内的访问次数
+	Counts []int64 // 各时间窗口内的访问次数，第一个为当前的时间窗口，依次往前
+}
+
+// key访问的采样记录，时间窗口按时间划分，放在一个环中循环使用
+type keyAccess struct {
+	mu      sync.Mutex
+	windows [hotKeyWindows]accessWindow
+}
+
+// 一个时间窗口内的访问记录
+type accessWindow struct {
+	epoch  int64              // 窗口的编号，即窗口开始的时间除以窗口时长
+	counts map[string]float64 // 各前缀的估算访问次数
+}
+
+// RecordAccess 记录一次key的访问，用于按前缀统计热点key，未开启采样或未被采样时直接返回
+// 数据库的操作之间会相互调用，因此不在每个操作中记录，服务端执行命令时对命令中的每个key调用一次，直接使用数据库时可以自行调用
+func (db *MinDB) RecordAccess(key []byte) {
+	db.mu.RLock()
+	rate, window := db.config.HotKeySampleRate, db.config.HotKeyWindow
+	db.mu.RUnlock()
+	if rate <= 0 || window <= 0 || len(key) == 0 {
+		return
+	}
+	if rate < 1 && rand.Float64() >= rate {
+		return
+	}
+
+	prefix := keyPrefix(key)
+
+	epoch := time.Now().Unix() / window
+	a := db.hotKeys
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	w := &a.windows[epoch%hotKeyWindows]
+	if w.epoch != epoch || w.counts == nil { // 窗口已经过期，重新开始统计
+		w.epoch, w.counts = epoch, make(map[string]float64)
+	}
+	p := string(prefix)
+	if _, ok := w.counts[p]; !ok && len(w.counts) >= hotKeyMaxPrefixes {
+		p = HotKeyOther
+	}
+	if rate > 1 {
+		rate = 1
+	}
+	w.counts[p] += 1 / rate
+}
+
+// HotKeys 返回最近一段时间内访问次数最多的 n 个key前缀，n 不大于 0 时返回所有前缀
+// 需要配置 HotKeySampleRate 开启采样，统计的时间为最近 hotKeyWindows 个时长为 HotKeyWindow 秒的窗口
+func (db *MinDB) HotKeys(n int) []PrefixAccess {
+	window := db.Config().HotKeyWindow
+	if window <= 0 {
+		return nil
+	}
+	now := time.Now().Unix() / window
+
+	a := db.hotKeys
+	a.mu.Lock()
+	prefixes := make(map[string]*PrefixAccess)
+	for i := int64(0); i < hotKeyWindows; i++ {
+		w := &a.windows[(now-i)%hotKeyWindows]
+		if w.epoch != now-i {
+			continue
+		}
+		for prefix, count := range w.counts {
+			p := prefixes[prefix]
+			if p == nil {
+				p = &PrefixAccess{Prefix: prefix, Counts: make([]int64, hotKeyWindows)}
+				prefixes[prefix] = p
+			}
+			p.Counts[i] = int64(count + 0.5)
+			p.Total += p.Counts[i]
+		}
+	}
+	a.mu.Unlock()
+
+	res := make([]PrefixAccess, 0, len(prefixes))
+	for _, p := range prefixes {
+		res = append(res, *p)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Total != res[j].Total {
+			return res[i].Total > res[j].Total
+		}
+		return res[i].Prefix < res[j].Prefix
+	})
+	if n > 0 && len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+// ResetHotKeys 清空key访问的统计
+func (db *MinDB) ResetHotKeys() {
+	a := db.hotKeys
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.windows = [hotKeyWindows]accessWindow{}
+}
@@ -11,7 +11,6 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"time"
 )
 
 // DataType 数据类型定义
@@ -30,6 +29,11 @@ const (
 const (
 	StringSet uint16 = iota
 	StringRem
+	StringExpire
+	StringPersist
+	StringPatch
+	StringIntent // 多key操作的开始
+	StringCommit // 多key操作的提交
 )
 
 // 列表相关操作标识
@@ -42,87 +46,193 @@ const (
 	ListLInsert
 	ListLSet
 	ListLTrim
+	ListCommit // 批量写入的提交，列表的操作不使用意向记录，见 db_batch.go
 )
 
 // 哈希相关操作标识
 const (
 	HashHSet uint16 = iota
 	HashHDel
+	HashIntent // 多key操作的开始
+	HashCommit // 多key操作的提交
 )
 
 // 集合相关操作标识
 const (
 	SetSAdd uint16 = iota
 	SetSRem
-	SetSMove
+	SetSMove  // 旧格式的移动操作，现在的 SMove 以意向记录写入，见 intent.go
+	SetIntent // 多key操作的开始
+	SetCommit // 多key操作的提交
 )
 
 // 有序集合相关操作标识
 const (
 	ZSetZAdd uint16 = iota
 	ZSetZRem
+	ZSetIntent // 多key操作的开始
+	ZSetCommit // 多key操作的提交
 )
 
-// 建立字符串索引
-func (db *MinDB) buildStringIndex(idx *index.Indexer, opt uint16) {
+// 建立字符串索引，deadline 为 entry 中携带的过期时间
+func (db *MinDB) buildStringIndex(idx *index.Indexer, opt uint16, deadline uint64) {
 	if db.strIndex == nil || idx == nil {
 		return
 	}
 
-	now := uint32(time.Now().Unix())
-	if deadline, exist := db.expires[string(idx.Meta.Key)]; exist && deadline <= now {
-		return
-	}
-
+	// 加载时已经过期的key同样保留在索引中，之后由读取或 RunActiveExpire 删除，从而触发 OnExpired 的回调
+	key := idx.Meta.Key
 	switch opt {
 	case StringSet:
-		db.strIndex.idxList.Put(idx.Meta.Key, idx)
+		delete(db.strIndex.patches, string(key)) // 完整的值覆盖了之前的所有修改
+		db.putStrIndex(key, idx)
+		if deadline > 0 {
+			db.expires[string(key)] = uint32(deadline)
+		} else {
+			delete(db.expires, string(key))
+		}
 	case StringRem:
-		db.strIndex.idxList.Remove(idx.Meta.Key)
+		db.removeStrIndex(key)
+		delete(db.expires, string(key))
+		delete(db.strIndex.patches, string(key))
+	case StringExpire:
+		if db.strIndex.idxList.Exist(key) {
+			db.expires[string(key)] = uint32(deadline)
+		}
+	case StringPersist:
+		delete(db.expires, string(key))
 	}
 }
 
 // 建立列表索引
-func (db *MinDB) buildListIndex(idx *index.Indexer, opt uint16) {
+func (db *MinDB) buildListIndex(idx *index.Indexer, opt uint16, seq uint64) {
 	if db.listIndex == nil || idx == nil {
 		return
 	}
 
-	key := string(idx.Meta.Key)
+	replayListOp(db.listIndex.indexes, idx.Meta, opt)
+	db.listIndex.observeSeq(string(idx.Meta.Key), seq)
+}
+
+// 列表的操作按文件id及位置的顺序重放，同一个列表的操作在文件中的先后与其操作序号一致（写入时持有列表的锁，
+// 回收后的文件id小于活跃文件），只需记住每个列表最大的序号即可发现例外：序号倒退的列表记录下来，
+// 遍历结束后重新读取这些列表的操作，按序号排序后单独重放，不需要把所有列表的操作都读入内存
+type listOrder struct {
+	last       map[string]uint64 // 每个列表已读到的最大操作序号
+	disordered map[string]bool   // 操作序号倒退的列表
+}
+
+func newListOrder() *listOrder {
+	return &listOrder{last: make(map[string]uint64), disordered: make(map[string]bool)}
+}
+
+func (o *listOrder) observe(e *storage.Entry) {
+	if e.Seq == 0 { // 旧格式没有序号的entry保持文件中的顺序
+		return
+	}
+	key := string(e.Meta.Key)
+	if e.Seq < o.last[key] {
+		o.disordered[key] = true
+	} else {
+		o.last[key] = e.Seq
+	}
+}
+
+// 文件中的一条列表操作及其位置
+type listOp struct {
+	e      *storage.Entry
+	fileId uint32
+	offset int64
+}
+
+// 读取 files 中属于 keys 的列表操作，按操作序号稳定排序，没有序号的旧格式entry排在最前并保持原来的顺序
+// 损坏的entry与加载时一样跳过，无法确定下一条entry位置时跳过文件的剩余部分
+func readListOps(files []*storage.DBFile, keys map[string]bool, bufSize int) []listOp {
+	var ops []listOp
+	iter := storage.NewMergedIterator(files, storage.OrderByOffset)
+	iter.SetBufferSize(bufSize)
+	for {
+		e, fileId, offset, err := iter.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if err != storage.ErrInvalidCrc {
+				iter.SkipFile()
+			}
+			continue
+		}
+		if keys[string(e.Meta.Key)] {
+			ops = append(ops, listOp{e: e, fileId: fileId, offset: offset})
+		}
+	}
+	sort.SliceStable(ops, func(i, j int) bool {
+		return ops[i].e.Seq < ops[j].e.Seq
+	})
+	return ops
+}
+
+// 按操作序号重新重放 keys 中的列表，用于加载时发现操作序号倒退的列表，已经提交的批量写入中的操作才会生效
+func (db *MinDB) replayListsBySeq(files []*storage.DBFile, keys map[string]bool) {
+	ops := readListOps(files, keys, 0)
+
+	db.listIndex.mu.Lock()
+	defer db.listIndex.mu.Unlock()
+	for key := range keys {
+		db.listIndex.indexes.Clear(key)
+		delete(db.listIndex.seqs, key)
+	}
+	for _, op := range ops {
+		e := op.e
+		if e.Mark == ListCommit || op.offset > db.config.BlockSize {
+			continue
+		}
+		if id, ok := listBatchId(e); ok && !db.intents.committed[List][id] {
+			continue
+		}
+		idx := &index.Indexer{Meta: e.Meta, FileId: op.fileId, EntrySize: e.Size(), Offset: op.offset}
+		db.buildListIndex(idx, e.Mark, e.Seq)
+	}
+	log.Printf("replayed %d lists by operation sequence\n", len(keys))
+}
+
+// 在列表上重放一条操作，建立索引和回收磁盘空间时共用
+func replayListOp(lis *list.List, meta *storage.Meta, opt uint16) {
+	key := string(meta.Key)
 	switch opt { // 根据操作类型对列表执行相应操作
 	case ListLPush:
-		db.listIndex.indexes.LPush(key, idx.Meta.Value)
+		lis.LPush(key, meta.Value)
 	case ListLPop:
-		db.listIndex.indexes.LPop(key)
+		lis.LPop(key)
 	case ListRPush:
-		db.listIndex.indexes.RPush(key, idx.Meta.Value)
+		lis.RPush(key, meta.Value)
 	case ListRPop:
-		db.listIndex.indexes.RPop(key)
+		lis.RPop(key)
 	case ListLRem:
-		if count, err := strconv.Atoi(string(idx.Meta.Extra)); err == nil {
-			db.listIndex.indexes.LRem(key, idx.Meta.Value, count)
+		if count, err := strconv.Atoi(string(meta.Extra)); err == nil {
+			lis.LRem(key, meta.Value, count)
 		}
 	case ListLInsert:
-		extra := string(idx.Meta.Extra)
+		extra := string(meta.Extra)
 		s := strings.Split(extra, ExtraSeparator)
 		if len(s) == 2 {
 			pivot := []byte(s[0])
 			if opt, err := strconv.Atoi(s[1]); err == nil {
-				db.listIndex.indexes.LInsert(string(idx.Meta.Key), list.InsertOption(opt), pivot, idx.Meta.Value)
+				lis.LInsert(key, list.InsertOption(opt), pivot, meta.Value)
 			}
 		}
 	case ListLSet:
-		if i, err := strconv.Atoi(string(idx.Meta.Extra)); err == nil {
-			db.listIndex.indexes.LSet(key, i, idx.Meta.Value)
+		if i, err := strconv.Atoi(string(meta.Extra)); err == nil {
+			lis.LSet(key, i, meta.Value)
 		}
 	case ListLTrim:
-		extra := string(idx.Meta.Extra)
+		extra := string(meta.Extra)
 		s := strings.Split(extra, ExtraSeparator)
 		if len(s) == 2 {
 			start, _ := strconv.Atoi(s[0])
 			end, _ := strconv.Atoi(s[1])
 
-			db.listIndex.indexes.LTrim(string(idx.Meta.Key), start, end)
+			lis.LTrim(key, start, end)
 		}
 	}
 }
@@ -180,12 +290,71 @@ func (db *MinDB) buildZsetIndex(idx *index.Indexer, opt uint16) {
 	}
 }
 
+// 加载索引时每批处理的entry数量
+const loadBatchSize = 1024
+
+// 获取某一类型索引的锁
+func (db *MinDB) idxLock(dataType DataType) *sync.RWMutex {
+	switch dataType {
+	case String:
+		return &db.strIndex.mu
+	case List:
+		return &db.listIndex.mu
+	case Hash:
+		return &db.hashIndex.mu
+	case Set:
+		return &db.setIndex.mu
+	case ZSet:
+		return &db.zsetIndex.mu
+	}
+	return nil
+}
+
+// 批量建立同一类型的索引，整批entry只加一次索引锁
+// 并根据批内每个key新增的元素个数预先分配哈希表、集合、有序集合的空间，避免逐条写入时map反复扩容
+func (db *MinDB) buildIndexBatch(dataType DataType, entries []*storage.Entry, idxes []*index.Indexer) {
+	if len(entries) == 0 {
+		return
+	}
+
+	mu := db.idxLock(dataType)
+	mu.Lock()
+	defer mu.Unlock()
+
+	counts := make(map[string]int)
+	for _, e := range entries {
+		if (dataType == Hash && e.Mark == HashHSet) || (dataType == Set && e.Mark == SetSAdd) ||
+			(dataType == ZSet && e.Mark == ZSetZAdd) {
+			counts[string(e.Meta.Key)]++
+		}
+	}
+	for key, n := range counts {
+		switch dataType {
+		case Hash:
+			db.hashIndex.indexes.Reserve(key, n)
+		case Set:
+			db.setIndex.indexes.Reserve(key, n)
+		case ZSet:
+			db.zsetIndex.indexes.Reserve(key, n)
+		}
+	}
+
+	for i, e := range entries {
+		if err := db.buildIndex(e, idxes[i]); err != nil {
+			log.Fatalf("a fatal err occurred, the db can not open.[%+v]", err)
+		}
+	}
+}
+
 // 从文件中加载String、List、Hash、Set、ZSet索引
 func (db *MinDB) loadIdxFromFiles() error {
 	if db.archFiles == nil && db.activeFile == nil {
 		return nil
 	}
 
+	var maxSeqs [5]uint64 // 各类型entry中最大的全局序号（如锁的 fencing token、多key操作的id），列表的序号是每个key各自的，不计入
+	var errs [5]error
+	var truncates [5][]corruptPos // RecoveryRepair 时各类型需要截断的位置
 	wg := sync.WaitGroup{}
 	wg.Add(5)
 	for dataType := 0; dataType < 5; dataType++ { // 遍历五种数据类型的文件
@@ -194,50 +363,101 @@ func (db *MinDB) loadIdxFromFiles() error {
 				wg.Done()
 			}()
 
-			// archived files
-			var fileIds []int                          // 记录文件id
-			dbFile := make(map[uint32]*storage.DBFile) // 记录文件id与数据文件信息的map
-			for k, v := range db.archFiles[dType] {    // 遍历当前类型的所有文件
-				dbFile[k] = v                     // 构造id与文件信息的map
-				fileIds = append(fileIds, int(k)) // 记录文件id
+			files := make([]*storage.DBFile, 0, len(db.archFiles[dType])+1)
+			for _, f := range db.archFiles[dType] { // 遍历当前类型的所有文件
+				files = append(files, f)
+			}
+			files = append(files, db.activeFile[dType])
+
+			// 按批建立索引，每批只需加一次锁
+			var batch []*storage.Entry
+			var idxes []*index.Indexer
+			flush := func() {
+				db.buildIndexBatch(dType, batch, idxes)
+				batch, idxes = batch[:0], idxes[:0]
 			}
 
-			// active file
-			dbFile[db.activeFileIds[dType]] = db.activeFile[dType]
-			fileIds = append(fileIds, int(db.activeFileIds[dType]))
-
-			// load the db files in a specified order.
-			sort.Ints(fileIds)
-			for i := 0; i < len(fileIds); i++ {
-				fid := uint32(fileIds[i])
-				df := dbFile[fid]
-				var offset int64 = 0
-
-				for offset <= db.config.BlockSize {
-					if e, err := df.Read(offset); err == nil {
-						idx := &index.Indexer{
-							Meta:      e.Meta,
-							FileId:    fid,
-							EntrySize: e.Size(),
-							Offset:    offset,
-						}
-						offset += int64(e.Size())
-
-						if len(e.Meta.Key) > 0 {
-							if err := db.buildIndex(e, idx); err != nil {
-								log.Fatalf("a fatal err occurred, the db can not open.[%+v]", err)
-							}
-						}
-					} else {
-						if err == io.EOF {
-							break
-						}
-						log.Fatalf("a fatal err occurred, the db can not open.[%+v]", err)
+			// 按文件id的顺序加载，列表的操作需要按操作序号重放，见 listOrder
+			var lists *listOrder
+			if dType == List {
+				lists = newListOrder()
+			}
+			ends := make(map[uint32]int64) // 每个文件中最后一条entry的结束位置
+			iter := storage.NewMergedIterator(files, storage.OrderByOffset)
+			for {
+				e, fid, offset, err := iter.Next()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					if errs[dType] = db.recoverCorrupt(iter, dType, fid, offset, err, &truncates[dType]); errs[dType] != nil {
+						return
+					}
+					continue
+				}
+				ends[fid] = offset + int64(e.Size())
+				if offset > db.config.BlockSize {
+					continue
+				}
+
+				idx := &index.Indexer{
+					Meta:      e.Meta,
+					FileId:    fid,
+					EntrySize: e.Size(),
+					Offset:    offset,
+				}
+
+				if dType != List && e.Seq > maxSeqs[dType] {
+					maxSeqs[dType] = e.Seq
+				}
+
+				if len(e.Meta.Key) > 0 {
+					if lists != nil {
+						lists.observe(e)
+					}
+					batch = append(batch, e)
+					idxes = append(idxes, idx)
+					if len(batch) >= loadBatchSize {
+						flush()
 					}
 				}
 			}
+			flush()
+			if lists != nil && len(lists.disordered) > 0 {
+				db.replayListsBySeq(files, lists.disordered)
+			}
+			// 写偏移只在关闭时保存到meta中，异常退出后从活跃文件中最后一条entry之后继续写入，避免覆盖已有的entry；
+			// 已封存文件的偏移同样是其数据的大小，供回收统计使用
+			for _, f := range files {
+				if f == nil {
+					continue
+				}
+				if end, ok := ends[f.Id]; ok && end > f.DataOffset() {
+					f.Offset = end
+				} else {
+					f.Offset = f.DataOffset()
+				}
+			}
 		}(uint16(dataType))
 	}
 	wg.Wait()
-	return nil
+	for dataType, err := range errs {
+		if err != nil {
+			return err
+		}
+		for _, pos := range truncates[dataType] {
+			if err := db.truncateCorrupt(DataType(dataType), pos); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 异常退出时meta中的写入序号可能没有保存，需要保证之后分配的序号大于数据文件中已有的序号
+	maxSeqs[List] = db.intents.maxListBatch
+	for _, seq := range maxSeqs {
+		if seq > db.meta.Sequence {
+			db.meta.Sequence = seq
+		}
+	}
+	return db.resolvePendingIntents()
 }
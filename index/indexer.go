@@ -10,4 +10,5 @@ type Indexer struct {
 	FileId    uint32        //存储数据的文件id
 	EntrySize uint32        //数据条目(Entry)的大小
 	Offset    int64         //Entry数据的查询起始位置
+	Checksum  uint32        //开启 VerifyValueOnRead 时字符串的值的校验和
 }
@@ -166,6 +166,32 @@ func (t *SkipList) Foreach(fun handleEle) {
 	}
 }
 
+// Sample 随机选取最多 n 个元素，不需要遍历整个跳表
+// 节点的层数与key无关，因此某一层上的节点本身就是所有节点的随机子集，从节点数不少于 2n 的最高一层中再随机选取
+// 多次调用时较高层的节点更容易被重复选中，适用于统计分析等只需近似均匀的场景
+func (t *SkipList) Sample(n int) []*Element {
+	if n <= 0 || t.Len == 0 {
+		return nil
+	}
+
+	level := 0
+	for level+1 < t.maxLevel && float64(t.Len)*math.Pow(t.probability, float64(level+1)) >= float64(2*n) {
+		level++
+	}
+
+	var candidates []*Element
+	for e := t.next[level]; e != nil; e = e.next[level] {
+		candidates = append(candidates, e)
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates
+}
+
 // 找到key对应的前一个节点索引的信息，即key节点在每一层索引的前一个节点
 func (t *SkipList) backNodes(key []byte) []*Node {
 	var prev = &t.Node
@@ -226,3 +252,36 @@ func probabilityTable(probability float64, maxLevel int) (table []float64) {
 	}
 	return table
 }
+
+// Seek 找到第一个key不小于给定key的Element，不存在时返回nil
+func (t *SkipList) Seek(key []byte) *Element {
+	var prev = &t.Node
+	var next *Element
+
+	for i := t.maxLevel - 1; i >= 0; i-- {
+		next = prev.next[i]
+
+		for next != nil && bytes.Compare(key, next.key) > 0 {
+			prev = &next.Node
+			next = next.next[i]
+		}
+	}
+	return next
+}
+
+// SeekBefore 找到最后一个key小于给定key的Element，key为nil时返回最后一个Element，不存在时返回nil
+func (t *SkipList) SeekBefore(key []byte) *Element {
+	var prev = &t.Node
+	var last *Element
+
+	for i := t.maxLevel - 1; i >= 0; i-- {
+		next := prev.next[i]
+
+		for next != nil && (key == nil || bytes.Compare(key, next.key) > 0) {
+			last = next
+			prev = &next.Node
+			next = next.next[i]
+		}
+	}
+	return last
+}
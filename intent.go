@@ -0,0 +1,229 @@
+package mindb
+
+import (
+	"log"
+	"mindb/index"
+	"mindb/storage"
+	"strconv"
+)
+
+// 修改多个key的操作（如 SMove）以意向记录的形式写入：
+//
+//	intent → 每个key上各自独立的操作（如源集合上的 SRem、目标集合上的 SAdd）→ commit
+//
+// 所有entry的 Seq 为同一个操作id。重建索引时 intent 之后带有该id的操作先缓冲，读到 commit 后一起应用，
+// 写入中途崩溃而没有 commit 的操作被丢弃，因此多key操作在重放时要么全部生效，要么全部不生效。
+// 每个key上的操作与普通的增删一样，回收时按各自key的当前状态判断是否有效；intent 和 commit 在回收时被丢弃，
+// 失去 intent 的操作在重放时直接应用，它们的效果已经与当前状态一致，不再依赖其他key上的数据。
+// 跨类型的批量写入（见 db_batch.go）在每种类型中各有一组意向记录，使用同一个id
+
+// 重建索引时等待 commit 的一条操作
+type pendingOp struct {
+	e   *storage.Entry
+	idx *index.Indexer
+}
+
+// 重建索引时意向记录的状态，数组的下标为数据类型，各类型的索引并发重建，互不影响
+type intentBuffers struct {
+	pending      [5]map[uint64][]pendingOp // 读到 intent 但还没有读到 commit 的操作
+	committed    [5]map[uint64]bool        // 读到 commit 的操作id，用于判断批量写入在其他类型中是否已经提交
+	maxListBatch uint64                    // 列表中最大的批量写入id，列表的操作序号是每个key各自的，批次id记录在 Extra 中
+}
+
+func (b *intentBuffers) commit(dataType DataType, id uint64) {
+	if b.committed[dataType] == nil {
+		b.committed[dataType] = make(map[uint64]bool)
+	}
+	b.committed[dataType][id] = true
+}
+
+// 操作在任意一种类型中已经提交
+func (b *intentBuffers) isCommitted(id uint64) bool {
+	for _, committed := range b.committed {
+		if committed[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// 数据类型中意向记录的操作标识，不支持多key操作的类型返回 false
+func intentMarks(dataType DataType) (intent, commit uint16, ok bool) {
+	switch dataType {
+	case String:
+		return StringIntent, StringCommit, true
+	case Hash:
+		return HashIntent, HashCommit, true
+	case Set:
+		return SetIntent, SetCommit, true
+	case ZSet:
+		return ZSetIntent, ZSetCommit, true
+	}
+	return 0, 0, false
+}
+
+// 写入一个多key操作，ops 为各key上的操作，前后分别写入 intent 和 commit，写入后统一持久化
+// 调用方需持有该类型索引的写锁，写入成功后再修改内存中的索引；全部写入并持久化之后才记录数据变更
+func (db *MinDB) writeIntent(intent, commit *storage.Entry, ops ...*storage.Entry) error {
+	id, err := db.seqs.next()
+	if err != nil {
+		return err
+	}
+	entries := make([]*storage.Entry, 0, len(ops)+2)
+	entries = append(entries, intent)
+	entries = append(entries, ops...)
+	entries = append(entries, commit)
+	if err = db.seqs.reserve(uint64(len(entries))); err != nil {
+		return err
+	}
+	defer db.seqs.release(uint64(len(entries)))
+
+	for _, e := range entries {
+		e.Seq = id
+		if err = db.writeEntry(e); err != nil {
+			return err
+		}
+	}
+	if err = db.syncActive(intent.Type, len(entries)); err != nil {
+		return err
+	}
+	db.emitWrites(entries...)
+	return nil
+}
+
+// 重建索引时处理意向记录，entry 属于一个还没有 commit 的多key操作时缓冲下来并返回 true，
+// 读到 commit 时应用缓冲的所有操作；调用方需持有该类型索引的写锁
+func (db *MinDB) replayIntent(e *storage.Entry, idx *index.Indexer) bool {
+	if e.Type == List {
+		return db.replayListBatch(e, idx)
+	}
+	intent, commit, ok := intentMarks(e.Type)
+	if !ok || e.Seq == 0 {
+		return false
+	}
+
+	pending := db.intents.pending[e.Type]
+	switch e.Mark {
+	case intent:
+		if pending == nil {
+			pending = make(map[uint64][]pendingOp)
+			db.intents.pending[e.Type] = pending
+		}
+		pending[e.Seq] = nil
+	case commit:
+		db.intents.commit(e.Type, e.Seq)
+		ops := pending[e.Seq]
+		delete(pending, e.Seq)
+		for _, op := range ops {
+			_ = db.buildIndex(op.e, op.idx)
+		}
+	default:
+		ops, exist := pending[e.Seq]
+		if !exist { // intent 已经在回收时被丢弃，操作单独生效
+			return false
+		}
+		pending[e.Seq] = append(ops, pendingOp{e: e, idx: idx})
+	}
+	return true
+}
+
+// 列表批量写入的操作在 commit 之前写入，期间持有列表的锁，操作与 commit 之间没有其他列表操作，
+// 因此读到带有批次id的操作时先缓冲下来，读到 commit 时按原来的顺序应用；没有 commit 的操作在重建完成后丢弃
+func (db *MinDB) replayListBatch(e *storage.Entry, idx *index.Indexer) bool {
+	if e.Mark == ListCommit {
+		if id, err := strconv.ParseUint(string(e.Meta.Extra), 10, 64); err == nil {
+			db.intents.commit(List, id)
+			db.observeListBatch(id)
+			ops := db.intents.pending[List][id]
+			delete(db.intents.pending[List], id)
+			for _, op := range ops {
+				_ = db.buildIndex(op.e, op.idx)
+			}
+		}
+		return true
+	}
+	id, ok := listBatchId(e)
+	if !ok {
+		return false
+	}
+	db.observeListBatch(id)
+	if db.intents.committed[List][id] {
+		return false
+	}
+	if db.intents.pending[List] == nil {
+		db.intents.pending[List] = make(map[uint64][]pendingOp)
+	}
+	db.intents.pending[List][id] = append(db.intents.pending[List][id], pendingOp{e: e, idx: idx})
+	return true
+}
+
+func (db *MinDB) observeListBatch(id uint64) {
+	if id > db.intents.maxListBatch {
+		db.intents.maxListBatch = id
+	}
+}
+
+// 列表中属于批量写入的操作在 Extra 中记录批次id，普通的 LPush、RPush 不带 Extra
+func listBatchId(e *storage.Entry) (uint64, bool) {
+	if (e.Mark != ListLPush && e.Mark != ListRPush) || len(e.Meta.Extra) == 0 {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(string(e.Meta.Extra), 10, 64)
+	return id, err == nil
+}
+
+// 属于没有提交的批量写入的列表操作，回收时丢弃，调用方需持有列表索引的锁
+func (db *MinDB) abortedListOp(e *storage.Entry) bool {
+	id, ok := listBatchId(e)
+	return ok && db.aborted[id]
+}
+
+// 索引重建完成后处理没有 commit 的多key操作：批量写入在写 commit 的中途崩溃时，部分类型中已经有 commit，
+// 其余类型中缺少 commit 的操作一定在该类型数据文件的末尾（写入期间持有该类型的锁），此时应用这些操作并补写 commit，
+// 之后的写入都在 commit 之后；在任何类型中都没有 commit 的操作没有完成写入，丢弃
+func (db *MinDB) resolvePendingIntents() error {
+	defer func() {
+		db.intents = intentBuffers{}
+	}()
+
+	for _, dataType := range DataTypes {
+		pending := db.intents.pending[dataType]
+		db.intents.pending[dataType] = nil // 之后应用的操作不再缓冲
+
+		discarded := 0
+		for id, ops := range pending {
+			if dataType == List || !db.intents.isCommitted(id) {
+				if dataType == List {
+					db.aborted[id] = true
+				}
+				discarded++
+				continue
+			}
+			for _, op := range ops {
+				_ = db.buildIndex(op.e, op.idx)
+			}
+			if err := db.repairCommit(dataType, id, ops); err != nil {
+				return err
+			}
+		}
+		if discarded > 0 {
+			log.Printf("discard %d uncommitted multi-key operations of type %d\n", discarded, dataType)
+		}
+	}
+	return nil
+}
+
+// 补写批量写入在某一类型中缺少的 commit
+func (db *MinDB) repairCommit(dataType DataType, id uint64, ops []pendingOp) error {
+	_, commit, _ := intentMarks(dataType)
+	if len(ops) == 0 { // 批量写入只在有操作的类型中写入意向记录
+		return nil
+	}
+	e := storage.NewEntryNoExtra(ops[0].e.Meta.Key, nil, dataType, commit)
+	e.Seq = id
+	if err := db.write(e); err != nil {
+		return err
+	}
+	activeFile, _ := db.getActiveFile(dataType)
+	return activeFile.Sync()
+}
@@ -0,0 +1,111 @@
+package mindb
+
+import (
+	"fmt"
+	"mindb/storage"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 在 cut 返回 true 的第一条entry处截断的位置
+type truncation struct {
+	dataType DataType
+	cut      func(e *storage.Entry) bool
+}
+
+// 在第一条 mark 之后的entry处截断，即只保留 mark 及其之前的entry
+func afterMark(dataType DataType, mark uint16) truncation {
+	seen := false
+	return truncation{dataType: dataType, cut: func(e *storage.Entry) bool {
+		if seen {
+			return true
+		}
+		seen = e.Mark == mark
+		return false
+	}}
+}
+
+// 在第一条 mark 处截断
+func atMark(dataType DataType, mark uint16) truncation {
+	return truncation{dataType: dataType, cut: func(e *storage.Entry) bool {
+		return e.Mark == mark
+	}}
+}
+
+// 模拟写入到一半时崩溃：异常退出后按 truncs 截断各类型的活跃文件，再重新打开数据库
+func crashTruncated(t *testing.T, db *MinDB, truncs ...truncation) *MinDB {
+	t.Helper()
+	if err := db.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := make(map[DataType]int64)
+	names := make(map[DataType]string)
+	for _, tr := range truncs {
+		df := db.activeFile[tr.dataType]
+		names[tr.dataType] = fmt.Sprintf(storage.DBFileFormatNames[tr.dataType], df.Id)
+		r := df.NewReaderSize(0)
+		for {
+			e, offset, err := r.Next()
+			if err != nil {
+				t.Fatalf("no entry to truncate at in type %d: %v", tr.dataType, err)
+			}
+			if tr.cut(e) {
+				sizes[tr.dataType] = offset
+				break
+			}
+		}
+	}
+
+	cfg := db.config
+	crash(t, db)
+	for dataType, size := range sizes {
+		if err := os.Truncate(filepath.Join(cfg.DirPath, names[dataType]), size); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// SMove 写入 commit 之前崩溃时，重新打开后源集合和目标集合都保持不变
+func TestSMoveCrashBeforeCommit(t *testing.T) {
+	cases := map[string]func() truncation{
+		"after intent":  func() truncation { return afterMark(Set, SetIntent) },
+		"before commit": func() truncation { return atMark(Set, SetCommit) },
+	}
+	for name, trunc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.DirPath = t.TempDir()
+			db, err := Open(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			src, dst, member := []byte("src"), []byte("dst"), []byte("m")
+			if _, err = db.SAdd(src, member); err != nil {
+				t.Fatal(err)
+			}
+			if err = db.SMove(src, dst, member); err != nil {
+				t.Fatal(err)
+			}
+
+			db = crashTruncated(t, db, trunc())
+			if !db.SIsMember(src, member) || db.SIsMember(dst, member) {
+				t.Fatalf("SMove applied partially or fully: src has m = %v, dst has m = %v",
+					db.SIsMember(src, member), db.SIsMember(dst, member))
+			}
+			if err = db.SMove(src, dst, member); err != nil {
+				t.Fatal(err)
+			}
+			if db.SIsMember(src, member) || !db.SIsMember(dst, member) {
+				t.Fatal("SMove after reopen not applied")
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package mindb
+
+import (
+	"bytes"
+	"mindb/index"
+	"sort"
+)
+
+// PrefixSize 一个key前缀下字符串key的数量及大小
+type PrefixSize struct {
+	Prefix string `json:"prefix"`
+	Keys   int64  `json:"keys"`
+	Bytes  int64  `json:"bytes"` // key与value的字节数之和，不包括数据文件中的entry头部等开销
+}
+
+// 按前缀统计的字符串key的数量及大小，在字符串索引增删key时增量更新，读取时不需要遍历索引
+// 前缀的规则与热点key的统计相同，前缀的数量超出 hotKeyMaxPrefixes 后新的前缀计入 HotKeyOther
+// 统计中的前缀在key全部删除后仍然保留，保证同一个前缀始终计入同一项；以 \x00 开头的内部key不计入
+type prefixSizes map[string]*PrefixSize
+
+// key所属的前缀，为key中第一个分隔符及之前的部分，没有分隔符时为整个key
+func keyPrefix(key []byte) []byte {
+	prefix := key
+	if i := bytes.IndexByte(key, hotKeyPrefixSep); i >= 0 {
+		prefix = key[:i+1]
+	}
+	if len(prefix) > hotKeyMaxPrefixLen {
+		prefix = prefix[:hotKeyMaxPrefixLen]
+	}
+	return prefix
+}
+
+func (p prefixSizes) add(key []byte, keys, size int64) {
+	if isInternalKey(key) {
+		return
+	}
+	prefix := string(keyPrefix(key))
+	s := p[prefix]
+	if s == nil && len(p) >= hotKeyMaxPrefixes {
+		prefix = HotKeyOther
+		s = p[prefix]
+	}
+	if s == nil {
+		s = &PrefixSize{Prefix: prefix}
+		p[prefix] = s
+	}
+	s.Keys += keys
+	s.Bytes += size
+}
+
+func strSize(idx *index.Indexer) int64 {
+	return int64(idx.Meta.KeySize) + int64(idx.Meta.ValueSize)
+}
+
+// 写入字符串索引并更新前缀统计，调用方需持有字符串索引的写锁
+func (db *MinDB) putStrIndex(key []byte, idx *index.Indexer) {
+	if node := db.strIndex.idxList.Get(key); node != nil {
+		db.strIndex.sizes.add(key, 0, strSize(idx)-strSize(node.Value().(*index.Indexer)))
+	} else {
+		db.strIndex.sizes.add(key, 1, strSize(idx))
+	}
+	db.strIndex.idxList.Put(key, idx)
+}
+
+// 从字符串索引中删除key并更新前缀统计，返回被删除的节点，调用方需持有字符串索引的写锁
+func (db *MinDB) removeStrIndex(key []byte) *index.Element {
+	ele := db.strIndex.idxList.Remove(key)
+	if ele != nil {
+		db.strIndex.sizes.add(key, -1, -strSize(ele.Value().(*index.Indexer)))
+	}
+	return ele
+}
+
+// PrefixSizes 返回各key前缀下字符串key的数量及大小，按大小从大到小排列，n 不大于 0 时返回所有前缀
+// 统计在写入时增量维护，调用的开销只与前缀的数量有关；已过期但还未删除的key仍然计入
+func (db *MinDB) PrefixSizes(n int) []PrefixSize {
+	db.strIndex.mu.RLock()
+	res := make([]PrefixSize, 0, len(db.strIndex.sizes))
+	for _, s := range db.strIndex.sizes {
+		if s.Keys > 0 {
+			res = append(res, *s)
+		}
+	}
+	db.strIndex.mu.RUnlock()
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Bytes != res[j].Bytes {
+			return res[i].Bytes > res[j].Bytes
+		}
+		return res[i].Prefix < res[j].Prefix
+	})
+	if n > 0 && len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
@@ -2,17 +2,21 @@ package mindb
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"mindb/ds/list"
 	"mindb/index"
 	"mindb/storage"
 	"mindb/utils"
 	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -36,6 +40,30 @@ var (
 	ErrInvalidTTL = errors.New("mindb: invalid ttl")
 
 	ErrKeyExpired = errors.New("mindb: key is expired")
+
+	ErrDBClosed = errors.New("mindb: the database is closed")
+
+	ErrReclaimRunning = errors.New("mindb: reclaim is already running")
+
+	ErrConfigImmutable = errors.New("mindb: the config can not be changed while the database is open")
+
+	ErrInvalidOffset = errors.New("mindb: offset is out of range")
+
+	ErrInvalidDataType = errors.New("mindb: invalid data type")
+
+	ErrCorruptData = errors.New("mindb: corrupted entry found when loading data files")
+
+	ErrDirLocked = errors.New("mindb: the dir path is used by another instance")
+)
+
+// Version mindb 的版本，HELLO 握手时返回给客户端
+const Version = "1.0.0"
+
+// 数据库的状态
+const (
+	stateOpen int32 = iota
+	stateClosing
+	stateClosed
 )
 
 const (
@@ -48,13 +76,16 @@ const (
 	//保存数据库相关信息的文件名称
 	dbMetaSaveFile = string(os.PathSeparator) + "db.meta"
 
+	//数据目录的锁文件，打开数据库期间持有其 flock
+	dirLockFile = string(os.PathSeparator) + "db.lock"
+
 	//回收磁盘空间时的临时目录
 	reclaimPath = string(os.PathSeparator) + "mindb_reclaim"
 
 	// ExtraSeparator 额外信息的分隔符，用于存储一些额外的信息（因此一些操作的value中不能包含此分隔符）
 	ExtraSeparator = "\\0"
 
-	//保存过期字典的文件名称
+	//旧版本保存过期字典的文件名称，现在过期时间随entry一起写入数据文件，此文件仅在打开时用于兼容迁移
 	expireFile = string(os.PathSeparator) + "db.expires"
 )
 
@@ -72,6 +103,33 @@ type (
 		mu            sync.RWMutex    //mutex
 		meta          *storage.DBMeta //数据库配置额外信息
 		expires       storage.Expires //过期字典
+		state         int32           //数据库的状态：打开、关闭中、已关闭
+		reclaiming    int32           //是否正在回收磁盘空间
+		fileMu        sync.RWMutex    //保护activeFile和activeFileIds，切换活跃文件时加写锁
+		hookMu        sync.RWMutex    //保护expiredHooks、queueHooks及hooks
+		expiredHooks  []ExpiredFunc   //key过期时的回调
+		expiry        *expiryNotifier //依次执行过期的回调
+		queueHooks    []QueueHook     //队列中有新的元素时的回调
+		hooks         []Hooks         //事件的监听
+		openedAt      time.Time       //数据库打开的时间
+		hotKeys       *keyAccess      //key访问的采样统计
+		seqs          *seqLimit       //写入序号已持久化的上限
+		changes       *changeFeed     //最近的数据变更
+		versions      *keyVersions    //被监视的key的修改版本
+		segCRCs       segmentCRCs     //已封存文件的校验和缓存，供冷备拉取
+		corruptReads  int64           //从磁盘读取到损坏数据的次数
+		repairedKeys  int64           //因数据损坏被修复的key数量
+		badChecksums  int64           //读取的值与索引中的校验和不一致的次数
+		loadCorrupt   int64           //打开时跳过或截断的损坏entry数
+		dirLock       *os.File        //数据目录的锁文件
+		queueMu       sync.Mutex      //依次执行队列的操作
+		queueIds      queueIdRanges   //各队列已分配的元素id，由 queueMu 保护
+		viewMu        sync.Mutex      //依次执行物化视图的创建及删除
+		views         atomic.Value    //物化视图的定义 []*View，修改时整体替换
+		reclaimRuns   []ReclaimRun    //最近的回收统计
+		intents       intentBuffers   //重建索引时等待 commit 的多key操作
+		aborted       map[uint64]bool //没有提交的列表批量写入的id，回收时丢弃其操作
+		txMu          sync.RWMutex    //Update 执行时独占，View 执行时共享
 	}
 
 	// ActiveFiles 不同类型的当前活跃文件
@@ -83,8 +141,25 @@ type (
 	ArchivedFiles map[DataType]map[uint32]*storage.DBFile
 )
 
-// Open 打开一个数据库实例
+// Open 打开一个数据库实例，数据目录已被其他实例打开时返回 ErrDirLocked，关闭数据库时释放
 func Open(config Config) (*MinDB, error) {
+	lock, err := lockDir(config.DirPath)
+	if err != nil {
+		return nil, err
+	}
+	db, err := open(config)
+	if err != nil {
+		unlockDir(lock)
+		return nil, err
+	}
+	db.dirLock = lock
+	return db, nil
+}
+
+func open(config Config) (*MinDB, error) {
+	if !config.Checksum.Valid() {
+		return nil, storage.ErrUnknownChecksum
+	}
 
 	//如果配置目录不存在则创建
 	if !utils.Exist(config.DirPath) {
@@ -93,8 +168,18 @@ func Open(config Config) (*MinDB, error) {
 		}
 	}
 
+	// 完成上一次被中断的回收，清理未完成的临时文件
+	reclaimed, err := recoverReclaim(config.DirPath, config.ReclaimTmpDir)
+	if err != nil {
+		return nil, err
+	}
+	// 上次运行时没有删除的临时快照（如全量同步期间进程崩溃）
+	if err = removeTempSnapshotDirs(config.DirPath); err != nil {
+		return nil, err
+	}
+
 	//加载数据文件信息，用一个map记录
-	archFiles, activeFileIds, err := storage.Build(config.DirPath, config.RwMethod, config.BlockSize)
+	archFiles, activeFileIds, err := storage.Build(config.DirPath, config.RwMethod, config.BlockSize, config.Checksum)
 	if err != nil {
 		return nil, err
 	}
@@ -102,22 +187,39 @@ func Open(config Config) (*MinDB, error) {
 	// 加载活跃文件
 	activeFiles := make(ActiveFiles)
 	for dataType, fileId := range activeFileIds { // 遍历每一种类型的活跃文件
-		file, err := storage.NewDBFile(config.DirPath, fileId, config.RwMethod, config.BlockSize, dataType)
+		file, err := storage.NewDBFile(config.DirPath, fileId, config.RwMethod, config.BlockSize, dataType, config.Checksum)
 		if err != nil {
 			return nil, err
 		}
 		activeFiles[dataType] = file // 将活跃文件信息进行缓存
 	}
 
-	// 加载过期字典
-	expires := storage.LoadExpires(config.DirPath + expireFile)
+	// 加载数据库额外信息（meta），旧版本的meta会被自动迁移
+	meta, err := storage.LoadMeta(config.DirPath + dbMetaSaveFile)
+	if err != nil {
+		return nil, err
+	}
+	// 写入序号从预留的上限继续，异常退出时 meta 中的序号没有保存
+	seqs, err := loadSeqLimit(config.DirPath, &meta.Sequence)
+	if err != nil {
+		return nil, err
+	}
 
-	// 加载数据库额外信息（meta）
-	meta, _ := storage.LoadMeta(config.DirPath + dbMetaSaveFile)
+	// 被回收替换的文件的失效数据统计已经不再准确
+	for dType, swap := range reclaimed {
+		for _, fileId := range swap.Old {
+			meta.ClearDeadBytes(dType, fileId)
+		}
+		for _, f := range swap.New {
+			meta.ClearDeadBytes(dType, f.Id)
+		}
+	}
 
-	// 更新当前活跃文件的写偏移
+	// 更新当前活跃文件的写偏移，写偏移不会在文件头之前
 	for dataType, file := range activeFiles {
-		file.Offset = meta.ActiveWriteOff[dataType]
+		if off := meta.ActiveWriteOff[dataType]; off > file.DataOffset() {
+			file.Offset = off
+		}
 	}
 
 	db := &MinDB{
@@ -131,14 +233,33 @@ func Open(config Config) (*MinDB, error) {
 		hashIndex:     newHashIdx(),
 		setIndex:      newSetIdx(),
 		zsetIndex:     newZsetIdx(),
-		expires:       expires,
+		expires:       make(storage.Expires),
+		openedAt:      time.Now(),
+		hotKeys:       &keyAccess{},
+		seqs:          seqs,
+		changes:       newChangeFeed(&meta.Sequence),
+		versions:      newKeyVersions(),
+		reclaimRuns:   loadReclaimHistory(config.DirPath),
+		aborted:       make(map[uint64]bool),
+		queueIds:      make(queueIdRanges),
 	}
 
-	// 从文件中加载索引信息
+	// 从文件中加载索引信息，过期字典也随之重建
 	if err := db.loadIdxFromFiles(); err != nil {
 		return nil, err
 	}
 
+	// 迁移旧版本单独保存的过期字典
+	if err := db.migrateLegacyExpires(); err != nil {
+		return nil, err
+	}
+
+	// 加载物化视图，并修复上次关闭前可能未完成的更新
+	if err := db.openViews(); err != nil {
+		return nil, err
+	}
+
+	db.expiry = newExpiryNotifier(db.fireExpired)
 	return db, nil
 }
 
@@ -161,9 +282,21 @@ func Reopen(path string) (*MinDB, error) {
 }
 
 // Close 关闭数据库，保存相关配置
+// 会等待正在进行的操作及磁盘空间回收完成，关闭之后的操作返回 ErrDBClosed
 func (db *MinDB) Close() error {
+	if !atomic.CompareAndSwapInt32(&db.state, stateOpen, stateClosing) {
+		return ErrDBClosed
+	}
+	defer atomic.StoreInt32(&db.state, stateClosed)
+	defer unlockDir(db.dirLock) // 文件都关闭之后才允许其他实例打开
+	db.changes.close() // 不再等待新的数据变更
+	// 等待已经排队的过期回调执行完成，回调中的操作返回 ErrDBClosed
+	db.expiry.close()
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	db.lockAllIdx() // 等待各类型正在进行的操作完成
+	defer db.unlockAllIdx()
 
 	if err := db.saveConfig(); err != nil {
 		return err
@@ -173,10 +306,6 @@ func (db *MinDB) Close() error {
 		return err
 	}
 
-	if err := db.expires.SaveExpires(db.config.DirPath + expireFile); err != nil { // 保存过期信息
-		return err
-	}
-
 	// close and sync the active file
 	for _, file := range db.activeFile {
 		if err := file.Close(true); err != nil {
@@ -204,20 +333,112 @@ func (db *MinDB) Sync() error {
 
 	db.mu.RLock()
 	defer db.mu.RUnlock()
-	for _, file := range db.activeFile {
-		if err := file.Sync(); err != nil {
+	if !db.isOpen() {
+		return ErrDBClosed
+	}
+
+	for _, dataType := range DataTypes {
+		lock := db.idxLock(dataType) // 避免与写入时切换活跃文件冲突
+		lock.RLock()
+		file, _ := db.getActiveFile(dataType)
+		err := file.Sync()
+		lock.RUnlock()
+		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// Config 返回数据库当前的配置
+func (db *MinDB) Config() Config {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.config
+}
+
+// SetConfig 在运行时修改数据库的配置，修改时会等待正在进行的操作完成
+// 数据目录、数据块大小、读写模式、索引模式、校验和算法决定了磁盘上的数据格式，打开后不能修改，否则返回 ErrConfigImmutable
+func (db *MinDB) SetConfig(fn func(config *Config)) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.lockAllIdx()
+	defer db.unlockAllIdx()
+
+	if !db.isOpen() {
+		return ErrDBClosed
+	}
+
+	config := db.config
+	fn(&config)
+	if config.DirPath != db.config.DirPath || config.BlockSize != db.config.BlockSize ||
+		config.RwMethod != db.config.RwMethod || config.IdxMode != db.config.IdxMode ||
+		config.Checksum != db.config.Checksum {
+		return ErrConfigImmutable
+	}
+	db.config = config
+	return nil
+}
+
+// 数据库是否处于打开状态
+func (db *MinDB) isOpen() bool {
+	return atomic.LoadInt32(&db.state) == stateOpen
+}
+
+// 按固定的顺序获取所有类型的索引锁，用于关闭和回收时阻塞其他操作
+func (db *MinDB) lockAllIdx() {
+	for _, dataType := range DataTypes {
+		db.idxLock(dataType).Lock()
+	}
+}
+
+func (db *MinDB) unlockAllIdx() {
+	for i := len(DataTypes) - 1; i >= 0; i-- {
+		db.idxLock(DataTypes[i]).Unlock()
+	}
+}
+
+func (db *MinDB) rLockAllIdx() {
+	for _, dataType := range DataTypes {
+		db.idxLock(dataType).RLock()
+	}
+}
+
+func (db *MinDB) rUnlockAllIdx() {
+	for i := len(DataTypes) - 1; i >= 0; i-- {
+		db.idxLock(DataTypes[i]).RUnlock()
+	}
+}
+
 // Reclaim 重新组织磁盘中的数据，回收磁盘空间，回收过程中数据库会阻塞，无法使用
+// 同一时间只能有一个回收在进行，否则返回 ErrReclaimRunning
 func (db *MinDB) Reclaim() (err error) {
+	return db.ReclaimContext(context.Background())
+}
+
+// ReclaimContext 与 Reclaim 相同，ctx 被取消或超时后放弃本次回收并返回 ctx 的错误，数据库继续使用原来的文件
+// 开始替换文件之后不再检查 ctx，保证替换完整完成
+func (db *MinDB) ReclaimContext(ctx context.Context) (err error) {
+	if !db.isOpen() {
+		return ErrDBClosed
+	}
+	if !atomic.CompareAndSwapInt32(&db.reclaiming, 0, 1) {
+		return ErrReclaimRunning
+	}
+	defer atomic.StoreInt32(&db.reclaiming, 0)
+
+	db.mu.Lock() // 回收操作需要加锁，避免有其他数据操作
+	defer db.mu.Unlock()
+	db.lockAllIdx()
+	defer db.unlockAllIdx()
 
-	var reclaimable bool                     // 是否需要回收空间的flag
-	for _, archFiles := range db.archFiles { // 遍历所有类型的已封存文件信息
-		if len(archFiles) >= db.config.ReclaimThreshold { // 如果某类型的已封存文件数量已经达到了配置的阈值，则可以回收
+	if !db.isOpen() { // 等待锁的过程中数据库可能已被关闭
+		return ErrDBClosed
+	}
+
+	var reclaimable bool              // 是否需要回收空间的flag
+	for dType := range db.archFiles { // 遍历所有类型的已封存文件信息
+		if db.reachReclaimThreshold(dType) { // 如果某类型的可回收空间已经达到了配置的阈值，则可以回收
 			reclaimable = true
 			break
 		}
@@ -226,129 +447,410 @@ func (db *MinDB) Reclaim() (err error) {
 		return ErrReclaimUnreached
 	}
 
+	var types []DataType
+	for _, dType := range DataTypes {
+		if db.reachReclaimThreshold(dType) {
+			types = append(types, dType)
+		}
+	}
+	run := ReclaimRun{Start: time.Now()}
+	done := db.notifyReclaim(types)
+	defer func() {
+		done(err)
+		db.recordReclaim(run, err)
+	}()
+
 	//新建临时目录，用于暂存新的数据文件
 	reclaimPath := db.config.DirPath + reclaimPath
-	if err := os.MkdirAll(reclaimPath, os.ModePerm); err != nil {
+	if db.config.ReclaimTmpDir != "" { // 多个数据库可能共用同一个临时目录，每次回收使用其中单独的子目录
+		if reclaimPath, err = newOwnedTmpDir(db.config.DirPath, db.config.ReclaimTmpDir); err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(reclaimPath, os.ModePerm); err != nil {
 		return err
 	}
 
-	defer os.RemoveAll(reclaimPath)
-
-	db.mu.Lock() // 回收操作需要加锁，避免有其他数据操作
-	defer db.mu.Unlock()
+	// 清单写入之后替换失败时保留临时目录，下次打开数据库时按清单完成替换
+	var swapping bool
+	defer func() {
+		if !swapping {
+			_ = os.RemoveAll(reclaimPath)
+		}
+	}()
 
-	// 用goroutine处理不同类型的文件
-	newArchivedFiles := sync.Map{} // 新的封存文件索引
-	reclaimedTypes := sync.Map{}
+	// 用goroutine处理不同类型的文件，任一类型回收失败时放弃本次回收，数据库继续使用原来的文件
+	// 同时处理的类型数量由 ReclaimWorkers 限制
+	workers := db.config.ReclaimWorkers
+	if workers <= 0 {
+		workers = len(DataTypes)
+	}
+	sem := make(chan struct{}, workers)
+	results := make([]*reclaimResult, len(DataTypes))
+	errs := make([]error, len(DataTypes))
 	wg := sync.WaitGroup{}
-	wg.Add(5)
-	for i := 0; i < 5; i++ { // dType由const表示,0到4分别表示几种数据类型
-		go func(dType uint16) { // 开一个goroutine处理当前类型的文件
-			defer func() { // 用defer来做最后的wg.Done()操作
-				wg.Done()
-			}()
-
-			if len(db.archFiles[dType]) < db.config.ReclaimThreshold { // 如果当前类型的封存文件数量没有达到阈值就不回收此类型
-				newArchivedFiles.Store(dType, db.archFiles[dType]) // 注意不回收也要将此类型加入到新的封存文件索引中
-				return
+	for i, dType := range DataTypes {
+		if !db.reachReclaimThreshold(dType) { // 如果当前类型的可回收空间没有达到阈值就不回收此类型
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, dType DataType) { // 开一个goroutine处理当前类型的文件
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i], errs[i] = db.reclaimType(ctx, dType, reclaimPath)
+		}(i, dType)
+	}
+	wg.Wait()
+	run.Types = db.reclaimStats(results)
+
+	for i, err := range errs {
+		if err != nil {
+			for _, res := range results {
+				res.close()
 			}
+			return fmt.Errorf("mindb: reclaim data type %d failed: %v", DataTypes[i], err)
+		}
+	}
+	if err = ctx.Err(); err != nil {
+		for _, res := range results {
+			res.close()
+		}
+		return err
+	}
+
+	// 先写入文件替换清单，保证替换过程中进程退出时可以在下次打开时继续完成
+	manifest, err := db.newReclaimManifest(reclaimPath, results)
+	if err == nil {
+		err = writeReclaimManifest(db.config.DirPath, manifest)
+	}
+	if err != nil {
+		for _, res := range results {
+			res.close()
+		}
+		return err
+	}
+	swapping = true
+
+	// 转移封存文件组：关闭旧的文件，将新的数据文件移动到数据目录中（同名的旧文件被直接替换），再删除多余的旧文件
+	for i, dType := range DataTypes {
+		res := results[i]
+		if res == nil { // 未回收的类型保持原样
+			continue
+		}
 
-			var (
-				df        *storage.DBFile
-				fileId    uint32
-				archFiles = make(map[uint32]*storage.DBFile)
-			)
-
-			for _, file := range db.archFiles[dType] { // 遍历当前类型的所有封存文件，key为id，value为文件信息
-				var offset int64 = 0
-				var reclaimEntries []*storage.Entry // 用一个Entry数组来记录新的有效的entry
-
-				// 读取db中所有当前类型文件，找出有效的entry
-				for {
-					if e, err := file.Read(offset); err == nil { // 通过offset值去读取文件中的entry，同时offset更新
-						if db.validEntry(e, offset, file.Id) { // 判断当前entry是否有效
-							reclaimEntries = append(reclaimEntries, e) // 如果有效就将此条entry加入到新的entry数组中
-						}
-						offset += int64(e.Size()) // 更新offset
-					} else { // 如果读取到了文件末尾，就退出
-						if err == io.EOF {
-							break
-						}
-						log.Fatalf("err occurred when read the entry: %+v", err)
-						return
+		for _, f := range db.archFiles[dType] {
+			_ = f.Close(false)
+		}
+		for fileId, f := range res.archFiles {
+			if res.archFiles[fileId], err = db.moveReclaimed(f, dType, reclaimPath); err != nil {
+				return fmt.Errorf("mindb: reclaim interrupted, it will be completed when the db is opened again: %v", err)
+			}
+		}
+		for fileId, f := range db.archFiles[dType] {
+			if _, exist := res.archFiles[fileId]; !exist {
+				_ = os.Remove(f.File.Name())
+			}
+			// 原来的封存文件已被替换，其失效数据的记录也一并清除
+			db.meta.ClearDeadBytes(dType, fileId)
+		}
+
+		// 数据在磁盘中的位置发生了变更，更新索引中记录的文件信息
+		// 封存文件中的增量修改已合并到新文件的值中，只保留活跃文件中的增量修改，重复应用它们不影响结果
+		_, activeFileId := db.getActiveFile(dType)
+		for _, idx := range res.strIdxes {
+			db.putStrIndex(idx.Meta.Key, idx)
+			key := string(idx.Meta.Key)
+			if patches, ok := db.strIndex.patches[key]; ok {
+				active := patches[:0]
+				for _, p := range patches {
+					if p.FileId == activeFileId {
+						active = append(active, p)
 					}
 				}
+				if len(active) == 0 {
+					delete(db.strIndex.patches, key)
+				} else {
+					db.strIndex.patches[key] = active
+				}
+			}
+		}
+		db.archFiles[dType] = res.archFiles
+	}
 
-				// 将找出来的有效的entry重新写入到新的一批数据文件中
-				for _, entry := range reclaimEntries {
-					if df == nil || int64(entry.Size())+df.Offset > db.config.BlockSize {
-						// 如果df未指向某个文件或者是当前文件将要满了，就新建一个文件
-						df, err = storage.NewDBFile(reclaimPath, fileId, db.config.RwMethod, db.config.BlockSize, dType)
-						if err != nil {
-							log.Fatalf("err occurred when create new db file: %+v", err)
-							return
-						}
-						archFiles[fileId] = df // 将文件id和文件进行映射缓存
-						fileId += 1
-					}
-					// 对当前文件进行entry的写入
-					if err = df.Write(entry); err != nil {
-						log.Fatalf("err occurred when write the entry: %+v", err)
-						return
-					}
+	// 替换完成，删除清单及临时目录
+	if err = syncDir(db.config.DirPath); err == nil {
+		err = manifest.finish(db.config.DirPath)
+	}
+	return
+}
 
-					// 因为磁盘中文件的位置发生了变更，因此索引中记录的文件信息也要更新
-					if dType == String {
-						item := db.strIndex.idxList.Get(entry.Meta.Key)
-						idx := item.Value().(*index.Indexer)
-						idx.Offset = df.Offset - int64(entry.Size()) // 更新offset
-						idx.FileId = fileId                          // 更新文件id
-						db.strIndex.idxList.Put(idx.Meta.Key, idx)
-					}
+// 将回收生成的新文件移动到数据目录中，同名的旧文件被直接替换
+// 临时目录与数据目录不在同一文件系统时无法直接移动，复制到数据目录后改为使用复制后的文件
+func (db *MinDB) moveReclaimed(f *storage.DBFile, dType DataType, reclaimPath string) (*storage.DBFile, error) {
+	name := storage.PathSeparator + fmt.Sprintf(storage.DBFileFormatNames[dType], f.Id)
+	copied, err := moveFile(reclaimPath+name, db.config.DirPath+name)
+	if err != nil || !copied {
+		return f, err
+	}
+
+	df, err := storage.NewDBFile(db.config.DirPath, f.Id, db.config.RwMethod, db.config.BlockSize, dType, db.config.Checksum)
+	if err != nil {
+		return nil, err
+	}
+	df.Offset = f.Offset
+	_ = f.Close(false)
+	return df, nil
+}
+
+// 一种类型数据的回收结果
+type reclaimResult struct {
+	archFiles map[uint32]*storage.DBFile // 新的封存文件
+	strIdxes  []*index.Indexer           // 字符串在新文件中的索引，回收成功后才替换原来的索引
+	skipped   int                        // 跳过的损坏entry数量
+	read      int64                      // 读取的entry数量，包括跳过的
+	written   int64                      // 写入新文件的entry数量
+	bufSize   int                        // 读写文件的缓冲区大小
+	ctx       context.Context            // 被取消时停止读取，放弃回收
+}
+
+// 关闭回收过程中新建的文件，用于放弃回收时
+func (res *reclaimResult) close() {
+	if res == nil {
+		return
+	}
+	for _, f := range res.archFiles {
+		_ = f.Close(false)
+	}
+}
+
+// 回收某一类型的已封存文件：顺序读取其中有效的entry，写入到临时目录下的一批新文件中
+// 校验和不正确的entry会被跳过并记录日志，其他错误会终止回收，已创建的新文件由调用方清理
+func (db *MinDB) reclaimType(ctx context.Context, dType DataType, reclaimPath string) (res *reclaimResult, err error) {
+	res = &reclaimResult{archFiles: make(map[uint32]*storage.DBFile), bufSize: db.config.ReclaimBufSize, ctx: ctx}
+	if res.bufSize <= 0 {
+		res.bufSize = storage.ReadAheadSize
+	}
+	var (
+		df     *storage.DBFile
+		writer *storage.EntryWriter
+		fileId uint32
+	)
+
+	// 将entry写入新文件，当前文件将要写满时新建一个文件
+	write := func(entry *storage.Entry) error {
+		if df == nil || int64(entry.Size())+df.Offset > db.config.BlockSize {
+			if writer != nil {
+				if err := writer.Flush(); err != nil {
+					return err
 				}
 			}
-			reclaimedTypes.Store(dType, struct{}{})  // 更新merge类型映射
-			newArchivedFiles.Store(dType, archFiles) // 更新新的类型与文件组映射
-		}(uint16(i))
+			var err error
+			if df, err = storage.NewDBFile(reclaimPath, fileId, db.config.RwMethod, db.config.BlockSize, dType, db.config.Checksum); err != nil {
+				return err
+			}
+			res.archFiles[fileId] = df // 将文件id和文件进行映射缓存
+			writer = df.NewWriter(res.bufSize)
+			fileId += 1
+		}
+		if err := writer.Write(entry); err != nil {
+			return err
+		}
+		res.written++
+
+		// 记录字符串在新文件中的位置
+		if dType == String {
+			old := db.strIndex.idxList.Get(entry.Meta.Key).Value().(*index.Indexer)
+			idx := *old
+			idx.Offset = df.Offset - int64(entry.Size())
+			idx.FileId = df.Id
+			idx.EntrySize = entry.Size()
+			res.strIdxes = append(res.strIdxes, &idx)
+		}
+		return nil
 	}
-	wg.Wait()
 
-	// 转移封存文件组
-	dbArchivedFiles := make(ArchivedFiles) // 新建封存文件组
-	for i := 0; i < 5; i++ {               // 遍历所有类型的新文件组
-		dType := uint16(i)
-		value, ok := newArchivedFiles.Load(dType) // 从sync.Map中取出文件组转移到新建文件组中
-		if !ok {
-			log.Printf("one type of data(%d) is missed after reclaiming.", dType)
+	files := make([]*storage.DBFile, 0, len(db.archFiles[dType]))
+	for _, file := range db.archFiles[dType] {
+		files = append(files, file)
+	}
+
+	if dType == List {
+		err = db.reclaimList(res, files, write)
+	} else {
+		// 按文件id的顺序处理，保证新文件中数据的先后顺序与原来一致
+		// 顺序读取所有当前类型的封存文件，将有效的entry重新写入到新的一批数据文件中
+		err = res.readArchived(dType, files, storage.OrderByOffset, func(e *storage.Entry, fileId uint32, offset int64) error {
+			if !db.validEntry(e, offset, fileId) {
+				return nil
+			}
+			if dType == Set && e.Mark == SetSMove { // 旧格式的移动操作改写为目标集合上的 SAdd，重放时不再依赖源集合
+				e = storage.NewEntryNoExtra(e.Meta.Extra, e.Meta.Value, Set, SetSAdd)
+			}
+			if dType == String { // 字符串的过期时间随数据一起写入新文件
+				e.Deadline = uint64(db.expires[string(e.Meta.Key)])
+				if err := db.materializeStr(e); err != nil {
+					return err
+				}
+			}
+			return write(e)
+		})
+	}
+	if err == nil && writer != nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		return
+	}
+
+	for _, f := range res.archFiles { // 新文件写入完成后持久化
+		if err = f.Sync(); err != nil {
 			return
 		}
-		dbArchivedFiles[dType] = value.(map[uint32]*storage.DBFile)
 	}
+	return
+}
 
-	// 删除掉旧的文件
-	for dataType, files := range db.archFiles {
-		// 通过回收类型这个map来判断当前类型是否被回收整理过，如果是则删除旧的
-		if _, exist := reclaimedTypes.Load(dataType); exist {
-			for _, f := range files {
-				_ = os.Remove(f.File.Name())
+// 按指定顺序读取一组已封存文件中的所有entry，校验和不正确的entry会被跳过
+func (res *reclaimResult) readArchived(dType DataType, files []*storage.DBFile, order storage.IterOrder,
+	fn func(e *storage.Entry, fileId uint32, offset int64) error) error {
+	iter := storage.NewMergedIterator(files, order)
+	iter.SetBufferSize(res.bufSize)
+	for {
+		if res.read%cancelCheckInterval == 0 {
+			if err := res.ctx.Err(); err != nil {
+				return err
 			}
 		}
+		e, fileId, offset, err := iter.Next() // 依次读取entry及其所在的文件和offset
+		if err == io.EOF {                    // 如果读取到了最后一个文件的末尾，就退出
+			return nil
+		}
+		if err == nil || err == storage.ErrInvalidCrc {
+			res.read++
+		}
+		if err == storage.ErrInvalidCrc { // 损坏的entry不再写入新文件
+			res.skipped++
+			log.Printf("skip corrupted entry when reclaiming, type: %d, file: %d, offset: %d\n", dType, fileId, offset)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(e, fileId, offset); err != nil {
+			return err
+		}
 	}
+}
 
-	// 将新的数据文件进行更名
-	for dataType, files := range dbArchivedFiles {
-		if _, exist := reclaimedTypes.Load(dataType); exist {
-			for _, f := range files {
-				name := storage.PathSeparator + fmt.Sprintf(storage.DBFileFormatNames[dataType], f.Id)
-				os.Rename(reclaimPath+name, db.config.DirPath+name)
+// 回收列表的已封存文件
+// 列表的操作依赖先后顺序，无法逐条判断entry是否有效，因此将已封存文件中的操作按文件的顺序重放到一个临时列表中，
+// 操作序号倒退的列表再按序号重新重放（见 listOrder），之后把每个列表重放后的结果写为一组 RPush 操作。
+// 这组操作沿用该列表在已封存文件中最大的那些序号，保证重建索引时它们仍然排在活跃文件中的后续操作之前
+func (db *MinDB) reclaimList(res *reclaimResult, files []*storage.DBFile, write func(*storage.Entry) error) error {
+	maxSeqs := make(map[string]uint64)
+	scratch := list.New()
+	order := newListOrder()
+	replay := func(e *storage.Entry) {
+		if db.abortedListOp(e) { // 没有提交的批量写入中的操作
+			return
+		}
+		replayListOp(scratch, e.Meta, e.Mark)
+		if e.Seq > maxSeqs[string(e.Meta.Key)] {
+			maxSeqs[string(e.Meta.Key)] = e.Seq
+		}
+	}
+	err := res.readArchived(List, files, storage.OrderByOffset, func(e *storage.Entry, fileId uint32, offset int64) error {
+		order.observe(e)
+		replay(e)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(order.disordered) > 0 {
+		for key := range order.disordered {
+			scratch.Clear(key)
+		}
+		for _, op := range readListOps(files, order.disordered, res.bufSize) {
+			replay(op.e)
+		}
+	}
+
+	keys := scratch.Keys()
+	sort.Strings(keys)
+	for _, key := range keys {
+		values := scratch.LRange(key, 0, -1)
+		n := uint64(len(values))
+
+		// 每个元素至少来自一条带序号的操作，因此最大序号不小于元素个数
+		// 只有旧格式没有序号的entry时无法满足，此时写入的操作也不带序号
+		var seq uint64
+		if maxSeq := maxSeqs[key]; maxSeq >= n {
+			seq = maxSeq - n + 1
+		}
+		for _, val := range values {
+			e := storage.NewEntryNoExtra([]byte(key), val, List, ListRPush)
+			e.Seq = seq
+			if err := write(e); err != nil {
+				return err
+			}
+			if seq > 0 {
+				seq++
 			}
 		}
 	}
+	return nil
+}
+
+// ReclaimableBytes 获取已封存文件中可以回收的磁盘空间大小
+// 目前只统计了字符串类型中被覆盖、删除和过期的数据
+func (db *MinDB) ReclaimableBytes() (n int64) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 
-	// 更新数据库配置
-	db.archFiles = dbArchivedFiles
+	for _, dType := range DataTypes {
+		lock := db.idxLock(dType) // 写入时可能切换活跃文件，改变已封存文件
+		lock.RLock()
+		dead, _ := db.reclaimableBytesOf(dType)
+		lock.RUnlock()
+		n += dead
+	}
+	return
+}
+
+// 获取某类型已封存文件中可回收的空间大小，以及已封存文件的总大小
+func (db *MinDB) reclaimableBytesOf(dataType DataType) (dead, total int64) {
+	for fileId, file := range db.archFiles[dataType] {
+		dead += db.meta.GetDeadBytes(dataType, fileId)
+		if info, err := file.File.Stat(); err == nil {
+			total += info.Size()
+		}
+	}
 	return
+}
+
+// 判断某类型的数据是否达到了回收的阈值
+// 配置了按空间回收的阈值时，根据可回收空间的大小或占比判断，否则根据已封存文件的数量判断
+// 没有统计失效数据的类型仍然根据已封存文件的数量判断
+func (db *MinDB) reachReclaimThreshold(dataType DataType) bool {
+	if len(db.archFiles[dataType]) == 0 {
+		return false
+	}
 
+	bySpace := db.config.ReclaimMinBytes > 0 || db.config.ReclaimRatio > 0
+	if !bySpace || !db.meta.HasDeadBytes(dataType) {
+		return db.config.ReclaimThreshold > 0 && len(db.archFiles[dataType]) >= db.config.ReclaimThreshold
+	}
+
+	dead, total := db.reclaimableBytesOf(dataType)
+	if dead <= 0 {
+		return false
+	}
+	if db.config.ReclaimMinBytes > 0 && dead >= db.config.ReclaimMinBytes {
+		return true
+	}
+	return db.config.ReclaimRatio > 0 && total > 0 && float64(dead)/float64(total) >= db.config.ReclaimRatio
 }
 
 // Backup 复制数据库目录，用于备份
@@ -396,10 +898,40 @@ func (db *MinDB) saveConfig() (err error) {
 
 // 持久化数据库信息
 func (db *MinDB) saveMeta() error {
+	// 活跃文件的写偏移在保存时统一记录，避免各类型写入时并发修改同一个map
+	for dataType, file := range db.activeFile {
+		db.meta.ActiveWriteOff[dataType] = file.Offset
+	}
+
 	metaPath := db.config.DirPath + dbMetaSaveFile
 	return db.meta.Store(metaPath)
 }
 
+// 旧版本将过期字典单独保存在 db.expires 文件中，打开时将其中的过期时间写入数据文件，之后删除该文件
+// 已经过期的key与加载时过期的key一样保留，之后删除时触发 OnExpired 的回调
+func (db *MinDB) migrateLegacyExpires() error {
+	path := db.config.DirPath + expireFile
+	if !utils.Exist(path) {
+		return nil
+	}
+
+	for key, deadline := range storage.LoadExpires(path) {
+		k := []byte(key)
+		if !db.strIndex.idxList.Exist(k) {
+			continue
+		}
+
+		db.expires[key] = deadline
+		e := storage.NewEntryNoExtra(k, nil, String, StringExpire)
+		e.Deadline = uint64(deadline)
+		if err := db.store(e); err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(path)
+}
+
 // 建立索引
 func (db *MinDB) buildIndex(e *storage.Entry, idx *index.Indexer) error {
 
@@ -407,11 +939,21 @@ func (db *MinDB) buildIndex(e *storage.Entry, idx *index.Indexer) error {
 		idx.Meta.Value = e.Meta.Value
 		idx.Meta.ValueSize = uint32(len(e.Meta.Value))
 	}
+	if db.config.VerifyValueOnRead && e.Type == storage.String {
+		idx.Checksum = db.config.Checksum.Sum(e.Meta.Value)
+	}
+	if db.replayIntent(e, idx) { // 多key操作的 entry 在读到 commit 后才应用
+		return nil
+	}
 	switch e.Type {
 	case storage.String: // 如果是string，就把当前索引加入到跳表中
-		db.buildStringIndex(idx, e.Mark)
+		if e.Mark == StringPatch {
+			db.buildStrPatch(e, idx)
+			break
+		}
+		db.buildStringIndex(idx, e.Mark, e.Deadline)
 	case storage.List: // 如果是list，就建立list索引
-		db.buildListIndex(idx, e.Mark)
+		db.buildListIndex(idx, e.Mark, e.Seq)
 	case storage.Hash:
 		db.buildHashIndex(idx, e.Mark)
 	case storage.Set:
@@ -425,26 +967,42 @@ func (db *MinDB) buildIndex(e *storage.Entry, idx *index.Indexer) error {
 
 // 写数据
 func (db *MinDB) store(e *storage.Entry) error {
+	if err := db.write(e); err != nil {
+		return err
+	}
+	return db.syncActive(e.Type, 1) // 数据持久化
+}
 
-	//如果数据文件空间不够，则持久化该文件，并新打开一个文件
-	config := db.config
-	if db.activeFile[e.Type].Offset+int64(e.Size()) > config.BlockSize {
-		if err := db.activeFile[e.Type].Sync(); err != nil {
-			return err
-		}
+// 将entry写入活跃文件，不进行持久化，批量写入时由调用方在最后统一持久化
+// 写入后即视为生效，分配写入序号并记录数据变更，多个entry一起生效的写入使用 writeEntry 和 emitWrites
+func (db *MinDB) write(e *storage.Entry) error {
+	if err := db.seqs.reserve(1); err != nil {
+		return err
+	}
+	defer db.seqs.release(1)
+
+	if err := db.writeEntry(e); err != nil {
+		return err
+	}
+	db.emitWrites(e)
+	return nil
+}
 
-		//保存旧的文件
-		activeFileId := db.activeFileIds[e.Type]
-		db.archFiles[e.Type][activeFileId] = db.activeFile[e.Type]
-		activeFileId = activeFileId + 1
+// 只将entry写入活跃文件，不分配写入序号，调用方需要事先通过 seqs.reserve 预留序号，全部写入成功后调用 emitWrites
+func (db *MinDB) writeEntry(e *storage.Entry) error {
 
-		newDbFile, err := storage.NewDBFile(config.DirPath, activeFileId, config.RwMethod, config.BlockSize, e.Type)
-		if err != nil {
+	if !db.isOpen() {
+		return ErrDBClosed
+	}
+
+	//如果数据文件空间不够，则持久化该文件，并新打开一个文件
+	config := db.config
+	activeFile, _ := db.getActiveFile(e.Type)
+	if activeFile.Offset+int64(e.Size()) > config.BlockSize {
+		var err error
+		if activeFile, err = db.rotate(e.Type); err != nil {
 			return err
 		}
-		db.activeFile[e.Type] = newDbFile
-		db.activeFileIds[e.Type] = activeFileId
-		db.meta.ActiveWriteOff[e.Type] = 0
 	}
 	//
 	////如果key已经存在，则原来的值被舍弃，所以需要新增可回收的磁盘空间值
@@ -456,20 +1014,74 @@ func (db *MinDB) store(e *storage.Entry) error {
 	//}
 
 	// 写入entry至文件中
-	if err := db.activeFile[e.Type].Write(e); err != nil {
-		return err
+	return activeFile.Write(e)
+}
+
+// 已写入的entry生效后按写入的顺序分配写入序号，记录数据变更，更新被监视key的版本并通知事件的监听
+// 没有生效的写入（如中途失败的批量写入）不调用，消费者及 WATCH 不会看到它们
+func (db *MinDB) emitWrites(entries ...*storage.Entry) {
+	backlog := db.config.ChangeBacklog
+	for _, e := range entries {
+		seq := db.changes.append(backlog, e) // 更新写入序号，并记录数据变更
+		db.versions.touch(e.Meta.Key, seq)
+		db.notifyWrite(seq, e)
 	}
+}
 
-	db.meta.ActiveWriteOff[e.Type] = db.activeFile[e.Type].Offset
+// RotateActiveFile 封存某类型当前的活跃文件并新建一个活跃文件，之后的写入进入新文件，活跃文件中没有数据时不做任何操作
+// 用于备份、全量同步、测试等需要当前数据都在已封存文件中的场景，封存后与写满时一样触发 OnSegmentRotate
+func (db *MinDB) RotateActiveFile(dataType DataType) error {
+	lock := db.idxLock(dataType)
+	if lock == nil {
+		return ErrInvalidDataType
+	}
+	lock.Lock()
+	defer lock.Unlock()
 
-	// 数据持久化
-	if config.Sync {
-		if err := db.activeFile[e.Type].Sync(); err != nil {
-			return err
-		}
+	if !db.isOpen() {
+		return ErrDBClosed
+	}
+	if activeFile, _ := db.getActiveFile(dataType); activeFile.Offset <= activeFile.DataOffset() {
+		return nil
 	}
+	_, err := db.rotate(dataType)
+	return err
+}
 
-	return nil
+// 持久化并封存当前的活跃文件，新建一个活跃文件并返回，调用方需持有该类型索引的写锁
+func (db *MinDB) rotate(dataType DataType) (*storage.DBFile, error) {
+	config := db.config
+	activeFile, activeFileId := db.getActiveFile(dataType)
+	if err := activeFile.Sync(); err != nil {
+		return nil, err
+	}
+
+	//保存旧的文件
+	db.archFiles[dataType][activeFileId] = activeFile
+	activeFileId = activeFileId + 1
+
+	newDbFile, err := storage.NewDBFile(config.DirPath, activeFileId, config.RwMethod, config.BlockSize, dataType, config.Checksum)
+	if err != nil {
+		return nil, err
+	}
+	db.fileMu.Lock()
+	db.activeFile[dataType] = newDbFile
+	db.activeFileIds[dataType] = activeFileId
+	db.fileMu.Unlock()
+	db.notifyRotate(dataType, activeFileId-1, activeFileId)
+	return newDbFile, nil
+}
+
+// 获取某类型当前的活跃文件及其id
+func (db *MinDB) getActiveFile(dataType DataType) (*storage.DBFile, uint32) {
+	db.fileMu.RLock()
+	defer db.fileMu.RUnlock()
+	return db.activeFile[dataType], db.activeFileIds[dataType]
+}
+
+// 记录被覆盖或删除的数据所占的空间，用于统计可回收的磁盘空间
+func (db *MinDB) markDead(dataType DataType, fileId uint32, size uint32) {
+	db.meta.AddDeadBytes(dataType, fileId, int64(size))
 }
 
 // 判断entry所属的操作标识(增、改类型的操作)，以及val是否是有效的
@@ -502,35 +1114,30 @@ func (db *MinDB) validEntry(e *storage.Entry, offset int64, fileId uint32) bool
 			}
 			return false
 		}
-	case List:
-		if mark == ListLPush || mark == ListRPush || mark == ListLInsert || mark == ListLSet {
-			// TODO 由于List是链表结构，无法有效的进行检索，取出全部数据依次比较的开销太大
-			if db.LValExists(e.Meta.Key, e.Meta.Value) {
-				return true
-			}
-		}
+	// 列表的回收不逐条判断entry，见 reclaimList
 	case Hash:
+		// 回收时已持有所有索引锁，这里直接访问索引
 		if mark == HashHSet {
-			if val := db.HGet(e.Meta.Key, e.Meta.Extra); string(val) == string(e.Meta.Value) {
+			if val := db.hashIndex.indexes.HGet(string(e.Meta.Key), string(e.Meta.Extra)); string(val) == string(e.Meta.Value) {
 				return true
 			}
 		}
 	case Set:
-		if mark == SetSMove { // 如果是移动member的操作
-			if db.SIsMember(e.Meta.Extra, e.Meta.Value) {
+		if mark == SetSMove { // 如果是旧格式的移动member的操作
+			if db.setIndex.indexes.SIsMember(string(e.Meta.Extra), e.Meta.Value) {
 				return true
 			}
 		}
 
 		if mark == SetSAdd {
-			if db.SIsMember(e.Meta.Key, e.Meta.Value) {
+			if db.setIndex.indexes.SIsMember(string(e.Meta.Key), e.Meta.Value) {
 				return true
 			}
 		}
 	case ZSet:
 		if mark == ZSetZAdd {
 			if val, err := utils.StrToFloat64(string(e.Meta.Extra)); err == nil {
-				score := db.ZScore(e.Meta.Key, e.Meta.Value)
+				score := db.zsetIndex.indexes.ZScore(string(e.Meta.Key), string(e.Meta.Value))
 				if score == val {
 					return true
 				}
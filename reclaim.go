@@ -0,0 +1,302 @@
+package mindb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mindb/storage"
+	"mindb/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+var (
+	// ErrReclaimUnrecoverable 上一次回收在替换文件的过程中被中断，且新文件已经丢失或损坏，无法完成替换
+	ErrReclaimUnrecoverable = errors.New("mindb: interrupted reclaim can not be recovered, reclaimed files are missing or corrupted")
+)
+
+const (
+	// 回收磁盘空间时记录文件替换计划的清单文件名称
+	reclaimManifestFile = string(os.PathSeparator) + "mindb_reclaim.manifest"
+
+	// 配置了 ReclaimTmpDir 时，临时目录中记录其所属数据目录的文件名称，用于打开时清理上次回收遗留的临时目录
+	reclaimOwnerFile = string(os.PathSeparator) + "owner"
+)
+
+// 回收的文件替换清单
+// 新文件全部写入并持久化后才写入清单，之后再开始替换数据目录中的文件；
+// 替换过程中进程退出时，下次打开数据库会按清单继续完成替换，数据目录不会停留在新旧文件混杂的状态
+type reclaimManifest struct {
+	TmpDir string                   `json:"tmp_dir"` // 存放新文件的临时目录
+	Types  map[DataType]reclaimSwap `json:"types"`   // 回收了的数据类型
+}
+
+// 一种类型的文件替换计划
+type reclaimSwap struct {
+	New []reclaimFile `json:"new"` // 临时目录中的新文件，替换数据目录中的同名文件
+	Old []uint32      `json:"old"` // 原来的封存文件，没有被同名新文件替换的会被删除
+}
+
+// 回收生成的新文件，恢复时根据大小及校验和确认文件完整
+type reclaimFile struct {
+	Id   uint32 `json:"id"`
+	Size int64  `json:"size"`
+	CRC  uint32 `json:"crc"`
+}
+
+// 根据回收结果生成替换清单，results 与 DataTypes 一一对应，没有回收的类型为 nil
+func (db *MinDB) newReclaimManifest(tmpDir string, results []*reclaimResult) (*reclaimManifest, error) {
+	m := &reclaimManifest{TmpDir: tmpDir, Types: make(map[DataType]reclaimSwap)}
+	for i, dType := range DataTypes {
+		if results[i] == nil {
+			continue
+		}
+		var swap reclaimSwap
+		for fileId := range results[i].archFiles {
+			path := tmpDir + storage.PathSeparator + fmt.Sprintf(storage.DBFileFormatNames[dType], fileId)
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			crc, err := fileCRC(path, info.Size())
+			if err != nil {
+				return nil, err
+			}
+			swap.New = append(swap.New, reclaimFile{Id: fileId, Size: info.Size(), CRC: crc})
+		}
+		for fileId := range db.archFiles[dType] {
+			swap.Old = append(swap.Old, fileId)
+		}
+		m.Types[dType] = swap
+	}
+	return m, nil
+}
+
+// 写入清单并持久化，清单写入后回收的结果即已确定
+func writeReclaimManifest(dirPath string, m *reclaimManifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	path := dirPath + reclaimManifestFile
+	if err = ioutil.WriteFile(path+".tmp", b, storage.FilePerm); err == nil {
+		err = syncFile(path + ".tmp")
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		_ = os.Remove(path + ".tmp")
+		return err
+	}
+	return syncDir(dirPath)
+}
+
+// 按清单替换数据目录中的文件，可以重复执行：已经移动过的新文件不在临时目录中，会被跳过
+func (m *reclaimManifest) apply(dirPath string) error {
+	for dType, swap := range m.Types {
+		replaced := make(map[uint32]bool, len(swap.New))
+		for _, f := range swap.New {
+			replaced[f.Id] = true
+			name := storage.PathSeparator + fmt.Sprintf(storage.DBFileFormatNames[dType], f.Id)
+			if !utils.Exist(m.TmpDir + name) {
+				continue
+			}
+			if _, err := moveFile(m.TmpDir+name, dirPath+name); err != nil {
+				return err
+			}
+		}
+		for _, fileId := range swap.Old {
+			if replaced[fileId] {
+				continue
+			}
+			name := storage.PathSeparator + fmt.Sprintf(storage.DBFileFormatNames[dType], fileId)
+			if err := os.Remove(dirPath + name); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return syncDir(dirPath)
+}
+
+// 检查清单中的每个新文件是否完整地存在于临时目录或数据目录中，同时返回是否已经有新文件被移动到了数据目录
+func (m *reclaimManifest) check(dirPath string) (complete, started bool) {
+	complete = true
+	for dType, swap := range m.Types {
+		for _, f := range swap.New {
+			name := storage.PathSeparator + fmt.Sprintf(storage.DBFileFormatNames[dType], f.Id)
+			switch {
+			case f.matches(m.TmpDir + name):
+			case f.matches(dirPath + name):
+				started = true
+			default:
+				complete = false
+			}
+		}
+	}
+	return
+}
+
+// 文件的大小及校验和是否与记录的一致
+func (f reclaimFile) matches(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != f.Size {
+		return false
+	}
+	crc, err := fileCRC(path, f.Size)
+	return err == nil && crc == f.CRC
+}
+
+// 替换完成后删除清单及临时目录
+func (m *reclaimManifest) finish(dirPath string) error {
+	if err := os.Remove(dirPath + reclaimManifestFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.RemoveAll(m.TmpDir)
+}
+
+// 打开数据库时处理上一次回收留下的状态，返回被替换的文件，这些文件的失效数据统计需要清除
+// 有清单时说明新文件已经全部写入，新文件完整时按清单完成替换；新文件不完整但替换还没有开始时放弃这次回收，
+// 继续使用原来的文件；替换已经开始而新文件不完整时数据目录已无法恢复到一致的状态，返回 ErrReclaimUnrecoverable
+// 没有清单时上一次回收还没有结果，直接删除临时文件
+// 同时清理复制新文件、拉取冷备文件时留下的未完成的数据文件，避免被当作数据文件加载
+func recoverReclaim(dirPath, tmpRoot string) (map[DataType]reclaimSwap, error) {
+	entries, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".data.") && strings.HasSuffix(e.Name(), ".tmp") {
+			if err = os.Remove(filepath.Join(dirPath, e.Name())); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	b, err := ioutil.ReadFile(dirPath + reclaimManifestFile)
+	if os.IsNotExist(err) {
+		if err = os.RemoveAll(dirPath + reclaimPath); err != nil {
+			return nil, err
+		}
+		return nil, removeOwnedTmpDirs(dirPath, tmpRoot)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := &reclaimManifest{}
+	if err = json.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+	complete, started := m.check(dirPath)
+	switch {
+	case complete:
+		if err = m.apply(dirPath); err != nil {
+			return nil, err
+		}
+		log.Printf("completed an interrupted reclaim in %s\n", dirPath)
+	case !started:
+		log.Printf("discarded an interrupted reclaim in %s, the reclaimed files are incomplete\n", dirPath)
+		m.Types = nil
+	default:
+		return nil, ErrReclaimUnrecoverable
+	}
+	if err = m.finish(dirPath); err != nil {
+		return nil, err
+	}
+	return m.Types, removeOwnedTmpDirs(dirPath, tmpRoot)
+}
+
+// 新建配置的 ReclaimTmpDir 中的临时目录，并记录其所属的数据目录
+func newOwnedTmpDir(dirPath, tmpRoot string) (string, error) {
+	owner, err := filepath.Abs(dirPath)
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(tmpRoot, os.ModePerm); err != nil {
+		return "", err
+	}
+	dir, err := ioutil.TempDir(tmpRoot, "mindb_reclaim")
+	if err != nil {
+		return "", err
+	}
+	if err = ioutil.WriteFile(dir+reclaimOwnerFile, []byte(owner), storage.FilePerm); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// 删除 ReclaimTmpDir 中属于数据目录的临时目录，临时目录可能被多个数据库共用，其他数据库的临时目录不受影响
+func removeOwnedTmpDirs(dirPath, tmpRoot string) error {
+	if tmpRoot == "" {
+		return nil
+	}
+	owner, err := filepath.Abs(dirPath)
+	if err != nil {
+		return err
+	}
+	dirs, err := filepath.Glob(filepath.Join(tmpRoot, "mindb_reclaim*"))
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if b, err := ioutil.ReadFile(dir + reclaimOwnerFile); err == nil && string(b) == owner {
+			if err = os.RemoveAll(dir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 移动文件，不在同一文件系统时无法直接移动，先复制到目标目录下的临时文件并持久化，再替换目标文件，返回是否为复制
+func moveFile(src, dst string) (bool, error) {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return false, err
+	}
+
+	tmp := dst + ".tmp"
+	if err = utils.CopyFile(src, tmp); err == nil {
+		err = syncFile(tmp)
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return true, err
+	}
+	return true, os.Remove(src)
+}
+
+// 将文件的内容持久化到磁盘
+func syncFile(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// 持久化目录中文件的创建、重命名及删除
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err = dir.Sync(); err != nil {
+		_ = dir.Close()
+		return err
+	}
+	return dir.Close()
+}
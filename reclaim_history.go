@@ -0,0 +1,103 @@
+package mindb
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"mindb/storage"
+	"os"
+	"time"
+)
+
+const (
+	// 保存最近几次回收统计的文件名称
+	reclaimHistoryFile = string(os.PathSeparator) + "db.reclaims"
+
+	// 保留的回收统计数量
+	reclaimHistorySize = 20
+)
+
+// ReclaimRun 一次回收磁盘空间的统计
+type ReclaimRun struct {
+	Start time.Time          `json:"start"`
+	End   time.Time          `json:"end"`
+	Err   string             `json:"err,omitempty"` // 回收失败的原因，成功时为空
+	Types []ReclaimTypeStats `json:"types"`         // 参与回收的各类型的统计
+}
+
+// ReclaimTypeStats 一种类型的数据在一次回收中的统计
+type ReclaimTypeStats struct {
+	Type           DataType `json:"type"`
+	BytesBefore    int64    `json:"bytes_before"`    // 回收前已封存文件的大小
+	BytesAfter     int64    `json:"bytes_after"`     // 回收生成的新文件的大小，回收失败时为已经写入的大小
+	EntriesRead    int64    `json:"entries_read"`    // 从已封存文件中读取的entry数量
+	EntriesDropped int64    `json:"entries_dropped"` // 失效或损坏而没有写入新文件的entry数量
+}
+
+// ReclaimHistory 返回最近的回收统计，最早的在前，最多保留 reclaimHistorySize 次
+// 统计保存在数据目录中，重新打开数据库后仍然可以查询
+func (db *MinDB) ReclaimHistory() []ReclaimRun {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return append([]ReclaimRun(nil), db.reclaimRuns...)
+}
+
+// 根据各类型的回收结果生成统计，需要在替换已封存文件之前调用，results 与 DataTypes 一一对应
+func (db *MinDB) reclaimStats(results []*reclaimResult) []ReclaimTypeStats {
+	var stats []ReclaimTypeStats
+	for i, dType := range DataTypes {
+		res := results[i]
+		if res == nil {
+			continue
+		}
+		s := ReclaimTypeStats{Type: dType, EntriesRead: res.read, EntriesDropped: res.read - res.written}
+		for _, f := range db.archFiles[dType] {
+			s.BytesBefore += f.Offset
+		}
+		for _, f := range res.archFiles {
+			s.BytesAfter += f.Offset
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// 记录一次回收的统计并写入文件，调用方需持有 db.mu 的写锁；写入失败只记录日志，不影响回收的结果
+func (db *MinDB) recordReclaim(run ReclaimRun, err error) {
+	run.End = time.Now()
+	if err != nil {
+		run.Err = err.Error()
+	}
+	db.reclaimRuns = append(db.reclaimRuns, run)
+	if n := len(db.reclaimRuns); n > reclaimHistorySize {
+		db.reclaimRuns = append([]ReclaimRun(nil), db.reclaimRuns[n-reclaimHistorySize:]...)
+	}
+
+	b, err := json.Marshal(db.reclaimRuns)
+	if err == nil {
+		path := db.config.DirPath + reclaimHistoryFile
+		if err = ioutil.WriteFile(path+".tmp", b, storage.FilePerm); err == nil {
+			err = os.Rename(path+".tmp", path)
+		}
+	}
+	if err != nil {
+		log.Printf("save reclaim history err: %+v\n", err)
+	}
+}
+
+// 加载保存的回收统计，文件不存在或已损坏时返回空，统计丢失不影响数据库的使用
+func loadReclaimHistory(dirPath string) []ReclaimRun {
+	b, err := ioutil.ReadFile(dirPath + reclaimHistoryFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("load reclaim history err: %+v\n", err)
+		}
+		return nil
+	}
+	var runs []ReclaimRun
+	if err = json.Unmarshal(b, &runs); err != nil {
+		log.Printf("load reclaim history err: %+v\n", err)
+		return nil
+	}
+	return runs
+}
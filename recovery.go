@@ -0,0 +1,47 @@
+package mindb
+
+import (
+	"fmt"
+	"log"
+	"mindb/storage"
+	"sync/atomic"
+)
+
+// 加载索引时读到损坏的entry的位置
+type corruptPos struct {
+	fileId uint32
+	offset int64
+}
+
+// 按 RecoveryMode 处理加载索引时读到的损坏的entry，返回 nil 时继续加载
+func (db *MinDB) recoverCorrupt(iter *storage.MergedIterator, dataType DataType, fileId uint32, offset int64, err error, truncates *[]corruptPos) error {
+	switch db.config.RecoveryMode {
+	case RecoveryTolerant:
+		atomic.AddInt64(&db.loadCorrupt, 1)
+		log.Printf("skip corrupted entry, type: %d, file: %d, offset: %d, err: %v\n", dataType, fileId, offset, err)
+		// 校验和不一致时entry已完整读出，可以接着读下一条；其他错误无法确定下一条entry的位置
+		if err != storage.ErrInvalidCrc {
+			iter.SkipFile()
+		}
+		return nil
+	case RecoveryRepair:
+		atomic.AddInt64(&db.loadCorrupt, 1)
+		log.Printf("truncate data file at corrupted entry, type: %d, file: %d, offset: %d, err: %v\n", dataType, fileId, offset, err)
+		iter.SkipFile()
+		*truncates = append(*truncates, corruptPos{fileId: fileId, offset: offset})
+		return nil
+	}
+	return fmt.Errorf("%w, type: %d, file: %d, offset: %d: %v", ErrCorruptData, dataType, fileId, offset, err)
+}
+
+// 在损坏的位置截断数据文件
+func (db *MinDB) truncateCorrupt(dataType DataType, pos corruptPos) error {
+	df := db.archFiles[dataType][pos.fileId]
+	if active := db.activeFile[dataType]; active != nil && active.Id == pos.fileId {
+		df = active
+	}
+	if df == nil {
+		return nil
+	}
+	return df.Truncate(pos.offset)
+}
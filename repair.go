@@ -0,0 +1,153 @@
+package mindb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"mindb/index"
+	"mindb/storage"
+	"strconv"
+	"sync/atomic"
+)
+
+// 读取数据文件中的entry时，表示数据已经损坏的错误
+func isCorruption(err error) bool {
+	return errors.Is(err, storage.ErrInvalidCrc) || errors.Is(err, storage.ErrInvalidEntry) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errEntryMismatch) ||
+		errors.Is(err, errChecksumMismatch)
+}
+
+var (
+	// 索引指向的entry不是这个key的，索引与数据文件已经不一致
+	errEntryMismatch = errors.New("mindb: the entry does not belong to the key")
+
+	// 读取的值与建立索引时记录的校验和不一致，只在开启 VerifyValueOnRead 时检查
+	errChecksumMismatch = errors.New("mindb: the value does not match the checksum in the index")
+)
+
+// 读取索引指向的字符串entry，并确认其属于key，调用方需持有字符串索引的锁
+// 开启 VerifyValueOnRead 且只有key在内存中时，还会确认读到的值与写入时的一致：
+// 校验和正确的entry也可能不是写入时的那个，如写入丢失或写到了错误的位置
+func (db *MinDB) readStrEntryOf(key []byte, idx *index.Indexer) (*storage.Entry, error) {
+	e, err := db.readStrEntry(idx)
+	if err == nil && !bytes.Equal(e.Meta.Key, key) {
+		err = errEntryMismatch
+	}
+	if err == nil && db.config.VerifyValueOnRead && db.config.IdxMode == KeyOnlyRamMode {
+		err = db.verifyValue(idx, e.Meta.Value)
+	}
+	return e, err
+}
+
+// 检查值与索引中记录的校验和是否一致
+func (db *MinDB) verifyValue(idx *index.Indexer, value []byte) error {
+	if db.config.Checksum.Sum(value) != idx.Checksum {
+		return errChecksumMismatch
+	}
+	return nil
+}
+
+// 从磁盘读取字符串的值失败（校验和错误、数据不完整）后修复索引，返回修复后的值
+// 值的增量修改损坏时，使用完整的值及损坏之前的增量修改得到的值；完整的值损坏时，在更早的数据中查找这个key最近一次可以读取的值；
+// 修复得到的值作为新的entry写入，之后的读取不再经过损坏的数据，都找不到时删除这个key
+func (db *MinDB) repairStr(key []byte) ([]byte, error) {
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	if !db.isOpen() {
+		return nil, ErrDBClosed
+	}
+	node := db.strIndex.idxList.Get(key)
+	if node == nil {
+		return nil, ErrKeyNotExist
+	}
+	idx := node.Value().(*index.Indexer)
+
+	// 等待写锁期间可能已经被其他的读取修复
+	value, err := db.getVal(key)
+	if err == nil || !isCorruption(err) {
+		return value, err
+	}
+	atomic.AddInt64(&db.corruptReads, 1)
+	if errors.Is(err, errChecksumMismatch) {
+		atomic.AddInt64(&db.badChecksums, 1)
+	}
+
+	var found bool
+	if e, err := db.readStrEntryOf(key, idx); err == nil {
+		value, found = e.Meta.Value, true
+		for _, p := range db.strIndex.patches[string(key)] { // 应用损坏之前的增量修改
+			pe, err := db.readStrEntryOf(key, p)
+			if err != nil {
+				break
+			}
+			offset, err := strconv.Atoi(string(pe.Meta.Extra))
+			if err != nil {
+				break
+			}
+			value = patchValue(value, offset, pe.Meta.Value)
+		}
+	} else {
+		value, found = db.scanOlderStr(key, idx)
+	}
+
+	if !found {
+		log.Printf("the value of key [%s] is corrupted and no older version can be read, the key is removed\n", key)
+		db.removeStrIndex(key)
+		delete(db.expires, string(key))
+		e := storage.NewEntryNoExtra(key, nil, String, StringRem)
+		if err := db.store(e); err != nil {
+			return nil, err
+		}
+		db.markStrRemoved(idx, e)
+		atomic.AddInt64(&db.repairedKeys, 1)
+		return nil, ErrKeyNotExist
+	}
+
+	log.Printf("the value of key [%s] is corrupted, repaired it with the newest readable version\n", key)
+	if err := db.setKeepTTL(key, value); err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&db.repairedKeys, 1)
+	return value, nil
+}
+
+// 在 idx 指向的entry之前的数据中，查找key最近一次可以读取的值，调用方需持有字符串索引的写锁
+// 之后的增量修改按顺序应用到找到的值上，值被删除后重新开始查找，损坏的entry被跳过
+func (db *MinDB) scanOlderStr(key []byte, idx *index.Indexer) (value []byte, found bool) {
+	var files []*storage.DBFile
+	for _, f := range db.sortedFiles(String) {
+		if f != nil && f.Id <= idx.FileId {
+			files = append(files, f)
+		}
+	}
+
+	it := storage.NewMergedIterator(files, storage.OrderByOffset)
+	for {
+		e, fileId, offset, err := it.Next()
+		if err == io.EOF || (fileId == idx.FileId && offset >= idx.Offset) {
+			return
+		}
+		if err != nil {
+			if errors.Is(err, storage.ErrInvalidCrc) {
+				continue
+			}
+			return
+		}
+		if !bytes.Equal(e.Meta.Key, key) {
+			continue
+		}
+
+		switch e.Mark {
+		case StringSet:
+			value, found = e.Meta.Value, true
+		case StringRem:
+			value, found = nil, false
+		case StringPatch:
+			if offset, err := strconv.Atoi(string(e.Meta.Extra)); err == nil && found {
+				value = patchValue(value, offset, e.Meta.Value)
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package mindb
+
+import (
+	"io/ioutil"
+	"mindb/storage"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// 保存已预留的写入序号上限的文件名称
+	seqLimitFile = string(os.PathSeparator) + "db.seq"
+
+	// 每次预留的写入序号数量
+	seqReserveSize = 1 << 16
+)
+
+// 写入序号（变更序号、批量写入及多key操作的id、锁的令牌）只在 Close 时随 meta 保存，异常退出后无法从数据文件中完整恢复，
+// 因此分配之前先将一段序号的上限持久化在单独的文件中，重新打开时从上限继续，之后分配的序号一定大于异常退出前分配过的序号；
+// 写入前按将要分配的数量预留（见 reserve），写入生效后才分配序号，预留的数量保证分配时不会超过已持久化的上限
+type seqLimit struct {
+	mu      sync.Mutex
+	seq     *uint64 // 数据库的写入序号
+	limit   uint64  // 已持久化的上限
+	pending uint64  // 已预留但还没有分配的数量
+	path    string
+}
+
+// 加载已持久化的上限，写入序号小于上限时从上限继续，文件不存在时上限为 0，第一次预留时写入
+func loadSeqLimit(dirPath string, seq *uint64) (*seqLimit, error) {
+	s := &seqLimit{seq: seq, path: dirPath + seqLimitFile}
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(b) > 0 {
+		if s.limit, err = strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	if *seq < s.limit {
+		*seq = s.limit
+	}
+	return s, nil
+}
+
+// 预留 n 个序号，已预留的序号超过持久化的上限时先持久化新的上限，持久化失败时不预留并返回错误
+// 预留的序号在写入完成（无论成功与否）后通过 release 归还
+func (s *seqLimit) reserve(n uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	need := atomic.LoadUint64(s.seq) + s.pending + n
+	if need > s.limit {
+		limit := need + seqReserveSize
+		if err := s.store(limit); err != nil {
+			return err
+		}
+		s.limit = limit
+	}
+	s.pending += n
+	return nil
+}
+
+func (s *seqLimit) release(n uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending -= n
+}
+
+// 预留并分配一个序号，用于不对应数据变更的id
+func (s *seqLimit) next() (uint64, error) {
+	if err := s.reserve(1); err != nil {
+		return 0, err
+	}
+	defer s.release(1)
+	return atomic.AddUint64(s.seq, 1), nil
+}
+
+// 先写入临时文件再重命名，避免写入一半时留下损坏的文件
+func (s *seqLimit) store(limit uint64) error {
+	tmpPath := s.path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, storage.FilePerm)
+	if err != nil {
+		return err
+	}
+	if _, err = file.WriteString(strconv.FormatUint(limit, 10)); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
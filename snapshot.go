@@ -0,0 +1,203 @@
+package mindb
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"mindb/storage"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+)
+
+// ErrSnapshotDirNotEmpty 快照的目录已存在且不为空
+var ErrSnapshotDirNotEmpty = errors.New("mindb: snapshot dir is not empty")
+
+// 临时快照目录名称中数据目录名之后的部分
+const tempSnapshotSuffix = ".snapshot-"
+
+// SnapshotFile 快照中的一个文件
+type SnapshotFile struct {
+	Name string // 文件名，与数据库目录中的文件名相同
+	Size int64  // 快照时刻文件的大小，活跃文件之后写入的内容不属于快照
+	CRC  uint32 // 前 Size 字节的 crc32 IEEE 校验和
+}
+
+// Snapshot 数据库在某一时刻的一致性快照，用于副本的全量同步
+//
+// 快照分两个阶段完成：创建时短暂地阻止写入，将所有数据文件硬链接到快照目录中冻结下来，
+// 并从快照之后的写入序号开始读取数据变更；之后不再阻塞写入，调用方逐个传输快照中的文件，
+// 同时通过 Changes 读取（通常是缓冲）快照之后的变更，文件传输完成后再将这些变更发送给副本重放。
+// 快照目录中的文件可以直接作为一个数据库目录打开，使用完毕后需要调用 Remove 删除
+type Snapshot struct {
+	Seq     uint64         // 快照包含的最大写入序号
+	Dir     string         // 冻结的文件所在的目录
+	Files   []SnapshotFile // 快照中的文件，数据文件按类型及文件id排列，最后为 meta
+	Changes *ChangeReader  // 从 Seq+1 开始读取快照之后的数据变更
+}
+
+// Snapshot 在目录 dir 中创建数据库的快照，dir 需要与数据库目录在同一个文件系统中，不存在时会被创建
+// 快照之后的变更通过变更流读取，需要配置 ChangeBacklog，否则返回 ErrChangesDisabled
+func (db *MinDB) Snapshot(dir string) (*Snapshot, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	if entries, err := os.ReadDir(dir); err != nil {
+		return nil, err
+	} else if len(entries) > 0 {
+		return nil, ErrSnapshotDirNotEmpty
+	}
+
+	snap, err := db.freeze(dir)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+
+	// 冻结的文件在 Size 之内不会再被修改，校验和在释放锁之后计算
+	for i := range snap.Files {
+		if snap.Files[i].CRC, err = fileCRC(filepath.Join(dir, snap.Files[i].Name), snap.Files[i].Size); err != nil {
+			_ = snap.Remove()
+			return nil, err
+		}
+	}
+	return snap, nil
+}
+
+// 阻止写入，将数据文件硬链接到快照目录中，并记录快照时刻的写入序号及活跃文件的写偏移
+func (db *MinDB) freeze(dir string) (*Snapshot, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if !db.isOpen() {
+		return nil, ErrDBClosed
+	}
+	db.rLockAllIdx() // 各类型的写入都需要持有索引的写锁
+	defer db.rUnlockAllIdx()
+
+	seq := atomic.LoadUint64(&db.meta.Sequence)
+	changes, err := db.changes.reader(db.config.ChangeBacklog, seq+1)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &Snapshot{Seq: seq, Dir: dir, Changes: changes}
+	meta := storage.NewDBMeta()
+	meta.Sequence = seq
+	for _, dataType := range DataTypes {
+		files := db.sortedFiles(dataType)
+		for i, file := range files {
+			if err := file.Sync(); err != nil {
+				return nil, err
+			}
+			name := fmt.Sprintf(storage.DBFileFormatNames[dataType], file.Id)
+			path := filepath.Join(dir, name)
+			if err := os.Link(filepath.Join(db.config.DirPath, name), path); err != nil {
+				return nil, err
+			}
+
+			size := file.Offset
+			if i < len(files)-1 { // 已封存的文件不再写入，传输整个文件
+				info, err := os.Stat(path)
+				if err != nil {
+					return nil, err
+				}
+				size = info.Size()
+			} else {
+				meta.ActiveWriteOff[dataType] = file.Offset
+			}
+			snap.Files = append(snap.Files, SnapshotFile{Name: name, Size: size})
+		}
+	}
+
+	metaPath := dir + dbMetaSaveFile
+	if err := meta.Store(metaPath); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(metaPath)
+	if err != nil {
+		return nil, err
+	}
+	snap.Files = append(snap.Files, SnapshotFile{Name: filepath.Base(metaPath), Size: info.Size()})
+	return snap, nil
+}
+
+// 某类型的所有数据文件，按文件id排列，最后一个为活跃文件，调用方需持有该类型索引的锁
+func (db *MinDB) sortedFiles(dataType DataType) []*storage.DBFile {
+	files := make([]*storage.DBFile, 0, len(db.archFiles[dataType])+1)
+	for _, file := range db.archFiles[dataType] {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Id < files[j].Id })
+	activeFile, _ := db.getActiveFile(dataType)
+	return append(files, activeFile)
+}
+
+// Open 读取快照中的一个文件，只包含快照时刻的内容
+func (s *Snapshot) Open(f SnapshotFile) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(s.Dir, f.Name))
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, f.Size), file}, nil
+}
+
+// TempSnapshotDir 新建一个用于 Snapshot 的空的临时目录，使用完毕后由 Snapshot.Remove 删除
+// 目录建在数据目录旁边（同一个父目录中），名称为数据目录名加 ".snapshot-" 及随机后缀：
+// 硬链接要求与数据目录在同一个文件系统中，而放在数据目录之外不会被 Backup 复制；进程崩溃时遗留的目录在下次打开时删除
+func (db *MinDB) TempSnapshotDir() (string, error) {
+	dirPath, err := filepath.Abs(db.config.DirPath)
+	if err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(filepath.Dir(dirPath), filepath.Base(dirPath)+tempSnapshotSuffix+"*")
+}
+
+// 删除上次运行遗留的临时快照目录，包括旧版本建在数据目录中的 snapshot-* 目录，调用方需持有数据目录的锁
+func removeTempSnapshotDirs(dirPath string) error {
+	dirPath, err := filepath.Abs(dirPath)
+	if err != nil {
+		return err
+	}
+	siblings, err := filepath.Glob(filepath.Join(filepath.Dir(dirPath), filepath.Base(dirPath)+tempSnapshotSuffix+"*"))
+	if err != nil {
+		return err
+	}
+	inner, err := filepath.Glob(filepath.Join(dirPath, "snapshot-*"))
+	if err != nil {
+		return err
+	}
+	for _, dir := range append(siblings, inner...) {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		if err = os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove 删除快照目录，不影响数据库中的文件
+func (s *Snapshot) Remove() error {
+	return os.RemoveAll(s.Dir)
+}
+
+// 计算文件前 size 字节的校验和
+func fileCRC(path string, size int64) (uint32, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	h := crc32.NewIEEE()
+	if _, err = io.CopyN(h, file, size); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
@@ -0,0 +1,376 @@
+package mindb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"mindb/storage"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrSegmentNotFound 要读取的数据文件不是已封存的文件，或已被回收删除
+	ErrSegmentNotFound = errors.New("mindb: segment not found")
+
+	// ErrSegmentChecksum 拉取的数据文件与来源给出的大小或校验和不一致
+	ErrSegmentChecksum = errors.New("mindb: segment checksum mismatch")
+)
+
+// 冷备目录中记录已拉取的数据文件的文件名
+const standbyManifestFile = "standby.manifest"
+
+// Segment 一个已封存的数据文件，封存后不会再写入，但回收磁盘空间时可能被同名的新文件替换或被删除
+type Segment struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	CRC  uint32 `json:"crc"` // 整个文件的 crc32 IEEE 校验和
+}
+
+// SegmentSource 冷备拉取数据文件的来源，如主节点或保存了数据文件的对象存储
+type SegmentSource interface {
+	// Segments 返回所有已封存的数据文件，以及来源当前的写入序号
+	Segments() ([]Segment, uint64, error)
+
+	// Fetch 读取一个数据文件的全部内容
+	Fetch(seg Segment) (io.ReadCloser, error)
+}
+
+// 已封存文件的校验和缓存，文件的大小及修改时间不变时不重新计算
+type segmentCRCs struct {
+	mu sync.Mutex
+	m  map[string]segmentCRC
+}
+
+type segmentCRC struct {
+	size    int64
+	modTime time.Time
+	crc     uint32
+}
+
+// 获取文件的大小及校验和
+func (c *segmentCRCs) get(path string) (int64, uint32, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	c.mu.Lock()
+	cached, ok := c.m[path]
+	c.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.size, cached.crc, nil
+	}
+
+	crc, err := fileCRC(path, info.Size())
+	if err != nil {
+		return 0, 0, err
+	}
+	c.mu.Lock()
+	if c.m == nil {
+		c.m = make(map[string]segmentCRC)
+	}
+	c.m[path] = segmentCRC{size: info.Size(), modTime: info.ModTime(), crc: crc}
+	c.mu.Unlock()
+	return info.Size(), crc, nil
+}
+
+// Segments 返回数据库所有已封存的数据文件及当前的写入序号，使数据库本身可以作为冷备的来源
+func (db *MinDB) Segments() ([]Segment, uint64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if !db.isOpen() {
+		return nil, 0, ErrDBClosed
+	}
+
+	var names []string
+	db.rLockAllIdx() // 避免与切换活跃文件及回收时替换封存文件冲突
+	seq := db.Sequence()
+	for _, dataType := range DataTypes {
+		for fileId := range db.archFiles[dataType] {
+			names = append(names, fmt.Sprintf(storage.DBFileFormatNames[dataType], fileId))
+		}
+	}
+	db.rUnlockAllIdx()
+	sort.Strings(names)
+
+	segs := make([]Segment, 0, len(names))
+	for _, name := range names {
+		size, crc, err := db.segCRCs.get(filepath.Join(db.config.DirPath, name))
+		if os.IsNotExist(err) { // 已被回收删除
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		segs = append(segs, Segment{Name: name, Size: size, CRC: crc})
+	}
+	return segs, seq, nil
+}
+
+// Fetch 读取一个已封存的数据文件
+func (db *MinDB) Fetch(seg Segment) (io.ReadCloser, error) {
+	if !db.isSegment(seg.Name) {
+		return nil, ErrSegmentNotFound
+	}
+	file, err := os.Open(filepath.Join(db.config.DirPath, seg.Name))
+	if os.IsNotExist(err) {
+		return nil, ErrSegmentNotFound
+	}
+	return file, err
+}
+
+// ReadSegment 从已封存的数据文件的 offset 处读取数据到 p 中，返回读取的字节数，读到文件末尾时返回 io.EOF
+func (db *MinDB) ReadSegment(name string, offset int64, p []byte) (int, error) {
+	rc, err := db.Fetch(Segment{Name: name})
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	return rc.(*os.File).ReadAt(p, offset)
+}
+
+// 文件名是否为当前已封存的数据文件
+func (db *MinDB) isSegment(name string) bool {
+	parts := strings.Split(name, ".")
+	if len(parts) != 3 || parts[1] != "data" {
+		return false
+	}
+	fileId, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return false
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for _, dataType := range DataTypes {
+		if storage.DBFileSuffixName[dataType] != parts[2] ||
+			name != fmt.Sprintf(storage.DBFileFormatNames[dataType], fileId) {
+			continue
+		}
+		lock := db.idxLock(dataType)
+		lock.RLock()
+		_, ok := db.archFiles[dataType][uint32(fileId)]
+		lock.RUnlock()
+		return ok
+	}
+	return false
+}
+
+// DirSource 以一个目录作为冷备的来源，如挂载的对象存储或定期上传数据文件的目录，目录中所有的数据文件都视为已封存的
+func DirSource(dir string) SegmentSource {
+	return dirSource{dir: dir, crcs: &segmentCRCs{}}
+}
+
+type dirSource struct {
+	dir  string
+	crcs *segmentCRCs
+}
+
+func (s dirSource) Segments() ([]Segment, uint64, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	var segs []Segment
+	for _, e := range entries {
+		if parts := strings.Split(e.Name(), "."); e.IsDir() || len(parts) != 3 || parts[1] != "data" {
+			continue
+		}
+		size, crc, err := s.crcs.get(filepath.Join(s.dir, e.Name()))
+		if err != nil {
+			return nil, 0, err
+		}
+		segs = append(segs, Segment{Name: e.Name(), Size: size, CRC: crc})
+	}
+
+	meta, err := storage.LoadMeta(s.dir + dbMetaSaveFile)
+	if err != nil {
+		return nil, 0, err
+	}
+	return segs, meta.Sequence, nil
+}
+
+func (s dirSource) Fetch(seg Segment) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.dir, filepath.Base(seg.Name)))
+}
+
+// Standby 冷备：定期从来源拉取新封存的数据文件，并生成对应的 meta，使冷备目录随时可以直接 Open
+//
+// 冷备只包含已封存的数据，各类型的数据封存的时间不同，因此冷备中各类型数据的时间点可能不一致，
+// 适用于不需要流式复制、能接受丢失最近写入的场景
+type Standby struct {
+	dir    string
+	source SegmentSource
+	have   map[string]Segment // 已拉取的数据文件
+}
+
+// NewStandby 创建冷备，dir 不存在时会被创建，之前拉取过的数据文件记录在 dir 中，重启后只拉取变化的文件
+func NewStandby(dir string, source SegmentSource) (*Standby, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	s := &Standby{dir: dir, source: source, have: make(map[string]Segment)}
+	b, err := ioutil.ReadFile(filepath.Join(dir, standbyManifestFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(b) > 0 {
+		var segs []Segment
+		if err = json.Unmarshal(b, &segs); err != nil {
+			return nil, err
+		}
+		for _, seg := range segs {
+			s.have[seg.Name] = seg
+		}
+	}
+	return s, nil
+}
+
+// Pull 拉取一轮：下载新增或被替换的数据文件，删除来源中已不存在的文件，然后更新 meta，返回下载的文件数
+func (s *Standby) Pull() (int, error) {
+	segs, seq, err := s.source.Segments()
+	if err != nil {
+		return 0, err
+	}
+
+	var fetched int
+	latest := make(map[string]Segment, len(segs))
+	for _, seg := range segs {
+		seg.Name = filepath.Base(seg.Name)
+		latest[seg.Name] = seg
+		if s.have[seg.Name] == seg {
+			continue
+		}
+		if err = s.fetch(seg); err != nil {
+			break
+		}
+		s.have[seg.Name] = seg
+		fetched++
+	}
+	if err == nil {
+		for name := range s.have {
+			if _, ok := latest[name]; ok {
+				continue
+			}
+			if err = os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+				break
+			}
+			err = nil
+			delete(s.have, name)
+		}
+	}
+
+	// 中途出错时也要保存已拉取的文件对应的 meta，保证冷备目录在任何时刻都可以打开
+	if saveErr := s.saveManifest(); saveErr != nil {
+		return fetched, saveErr
+	}
+	if saveErr := s.saveMeta(seq); saveErr != nil {
+		return fetched, saveErr
+	}
+	return fetched, err
+}
+
+// Run 每隔 interval 拉取一次，直到 stop 被关闭，出错时记录日志后在下一轮重试
+func (s *Standby) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := s.Pull(); err != nil {
+			log.Printf("standby pull err: %+v\n", err)
+		} else if n > 0 {
+			log.Printf("standby pulled %d segments\n", n)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// 下载一个数据文件，先写入临时文件，校验通过后再替换
+func (s *Standby) fetch(seg Segment) error {
+	rc, err := s.source.Fetch(seg)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	path := filepath.Join(s.dir, seg.Name)
+	tmp, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, storage.FilePerm)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(tmp, h), rc)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if n != seg.Size || h.Sum32() != seg.CRC {
+		return ErrSegmentChecksum
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *Standby) saveManifest() error {
+	segs := make([]Segment, 0, len(s.have))
+	for _, seg := range s.have {
+		segs = append(segs, seg)
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].Name < segs[j].Name })
+	b, err := json.Marshal(segs)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, standbyManifestFile)
+	if err = ioutil.WriteFile(path+".tmp", b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// 生成冷备目录的 meta：打开时每种类型id最大的文件会成为活跃文件，其写偏移为文件的大小
+func (s *Standby) saveMeta(seq uint64) error {
+	meta := storage.NewDBMeta()
+	meta.Sequence = seq
+	lastIds := make(map[uint16]uint32)
+	for name, seg := range s.have {
+		parts := strings.Split(name, ".")
+		if len(parts) != 3 {
+			continue
+		}
+		fileId, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			continue
+		}
+		for _, dataType := range DataTypes {
+			if storage.DBFileSuffixName[dataType] != parts[2] {
+				continue
+			}
+			if last, ok := lastIds[dataType]; !ok || uint32(fileId) > last {
+				lastIds[dataType] = uint32(fileId)
+				meta.ActiveWriteOff[dataType] = seg.Size
+			}
+		}
+	}
+	return meta.Store(s.dir + dbMetaSaveFile)
+}
@@ -0,0 +1,83 @@
+package mindb
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats 数据库的统计信息
+type Stats struct {
+	Uptime             time.Duration      // 数据库打开的时长
+	Keys               map[DataType]int   // 各类型的key数量，不包括内部key，字符串中可能包含已过期但还未删除的key
+	Expires            int                // 设置了过期时间的字符串key数量
+	ArchivedFiles      map[DataType]int   // 各类型已封存文件的数量
+	ActiveFileOffset   map[DataType]int64 // 各类型活跃文件的写偏移
+	ReclaimableBytes   map[DataType]int64 // 各类型已封存文件中可回收的空间大小，目前只统计了字符串类型
+	Reclaiming         bool               // 是否正在回收磁盘空间
+	HotKeys            []PrefixAccess     // 最近访问次数最多的key前缀，需要开启 HotKeySampleRate
+	CorruptReads       int64              // 从磁盘读取到损坏数据的次数
+	RepairedKeys       int64              // 因数据损坏而回退到较早的值或被删除的key数量
+	ChecksumMismatches int64              // 开启 VerifyValueOnRead 后，读取的值与索引中的校验和不一致的次数
+	CorruptOnLoad      int64              // 打开时按 RecoveryMode 跳过或截断的损坏entry数
+}
+
+// Stats 中最多返回的key前缀数量
+const statsHotKeys = 10
+
+// Stats 获取数据库的统计信息，key的数量需要遍历各类型的索引，key很多时有一定的耗时，回收磁盘空间期间会等待回收完成
+func (db *MinDB) Stats() Stats {
+	stats := Stats{
+		Uptime:           time.Since(db.openedAt),
+		Keys:             make(map[DataType]int),
+		ArchivedFiles:    make(map[DataType]int),
+		ActiveFileOffset: make(map[DataType]int64),
+		ReclaimableBytes: make(map[DataType]int64),
+	}
+
+	stats.Reclaiming = atomic.LoadInt32(&db.reclaiming) == 1
+	stats.CorruptReads = atomic.LoadInt64(&db.corruptReads)
+	stats.RepairedKeys = atomic.LoadInt64(&db.repairedKeys)
+	stats.ChecksumMismatches = atomic.LoadInt64(&db.badChecksums)
+	stats.CorruptOnLoad = atomic.LoadInt64(&db.loadCorrupt)
+	stats.HotKeys = db.HotKeys(statsHotKeys)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	for _, dType := range DataTypes {
+		lock := db.idxLock(dType)
+		lock.RLock()
+		switch dType {
+		case String:
+			internal, internalExpires := db.internalStrKeys()
+			stats.Keys[dType] = db.strIndex.idxList.Len - internal
+			stats.Expires = len(db.expires) - internalExpires
+		case List:
+			stats.Keys[dType] = db.listIndex.indexes.KeyCount(isInternalName)
+		case Hash:
+			stats.Keys[dType] = db.hashIndex.indexes.KeyCount(isInternalName)
+		case Set:
+			stats.Keys[dType] = db.setIndex.indexes.KeyCount(isInternalName)
+		case ZSet:
+			stats.Keys[dType] = db.zsetIndex.indexes.KeyCount(isInternalName)
+		}
+		stats.ArchivedFiles[dType] = len(db.archFiles[dType])
+		if file, _ := db.getActiveFile(dType); file != nil {
+			stats.ActiveFileOffset[dType] = file.Offset
+		}
+		stats.ReclaimableBytes[dType], _ = db.reclaimableBytesOf(dType)
+		lock.RUnlock()
+	}
+	return stats
+}
+
+// 字符串索引中内部key的数量，及其中设置了过期时间的数量，调用方需持有字符串索引的读锁
+func (db *MinDB) internalStrKeys() (keys, expires int) {
+	for e := db.strIndex.idxList.FindPrefix([]byte(InternalKeyPrefix)); e != nil && isInternalKey(e.Key()); e = e.Next() {
+		keys++
+		if _, ok := db.expires[string(e.Key())]; ok {
+			expires++
+		}
+	}
+	return
+}
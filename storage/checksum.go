@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math/bits"
+)
+
+var ErrUnknownChecksum = errors.New("storage/checksum: unknown checksum type")
+
+// ChecksumType entry 校验和使用的算法，记录在每个数据文件的文件头中，不同文件可以使用不同的算法
+type ChecksumType uint8
+
+const (
+	// ChecksumCRC32 crc32 IEEE 多项式，没有文件头的旧数据文件均使用此算法
+	ChecksumCRC32 ChecksumType = iota
+
+	// ChecksumCRC32C crc32 Castagnoli 多项式，支持 SSE4.2 的 CPU 上有硬件加速
+	ChecksumCRC32C
+
+	// ChecksumXXHash64 xxhash64，较大的 value 上速度更快，取结果的低 32 位作为校验和
+	ChecksumXXHash64
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Valid 是否是支持的校验和算法
+func (c ChecksumType) Valid() bool {
+	return c <= ChecksumXXHash64
+}
+
+// Sum 计算校验和
+func (c ChecksumType) Sum(b []byte) uint32 {
+	return c.sum(b)
+}
+
+// 计算校验和
+func (c ChecksumType) sum(b []byte) uint32 {
+	switch c {
+	case ChecksumCRC32C:
+		return crc32.Checksum(b, castagnoliTable)
+	case ChecksumXXHash64:
+		return uint32(xxhash64(b))
+	default:
+		return crc32.ChecksumIEEE(b)
+	}
+}
+
+// xxhash64 的常量，seed 固定为 0，使用变量以便运算时按 uint64 溢出回绕
+var (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// 计算 xxhash64，参考 https://github.com/Cyan4973/xxHash/blob/dev/doc/xxhash_spec.md
+func xxhash64(b []byte) uint64 {
+	n := len(b)
+	var h uint64
+
+	if n >= 32 {
+		v1 := xxPrime1 + xxPrime2
+		v2 := xxPrime2
+		v3 := uint64(0)
+		v4 := -xxPrime1
+		for len(b) >= 32 {
+			v1 = xxRound(v1, binary.LittleEndian.Uint64(b[0:8]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint64(b[8:16]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint64(b[16:24]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint64(b[24:32]))
+			b = b[32:]
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMergeRound(h, v1)
+		h = xxMergeRound(h, v2)
+		h = xxMergeRound(h, v3)
+		h = xxMergeRound(h, v4)
+	} else {
+		h = xxPrime5
+	}
+
+	h += uint64(n)
+
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(b[:8]))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b[:4])) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		b = b[4:]
+	}
+	for ; len(b) > 0; b = b[1:] {
+		h ^= uint64(b[0]) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	val = xxRound(0, val)
+	acc ^= val
+	return acc*xxPrime1 + xxPrime4
+}
@@ -1,10 +1,11 @@
 package storage
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/edsrzf/mmap-go"
-	"hash/crc32"
+	"io"
 	"io/ioutil"
 	"os"
 	"sort"
@@ -18,10 +19,21 @@ const (
 
 	// PathSeparator the default path separator
 	PathSeparator = string(os.PathSeparator)
+
+	// 数据文件头：4字节的magic + 1字节的格式版本 + 1字节的校验和算法 + 2字节保留
+	// 旧格式的数据文件没有文件头，entry 从文件起始处开始，校验和均为 crc32 IEEE
+	fileHeaderSize = 8
+
+	// 数据文件格式的版本
+	fileFormatVersion = 1
 )
 
+var fileMagic = []byte("MDBF")
+
 var (
 	ErrEmptyEntry = errors.New("storage/db_file: entry or the Key of entry is empty")
+
+	ErrFileVersion = errors.New("storage/db_file: unsupported data file version")
 )
 
 var (
@@ -59,10 +71,14 @@ type DBFile struct {
 	mmap   mmap.MMap
 	Offset int64
 	method FileRWMethod
+
+	checksum ChecksumType // 文件中entry使用的校验和算法
+	dataOff  int64        // 第一条entry的位置，即文件头的大小
 }
 
 // NewDBFile 新建一个数据读写文件，如果是MMap，则需要Truncate文件并进行加载
-func NewDBFile(path string, fileId uint32, method FileRWMethod, blockSize int64, eType uint16) (*DBFile, error) {
+// checksum 为新文件使用的校验和算法，已存在的文件使用其文件头中记录的算法
+func NewDBFile(path string, fileId uint32, method FileRWMethod, blockSize int64, eType uint16, checksum ChecksumType) (*DBFile, error) {
 	filePath := path + PathSeparator + fmt.Sprintf(DBFileFormatNames[eType], fileId) // 要指定文件的数据类型
 
 	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDWR, FilePerm)
@@ -84,49 +100,136 @@ func NewDBFile(path string, fileId uint32, method FileRWMethod, blockSize int64,
 		}
 		df.mmap = m
 	}
+
+	if err = df.loadHeader(checksum); err != nil {
+		_ = df.Close(false)
+		return nil, err
+	}
 	return df, nil
 }
 
+// 读取文件头，确定文件中entry使用的校验和算法，空文件则写入使用 checksum 的文件头
+func (df *DBFile) loadHeader(checksum ChecksumType) error {
+	var empty bool
+	if df.method == FileIO {
+		info, err := df.File.Stat()
+		if err != nil {
+			return err
+		}
+		empty = info.Size() == 0
+	} else { // MMap 的文件被 Truncate 过，新文件的内容全为0，而旧格式文件的第一条entry的key不为空
+		empty = bytes.Equal(df.mmap[:entryHeaderSize], make([]byte, entryHeaderSize))
+	}
+
+	if empty {
+		if !checksum.Valid() {
+			return ErrUnknownChecksum
+		}
+		header := make([]byte, fileHeaderSize)
+		copy(header, fileMagic)
+		header[4] = fileFormatVersion
+		header[5] = byte(checksum)
+		if df.method == FileIO {
+			if _, err := df.File.WriteAt(header, 0); err != nil {
+				return err
+			}
+		} else {
+			copy(df.mmap, header)
+		}
+		df.checksum, df.dataOff = checksum, fileHeaderSize
+	} else {
+		header, err := df.readBuf(0, fileHeaderSize)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(header[:len(fileMagic)], fileMagic) { // 没有文件头的旧格式文件
+			df.checksum, df.dataOff = ChecksumCRC32, 0
+		} else {
+			if header[4] != fileFormatVersion {
+				return ErrFileVersion
+			}
+			if df.checksum = ChecksumType(header[5]); !df.checksum.Valid() {
+				return ErrUnknownChecksum
+			}
+			df.dataOff = fileHeaderSize
+		}
+	}
+
+	df.Offset = df.dataOff
+	return nil
+}
+
+// DataOffset 文件中第一条entry的位置
+func (df *DBFile) DataOffset() int64 {
+	return df.dataOff
+}
+
 // Read 从数据文件中读数据 offset是读的起始位置
 func (df *DBFile) Read(offset int64) (e *Entry, err error) {
+	return readEntry(func(n int64) (buf []byte, err error) {
+		if buf, err = df.readBuf(offset, n); err == nil {
+			offset += n // 更新offset
+		}
+		return
+	}, df.checksum)
+}
+
+// 依次读取并解码一条entry，read 每次调用按顺序返回接下来的n个字节，checksum 为校验和算法
+func readEntry(read func(n int64) ([]byte, error), checksum ChecksumType) (e *Entry, err error) {
 
 	var buf []byte
-	if buf, err = df.readBuf(offset, int64(entryHeaderSize)); err != nil { // 读取entry header信息到buf中
+	if buf, err = read(int64(entryHeaderSize)); err != nil { // 读取entry header信息到buf中
 		return
 	}
+	// 有效的entry一定有key，header不会全为0；全为0的是 MMap 预分配的或写入时被截断的文件末尾，
+	// 不能交给校验和判断，空数据的校验和并不都为0（如 xxhash64）
+	if bytes.Equal(buf, make([]byte, len(buf))) {
+		return nil, io.EOF
+	}
 
 	if e, err = Decode(buf); err != nil { // 对buf进行解码得到entry
 		return
 	}
 
-	offset += entryHeaderSize // 更新offset
-	if e.Meta.KeySize > 0 {   // 如果解码出的entry中有key，就对其key进行赋值
+	if e.hasDeadline() { // 新格式的entry在header之后带有过期时间
+		if buf, err = read(entryDeadlineSize); err != nil {
+			return
+		}
+		e.decodeDeadline(buf)
+	}
+
+	if e.hasSeq() { // 带有操作序号
+		if buf, err = read(entrySeqSize); err != nil {
+			return
+		}
+		e.decodeSeq(buf)
+	}
+
+	if e.Meta.KeySize > 0 { // 如果解码出的entry中有key，就对其key进行赋值
 		var key []byte
-		if key, err = df.readBuf(offset, int64(e.Meta.KeySize)); err != nil {
+		if key, err = read(int64(e.Meta.KeySize)); err != nil {
 			return
 		}
 		e.Meta.Key = key
 	}
 
-	offset += int64(e.Meta.KeySize) // 更新offset
-	if e.Meta.ValueSize > 0 {       // 如果解码出的entry中有value，就对其key进行赋值
+	if e.Meta.ValueSize > 0 { // 如果解码出的entry中有value，就对其key进行赋值
 		var val []byte
-		if val, err = df.readBuf(offset, int64(e.Meta.ValueSize)); err != nil {
+		if val, err = read(int64(e.Meta.ValueSize)); err != nil {
 			return
 		}
 		e.Meta.Value = val
 	}
 
-	offset += int64(e.Meta.ValueSize) // 更新offset
-	if e.Meta.ExtraSize > 0 {         // 如果解码出的entry中有extra，就对其key进行赋值
+	if e.Meta.ExtraSize > 0 { // 如果解码出的entry中有extra，就对其key进行赋值
 		var val []byte
-		if val, err = df.readBuf(offset, int64(e.Meta.ExtraSize)); err != nil {
+		if val, err = read(int64(e.Meta.ExtraSize)); err != nil {
 			return
 		}
 		e.Meta.Extra = val
 	}
 
-	checkCrc := crc32.ChecksumIEEE(e.Meta.Value) // 计算校验和进行检验
+	checkCrc := checksum.sum(e.Meta.Value) // 计算校验和进行检验
 	if checkCrc != e.crc32 {
 		return nil, ErrInvalidCrc
 	}
@@ -160,7 +263,7 @@ func (df *DBFile) Write(e *Entry) error {
 
 	method := df.method
 	writeOff := df.Offset
-	encVal, err := e.Encode()
+	encVal, err := e.encode(df.checksum)
 	if err != nil {
 		return err
 	}
@@ -178,6 +281,28 @@ func (df *DBFile) Write(e *Entry) error {
 	return nil
 }
 
+// Truncate 丢弃文件中 offset 之后的数据并持久化，用于修复损坏的数据文件，写偏移在 offset 之后时一并回退
+// MMap 的文件大小固定，将 offset 之后的内容清零，与新文件中未写入的部分一样
+func (df *DBFile) Truncate(offset int64) error {
+	if offset < df.dataOff {
+		offset = df.dataOff
+	}
+	if df.method == FileIO {
+		if err := df.File.Truncate(offset); err != nil {
+			return err
+		}
+	} else if offset < int64(len(df.mmap)) {
+		tail := df.mmap[offset:]
+		for i := range tail {
+			tail[i] = 0
+		}
+	}
+	if df.Offset > offset {
+		df.Offset = offset
+	}
+	return df.Sync()
+}
+
 // Close 读写后进行关闭操作
 func (df *DBFile) Close(sync bool) (err error) { //sync 关闭前是否持久化数据
 	if sync {
@@ -206,7 +331,7 @@ func (df *DBFile) Sync() (err error) {
 }
 
 // Build 加载数据文件
-func Build(path string, method FileRWMethod, blockSize int64) (map[uint16]map[uint32]*DBFile, map[uint16]uint32, error) {
+func Build(path string, method FileRWMethod, blockSize int64, checksum ChecksumType) (map[uint16]map[uint32]*DBFile, map[uint16]uint32, error) {
 	dir, err := ioutil.ReadDir(path) // 读取该目录下的文件和目录
 	if err != nil {
 		return nil, nil, err
@@ -248,7 +373,7 @@ func Build(path string, method FileRWMethod, blockSize int64) (map[uint16]map[ui
 			for i := 0; i < len(fileIDs)-1; i++ {
 				id := fileIDs[i]
 
-				file, err := NewDBFile(path, uint32(id), method, blockSize, dataType)
+				file, err := NewDBFile(path, uint32(id), method, blockSize, dataType, checksum)
 				if err != nil {
 					return nil, nil, err
 				}
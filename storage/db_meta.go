@@ -1,22 +1,62 @@
 package storage
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"hash/crc32"
 	"io/ioutil"
 	"os"
+	"sync"
 )
 
+var (
+	ErrMetaChecksum = errors.New("storage/db_meta: meta file checksum mismatch")
+
+	ErrMetaVersion = errors.New("storage/db_meta: meta file version is newer than supported")
+)
+
+const (
+	// MetaVersion 当前 meta 文件的格式版本
+	// 1: 旧版本直接保存 json，没有版本号和校验和
+	// 2: magic + crc32 + json，增加了写入序号和失效数据大小
+	MetaVersion = 2
+
+	// meta 文件头：4字节的magic + 4字节的crc32校验和
+	metaHeaderSize = 8
+)
+
+var metaMagic = []byte("MDBM")
+
 // DBMeta 保存数据库的一些额外信息
 type DBMeta struct {
-	ActiveWriteOff map[uint16]int64 `json:"active_write_off"` //当前数据文件的写偏移（分类型）
+	Version        uint32                      `json:"version"`          //meta格式版本
+	ActiveWriteOff map[uint16]int64            `json:"active_write_off"` //当前数据文件的写偏移（分类型）
+	Sequence       uint64                      `json:"sequence"`         //已分配的最大写入序号
+	DeadBytes      map[uint16]map[uint32]int64 `json:"dead_bytes"`       //每个数据文件中已失效数据的字节数（分类型）
+	deadMu         sync.Mutex                  //保护DeadBytes
+}
+
+// NewDBMeta 新建一个空的meta
+func NewDBMeta() *DBMeta {
+	return &DBMeta{
+		Version:        MetaVersion,
+		ActiveWriteOff: make(map[uint16]int64),
+		DeadBytes:      make(map[uint16]map[uint32]int64),
+	}
 }
 
-// LoadMeta 加载数据库信息
+// LoadMeta 加载数据库信息，文件不存在时返回一个空的meta
+// 旧版本的meta文件会被自动迁移为当前版本并写回
 func LoadMeta(path string) (m *DBMeta, err error) {
-	m = &DBMeta{ActiveWriteOff: make(map[uint16]int64)}
+	m = NewDBMeta()
 
 	file, err := os.OpenFile(path, os.O_RDONLY, 0600) // 只读权限打开path路径下的文件
 	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
 		return
 	}
 
@@ -27,27 +67,110 @@ func LoadMeta(path string) (m *DBMeta, err error) {
 		return
 	}
 
-	err = json.Unmarshal(b, m) // 解析json编码的数据到DBMeta中
-	if err != nil {
+	legacy := !bytes.HasPrefix(b, metaMagic)
+	if !legacy { // 新版本的meta文件，先检验校验和
+		if len(b) < metaHeaderSize {
+			return nil, ErrMetaChecksum
+		}
+		payload := b[metaHeaderSize:]
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(b[4:metaHeaderSize]) {
+			return nil, ErrMetaChecksum
+		}
+		b = payload
+	}
+
+	m.Version = 0
+	if err = json.Unmarshal(b, m); err != nil { // 解析json编码的数据到DBMeta中
 		return
 	}
+	if legacy && m.Version == 0 {
+		m.Version = 1
+	}
+	if m.Version > MetaVersion {
+		return nil, ErrMetaVersion
+	}
+
+	if m.migrate() {
+		err = m.Store(path)
+	}
 	return
 }
 
-// Store 将数据库信息存储
+// 将旧版本的meta迁移为当前版本，返回是否进行了迁移
+func (m *DBMeta) migrate() bool {
+	if m.Version == MetaVersion {
+		return false
+	}
+
+	if m.ActiveWriteOff == nil {
+		m.ActiveWriteOff = make(map[uint16]int64)
+	}
+	if m.DeadBytes == nil { // 版本1没有记录失效数据，从0开始统计
+		m.DeadBytes = make(map[uint16]map[uint32]int64)
+	}
+	m.Version = MetaVersion
+	return true
+}
+
+// AddDeadBytes 记录某个数据文件中新增的失效数据大小
+func (m *DBMeta) AddDeadBytes(dataType uint16, fileId uint32, n int64) {
+	m.deadMu.Lock()
+	defer m.deadMu.Unlock()
+	if m.DeadBytes[dataType] == nil {
+		m.DeadBytes[dataType] = make(map[uint32]int64)
+	}
+	m.DeadBytes[dataType][fileId] += n
+}
+
+// GetDeadBytes 获取某个数据文件中已失效数据的大小
+func (m *DBMeta) GetDeadBytes(dataType uint16, fileId uint32) int64 {
+	m.deadMu.Lock()
+	defer m.deadMu.Unlock()
+	return m.DeadBytes[dataType][fileId]
+}
+
+// HasDeadBytes 该类型的数据是否统计了失效数据的大小
+func (m *DBMeta) HasDeadBytes(dataType uint16) bool {
+	m.deadMu.Lock()
+	defer m.deadMu.Unlock()
+	return len(m.DeadBytes[dataType]) > 0
+}
+
+// ClearDeadBytes 数据文件被回收后，清除其失效数据的记录
+func (m *DBMeta) ClearDeadBytes(dataType uint16, fileId uint32) {
+	m.deadMu.Lock()
+	defer m.deadMu.Unlock()
+	delete(m.DeadBytes[dataType], fileId)
+}
+
+// Store 将数据库信息存储，先写入临时文件再重命名，避免写入一半时留下损坏的文件
 func (m *DBMeta) Store(path string) error {
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0600)
+	m.deadMu.Lock()
+	payload, err := json.Marshal(m) // 对DBMeta进行json编码
+	m.deadMu.Unlock()
 	if err != nil {
 		return err
 	}
 
-	defer file.Close()
+	b := make([]byte, metaHeaderSize+len(payload))
+	copy(b[:4], metaMagic)
+	binary.BigEndian.PutUint32(b[4:metaHeaderSize], crc32.ChecksumIEEE(payload))
+	copy(b[metaHeaderSize:], payload)
 
-	b, err := json.Marshal(m) // 对DBMeta进行json编码
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
 
-	_, err = file.Write(b) // 写入到文件中
-	return err
+	if _, err = file.Write(b); err == nil { // 写入到文件中
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
 }
@@ -3,7 +3,6 @@ package storage
 import (
 	"encoding/binary"
 	"errors"
-	"hash/crc32"
 )
 
 var (
@@ -16,6 +15,22 @@ const (
 	//Type 和 Mark 占 2 + 2
 	//4 + 4 + 4 + 4 + 2 + 2 = 20
 	entryHeaderSize = 20
+
+	// entryDeadlineSize 可选的过期时间字段大小，uint64 占 8 字节，紧跟在 header 之后
+	entryDeadlineSize = 8
+
+	// entryDeadlineFlag Type 字段的最高位，置位时表示 header 后带有过期时间字段
+	// 旧格式的 entry 没有此标识，依然可以正常读取
+	entryDeadlineFlag uint16 = 1 << 15
+
+	// entrySeqSize 可选的操作序号字段大小，uint64 占 8 字节，位于过期时间字段之后
+	entrySeqSize = 8
+
+	// entrySeqFlag Type 字段的次高位，置位时表示 header 后带有操作序号字段
+	entrySeqFlag uint16 = 1 << 14
+
+	// Type 字段中的所有标识位
+	entryFlagMask = entryDeadlineFlag | entrySeqFlag
 )
 
 //Value的数据结构类型
@@ -34,6 +49,14 @@ type (
 		Type  uint16 //数据类型
 		Mark  uint16 //数据操作类型
 		crc32 uint32 //校验和
+		flag  uint16 //解码时 Type 字段中的标识位
+
+		// Deadline 过期时间（unix 秒），为 0 表示不过期
+		Deadline uint64
+
+		// Seq 同一个key上的操作序号，从 1 开始递增，为 0 表示没有记录（如旧格式的entry）
+		// 重建索引时按此序号重放，不依赖entry在文件中的位置
+		Seq uint64
 	}
 
 	// Meta meta 数据
@@ -70,38 +93,69 @@ func NewEntryNoExtra(key, value []byte, t, mark uint16) *Entry {
 
 // Size 返回entry的大小（包括header和key和value）
 func (e *Entry) Size() uint32 {
-	return entryHeaderSize + e.Meta.KeySize + e.Meta.ValueSize + e.Meta.ExtraSize
+	return e.headerSize() + e.Meta.KeySize + e.Meta.ValueSize + e.Meta.ExtraSize
+}
+
+// header 的大小，带有过期时间、操作序号时需要额外加上相应字段
+func (e *Entry) headerSize() uint32 {
+	size := uint32(entryHeaderSize)
+	if e.Deadline > 0 {
+		size += entryDeadlineSize
+	}
+	if e.Seq > 0 {
+		size += entrySeqSize
+	}
+	return size
 }
 
-// Encode 对Entry进行编码，返回字节数组
+// Encode 对Entry进行编码，返回字节数组，校验和使用 crc32 IEEE
 func (e *Entry) Encode() ([]byte, error) {
+	return e.encode(ChecksumCRC32)
+}
+
+// 使用指定的校验和算法对Entry进行编码
+func (e *Entry) encode(checksum ChecksumType) ([]byte, error) {
 	if e == nil || e.Meta.KeySize == 0 {
 		return nil, ErrInvalidEntry
 	}
 
 	ks, vs := e.Meta.KeySize, e.Meta.ValueSize
 	es := e.Meta.ExtraSize
+	hs := e.headerSize()
 	buf := make([]byte, e.Size())
 
+	t := e.Type
+	off := entryHeaderSize
+	if e.Deadline > 0 { // 有过期时间时在 Type 中打上标识，并写入过期时间
+		t |= entryDeadlineFlag
+		binary.BigEndian.PutUint64(buf[off:off+entryDeadlineSize], e.Deadline)
+		off += entryDeadlineSize
+	}
+	if e.Seq > 0 { // 有操作序号时在 Type 中打上标识，并写入操作序号
+		t |= entrySeqFlag
+		binary.BigEndian.PutUint64(buf[off:off+entrySeqSize], e.Seq)
+	}
+
 	binary.BigEndian.PutUint32(buf[4:8], ks)   //  写入key的大小
 	binary.BigEndian.PutUint32(buf[8:12], vs)  //  写入value的大小
 	binary.BigEndian.PutUint32(buf[12:16], es) // 写入extra信息的大小
-	binary.BigEndian.PutUint16(buf[16:18], e.Type)
+	binary.BigEndian.PutUint16(buf[16:18], t)
 	binary.BigEndian.PutUint16(buf[18:20], e.Mark)
-	copy(buf[entryHeaderSize:entryHeaderSize+ks], e.Meta.Key)           //  写入key
-	copy(buf[entryHeaderSize+ks:(entryHeaderSize+ks+vs)], e.Meta.Value) // 写入value
+	copy(buf[hs:hs+ks], e.Meta.Key)           //  写入key
+	copy(buf[hs+ks:(hs+ks+vs)], e.Meta.Value) // 写入value
 
 	if es > 0 { // 如果有extra info，就将其写入到buf中
-		copy(buf[(entryHeaderSize+ks+vs):(entryHeaderSize+ks+vs+es)], e.Meta.Extra)
+		copy(buf[(hs+ks+vs):(hs+ks+vs+es)], e.Meta.Extra)
 	}
 
-	crc := crc32.ChecksumIEEE(e.Meta.Value)   // 计算校验和
+	crc := checksum.sum(e.Meta.Value)         // 计算校验和
 	binary.BigEndian.PutUint32(buf[0:4], crc) // 第一部分 写入校验和 crc
 
 	return buf, nil
 }
 
 // Decode 解码字节数组，返回Entry
+// 如果 header 后带有过期时间、操作序号字段，需要再单独读取并解码
 func Decode(buf []byte) (*Entry, error) {
 	ks := binary.BigEndian.Uint32(buf[4:8])  // 取出 key的大小
 	vs := binary.BigEndian.Uint32(buf[8:12]) // 取出 value的大小
@@ -116,8 +170,29 @@ func Decode(buf []byte) (*Entry, error) {
 			ValueSize: vs,
 			ExtraSize: es,
 		},
-		Type:  t,
+		Type:  t &^ entryFlagMask,
 		Mark:  mark,
 		crc32: crc,
+		flag:  t & entryFlagMask,
 	}, nil
 }
+
+// 解码出的 entry 的 header 后是否还带有过期时间字段
+func (e *Entry) hasDeadline() bool {
+	return e.flag&entryDeadlineFlag != 0
+}
+
+// 解码 header 之后的过期时间字段
+func (e *Entry) decodeDeadline(buf []byte) {
+	e.Deadline = binary.BigEndian.Uint64(buf[:entryDeadlineSize])
+}
+
+// 解码出的 entry 的 header 后是否还带有操作序号字段
+func (e *Entry) hasSeq() bool {
+	return e.flag&entrySeqFlag != 0
+}
+
+// 解码操作序号字段
+func (e *Entry) decodeSeq(buf []byte) {
+	e.Seq = binary.BigEndian.Uint64(buf[:entrySeqSize])
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"io"
+	"sort"
+)
+
+// IterOrder 跨文件遍历entry的顺序
+type IterOrder uint8
+
+const (
+	// OrderByOffset 按文件id从小到大，同一文件内按写入位置的先后遍历
+	// 同一文件中不同key的序号不一定递增（如回收后重写的文件），需要按序号处理的调用方自行处理，迭代器不缓存entry
+	OrderByOffset IterOrder = iota
+)
+
+// MergedIterator 跨一组数据文件的entry迭代器
+// 每个文件使用带预读缓冲的顺序读取器，供加载索引、回收磁盘空间等需要扫描一组文件的场景共用
+type MergedIterator struct {
+	files  []*DBFile
+	order  IterOrder
+	cur    int          // 正在读取的文件在 files 中的下标
+	reader *EntryReader // 正在读取的文件的顺序读取器
+
+	bufSize int // 每个文件的顺序读取器的预读缓冲区大小，不大于0时使用默认值
+}
+
+// NewMergedIterator 新建一个遍历 files 中所有entry的迭代器，files 会按文件id排序
+func NewMergedIterator(files []*DBFile, order IterOrder) *MergedIterator {
+	sortedFiles := make([]*DBFile, 0, len(files))
+	for _, f := range files {
+		if f != nil {
+			sortedFiles = append(sortedFiles, f)
+		}
+	}
+	sort.Slice(sortedFiles, func(i, j int) bool {
+		return sortedFiles[i].Id < sortedFiles[j].Id
+	})
+	return &MergedIterator{files: sortedFiles, order: order}
+}
+
+// SetBufferSize 设置读取每个文件时的预读缓冲区大小，需要在第一次调用 Next 之前设置
+func (it *MergedIterator) SetBufferSize(size int) {
+	it.bufSize = size
+}
+
+// Next 返回下一条entry及其所在的文件id和位置，遍历结束时返回 io.EOF
+// 遇到校验和不正确的entry时返回 ErrInvalidCrc 及其位置，可以继续调用 Next 跳过它
+func (it *MergedIterator) Next() (e *Entry, fileId uint32, offset int64, err error) {
+	return it.next()
+}
+
+// SkipFile 跳过正在读取的文件中剩余的entry，下一次 Next 从下一个文件开始，用于无法确定下一条entry位置的损坏数据
+func (it *MergedIterator) SkipFile() {
+	if it.cur < len(it.files) {
+		it.cur++
+		it.reader = nil
+	}
+}
+
+// 按文件id及位置的顺序读取下一条entry
+func (it *MergedIterator) next() (e *Entry, fileId uint32, offset int64, err error) {
+	for it.cur < len(it.files) {
+		df := it.files[it.cur]
+		if it.reader == nil {
+			it.reader = df.NewReaderSize(it.bufSize)
+		}
+		e, offset, err = it.reader.Next()
+		if err == io.EOF {
+			it.cur++
+			it.reader = nil
+			continue
+		}
+		return e, df.Id, offset, err
+	}
+	err = io.EOF
+	return
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+)
+
+// ReadAheadSize 顺序读取数据文件时的预读缓冲区大小：1MB
+const ReadAheadSize = 1 << 20
+
+// EntryReader 数据文件的顺序读取器
+// 加载索引、回收磁盘空间等需要扫描整个文件的场景下，使用带缓冲的顺序读代替每条entry多次的 ReadAt，减少IO次数
+type EntryReader struct {
+	reader   *bufio.Reader
+	offset   int64        // 下一条entry的起始位置
+	checksum ChecksumType // 文件使用的校验和算法
+}
+
+// NewReader 新建一个从第一条entry开始的顺序读取器
+func (df *DBFile) NewReader() *EntryReader {
+	return df.NewReaderSize(ReadAheadSize)
+}
+
+// NewReaderSize 新建一个预读缓冲区为 size 字节的顺序读取器，size 不大于0时使用默认的 ReadAheadSize
+func (df *DBFile) NewReaderSize(size int) *EntryReader {
+	if size <= 0 {
+		size = ReadAheadSize
+	}
+	var r io.Reader
+	if df.method == MMap {
+		r = bytes.NewReader(df.mmap[df.dataOff:])
+	} else {
+		r = io.NewSectionReader(df.File, df.dataOff, math.MaxInt64-df.dataOff) // 使用独立的读偏移，不影响文件的其他读写
+	}
+	return &EntryReader{
+		reader:   bufio.NewReaderSize(r, size),
+		offset:   df.dataOff,
+		checksum: df.checksum,
+	}
+}
+
+// Next 读取下一条entry，同时返回该entry在文件中的起始位置，读到文件末尾时返回 io.EOF
+func (r *EntryReader) Next() (e *Entry, offset int64, err error) {
+	offset = r.offset
+	e, err = readEntry(func(n int64) ([]byte, error) {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r.reader, buf); err != nil {
+			if err == io.ErrUnexpectedEOF { // 与 ReadAt 保持一致，不完整的数据也视为读到了文件末尾
+				err = io.EOF
+			}
+			return nil, err
+		}
+		r.offset += n // 即使校验失败，读过的数据也已被消费，偏移需要同步前移
+		return buf, nil
+	}, r.checksum)
+	return
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"bufio"
+	"io"
+)
+
+// EntryWriter 数据文件的顺序写入器
+// 回收磁盘空间等需要连续写入大量entry的场景下，先将entry写入缓冲区，缓冲区满时再一次写入文件，减少IO次数
+// 写入器持有的数据在 Flush 之后才会写入文件，期间不能通过 DBFile 读取
+type EntryWriter struct {
+	df     *DBFile
+	writer *bufio.Writer // 为 nil 时直接写入文件
+}
+
+// 从指定位置开始顺序写入文件
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.w.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
+
+// NewWriter 新建一个从文件当前写偏移开始、缓冲区为 size 字节的顺序写入器，size 不大于0或使用 MMap 时不缓冲
+func (df *DBFile) NewWriter(size int) *EntryWriter {
+	w := &EntryWriter{df: df}
+	if size > 0 && df.method == FileIO {
+		w.writer = bufio.NewWriterSize(&offsetWriter{w: df.File, off: df.Offset}, size)
+	}
+	return w
+}
+
+// Write 写入一条entry，文件的写偏移随之前移
+func (w *EntryWriter) Write(e *Entry) error {
+	if w.writer == nil {
+		return w.df.Write(e)
+	}
+	if e == nil || e.Meta.KeySize == 0 {
+		return ErrEmptyEntry
+	}
+
+	encVal, err := e.encode(w.df.checksum)
+	if err != nil {
+		return err
+	}
+	if _, err = w.writer.Write(encVal); err != nil {
+		return err
+	}
+	w.df.Offset += int64(e.Size())
+	return nil
+}
+
+// Flush 将缓冲区中的数据写入文件
+func (w *EntryWriter) Flush() error {
+	if w.writer == nil {
+		return nil
+	}
+	return w.writer.Flush()
+}
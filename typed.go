@@ -0,0 +1,143 @@
+package mindb
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// Typed 带类型的键空间，key 和 value 在编译期确定类型，存储为字符串类型的数据
+// 所有 key 都带有相同的前缀，不同的 Typed 使用不同的前缀即可互不干扰
+// string 及整数类型的 key 直接转换为字节数组（整数按十进制），其他类型的 key 和所有的 value 使用 codec 编码
+type Typed[K comparable, V any] struct {
+	db     *MinDB
+	prefix []byte
+	codec  Codec
+}
+
+// NewTyped 新建一个带类型的键空间，codec 为 nil 时使用 DefaultCodec
+func NewTyped[K comparable, V any](db *MinDB, prefix string, codec Codec) *Typed[K, V] {
+	if codec == nil {
+		codec = DefaultCodec
+	}
+	return &Typed[K, V]{db: db, prefix: []byte(prefix), codec: codec}
+}
+
+// Get 获取 key 对应的值，key 不存在时返回 ErrKeyNotExist
+func (t *Typed[K, V]) Get(key K) (v V, err error) {
+	k, err := t.encodeKey(key)
+	if err != nil {
+		return
+	}
+	data, err := t.db.Get(k)
+	if err != nil {
+		return
+	}
+	err = t.codec.Unmarshal(data, &v)
+	return
+}
+
+// Set 设置 key 对应的值
+func (t *Typed[K, V]) Set(key K, value V) error {
+	k, err := t.encodeKey(key)
+	if err != nil {
+		return err
+	}
+	data, err := t.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return t.db.Set(k, data)
+}
+
+// Delete 删除 key
+func (t *Typed[K, V]) Delete(key K) error {
+	k, err := t.encodeKey(key)
+	if err != nil {
+		return err
+	}
+	return t.db.StrRem(k)
+}
+
+// Iterate 按编码后 key 的字典序遍历键空间中的所有 key 和 value，fn 返回 false 时停止遍历
+// 遍历的是开始时的 key 快照，遍历过程中被删除的 key 会被跳过，在 fn 中可以安全地修改键空间
+func (t *Typed[K, V]) Iterate(fn func(key K, value V) bool) error {
+	for _, k := range t.db.keysOf(String) {
+		if !bytes.HasPrefix(k, t.prefix) {
+			continue
+		}
+		key, err := t.decodeKey(k)
+		if err != nil {
+			return err
+		}
+		value, err := t.Get(key)
+		if err == ErrKeyNotExist || err == ErrKeyExpired {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !fn(key, value) {
+			break
+		}
+	}
+	return nil
+}
+
+// 将 key 编码为带前缀的字节数组
+func (t *Typed[K, V]) encodeKey(key K) ([]byte, error) {
+	var b []byte
+	switch k := any(key).(type) {
+	case string:
+		b = []byte(k)
+	case int:
+		b = strconv.AppendInt(nil, int64(k), 10)
+	case int32:
+		b = strconv.AppendInt(nil, int64(k), 10)
+	case int64:
+		b = strconv.AppendInt(nil, k, 10)
+	case uint:
+		b = strconv.AppendUint(nil, uint64(k), 10)
+	case uint32:
+		b = strconv.AppendUint(nil, uint64(k), 10)
+	case uint64:
+		b = strconv.AppendUint(nil, k, 10)
+	default:
+		var err error
+		if b, err = t.codec.Marshal(key); err != nil {
+			return nil, err
+		}
+	}
+	return append(append([]byte{}, t.prefix...), b...), nil
+}
+
+// 将带前缀的字节数组解码为 key，与 encodeKey 相反
+func (t *Typed[K, V]) decodeKey(b []byte) (key K, err error) {
+	b = b[len(t.prefix):]
+	switch k := any(&key).(type) {
+	case *string:
+		*k = string(b)
+	case *int:
+		var n int64
+		n, err = strconv.ParseInt(string(b), 10, 0)
+		*k = int(n)
+	case *int32:
+		var n int64
+		n, err = strconv.ParseInt(string(b), 10, 32)
+		*k = int32(n)
+	case *int64:
+		*k, err = strconv.ParseInt(string(b), 10, 64)
+	case *uint:
+		var n uint64
+		n, err = strconv.ParseUint(string(b), 10, 0)
+		*k = uint(n)
+	case *uint32:
+		var n uint64
+		n, err = strconv.ParseUint(string(b), 10, 32)
+		*k = uint32(n)
+	case *uint64:
+		*k, err = strconv.ParseUint(string(b), 10, 64)
+	default:
+		err = t.codec.Unmarshal(b, &key)
+	}
+	return
+}
@@ -0,0 +1,273 @@
+package mindb
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strconv"
+)
+
+var (
+	// ErrViewExists 同名的物化视图已经存在
+	ErrViewExists = errors.New("mindb: the view already exists")
+
+	// ErrViewNotExist 物化视图不存在
+	ErrViewNotExist = errors.New("mindb: the view does not exist")
+
+	// ErrInvalidView 物化视图的定义不合法
+	ErrInvalidView = errors.New("mindb: invalid view definition")
+)
+
+// ViewKind 物化视图的类型
+type ViewKind uint8
+
+const (
+	// ViewLeaderboard 有序集合 Target 保存所有以 Prefix 开头的哈希中 Field 域的数值，成员为哈希的key，域的值不是数值时不在其中
+	ViewLeaderboard ViewKind = iota + 1
+
+	// ViewCount 字符串 Target 保存以 Prefix 开头的字符串key的数量，过期的key在被删除时才不再计入
+	ViewCount
+)
+
+// View 物化视图的定义
+type View struct {
+	Name   string   `json:"name"`
+	Kind   ViewKind `json:"kind"`
+	Target []byte   `json:"target"`          // 保存结果的key
+	Prefix []byte   `json:"prefix"`          // 来源key的前缀
+	Field  []byte   `json:"field,omitempty"` // 来源哈希的域，只用于 ViewLeaderboard
+}
+
+// 保存所有物化视图定义的内部哈希，域为视图的名称
+const viewsKey = "\x00views"
+
+// 物化视图由写入路径维护：来源key的写入与结果的更新在同一次加锁内完成，应用不需要自己同时写两份数据
+// 创建视图及每次打开数据库时根据已有的数据重新计算结果，只写入有差异的部分，写入中途崩溃留下的不一致也随之修复
+// 视图只维护结果，删除视图时结果保留；以 \x00 开头的内部key及视图的结果本身不会计入
+
+func (v *View) matches(key []byte) bool {
+	return !isInternalKey(key) && bytes.HasPrefix(key, v.Prefix) && !bytes.Equal(key, v.Target)
+}
+
+func (v *View) valid() bool {
+	if v.Name == "" || len(v.Target) == 0 || isInternalKey(v.Target) {
+		return false
+	}
+	switch v.Kind {
+	case ViewLeaderboard:
+		return len(v.Field) > 0
+	case ViewCount:
+		return true
+	}
+	return false
+}
+
+// CreateView 创建物化视图，并根据已有的数据计算出结果
+func (db *MinDB) CreateView(v View) error {
+	if !v.valid() {
+		return ErrInvalidView
+	}
+
+	db.viewMu.Lock()
+	defer db.viewMu.Unlock()
+
+	views := db.loadViews()
+	for _, old := range views {
+		if old.Name == v.Name {
+			return ErrViewExists
+		}
+	}
+	data, err := (JSONCodec{}).Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err = db.rawHSet([]byte(viewsKey), []byte(v.Name), data); err != nil {
+		return err
+	}
+
+	db.views.Store(append(views[:len(views):len(views)], &v)) // 复制一份，已经取出的列表不受影响
+	return db.refreshView(&v)
+}
+
+// DropView 删除物化视图，之后不再维护其结果，已有的结果保留
+func (db *MinDB) DropView(name string) error {
+	db.viewMu.Lock()
+	defer db.viewMu.Unlock()
+
+	views := db.loadViews()
+	for i, v := range views {
+		if v.Name != name {
+			continue
+		}
+		if err := db.rawHDel([]byte(viewsKey), []byte(name)); err != nil {
+			return err
+		}
+		rest := make([]*View, 0, len(views)-1)
+		db.views.Store(append(append(rest, views[:i]...), views[i+1:]...))
+		return nil
+	}
+	return ErrViewNotExist
+}
+
+// Views 返回所有的物化视图
+func (db *MinDB) Views() []View {
+	var res []View
+	for _, v := range db.loadViews() {
+		res = append(res, *v)
+	}
+	return res
+}
+
+func (db *MinDB) loadViews() []*View {
+	views, _ := db.views.Load().([]*View)
+	return views
+}
+
+// 打开数据库时加载视图的定义，并重新计算结果
+func (db *MinDB) openViews() error {
+	fields := db.hashIndex.indexes.HGetAll(viewsKey)
+	var views []*View
+	for i := 0; i+1 < len(fields); i += 2 {
+		v := &View{}
+		if err := (JSONCodec{}).Unmarshal(fields[i+1], v); err != nil {
+			return err
+		}
+		views = append(views, v)
+	}
+	db.views.Store(views)
+
+	for _, v := range views {
+		if err := db.refreshView(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 根据已有的数据重新计算视图的结果，计算期间持有来源类型索引的锁
+func (db *MinDB) refreshView(v *View) error {
+	switch v.Kind {
+	case ViewLeaderboard:
+		return db.refreshLeaderboard(v)
+	case ViewCount:
+		return db.refreshCount(v)
+	}
+	return nil
+}
+
+func (db *MinDB) refreshLeaderboard(v *View) error {
+	db.hashIndex.mu.RLock()
+	defer db.hashIndex.mu.RUnlock()
+
+	scores := make(map[string]float64)
+	for _, key := range db.hashIndex.indexes.Keys() {
+		if !v.matches([]byte(key)) {
+			continue
+		}
+		if score, err := strconv.ParseFloat(string(db.hashIndex.indexes.HGet(key, string(v.Field))), 64); err == nil {
+			scores[key] = score
+		}
+	}
+
+	db.zsetIndex.mu.RLock()
+	current := db.zsetIndex.indexes.ZRange(string(v.Target), 0, -1)
+	db.zsetIndex.mu.RUnlock()
+	for i := 0; i+1 < len(current); i += 2 {
+		member := current[i].(string)
+		if score, ok := scores[member]; !ok {
+			if _, err := db.rawZRem(v.Target, []byte(member)); err != nil {
+				return err
+			}
+		} else if score == current[i+1].(float64) {
+			delete(scores, member)
+		}
+	}
+	for member, score := range scores {
+		if err := db.rawZAdd(v.Target, []byte(member), score); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *MinDB) refreshCount(v *View) error {
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	var n int64
+	for e := db.strIndex.idxList.FindPrefix(v.Prefix); e != nil && bytes.HasPrefix(e.Key(), v.Prefix); e = e.Next() {
+		if v.matches(e.Key()) {
+			n++
+		}
+	}
+	if current, ok := db.viewCounter(v.Target); ok && current == n {
+		return nil
+	}
+	return db.setKeepTTL(v.Target, []byte(strconv.FormatInt(n, 10)))
+}
+
+// 读取计数视图的结果，调用方需持有字符串索引的写锁
+func (db *MinDB) viewCounter(target []byte) (int64, bool) {
+	if !db.strIndex.idxList.Exist(target) {
+		return 0, false
+	}
+	val, err := db.getVal(target)
+	if err != nil {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(string(val), 10, 64)
+	return n, err == nil
+}
+
+// 字符串key被新建（delta 为 1）或删除（delta 为 -1）后更新计数视图，调用方需持有字符串索引的写锁
+func (db *MinDB) updateCountViews(key []byte, delta int64) {
+	for _, v := range db.loadViews() {
+		if v.Kind != ViewCount || !v.matches(key) {
+			continue
+		}
+		n, _ := db.viewCounter(v.Target)
+		if n += delta; n < 0 {
+			n = 0
+		}
+		if err := db.setKeepTTL(v.Target, []byte(strconv.FormatInt(n, 10))); err != nil {
+			log.Printf("update view [%s] err: %+v\n", v.Name, err)
+		}
+	}
+}
+
+// 哈希的域被写入后更新排行榜视图，value 为 nil 表示域被删除，调用方需持有哈希索引的写锁，不能持有有序集合索引的锁
+func (db *MinDB) updateLeaderboards(key, field, value []byte) {
+	views := db.leaderboards(key, field)
+	if len(views) == 0 {
+		return
+	}
+	db.zsetIndex.mu.Lock()
+	defer db.zsetIndex.mu.Unlock()
+	db.writeLeaderboards(views, key, value)
+}
+
+// 哈希 key 的域 field 所对应的排行榜视图
+func (db *MinDB) leaderboards(key, field []byte) []*View {
+	var views []*View
+	for _, v := range db.loadViews() {
+		if v.Kind == ViewLeaderboard && bytes.Equal(field, v.Field) && v.matches(key) {
+			views = append(views, v)
+		}
+	}
+	return views
+}
+
+// 调用方需持有哈希索引及有序集合索引的写锁
+func (db *MinDB) writeLeaderboards(views []*View, key, value []byte) {
+	for _, v := range views {
+		var err error
+		if score, parseErr := strconv.ParseFloat(string(value), 64); value != nil && parseErr == nil {
+			err = db.storeZAdd(v.Target, key, score)
+		} else {
+			_, err = db.storeZRem(v.Target, key)
+		}
+		if err != nil {
+			log.Printf("update view [%s] err: %+v\n", v.Name, err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package mindb
+
+import "testing"
+
+// 批量写入提交返回时排行榜视图已经更新，批量写入本身是否涉及有序集合都不能死锁
+func TestBatchUpdatesLeaderboard(t *testing.T) {
+	db := openTestDB(t, KeyValueRamMode)
+	board := []byte("board")
+	if err := db.CreateView(View{Name: "scores", Kind: ViewLeaderboard, Target: board, Prefix: []byte("player:"), Field: []byte("score")}); err != nil {
+		t.Fatal(err)
+	}
+
+	b := db.NewWriteBatch()
+	b.HSet([]byte("player:1"), []byte("score"), []byte("10"))
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if score := db.ZScore(board, []byte("player:1")); score != 10 {
+		t.Fatalf("score after batch = %v, want 10", score)
+	}
+
+	b = db.NewWriteBatch()
+	b.HSet([]byte("player:2"), []byte("score"), []byte("20"))
+	b.ZAdd([]byte("other"), 1, []byte("m"))
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if score := db.ZScore(board, []byte("player:2")); score != 20 {
+		t.Fatalf("score after batch with a zset = %v, want 20", score)
+	}
+}
@@ -0,0 +1,78 @@
+package mindb
+
+import "sync"
+
+// 被监视的key的修改版本，只记录被监视的key，避免为所有的key保存版本
+// 版本为最后一次修改该key时的写入序号，不区分数据类型，同名的不同类型的key共用一个版本
+type keyVersions struct {
+	mu sync.Mutex
+	m  map[string]*keyVersion
+}
+
+type keyVersion struct {
+	refs    int    // 监视者的数量
+	version uint64 // 开始监视后最后一次修改的写入序号，未修改过时为 0
+}
+
+func newKeyVersions() *keyVersions {
+	return &keyVersions{m: make(map[string]*keyVersion)}
+}
+
+// 写入key后调用，key 被监视时更新其版本
+func (v *keyVersions) touch(key []byte, seq uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if kv, ok := v.m[string(key)]; ok {
+		kv.version = seq
+	}
+}
+
+// versions 中的key的当前版本是否都与记录的相同，调用方需持有这些key的监视
+func (v *keyVersions) unchanged(versions map[string]uint64) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for key, version := range versions {
+		if kv, ok := v.m[key]; !ok || kv.version != version {
+			return false
+		}
+	}
+	return true
+}
+
+// Watch 开始监视key的修改，返回当前的版本，之后key的任何写入（包括删除、设置过期时间）都会使版本变化
+// 每次 Watch 都需要对应一次 Unwatch，否则key的版本会一直被保留
+func (db *MinDB) Watch(key []byte) uint64 {
+	v := db.versions
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	kv, ok := v.m[string(key)]
+	if !ok {
+		kv = &keyVersion{}
+		v.m[string(key)] = kv
+	}
+	kv.refs++
+	return kv.version
+}
+
+// Unwatch 停止监视key，所有的监视者都停止后不再记录key的版本
+func (db *MinDB) Unwatch(key []byte) {
+	v := db.versions
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if kv, ok := v.m[string(key)]; ok {
+		if kv.refs--; kv.refs <= 0 {
+			delete(v.m, string(key))
+		}
+	}
+}
+
+// KeyVersion 获取被监视的key的当前版本，与 Watch 返回的版本不同时说明key在此期间被修改过
+func (db *MinDB) KeyVersion(key []byte) uint64 {
+	v := db.versions
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if kv, ok := v.m[string(key)]; ok {
+		return kv.version
+	}
+	return 0
+}